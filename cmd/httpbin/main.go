@@ -0,0 +1,18 @@
+// Command httpbin manages the bins of a server from the command line, see
+// package cli.
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"app/internal/cli"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	status := cli.Run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(status)
+}
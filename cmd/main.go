@@ -1,20 +1,38 @@
 package main
 
 import (
-	"log"
+	"log/slog"
+	"os"
 
 	app "app/internal"
 )
 
 func main() {
-	myApp := app.NewApp()
-	err := myApp.Init()
+	myApp, err := app.NewApp()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
+	err = myApp.Migrate()
+	if err != nil {
+		fatal(err)
+	}
+
+	// the app is served while it is initialised, reporting not ready
+	go func() {
+		err := myApp.Init()
+		if err != nil {
+			fatal(err)
+		}
+	}()
+
 	err = myApp.Start()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 }
+
+func fatal(err error) {
+	slog.Error("exiting", "error", err)
+	os.Exit(1)
+}
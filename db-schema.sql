@@ -1,7 +1,11 @@
+-- user_version is checked against db.SchemaVersion, bump both together
+PRAGMA user_version = 9;
 CREATE TABLE [bins] (
 	bin_id INTEGER PRIMARY KEY,
 	created_at DATETIME NOT NULL,
-	owner TEXT
+	owner TEXT,
+	dropped INTEGER NOT NULL DEFAULT 0,
+	rate_limit TEXT NOT NULL DEFAULT ''
 );
 CREATE TABLE [requests] (
 	id INTEGER PRIMARY KEY,
@@ -13,5 +17,103 @@ CREATE TABLE [requests] (
   requestUri TEXT NOT NULL,
 	"method" TEXT NOT NULL,
 	bin INTEGER NOT NULL,
-	FOREIGN KEY (bin) REFERENCES bins(bin_id)	
+	subPath TEXT NOT NULL DEFAULT '',
+	ruleId INTEGER NOT NULL DEFAULT 0,
+	responseError TEXT NOT NULL DEFAULT '',
+	proto TEXT NOT NULL DEFAULT '',
+	contentLength INTEGER NOT NULL DEFAULT -1,
+	transferEncoding TEXT NOT NULL DEFAULT '',
+	trailers TEXT NOT NULL DEFAULT '{}',
+	rawHead TEXT NOT NULL DEFAULT '',
+	rawChunkSizes TEXT NOT NULL DEFAULT '',
+	rawTrailers TEXT NOT NULL DEFAULT '',
+	clientIp TEXT NOT NULL DEFAULT '',
+	tlsVersion TEXT NOT NULL DEFAULT '',
+	tlsCipherSuite TEXT NOT NULL DEFAULT '',
+	tlsServerName TEXT NOT NULL DEFAULT '',
+	tlsClientSubject TEXT NOT NULL DEFAULT '',
+	closedAt DATETIME,
+	mailFrom TEXT NOT NULL DEFAULT '',
+	mailTo TEXT NOT NULL DEFAULT '',
+	validation TEXT NOT NULL DEFAULT '',
+	validationErrors TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (bin) REFERENCES bins(bin_id) ON DELETE CASCADE
 );
+CREATE INDEX requests_bin_timestamp ON requests (bin, julianday(timestamp));
+CREATE INDEX requests_bin_method ON requests (bin, "method");
+CREATE TABLE [request_headers] (
+	request INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	value TEXT NOT NULL,
+	FOREIGN KEY (request) REFERENCES requests(id) ON DELETE CASCADE
+);
+CREATE INDEX request_headers_request ON request_headers (request);
+CREATE INDEX request_headers_name ON request_headers (name, value);
+CREATE TABLE [rules] (
+	id INTEGER PRIMARY KEY,
+	bin INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	"method" TEXT NOT NULL DEFAULT '',
+	pathGlob TEXT NOT NULL DEFAULT '',
+	headers TEXT NOT NULL DEFAULT '{}',
+	query TEXT NOT NULL DEFAULT '{}',
+	bodyFields TEXT NOT NULL DEFAULT '{}',
+	status INTEGER NOT NULL,
+	responseHeaders TEXT NOT NULL DEFAULT '{}',
+	responseBody TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (bin) REFERENCES bins(bin_id) ON DELETE CASCADE
+);
+CREATE TABLE [notification_targets] (
+	id INTEGER PRIMARY KEY,
+	bin INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	kind TEXT NOT NULL,
+	url TEXT NOT NULL DEFAULT '',
+	headers TEXT NOT NULL DEFAULT '{}',
+	recipients TEXT NOT NULL DEFAULT '',
+	subject TEXT NOT NULL DEFAULT '',
+	payload TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (bin) REFERENCES bins(bin_id) ON DELETE CASCADE
+);
+CREATE INDEX notification_targets_bin ON notification_targets (bin, position);
+CREATE TABLE [websocket_frames] (
+	id INTEGER PRIMARY KEY,
+	request INTEGER NOT NULL,
+	timestamp DATETIME NOT NULL,
+	direction TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	payload BLOB NOT NULL,
+	FOREIGN KEY (request) REFERENCES requests(id) ON DELETE CASCADE
+);
+CREATE INDEX websocket_frames_request ON websocket_frames (request, id);
+CREATE TABLE [websocket_scripts] (
+	bin INTEGER PRIMARY KEY,
+	echo INTEGER NOT NULL DEFAULT 0,
+	onConnect TEXT NOT NULL DEFAULT '[]',
+	reply TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (bin) REFERENCES bins(bin_id) ON DELETE CASCADE
+);
+CREATE TABLE [grpc_settings] (
+	bin INTEGER PRIMARY KEY,
+	status INTEGER NOT NULL DEFAULT 0,
+	message TEXT NOT NULL DEFAULT '',
+	responses TEXT NOT NULL DEFAULT '{}',
+	descriptorSet BLOB NOT NULL DEFAULT x'',
+	FOREIGN KEY (bin) REFERENCES bins(bin_id) ON DELETE CASCADE
+);
+CREATE TABLE [bin_schemas] (
+	bin INTEGER PRIMARY KEY,
+	schema TEXT NOT NULL DEFAULT '',
+	openapi TEXT NOT NULL DEFAULT '',
+	operation TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (bin) REFERENCES bins(bin_id) ON DELETE CASCADE
+);
+CREATE TABLE [capture_ports] (
+	protocol TEXT NOT NULL,
+	port INTEGER NOT NULL,
+	bin INTEGER NOT NULL,
+	PRIMARY KEY (protocol, port),
+	FOREIGN KEY (bin) REFERENCES bins(bin_id) ON DELETE CASCADE
+);
+CREATE INDEX capture_ports_bin ON capture_ports (bin);
@@ -1,6 +1,6 @@
 module app
 
-go 1.23
+go 1.24
 
 require (
 	github.com/go-chi/chi/v5 v5.1.0
@@ -12,7 +12,10 @@ require github.com/a-h/templ v0.2.747
 require (
 	github.com/google/uuid v1.6.0
 	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.2
 	github.com/stretchr/testify v1.9.0
+	golang.org/x/text v0.14.0
+	google.golang.org/protobuf v1.36.11
 )
 
 require (
@@ -1,11 +1,26 @@
 package app
 
 import (
+	"app/internal/capture"
 	"app/internal/controllers"
 	"app/internal/db"
+	"app/internal/dnsd"
+	"app/internal/health"
+	"app/internal/logging"
+	"app/internal/metrics"
+	"app/internal/notify"
 	"app/internal/router"
 	"app/internal/services"
-	"log"
+	"app/internal/smtpd"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
 )
 
 type Deps struct {
@@ -15,48 +30,191 @@ type Deps struct {
 }
 
 type App struct {
-	db       Db
-	services Services
-	server   Server
+	db            Db
+	services      Services
+	server        Server
+	health        *health.Health
+	notifications *notify.Queue
+	listeners     *capture.Listeners
+
+	shutdownDrain   time.Duration
+	shutdownTimeout time.Duration
 }
 
-func NewApp() *App {
-	dataService, err := db.NewDb("sqlite3", "./database.db")
+func NewApp() (*App, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(logging.New(os.Stderr, config.LogLevel, config.LogFormat))
+
+	dataService, err := db.NewDb("sqlite3", config.DbPath)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	appMetrics := metrics.New()
+	appMetrics.WatchDbSize(func() (int64, error) {
+		return dataService.Size(context.Background())
+	})
+
+	senders := notify.Senders{
+		notify.KindWebhook: notify.NewWebhook(config.NotifyPrivateNetworks),
 	}
+	if config.SMTPAddr != "" {
+		senders[notify.KindEmail] = &notify.SMTP{
+			Addr:     config.SMTPAddr,
+			From:     config.SMTPFrom,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+		}
+	}
+	notifications := notify.NewQueue(notify.Options{
+		Sender:  senders,
+		Metrics: appMetrics,
+	})
+
+	listeners := capture.New(capture.DefaultOptions)
 
 	srvs := services.New(&services.Deps{
-		Db: dataService,
+		Db:                 dataService,
+		TrustedProxies:     config.TrustedProxies,
+		Metrics:            appMetrics,
+		RateLimits:         config.RateLimits,
+		Notifier:           notifications,
+		EmailNotifications: config.SMTPAddr != "",
+		EmailDomains:       config.SMTPAllowedDomains,
+		Listeners:          listeners,
+		CapturePorts:       config.CapturePorts,
+	})
+
+	appHealth := health.New()
+	appHealth.AddCheck("db", func(context.Context) error {
+		return dataService.Ping()
 	})
+	appHealth.AddCheck("schema", dataService.CheckSchema)
+	appHealth.AddCheck("disk", health.MinDiskFree(filepath.Dir(config.DbPath), config.MinDiskFreeMB<<20))
 
 	controllers := controllers.NewControllers(&controllers.Deps{
 		Services: srvs,
 	})
-	router := router.Routes(controllers)
+	router := router.Routes(controllers, appMetrics, appHealth, router.Options{
+		BinDomain: config.BinDomain,
+	})
 
-	newServer := NewServer(":3000", router)
+	serverOptions := ServerOptions{
+		Addr:     config.Addr,
+		TLSAddr:  config.TLSAddr,
+		GrpcAddr: config.GrpcAddr,
+	}
+	binExists := func(ctx context.Context, binId int64) error {
+		_, err := srvs.GetBin(ctx, binId)
+		return err
+	}
+	if config.MailAddr != "" {
+		serverOptions.Mail = smtpd.New(smtpd.Options{
+			Addr:   config.MailAddr,
+			Domain: config.MailDomain,
+			Accept: binExists,
+		}, srvs.CaptureMail)
+	}
+	if config.DNSAddr != "" {
+		serverOptions.DNS = dnsd.New(dnsd.Options{
+			Addr:    config.DNSAddr,
+			Zone:    config.DNSZone,
+			Answers: config.DNSAnswers,
+			Accept:  binExists,
+		}, srvs.CaptureDNSQuery)
+	}
+	if config.TLSAddr != "" {
+		serverOptions.TLSConfig, err = NewTLSConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("setting up TLS: %w", err)
+		}
+	}
+	newServer := NewServer(serverOptions, router)
 
 	return &App{
-		db:     dataService,
-		server: newServer,
-	}
+		db:              dataService,
+		services:        srvs,
+		notifications:   notifications,
+		listeners:       listeners,
+		server:          newServer,
+		health:          appHealth,
+		shutdownDrain:   config.ShutdownDrain,
+		shutdownTimeout: config.ShutdownTimeout,
+	}, nil
+}
+
+// Migrate prepares the database, migrating it to the schema the app
+// expects. It runs before the app is started, as requests are captured as
+// soon as it is.
+func (app *App) Migrate() error {
+	return app.db.Connect(context.Background())
 }
 
+// Init opens the ports of bins, the app reports ready once done. It may run
+// while the app is started.
 func (app *App) Init() error {
-	err := app.db.Connect()
+	err := app.services.OpenCapturePorts(context.Background())
 	if err != nil {
-		return err
+		return fmt.Errorf("opening capture ports: %w", err)
 	}
 
+	app.health.SetState(health.Ready)
 	return nil
 }
 
+// Start serves until SIGINT or SIGTERM, then drains: the app reports not
+// ready for the drain delay, so no new requests are routed to it, before
+// the servers stop and the requests being served are waited for.
 func (app *App) Start() error {
-	err := app.server.Start()
-	if err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// background work goes on until the servers are shut down
+	background, stopBackground := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, run := range []func(context.Context){
+		app.services.RecordDroppedRequests,
+		app.notifications.Run,
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(background)
+		}()
+	}
+	defer func() {
+		stopBackground()
+		wg.Wait()
+	}()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- app.server.Start()
+	}()
+
+	select {
+	case err := <-errs:
 		return err
+	case <-ctx.Done():
 	}
+	// a second signal stops the app at once
+	stop()
 
+	app.health.SetState(health.Draining)
+	slog.Info("draining", "delay", app.shutdownDrain)
+	time.Sleep(app.shutdownDrain)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.shutdownTimeout)
+	defer cancel()
+	app.services.CloseStreams(shutdownCtx)
+	err := app.server.Shutdown(shutdownCtx)
+	// connections to the ports of bins are captured as they are closed
+	app.listeners.Close()
+	if err != nil {
+		return fmt.Errorf("shutting down: %w", err)
+	}
+	slog.Info("stopped")
 	return nil
 }
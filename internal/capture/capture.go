@@ -0,0 +1,260 @@
+// Package capture listens on the TCP and UDP ports allocated to bins, and
+// captures every connection and datagram they receive as a request.
+package capture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"app/internal/models"
+)
+
+// Capture stores a connection or datagram received on a port of a bin.
+type Capture = func(ctx context.Context, request models.Request)
+
+type Options struct {
+	// Host is the address the ports are listened on, all addresses when
+	// empty.
+	Host string
+	// MaxPayload bounds what is read of a connection, closed once it sent
+	// that much, and recorded of a datagram.
+	MaxPayload int
+	// IdleTimeout closes the connections which sent nothing for that long.
+	IdleTimeout time.Duration
+	// MaxConnections are open at once on a TCP port, those beyond it are
+	// closed right away, without being captured.
+	MaxConnections int
+}
+
+// DefaultOptions are the options used in place of zero ones.
+var DefaultOptions = Options{
+	MaxPayload:     1 << 20,
+	IdleTimeout:    time.Minute,
+	MaxConnections: 100,
+}
+
+// maxDatagram is the size of the largest UDP datagram.
+const maxDatagram = 64 << 10
+
+// Bounds of the delay before accepting connections again after an error,
+// doubling with every error in a row.
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// Listeners are the ports listened on.
+type Listeners struct {
+	options Options
+
+	mu     sync.Mutex
+	ports  map[string]io.Closer
+	conns  map[net.Conn]struct{}
+	closed bool
+	// serving counts the goroutines serving ports and connections, which
+	// Close waits for.
+	serving sync.WaitGroup
+}
+
+func New(options Options) *Listeners {
+	if options.MaxPayload <= 0 {
+		options.MaxPayload = DefaultOptions.MaxPayload
+	}
+	if options.IdleTimeout <= 0 {
+		options.IdleTimeout = DefaultOptions.IdleTimeout
+	}
+	if options.MaxConnections <= 0 {
+		options.MaxConnections = DefaultOptions.MaxConnections
+	}
+	return &Listeners{
+		options: options,
+		ports:   map[string]io.Closer{},
+		conns:   map[net.Conn]struct{}{},
+	}
+}
+
+func portKey(protocol string, port int) string {
+	return protocol + "/" + strconv.Itoa(port)
+}
+
+// Listen starts capturing what a port receives, until Release or Close.
+func (l *Listeners) Listen(port models.CapturePort, capture Capture) error {
+	address := net.JoinHostPort(l.options.Host, strconv.Itoa(port.Port))
+	var listener io.Closer
+	var serve func()
+	switch port.Protocol {
+	case models.TCP:
+		tcp, err := net.Listen("tcp", address)
+		if err != nil {
+			return err
+		}
+		listener, serve = tcp, func() { l.serveTCP(tcp, port, capture) }
+	case models.UDP:
+		udp, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return err
+		}
+		listener, serve = udp, func() { l.serveUDP(udp, port, capture) }
+	default:
+		return fmt.Errorf("unknown protocol %q", port.Protocol)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		listener.Close()
+		return net.ErrClosed
+	}
+	l.ports[portKey(port.Protocol, port.Port)] = listener
+	l.serving.Add(1)
+	go func() {
+		defer l.serving.Done()
+		serve()
+	}()
+	slog.Info("capturing port", "protocol", port.Protocol, "port", port.Port, "bin", port.Bin)
+	return nil
+}
+
+// Release stops listening on a port. Its open connections are still
+// captured once they close.
+func (l *Listeners) Release(protocol string, port int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := portKey(protocol, port)
+	if listener, ok := l.ports[key]; ok {
+		listener.Close()
+		delete(l.ports, key)
+	}
+}
+
+// Close stops listening on every port, closes the open connections and
+// waits for them to be captured.
+func (l *Listeners) Close() {
+	l.mu.Lock()
+	l.closed = true
+	for key, listener := range l.ports {
+		listener.Close()
+		delete(l.ports, key)
+	}
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mu.Unlock()
+	l.serving.Wait()
+}
+
+func (l *Listeners) serveTCP(listener net.Listener, port models.CapturePort, capture Capture) {
+	// open counts the connections of the port, bounded by MaxConnections
+	open := make(chan struct{}, l.options.MaxConnections)
+	// retryDelay backs off accepting after errors, e.g. running out of file
+	// descriptors, as net/http does
+	var retryDelay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			retryDelay = min(max(2*retryDelay, minAcceptDelay), maxAcceptDelay)
+			slog.Warn("accepting connection", "port", port.Port, "retryIn", retryDelay, "error", err)
+			time.Sleep(retryDelay)
+			continue
+		}
+		retryDelay = 0
+		select {
+		case open <- struct{}{}:
+		default:
+			slog.Warn("too many connections, closing connection", "port", port.Port, "remoteAddr", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+		if !l.track(conn) {
+			conn.Close()
+			return
+		}
+		go func() {
+			defer l.serving.Done()
+			defer func() { <-open }()
+			l.serveConn(conn, port, capture)
+		}()
+	}
+}
+
+// track registers a connection to close on Close, unless closed already.
+func (l *Listeners) track(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	l.conns[conn] = struct{}{}
+	l.serving.Add(1)
+	return true
+}
+
+// serveConn reads a connection until the client closes it, it idles or
+// sent MaxPayload bytes, and captures it with what it sent.
+func (l *Listeners) serveConn(conn net.Conn, port models.CapturePort, capture Capture) {
+	request := newRequest(port, conn.LocalAddr(), conn.RemoteAddr())
+	var payload strings.Builder
+	buf := make([]byte, 32<<10)
+	for payload.Len() < l.options.MaxPayload {
+		conn.SetReadDeadline(time.Now().Add(l.options.IdleTimeout))
+		n, err := conn.Read(buf[:min(len(buf), l.options.MaxPayload-payload.Len())])
+		payload.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	conn.Close()
+	l.mu.Lock()
+	delete(l.conns, conn)
+	l.mu.Unlock()
+
+	request.ClosedAt = time.Now()
+	request.Body = payload.String()
+	request.ContentLength = int64(payload.Len())
+	capture(context.Background(), request)
+}
+
+// serveUDP captures every datagram a port receives, truncated to
+// MaxPayload.
+func (l *Listeners) serveUDP(conn net.PacketConn, port models.CapturePort, capture Capture) {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Warn("reading datagram", "port", port.Port, "error", err)
+			continue
+		}
+		request := newRequest(port, conn.LocalAddr(), addr)
+		request.Body = string(buf[:min(n, l.options.MaxPayload)])
+		request.ContentLength = int64(n)
+		capture(context.Background(), request)
+	}
+}
+
+// newRequest is the request a connection or datagram is captured as.
+func newRequest(port models.CapturePort, local, remote net.Addr) models.Request {
+	request := models.Request{
+		Bin:        port.Bin,
+		RecievedAt: time.Now(),
+		Host:       local.String(),
+		RemoteAddr: remote.String(),
+		Method:     strings.ToUpper(port.Protocol),
+		Proto:      port.Protocol,
+	}
+	request.SetHeaders(map[string][]string{})
+	return request
+}
@@ -0,0 +1,207 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"app/internal/models"
+)
+
+// freePort returns a port nothing listens on, for protocol.
+func freePort(t *testing.T, protocol string) int {
+	t.Helper()
+	if protocol == models.UDP {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// listen captures a free port of protocol into the returned channel.
+func listen(t *testing.T, listeners *Listeners, protocol string) (models.CapturePort, <-chan models.Request) {
+	t.Helper()
+	port := models.CapturePort{Protocol: protocol, Port: freePort(t, protocol), Bin: 7}
+	captured := make(chan models.Request, 10)
+	err := listeners.Listen(port, func(ctx context.Context, request models.Request) {
+		captured <- request
+	})
+	assert.NoError(t, err)
+	return port, captured
+}
+
+func receive(t *testing.T, captured <-chan models.Request) models.Request {
+	t.Helper()
+	select {
+	case request := <-captured:
+		return request
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing captured")
+		return models.Request{}
+	}
+}
+
+func Test_Listen(t *testing.T) {
+	t.Run("tcp connection", func(t *testing.T) {
+		listeners := New(Options{Host: "127.0.0.1"})
+		defer listeners.Close()
+		port, captured := listen(t, listeners, models.TCP)
+
+		conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port.Port))
+		assert.NoError(t, err)
+		conn.Write([]byte("<34>Oct 11 22:14:15 host su: failed\n"))
+		conn.Write([]byte("<34>Oct 11 22:14:16 host su: failed again\n"))
+		conn.Close()
+
+		request := receive(t, captured)
+		assert.Equal(t, int64(7), request.Bin)
+		assert.Equal(t, "TCP", request.Method)
+		assert.Equal(t, models.TCP, request.Proto)
+		assert.Equal(t, "<34>Oct 11 22:14:15 host su: failed\n<34>Oct 11 22:14:16 host su: failed again\n", request.Body)
+		assert.Equal(t, int64(len(request.Body)), request.ContentLength)
+		assert.Equal(t, conn.LocalAddr().String(), request.RemoteAddr)
+		assert.Equal(t, conn.RemoteAddr().String(), request.Host)
+		assert.False(t, request.ClosedAt.Before(request.RecievedAt))
+		headers, err := request.GetHeaders()
+		assert.NoError(t, err)
+		assert.Empty(t, headers)
+	})
+
+	t.Run("tcp connection idle or sending too much", func(t *testing.T) {
+		listeners := New(Options{Host: "127.0.0.1", MaxPayload: 4, IdleTimeout: 50 * time.Millisecond})
+		defer listeners.Close()
+		port, captured := listen(t, listeners, models.TCP)
+
+		idle, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port.Port))
+		assert.NoError(t, err)
+		defer idle.Close()
+		idle.Write([]byte("ab"))
+		request := receive(t, captured)
+		assert.Equal(t, "ab", request.Body)
+
+		large, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port.Port))
+		assert.NoError(t, err)
+		defer large.Close()
+		large.Write([]byte("abcdefgh"))
+		request = receive(t, captured)
+		assert.Equal(t, "abcd", request.Body)
+		assert.Equal(t, int64(4), request.ContentLength)
+	})
+
+	t.Run("udp datagrams", func(t *testing.T) {
+		listeners := New(Options{Host: "127.0.0.1", MaxPayload: 8})
+		defer listeners.Close()
+		port, captured := listen(t, listeners, models.UDP)
+
+		conn, err := net.Dial("udp", "127.0.0.1:"+strconv.Itoa(port.Port))
+		assert.NoError(t, err)
+		defer conn.Close()
+		conn.Write([]byte("first"))
+		conn.Write([]byte("second datagram"))
+
+		request := receive(t, captured)
+		assert.Equal(t, "UDP", request.Method)
+		assert.Equal(t, models.UDP, request.Proto)
+		assert.Equal(t, "first", request.Body)
+		assert.Equal(t, conn.LocalAddr().String(), request.RemoteAddr)
+		assert.True(t, request.ClosedAt.IsZero())
+
+		request = receive(t, captured)
+		assert.Equal(t, "second d", request.Body)
+		assert.Equal(t, int64(15), request.ContentLength)
+	})
+
+	t.Run("port taken", func(t *testing.T) {
+		listeners := New(Options{Host: "127.0.0.1"})
+		defer listeners.Close()
+		port, _ := listen(t, listeners, models.TCP)
+
+		err := listeners.Listen(port, func(context.Context, models.Request) {})
+		assert.Error(t, err)
+	})
+}
+
+func Test_Release(t *testing.T) {
+	listeners := New(Options{Host: "127.0.0.1"})
+	defer listeners.Close()
+	port, captured := listen(t, listeners, models.TCP)
+
+	open, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port.Port))
+	assert.NoError(t, err)
+	open.Write([]byte("before"))
+	// the connection is accepted before the port is released
+	time.Sleep(50 * time.Millisecond)
+
+	listeners.Release(models.TCP, port.Port)
+	_, err = net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port.Port))
+	assert.Error(t, err)
+
+	// open connections are still captured
+	open.Close()
+	request := receive(t, captured)
+	assert.Equal(t, "before", request.Body)
+}
+
+func Test_Close(t *testing.T) {
+	listeners := New(Options{Host: "127.0.0.1"})
+	port, captured := listen(t, listeners, models.TCP)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port.Port))
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("until shutdown"))
+	time.Sleep(50 * time.Millisecond)
+
+	// open connections are closed and captured
+	listeners.Close()
+	request := receive(t, captured)
+	assert.Equal(t, "until shutdown", request.Body)
+
+	err = listeners.Listen(port, func(context.Context, models.Request) {})
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+// failingListener fails to accept until closed.
+type failingListener struct {
+	net.Listener
+	accepts []time.Time
+	closed  chan struct{}
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts = append(l.accepts, time.Now())
+	select {
+	case <-l.closed:
+		return nil, net.ErrClosed
+	default:
+		return nil, errors.New("accept: too many open files")
+	}
+}
+
+func Test_serveTCP(t *testing.T) {
+	t.Run("accept errors back off", func(t *testing.T) {
+		listeners := New(Options{Host: "127.0.0.1"})
+		listener := &failingListener{closed: make(chan struct{})}
+		time.AfterFunc(100*time.Millisecond, func() { close(listener.closed) })
+
+		listeners.serveTCP(listener, models.CapturePort{Protocol: models.TCP, Port: 40000, Bin: 7}, func(context.Context, models.Request) {})
+
+		// waiting 5, 10, 20ms and so on between attempts
+		assert.GreaterOrEqual(t, len(listener.accepts), 3)
+		delay := minAcceptDelay
+		for i := 1; i < len(listener.accepts); i++ {
+			assert.GreaterOrEqual(t, listener.accepts[i].Sub(listener.accepts[i-1]), delay)
+			delay *= 2
+		}
+	})
+}
@@ -0,0 +1,297 @@
+// Package cli is the command-line client of the app, managing bins over the
+// JSON API of a server.
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"app/internal/models"
+)
+
+// importBatch is how many requests are imported at once, the most the
+// server accepts.
+const importBatch = 1000
+
+const usage = `Usage: httpbin [-url URL] [-token TOKEN] COMMAND [ARGS]
+
+Commands:
+  create                          create a bin and print its id and URL
+  tail [FLAGS] BIN                print the requests a bin captures as they land,
+                                  run tail -h for its flags
+  show BIN REQUEST                print a request with its headers and body
+  export BIN                      print the requests of a bin as JSON lines
+  import BIN [FILE]               add exported requests to a bin, read from stdin without FILE
+  replay BIN REQUEST URL          send a request again, to URL with its sub-path and query
+  delete BIN [REQUEST]            delete a bin, or one of its requests
+  clear BIN                       delete all requests of a bin
+
+Flags:
+`
+
+// errUsage is returned by commands given invalid arguments.
+var errUsage = errors.New("invalid usage")
+
+// command runs a subcommand with its arguments.
+type command struct {
+	client *Client
+	args   []string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// Run runs the command line args, without the program name, and returns
+// the exit status.
+func Run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("httpbin", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	baseUrl := flags.String("url", envOrDefault("HTTPBIN_URL", "http://localhost:3000"), "URL of the server, or HTTPBIN_URL")
+	// the token is not the default of the flag, which would print it
+	token := flags.String("token", "", "bearer token owning the bins created, or HTTPBIN_TOKEN")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *token == "" {
+		*token = os.Getenv("HTTPBIN_TOKEN")
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	c := command{
+		client: NewClient(*baseUrl, *token),
+		args:   flags.Args()[1:],
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+	}
+	commands := map[string]func(context.Context) error{
+		"create": c.create,
+		"tail":   c.tail,
+		"show":   c.show,
+		"export": c.export,
+		"import": c.importRequests,
+		"replay": c.replay,
+		"delete": c.delete,
+		"clear":  c.clear,
+	}
+	run, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+
+	err := run(ctx)
+	switch {
+	case errors.Is(err, errUsage):
+		flags.Usage()
+		return 2
+	case err != nil && ctx.Err() != nil:
+		// interrupted
+		return 130
+	case err != nil:
+		fmt.Fprintf(stderr, "httpbin %s: %s\n", flags.Arg(0), err)
+		return 1
+	}
+	return 0
+}
+
+func envOrDefault(key, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// ids parses the arguments from the first one as ids, of which there are
+// min to max.
+func (c *command) ids(min, max int) ([]int64, error) {
+	if len(c.args) < min || len(c.args) > max {
+		return nil, errUsage
+	}
+	ids := make([]int64, len(c.args))
+	for i, arg := range c.args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q", arg)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func (c *command) create(ctx context.Context) error {
+	if len(c.args) != 0 {
+		return errUsage
+	}
+	binId, err := c.client.CreateBin(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "%d\t%s\n", binId, c.client.CaptureUrl(binId))
+	return nil
+}
+
+func (c *command) show(ctx context.Context) error {
+	ids, err := c.ids(2, 2)
+	if err != nil {
+		return err
+	}
+	request, err := c.client.GetRequest(ctx, ids[0], ids[1])
+	if err != nil {
+		return err
+	}
+	return writeRequest(c.stdout, request)
+}
+
+func (c *command) export(ctx context.Context) error {
+	ids, err := c.ids(1, 1)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(c.stdout)
+	return c.client.EachRequest(ctx, ids[0], func(request models.Request) error {
+		return encoder.Encode(request)
+	})
+}
+
+func (c *command) importRequests(ctx context.Context) error {
+	if len(c.args) == 2 {
+		file, err := os.Open(c.args[1])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		c.stdin = file
+		c.args = c.args[:1]
+	}
+	ids, err := c.ids(1, 1)
+	if err != nil {
+		return err
+	}
+
+	imported := 0
+	batch := make([]models.Request, 0, importBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := c.client.ImportRequests(ctx, ids[0], batch); err != nil {
+			return err
+		}
+		imported += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	err = readRequests(c.stdin, func(request models.Request) error {
+		batch = append(batch, request)
+		if len(batch) == importBatch {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	fmt.Fprintf(c.stdout, "imported %d requests\n", imported)
+	return err
+}
+
+// readRequests reads requests as exported, one JSON object per line, or as
+// listed by the API, in JSON arrays.
+func readRequests(r io.Reader, f func(models.Request) error) error {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var value json.RawMessage
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var requests []models.Request
+		if trimmed := bytes.TrimSpace(value); len(trimmed) > 0 && trimmed[0] == '[' {
+			err = json.Unmarshal(value, &requests)
+		} else {
+			requests = make([]models.Request, 1)
+			err = json.Unmarshal(value, &requests[0])
+		}
+		if err != nil {
+			return err
+		}
+		for _, request := range requests {
+			if err := f(request); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *command) replay(ctx context.Context) error {
+	if len(c.args) != 3 {
+		return errUsage
+	}
+	target := c.args[2]
+	c.args = c.args[:2]
+	ids, err := c.ids(2, 2)
+	if err != nil {
+		return err
+	}
+
+	request, err := c.client.GetRequest(ctx, ids[0], ids[1])
+	if err != nil {
+		return err
+	}
+	replayed, err := replayRequest(ctx, request, target)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(replayed)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	fmt.Fprintf(c.stdout, "%s %s\n", response.Proto, response.Status)
+	response.Header.Write(c.stdout)
+	fmt.Fprintln(c.stdout)
+	_, err = io.Copy(c.stdout, response.Body)
+	return err
+}
+
+func (c *command) delete(ctx context.Context) error {
+	ids, err := c.ids(1, 2)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 2 {
+		return c.client.DeleteRequest(ctx, ids[0], ids[1])
+	}
+	return c.client.DeleteBin(ctx, ids[0])
+}
+
+func (c *command) clear(ctx context.Context) error {
+	ids, err := c.ids(1, 1)
+	if err != nil {
+		return err
+	}
+	return c.client.ClearBin(ctx, ids[0])
+}
@@ -0,0 +1,304 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"app/internal/models"
+)
+
+func newRequest(id int64, method, subPath string) models.Request {
+	request := models.Request{
+		Id:         id,
+		Bin:        1,
+		RecievedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Method:     method,
+		SubPath:    subPath,
+		RequestUri: "/bin/1" + subPath + "?source=shop",
+		Proto:      "HTTP/1.1",
+		Host:       "bins.example.com",
+		ClientIp:   "203.0.113.7",
+		Body:       `{"id": 42}`,
+	}
+	_ = request.SetHeaders(map[string][]string{
+		"Content-Type": {"application/json"},
+		"Connection":   {"keep-alive"},
+	})
+	return request
+}
+
+// assertSameRequest compares requests with their headers decoded, as their
+// encoding varies.
+func assertSameRequest(t *testing.T, expected, actual models.Request) {
+	expectedHeaders, _ := expected.GetHeaders()
+	actualHeaders, _ := actual.GetHeaders()
+	assert.Equal(t, expectedHeaders, actualHeaders)
+	expected.Headers, actual.Headers = "", ""
+	assert.Equal(t, expected, actual)
+}
+
+func run(t *testing.T, server *httptest.Server, stdin string, args ...string) (int, string, string) {
+	t.Setenv("HTTPBIN_URL", server.URL)
+	t.Setenv("HTTPBIN_TOKEN", "token")
+	var stdout, stderr bytes.Buffer
+	status := Run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func Test_Create(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST /api/bins", r.Method+" "+r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 7}`))
+	}))
+	defer server.Close()
+
+	status, stdout, _ := run(t, server, "", "create")
+	assert.Equal(t, 0, status)
+	assert.Equal(t, fmt.Sprintf("7\t%s/bin/7\n", server.URL), stdout)
+}
+
+func Test_Show(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/bins/1/requests/3", r.URL.Path)
+		json.NewEncoder(w).Encode(newRequest(3, "POST", "/orders"))
+	}))
+	defer server.Close()
+
+	status, stdout, _ := run(t, server, "", "show", "1", "3")
+	assert.Equal(t, 0, status)
+	assert.Contains(t, stdout, "POST /bin/1/orders?source=shop HTTP/1.1\nHost: bins.example.com\nConnection: keep-alive\nContent-Type: application/json\n\n{\"id\": 42}\n")
+}
+
+func Test_Export(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/bins/1/requests", r.URL.Path)
+		if r.URL.Query().Get("before") == "" {
+			w.Header().Set("Link", `</api/bins/1/requests?before=2-2>; rel="next"`)
+			json.NewEncoder(w).Encode([]models.Request{newRequest(3, "POST", "/a"), newRequest(2, "GET", "/b")})
+			return
+		}
+		json.NewEncoder(w).Encode([]models.Request{newRequest(1, "PUT", "/c")})
+	}))
+	defer server.Close()
+
+	status, stdout, _ := run(t, server, "", "export", "1")
+	assert.Equal(t, 0, status)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	assert.Len(t, lines, 3)
+	var last models.Request
+	assert.NoError(t, json.Unmarshal([]byte(lines[2]), &last))
+	assertSameRequest(t, newRequest(1, "PUT", "/c"), last)
+}
+
+func Test_Import(t *testing.T) {
+	var imported []models.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST /api/bins/2/requests", r.Method+" "+r.URL.Path)
+		var requests []models.Request
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&requests))
+		imported = append(imported, requests...)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	t.Run("json lines", func(t *testing.T) {
+		imported = nil
+		var export bytes.Buffer
+		for i := range importBatch + 1 {
+			json.NewEncoder(&export).Encode(newRequest(int64(i), "POST", "/a"))
+		}
+
+		status, stdout, _ := run(t, server, export.String(), "import", "2")
+		assert.Equal(t, 0, status)
+		assert.Equal(t, "imported 1001 requests\n", stdout)
+		assert.Len(t, imported, importBatch+1)
+	})
+	t.Run("json array file", func(t *testing.T) {
+		imported = nil
+		file := filepath.Join(t.TempDir(), "requests.json")
+		data, _ := json.Marshal([]models.Request{newRequest(1, "POST", "/a"), newRequest(2, "GET", "/b")})
+		assert.NoError(t, os.WriteFile(file, data, 0o600))
+
+		status, _, _ := run(t, server, "", "import", "2", file)
+		assert.Equal(t, 0, status)
+		assert.Len(t, imported, 2)
+		assertSameRequest(t, newRequest(2, "GET", "/b"), imported[1])
+	})
+}
+
+func Test_Tail(t *testing.T) {
+	minReconnectDelay = time.Millisecond
+	t.Cleanup(func() {
+		minReconnectDelay = time.Second
+	})
+	streams := [][]models.Request{
+		{newRequest(1, "POST", "/orders"), newRequest(2, "GET", "")},
+		{newRequest(3, "PUT", "/orders/1")},
+	}
+
+	var lastEventIds []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bins/1/requests" {
+			// the bin is empty
+			w.Write([]byte(`[]`))
+			return
+		}
+		assert.Equal(t, "/api/bins/1/stream", r.URL.Path)
+		lastEventIds = append(lastEventIds, r.Header.Get("Last-Event-ID"))
+		if len(lastEventIds) > len(streams) {
+			// the bin was deleted
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "bin 1: not found"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, request := range streams[len(lastEventIds)-1] {
+			data, _ := json.Marshal(request)
+			fmt.Fprintf(w, ": keep-alive\n\nid: %d\nevent: request\ndata: %s\n\n", request.Id, data)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("summary", func(t *testing.T) {
+		lastEventIds = nil
+
+		status, stdout, stderr := run(t, server, "", "tail", "1")
+		// reconnected once the stream is ended by the server, until the bin
+		// is missing
+		assert.Equal(t, 1, status)
+		assert.Equal(t, []string{"0", "2", "3"}, lastEventIds)
+		assert.Equal(t, "httpbin tail: stream ended by the server, reconnecting in 1ms\n"+
+			"httpbin tail: stream ended by the server, reconnecting in 2ms\n"+
+			"httpbin tail: server answered 404: bin 1: not found\n", stderr)
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		assert.Len(t, lines, 3)
+		assert.Contains(t, lines[0], "#1  POST    /orders  203.0.113.7  10B")
+		assert.Contains(t, lines[1], "#2  GET     /  203.0.113.7  10B")
+		assert.Contains(t, lines[2], "#3  PUT     /orders/1  203.0.113.7  10B")
+	})
+	t.Run("filtered json", func(t *testing.T) {
+		lastEventIds = nil
+
+		_, stdout, _ := run(t, server, "", "tail", "-format", "json", "-method", "post,put", "-path", "/orders", "1")
+		// requests filtered out are still resumed after
+		assert.Equal(t, []string{"0", "2", "3"}, lastEventIds)
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		assert.Len(t, lines, 2)
+		var request models.Request
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &request))
+		assertSameRequest(t, newRequest(3, "PUT", "/orders/1"), request)
+	})
+	t.Run("full", func(t *testing.T) {
+		lastEventIds = nil
+
+		_, stdout, _ := run(t, server, "", "tail", "-format", "full", "-method", "GET", "1")
+		assert.Contains(t, stdout, "GET /bin/1?source=shop HTTP/1.1\nHost: bins.example.com\n")
+		assert.True(t, strings.HasSuffix(stdout, "{\"id\": 42}\n\n"))
+	})
+	t.Run("reconnected before any request", func(t *testing.T) {
+		var lastEventIds []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/bins/1/requests" {
+				assert.Equal(t, "1", r.URL.Query().Get("limit"))
+				data, _ := json.Marshal([]models.Request{newRequest(7, "GET", "")})
+				w.Write(data)
+				return
+			}
+			lastEventIds = append(lastEventIds, r.Header.Get("Last-Event-ID"))
+			w.Header().Set("Content-Type", "text/event-stream")
+			switch len(lastEventIds) {
+			case 1:
+				// dropped before any request
+			case 2:
+				// the request captured meanwhile is resumed
+				data, _ := json.Marshal(newRequest(8, "POST", "/missed"))
+				fmt.Fprintf(w, "id: 8\nevent: request\ndata: %s\n\n", data)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		_, stdout, _ := run(t, server, "", "tail", "1")
+		assert.Equal(t, []string{"7", "7", "8"}, lastEventIds)
+		assert.Contains(t, stdout, "#8  POST    /missed")
+	})
+	t.Run("invalid format", func(t *testing.T) {
+		status, _, stderr := run(t, server, "", "tail", "-format", "xml", "1")
+		assert.Equal(t, 1, status)
+		assert.Contains(t, stderr, "invalid format")
+	})
+}
+
+func Test_Replay(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/hooks/orders?source=shop", r.URL.RequestURI())
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, `{"id": 42}`, string(body))
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("queued"))
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(newRequest(3, "POST", "/orders"))
+	}))
+	defer server.Close()
+
+	status, stdout, _ := run(t, server, "", "replay", "1", "3", target.URL+"/hooks")
+	assert.Equal(t, 0, status)
+	assert.True(t, strings.HasPrefix(stdout, "HTTP/1.1 202 Accepted\n"))
+	assert.True(t, strings.HasSuffix(stdout, "\nqueued"))
+}
+
+func Test_Delete(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deleted = append(deleted, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/api/bins/9" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error": "forbidden"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	status, _, _ := run(t, server, "", "delete", "1", "2")
+	assert.Equal(t, 0, status)
+	status, _, _ = run(t, server, "", "clear", "1")
+	assert.Equal(t, 0, status)
+	status, _, stderr := run(t, server, "", "delete", "9")
+	assert.Equal(t, 1, status)
+	assert.Equal(t, "httpbin delete: server answered 403: forbidden\n", stderr)
+	assert.Equal(t, []string{"DELETE /api/bins/1/requests/2", "DELETE /api/bins/1/requests", "DELETE /api/bins/9"}, deleted)
+}
+
+func Test_Usage(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	for _, args := range [][]string{{}, {"unknown"}, {"show", "1"}, {"create", "1"}, {"-unknown"}} {
+		status, _, stderr := run(t, server, "", args...)
+		assert.Equal(t, 2, status, args)
+		assert.Contains(t, stderr, "Usage: httpbin", args)
+	}
+	status, _, stderr := run(t, server, "", "show", "1", "x")
+	assert.Equal(t, 1, status)
+	assert.Equal(t, "httpbin show: invalid id \"x\"\n", stderr)
+}
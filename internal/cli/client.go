@@ -0,0 +1,264 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"app/internal/models"
+)
+
+// maxEventSize bounds the events read off streams, as they carry request
+// bodies.
+const maxEventSize = 64 << 20
+
+// ErrStreamEnded is returned when the server ends a stream, e.g. when it
+// shuts down.
+var ErrStreamEnded = errors.New("stream ended by the server")
+
+// APIError is returned when the server answers with an error.
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server answered %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("server answered %d: %s", e.Status, e.Message)
+}
+
+// Client calls the JSON API of a server.
+type Client struct {
+	// BaseUrl is the URL the server is reached at, e.g.
+	// http://localhost:3000.
+	BaseUrl string
+	// Token is sent as a bearer token when set. It owns the bins it
+	// creates, only it may change them.
+	Token string
+	HTTP  *http.Client
+}
+
+func NewClient(baseUrl, token string) *Client {
+	return &Client{
+		BaseUrl: strings.TrimSuffix(baseUrl, "/"),
+		Token:   token,
+		HTTP:    http.DefaultClient,
+	}
+}
+
+// CaptureUrl is the URL requests are captured by a bin at.
+func (c *Client) CaptureUrl(binId int64) string {
+	return fmt.Sprintf("%s/bin/%d", c.BaseUrl, binId)
+}
+
+func (c *Client) CreateBin(ctx context.Context) (int64, error) {
+	var created struct {
+		Id int64 `json:"id"`
+	}
+	err := c.call(ctx, http.MethodPost, "/api/bins", nil, &created)
+	return created.Id, err
+}
+
+func (c *Client) GetRequest(ctx context.Context, binId, requestId int64) (models.Request, error) {
+	var request models.Request
+	err := c.call(ctx, http.MethodGet, fmt.Sprintf("/api/bins/%d/requests/%d", binId, requestId), nil, &request)
+	return request, err
+}
+
+// EachRequest calls f with every request of a bin, newest first, following
+// the pages of the listing.
+func (c *Client) EachRequest(ctx context.Context, binId int64, f func(models.Request) error) error {
+	next := fmt.Sprintf("/api/bins/%d/requests", binId)
+	for next != "" {
+		response, err := c.send(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return err
+		}
+		var requests []models.Request
+		err = json.NewDecoder(response.Body).Decode(&requests)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, request := range requests {
+			if err := f(request); err != nil {
+				return err
+			}
+		}
+		next = nextLink(response.Header.Get("Link"))
+	}
+	return nil
+}
+
+// nextLink returns the target of the rel="next" link of a Link header.
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+		if ok && strings.Contains(params, `rel="next"`) {
+			return strings.Trim(strings.TrimSpace(target), "<>")
+		}
+	}
+	return ""
+}
+
+func (c *Client) ImportRequests(ctx context.Context, binId int64, requests []models.Request) error {
+	return c.call(ctx, http.MethodPost, fmt.Sprintf("/api/bins/%d/requests", binId), requests, nil)
+}
+
+func (c *Client) DeleteBin(ctx context.Context, binId int64) error {
+	return c.call(ctx, http.MethodDelete, fmt.Sprintf("/api/bins/%d", binId), nil, nil)
+}
+
+func (c *Client) ClearBin(ctx context.Context, binId int64) error {
+	return c.call(ctx, http.MethodDelete, fmt.Sprintf("/api/bins/%d/requests", binId), nil, nil)
+}
+
+func (c *Client) DeleteRequest(ctx context.Context, binId, requestId int64) error {
+	return c.call(ctx, http.MethodDelete, fmt.Sprintf("/api/bins/%d/requests/%d", binId, requestId), nil, nil)
+}
+
+// NewestRequestId returns the id of the newest request of a bin, zero when
+// it has none.
+func (c *Client) NewestRequestId(ctx context.Context, binId int64) (int64, error) {
+	var requests []models.Request
+	err := c.call(ctx, http.MethodGet, fmt.Sprintf("/api/bins/%d/requests?limit=1", binId), nil, &requests)
+	if err != nil || len(requests) == 0 {
+		return 0, err
+	}
+	return requests[0].Id, nil
+}
+
+// StreamRequests calls f with every request the bin captures until ctx is
+// done, f fails or the server ends the stream. Streams resuming after the
+// request lastId, zero for a bin which had none, start with the requests
+// captured since, unless lastId is negative.
+func (c *Client) StreamRequests(ctx context.Context, binId, lastId int64, f func(models.Request) error) error {
+	request, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("/api/bins/%d/stream", binId), nil)
+	if err != nil {
+		return err
+	}
+	if lastId >= 0 {
+		request.Header.Set("Last-Event-ID", strconv.FormatInt(lastId, 10))
+	}
+	response, err := c.do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(nil, maxEventSize)
+	var event string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// a blank line ends the event
+			if event == "request" && len(data) > 0 {
+				var request models.Request
+				if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &request); err != nil {
+					return err
+				}
+				if err := f(request); err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return ErrStreamEnded
+}
+
+// call sends a request with body encoded as JSON, and decodes the response
+// into v, unless nil.
+func (c *Client) call(ctx context.Context, method, path string, body any, v any) error {
+	response, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+func (c *Client) send(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	request, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(request)
+}
+
+// newRequest creates a request to the API with body encoded as JSON, unless
+// nil.
+func (c *Client) newRequest(ctx context.Context, method, path string, body any) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.BaseUrl+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return request, nil
+}
+
+// do sends a request, failing with an APIError on error statuses.
+func (c *Client) do(request *http.Request) (*http.Response, error) {
+	response, err := c.HTTP.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 400 {
+		defer response.Body.Close()
+		apiErr := APIError{Status: response.StatusCode}
+		var decoded struct {
+			Error string `json:"error"`
+		}
+		b, _ := io.ReadAll(io.LimitReader(response.Body, 1<<16))
+		if json.Unmarshal(b, &decoded) == nil {
+			apiErr.Message = decoded.Error
+		} else {
+			apiErr.Message = strings.TrimSpace(string(b))
+		}
+		return nil, apiErr
+	}
+	return response, nil
+}
@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"app/internal/models"
+)
+
+// hopHeaders are the headers of a connection rather than of a request,
+// which are not replayed.
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+	"Host", "Content-Length",
+}
+
+// writeSummary writes a request on a single line.
+func writeSummary(w io.Writer, request models.Request) error {
+	path := request.SubPath
+	if path == "" {
+		path = "/"
+	}
+	_, err := fmt.Fprintf(w, "%s  #%d  %-7s %s  %s  %dB\n",
+		request.RecievedAt.Local().Format(time.DateTime),
+		request.Id,
+		request.Method,
+		path,
+		request.ClientIp,
+		len(request.Body),
+	)
+	return err
+}
+
+// writeRequest writes a request as it was sent, with its headers and body.
+func writeRequest(w io.Writer, request models.Request) error {
+	headers, err := request.GetHeaders()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "# request %d of bin %d, received %s from %s\n",
+		request.Id, request.Bin, request.RecievedAt.Local().Format(time.RFC3339), request.ClientIp)
+	fmt.Fprintf(w, "%s %s %s\n", request.Method, request.RequestUri, request.Proto)
+	fmt.Fprintf(w, "Host: %s\n", request.Host)
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, value := range headers[name] {
+			fmt.Fprintf(w, "%s: %s\n", name, value)
+		}
+	}
+	fmt.Fprintln(w)
+	if request.Body != "" {
+		_, err = io.WriteString(w, request.Body)
+		if err == nil && !strings.HasSuffix(request.Body, "\n") {
+			_, err = fmt.Fprintln(w)
+		}
+	}
+	return err
+}
+
+// writeFull writes a request as writeRequest does, followed by a blank line
+// separating it from the next one.
+func writeFull(w io.Writer, request models.Request) error {
+	if err := writeRequest(w, request); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// writeJSONLine writes a request as exported, on a single line.
+func writeJSONLine(w io.Writer, request models.Request) error {
+	return json.NewEncoder(w).Encode(request)
+}
+
+// replayRequest builds a request sending a captured one to target, with
+// its sub-path appended to the path of target and its query.
+func replayRequest(ctx context.Context, request models.Request, target string) (*http.Request, error) {
+	targetUrl, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if targetUrl.Scheme != "http" && targetUrl.Scheme != "https" {
+		return nil, fmt.Errorf("invalid url %q", target)
+	}
+	targetUrl = targetUrl.JoinPath(request.SubPath)
+	if captured, err := url.ParseRequestURI(request.RequestUri); err == nil && captured.RawQuery != "" {
+		targetUrl.RawQuery = captured.RawQuery
+	}
+
+	replayed, err := http.NewRequestWithContext(ctx, request.Method, targetUrl.String(), strings.NewReader(request.Body))
+	if err != nil {
+		return nil, err
+	}
+	headers, err := request.GetHeaders()
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range headers {
+		if slices.Contains(hopHeaders, http.CanonicalHeaderKey(name)) {
+			continue
+		}
+		for _, value := range values {
+			replayed.Header.Add(name, value)
+		}
+	}
+	return replayed, nil
+}
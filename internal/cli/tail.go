@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"app/internal/models"
+)
+
+// Delays before reconnecting lost streams, doubled after every failed
+// attempt. Streams lasting longer than resetBackoffAfter start over from
+// minReconnectDelay. Variables for tests.
+var (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+	resetBackoffAfter = time.Minute
+)
+
+// formats write the requests tailed.
+var formats = map[string]func(io.Writer, models.Request) error{
+	"summary": writeSummary,
+	"full":    writeFull,
+	"json":    writeJSONLine,
+}
+
+// tailFilter selects the requests tailed. Zero valued fields do not filter.
+type tailFilter struct {
+	// methods are upper case.
+	methods []string
+	// path matches sub-paths containing it, as the filters of the API do.
+	path string
+}
+
+func (f tailFilter) match(request models.Request) bool {
+	if len(f.methods) > 0 && !slices.Contains(f.methods, strings.ToUpper(request.Method)) {
+		return false
+	}
+	return strings.Contains(request.SubPath, f.path)
+}
+
+func (c *command) tail(ctx context.Context) error {
+	flags := flag.NewFlagSet("tail", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	format := flags.String("format", "summary", "summary lines, full requests with their headers and body, or json lines")
+	methods := flags.String("method", "", "comma separated methods of the requests printed")
+	path := flags.String("path", "", "text the sub-path of the requests printed contains")
+	if err := flags.Parse(c.args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	write, ok := formats[*format]
+	if !ok {
+		return fmt.Errorf("invalid format %q, expected summary, full or json", *format)
+	}
+	filter := tailFilter{path: *path}
+	for _, method := range strings.Split(*methods, ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			filter.methods = append(filter.methods, strings.ToUpper(method))
+		}
+	}
+	c.args = flags.Args()
+	ids, err := c.ids(1, 1)
+	if err != nil {
+		return err
+	}
+
+	// resumed from the start, so that requests captured before the first
+	// one streamed are not missed when reconnecting
+	lastId, err := c.client.NewestRequestId(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+	delay := minReconnectDelay
+	for {
+		start := time.Now()
+		err := c.client.StreamRequests(ctx, ids[0], lastId, func(request models.Request) error {
+			lastId = request.Id
+			if !filter.match(request) {
+				return nil
+			}
+			if err := write(c.stdout, request); err != nil {
+				return writeError{err}
+			}
+			return nil
+		})
+		if ctx.Err() != nil || !reconnectable(err) {
+			return err
+		}
+
+		if time.Since(start) > resetBackoffAfter {
+			delay = minReconnectDelay
+		}
+		fmt.Fprintf(c.stderr, "httpbin tail: %s, reconnecting in %s\n", err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
+
+// writeError is an error writing the requests tailed.
+type writeError struct {
+	error
+}
+
+func (e writeError) Unwrap() error {
+	return e.error
+}
+
+// reconnectable reports whether a stream failing with err may succeed
+// again, unlike streams of missing bins.
+func reconnectable(err error) bool {
+	var apiErr APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Status >= 500 ||
+			apiErr.Status == http.StatusRequestTimeout ||
+			apiErr.Status == http.StatusTooManyRequests
+	}
+	// failing to write the requests is not fixed either
+	var writeErr writeError
+	return !errors.As(err, &writeErr)
+}
@@ -0,0 +1,277 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"app/internal/logging"
+	"app/internal/ratelimit"
+	"app/internal/services"
+)
+
+// Config is read from environment variables by LoadConfig.
+type Config struct {
+	// Addr is the address the HTTP server listens on, ADDR.
+	Addr string
+	// DbPath is the path of the sqlite database, DB_PATH.
+	DbPath string
+	// TrustedProxies are the networks of the proxies whose Forwarded and
+	// X-Forwarded-For headers are believed when resolving the IP of a
+	// client, TRUSTED_PROXIES, a comma separated list of addresses and CIDR
+	// prefixes.
+	TrustedProxies []netip.Prefix
+	// BinDomain has the requests made to {binId}.BinDomain captured by the
+	// bin on any path, BIN_DOMAIN, along with those made to /bin/{binId}.
+	// None when empty.
+	BinDomain string
+
+	// TLSAddr is the address the HTTPS server listens on, TLS_ADDR. HTTPS
+	// is only served when it is set, and plain HTTP is then only served
+	// when ADDR is not set to an empty value.
+	TLSAddr string
+	// TLSCertFile and TLSKeyFile are the PEM files of the served
+	// certificate, TLS_CERT_FILE and TLS_KEY_FILE. Without them a
+	// self-signed certificate for TLSHosts is served.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSCacheDir is where the self-signed certificate is kept across
+	// restarts, TLS_CACHE_DIR.
+	TLSCacheDir string
+	// TLSHosts are the host names and IPs of the self-signed certificate,
+	// TLS_HOSTS, comma separated.
+	TLSHosts []string
+	// TLSClientAuth asks clients for a certificate, TLS_CLIENT_AUTH, either
+	// "request" or "require". Client certificates are verified against the
+	// CAs of TLSClientCAFile, TLS_CLIENT_CA_FILE, when set.
+	TLSClientAuth   string
+	TLSClientCAFile string
+	// GrpcAddr is the address gRPC calls are served on over unencrypted
+	// HTTP/2, as well as HTTP/1.1, GRPC_ADDR. None when empty, while the
+	// HTTPS server serves them over HTTP/2.
+	GrpcAddr string
+	// CapturePorts are the ports allocated to bins capturing TCP
+	// connections and UDP datagrams, CAPTURE_PORTS, a range such as
+	// 40000-40099 or a single port. None when empty.
+	CapturePorts services.PortRange
+	// MailAddr is the address mails to bins are received on over SMTP,
+	// MAIL_ADDR, addressed to <bin>@MailDomain, MAIL_DOMAIN. None when
+	// empty.
+	MailAddr   string
+	MailDomain string
+	// DNSAddr is the address DNS queries for the names of bins are answered
+	// on over UDP and TCP, DNS_ADDR, <bin>.DNSZone and the names below it,
+	// DNS_ZONE. None when empty. A and AAAA queries are answered with
+	// DNSAnswers, DNS_ANSWER_IP, a comma separated list of IPv4 and IPv6
+	// addresses.
+	DNSAddr    string
+	DNSZone    string
+	DNSAnswers []netip.Addr
+
+	// LogLevel is the lowest level logged, LOG_LEVEL, one of debug, info,
+	// warn and error.
+	LogLevel slog.Level
+	// LogFormat is how log records are written, LOG_FORMAT, either text or
+	// json.
+	LogFormat string
+
+	// MinDiskFreeMB is the space, in megabytes, that must be left on the
+	// file system of the database for the app to report ready,
+	// MIN_DISK_FREE_MB.
+	MinDiskFreeMB uint64
+	// ShutdownDrain is how long the app reports not ready before it stops
+	// accepting connections on shutdown, SHUTDOWN_DRAIN, and ShutdownTimeout
+	// how long requests being served and WebSocket sessions are then waited
+	// for, SHUTDOWN_TIMEOUT.
+	ShutdownDrain   time.Duration
+	ShutdownTimeout time.Duration
+
+	// RateLimits are read from BIN_RATE_LIMIT, CLIENT_RATE_LIMIT,
+	// NEW_BIN_RATE_LIMIT and EMAIL_RATE_LIMIT, written as events/duration,
+	// e.g. 100/1s, or off.
+	RateLimits services.RateLimits
+
+	// SMTPAddr is the host:port of the mail server sending email
+	// notifications, SMTP_ADDR, as SMTPFrom, SMTP_FROM. Email targets are
+	// refused without it. SMTPUsername and SMTPPassword, SMTP_USERNAME and
+	// SMTP_PASSWORD, authenticate with the server when set.
+	// SMTPAllowedDomains, SMTP_ALLOWED_DOMAINS, are the domains email
+	// targets may send to, any when empty.
+	SMTPAddr           string
+	SMTPFrom           string
+	SMTPUsername       string
+	SMTPPassword       string
+	SMTPAllowedDomains []string
+	// NotifyPrivateNetworks lets webhook notifications reach loopback,
+	// private and link-local addresses, NOTIFY_PRIVATE_NETWORKS=true.
+	NotifyPrivateNetworks bool
+}
+
+func LoadConfig() (Config, error) {
+	config := Config{
+		Addr:      envOrDefault("ADDR", ":3000"),
+		DbPath:    envOrDefault("DB_PATH", "./database.db"),
+		BinDomain: os.Getenv("BIN_DOMAIN"),
+
+		TLSAddr:         os.Getenv("TLS_ADDR"),
+		TLSCertFile:     os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:      os.Getenv("TLS_KEY_FILE"),
+		TLSCacheDir:     envOrDefault("TLS_CACHE_DIR", "./tls"),
+		TLSHosts:        splitList(envOrDefault("TLS_HOSTS", "localhost,127.0.0.1")),
+		TLSClientAuth:   os.Getenv("TLS_CLIENT_AUTH"),
+		TLSClientCAFile: os.Getenv("TLS_CLIENT_CA_FILE"),
+		GrpcAddr:        os.Getenv("GRPC_ADDR"),
+		MailAddr:        os.Getenv("MAIL_ADDR"),
+		MailDomain:      envOrDefault("MAIL_DOMAIN", "localhost"),
+		DNSAddr:         os.Getenv("DNS_ADDR"),
+		DNSZone:         envOrDefault("DNS_ZONE", "localhost"),
+
+		SMTPAddr:           os.Getenv("SMTP_ADDR"),
+		SMTPFrom:           os.Getenv("SMTP_FROM"),
+		SMTPUsername:       os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:       os.Getenv("SMTP_PASSWORD"),
+		SMTPAllowedDomains: splitList(os.Getenv("SMTP_ALLOWED_DOMAINS")),
+	}
+
+	err := config.LogLevel.UnmarshalText([]byte(envOrDefault("LOG_LEVEL", "info")))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+	}
+	config.LogFormat, err = logging.ParseFormat(envOrDefault("LOG_FORMAT", "text"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid LOG_FORMAT: %w", err)
+	}
+
+	config.MinDiskFreeMB, err = strconv.ParseUint(envOrDefault("MIN_DISK_FREE_MB", "100"), 10, 64)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid MIN_DISK_FREE_MB: %w", err)
+	}
+	config.ShutdownDrain, err = time.ParseDuration(envOrDefault("SHUTDOWN_DRAIN", "5s"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid SHUTDOWN_DRAIN: %w", err)
+	}
+	config.ShutdownTimeout, err = time.ParseDuration(envOrDefault("SHUTDOWN_TIMEOUT", "30s"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid SHUTDOWN_TIMEOUT: %w", err)
+	}
+
+	for _, limit := range []struct {
+		name         string
+		defaultValue string
+		limit        *ratelimit.Limit
+	}{
+		{"BIN_RATE_LIMIT", "100/1s", &config.RateLimits.Bin},
+		{"CLIENT_RATE_LIMIT", "50/1s", &config.RateLimits.Client},
+		{"NEW_BIN_RATE_LIMIT", "60/1m", &config.RateLimits.NewBin},
+		{"EMAIL_RATE_LIMIT", "10/1h", &config.RateLimits.Email},
+	} {
+		*limit.limit, err = ratelimit.ParseLimit(envOrDefault(limit.name, limit.defaultValue))
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", limit.name, err)
+		}
+	}
+
+	config.NotifyPrivateNetworks, err = strconv.ParseBool(envOrDefault("NOTIFY_PRIVATE_NETWORKS", "false"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid NOTIFY_PRIVATE_NETWORKS: %w", err)
+	}
+	config.CapturePorts, err = parsePortRange(os.Getenv("CAPTURE_PORTS"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid CAPTURE_PORTS: %w", err)
+	}
+	for _, answer := range splitList(envOrDefault("DNS_ANSWER_IP", "127.0.0.1")) {
+		addr, err := netip.ParseAddr(answer)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid DNS_ANSWER_IP: %w", err)
+		}
+		config.DNSAnswers = append(config.DNSAnswers, addr.Unmap())
+	}
+	if config.SMTPAddr != "" && config.SMTPFrom == "" {
+		return Config{}, errors.New("SMTP_ADDR requires SMTP_FROM")
+	}
+
+	for _, proxy := range splitList(os.Getenv("TRUSTED_PROXIES")) {
+		prefix, err := parsePrefix(proxy)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+		}
+		config.TrustedProxies = append(config.TrustedProxies, prefix)
+	}
+
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return Config{}, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	switch config.TLSClientAuth {
+	case "", "request", "require":
+	default:
+		return Config{}, fmt.Errorf("invalid TLS_CLIENT_AUTH %q, expected request or require", config.TLSClientAuth)
+	}
+	if config.TLSClientCAFile != "" && config.TLSClientAuth == "" {
+		return Config{}, errors.New("TLS_CLIENT_CA_FILE requires TLS_CLIENT_AUTH")
+	}
+	if config.Addr == "" && config.TLSAddr == "" && config.GrpcAddr == "" {
+		return Config{}, errors.New("one of ADDR, TLS_ADDR and GRPC_ADDR must be set")
+	}
+
+	return config, nil
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func envOrDefault(name, defaultValue string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// parsePrefix parses a CIDR prefix, or a single address as the prefix
+// holding only that address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parsePortRange parses a range of ports, first-last, or a single port. The
+// empty string is the empty range.
+func parsePortRange(s string) (services.PortRange, error) {
+	if s == "" {
+		return services.PortRange{}, nil
+	}
+	first, last, isRange := strings.Cut(s, "-")
+	if !isRange {
+		last = first
+	}
+	var ports services.PortRange
+	var err error
+	if ports.First, err = strconv.Atoi(strings.TrimSpace(first)); err != nil {
+		return services.PortRange{}, err
+	}
+	if ports.Last, err = strconv.Atoi(strings.TrimSpace(last)); err != nil {
+		return services.PortRange{}, err
+	}
+	if ports.First < 1 || ports.Last > 65535 || ports.First > ports.Last {
+		return services.PortRange{}, fmt.Errorf("%q is not a range of ports between 1 and 65535", s)
+	}
+	return ports, nil
+}
@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"app/internal/models"
+)
+
+// maxImportSize bounds the body of imports, of up to
+// services.MaxImportedRequests requests.
+const maxImportSize = 64 << 20
+
+// maxRulesSize bounds the JSON of the rules of a bin.
+const maxRulesSize = 1 << 20
+
+// maxNotificationTargetsSize bounds the JSON of the notification targets
+// of a bin.
+const maxNotificationTargetsSize = 1 << 20
+
+// CreateBin creates a bin owned by the bearer token of the request, if any.
+func (c *Controllers) CreateBin(w http.ResponseWriter, r *http.Request) {
+	binId, err := c.services.CreateNewBin(r.Context(), callerOwner(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "creating bin", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/bins/%d/requests", binId))
+	writeJSON(w, http.StatusCreated, map[string]int64{"id": binId})
+}
+
+// GetRequests lists a page of the requests captured by a bin, newest first,
+// filtered by the same query parameters as the bin contents page. The next
+// page is linked in the Link header.
+func (c *Controllers) GetRequests(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	filter, err := requestFilterFromQuery(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	page, err := pageFromQuery(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	requests, err := c.services.GetRequestsInBin(r.Context(), binId, filter, page)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting requests", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+	if requests == nil {
+		requests = []models.Request{}
+	}
+
+	if next := nextPageUrl(r, page, requests); next != "" {
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next))
+	}
+
+	writeJSON(w, http.StatusOK, requests)
+}
+
+// GetRequest returns a single request captured by a bin.
+func (c *Controllers) GetRequest(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+	requestId, err := requestIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing request id: %s", err.Error())})
+		return
+	}
+
+	request, err := c.services.GetRequest(r.Context(), binId, requestId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting request", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, request)
+}
+
+// ImportRequests adds the requests in the body, as listed by GetRequests,
+// to a bin.
+func (c *Controllers) ImportRequests(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	var requests []models.Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize)).Decode(&requests); err != nil {
+		writeJSON(w, decodeStatus(err), map[string]string{"error": fmt.Sprintf("Error parsing requests: %s", err.Error())})
+		return
+	}
+
+	err = c.services.ImportRequests(r.Context(), callerOwner(r), binId, requests)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "importing requests", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]int{"imported": len(requests)})
+}
+
+func (c *Controllers) GetRules(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	rules, err := c.services.GetRules(r.Context(), binId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting rules", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+	if rules == nil {
+		rules = []models.Rule{}
+	}
+
+	writeJSON(w, http.StatusOK, rules)
+}
+
+// SetRules replaces the rules of a bin with the ordered list in the body.
+func (c *Controllers) SetRules(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	var rules []models.Rule
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRulesSize)).Decode(&rules); err != nil {
+		writeJSON(w, decodeStatus(err), map[string]string{"error": fmt.Sprintf("Error parsing rules: %s", err.Error())})
+		return
+	}
+
+	err = c.services.SetRules(r.Context(), callerOwner(r), binId, rules)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "setting rules", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	rules, err = c.services.GetRules(r.Context(), binId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting rules", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+	if rules == nil {
+		rules = []models.Rule{}
+	}
+
+	writeJSON(w, http.StatusOK, rules)
+}
+
+// GetNotificationTargets lists the notification targets of a bin, to its
+// owner.
+func (c *Controllers) GetNotificationTargets(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	targets, err := c.services.GetNotificationTargets(r.Context(), callerOwner(r), binId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting notification targets", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+	if targets == nil {
+		targets = []models.NotificationTarget{}
+	}
+
+	writeJSON(w, http.StatusOK, targets)
+}
+
+// SetNotificationTargets replaces the notification targets of a bin with
+// the list in the body.
+func (c *Controllers) SetNotificationTargets(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	var targets []models.NotificationTarget
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxNotificationTargetsSize)).Decode(&targets); err != nil {
+		writeJSON(w, decodeStatus(err), map[string]string{"error": fmt.Sprintf("Error parsing notification targets: %s", err.Error())})
+		return
+	}
+
+	caller := callerOwner(r)
+	err = c.services.SetNotificationTargets(r.Context(), caller, binId, targets)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "setting notification targets", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	targets, err = c.services.GetNotificationTargets(r.Context(), caller, binId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting notification targets", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+	if targets == nil {
+		targets = []models.NotificationTarget{}
+	}
+
+	writeJSON(w, http.StatusOK, targets)
+}
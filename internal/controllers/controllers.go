@@ -2,23 +2,57 @@ package controllers
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"app/internal/models"
+	"app/internal/services"
 	"app/internal/templates"
+	"app/internal/websocket"
+	"app/internal/wire"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type Services interface {
-	CreateNewBin() (int64, error)
-	LogRequest(request models.Request) error
-	GetRequestsInBin(binId int64) ([]models.Request, error)
+	CreateNewBin(ctx context.Context, owner string) (int64, error)
+	LogRequest(ctx context.Context, request models.Request) (models.Response, error)
+	AdmitRequest(ctx context.Context, request models.Request) error
+	GetBin(ctx context.Context, binId int64) (models.Bin, error)
+	GetRequestsInBin(ctx context.Context, binId int64, filter models.RequestFilter, page models.Page) ([]models.Request, error)
+	GetRequest(ctx context.Context, binId, requestId int64) (models.Request, error)
+	ImportRequests(ctx context.Context, caller string, binId int64, requests []models.Request) error
+	StreamRequests(ctx context.Context, binId, lastId int64) (<-chan models.Request, error)
+	SetRules(ctx context.Context, caller string, binId int64, rules []models.Rule) error
+	GetRules(ctx context.Context, binId int64) ([]models.Rule, error)
+	DeleteRequest(ctx context.Context, caller string, binId, requestId int64) error
+	ClearBin(ctx context.Context, caller string, binId int64) error
+	DeleteBin(ctx context.Context, caller string, binId int64) error
+	SetNotificationTargets(ctx context.Context, caller string, binId int64, targets []models.NotificationTarget) error
+	GetNotificationTargets(ctx context.Context, caller string, binId int64) ([]models.NotificationTarget, error)
+	OpenWebSocket(ctx context.Context, request models.Request) (*services.WebSocketSession, error)
+	GetFrames(ctx context.Context, binId, requestId int64) ([]models.Frame, error)
+	SetWebSocketScript(ctx context.Context, caller string, binId int64, script models.WebSocketScript) error
+	GetWebSocketScript(ctx context.Context, binId int64) (models.WebSocketScript, error)
+	GetGrpcMessages(ctx context.Context, binId, requestId int64) ([]models.GrpcMessage, error)
+	SetGrpcSettings(ctx context.Context, caller string, binId int64, settings models.GrpcSettings) error
+	GetGrpcSettings(ctx context.Context, binId int64) (models.GrpcSettings, error)
+	AllocateCapturePort(ctx context.Context, caller string, binId int64, protocol string) (models.CapturePort, error)
+	GetCapturePorts(ctx context.Context, binId int64) ([]models.CapturePort, error)
+	ReleaseCapturePort(ctx context.Context, caller string, binId int64, protocol string, port int) error
+	GetMailMessage(ctx context.Context, binId, requestId int64) (models.MailMessage, error)
+	DiffRequests(ctx context.Context, binId, requestId, otherBinId, otherRequestId int64) (models.RequestDiff, error)
+	SetBinSchema(ctx context.Context, caller string, binId int64, schema models.BinSchema) error
+	GetBinSchema(ctx context.Context, binId int64) (models.BinSchema, error)
+	SetBinRateLimit(ctx context.Context, caller string, binId int64, limit string) error
+	GetBinRateLimit(ctx context.Context, binId int64) (string, error)
 }
 
 type Controllers struct {
@@ -38,20 +72,19 @@ func NewControllers(deps *Deps) *Controllers {
 func (c *Controllers) Index(w http.ResponseWriter, r *http.Request) {
 	component := wrapComponentTemplate(templates.Intro(), r)
 
-	err := component.Render(context.Background(), w)
+	err := component.Render(r.Context(), w)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(r.Context(), "rendering template", "error", err)
 	}
 
 	w.Header().Set("Content-Type", "text/html")
 }
 
 func (c *Controllers) NewBin(w http.ResponseWriter, r *http.Request) {
-	binId, err := c.services.CreateNewBin()
+	binId, err := c.services.CreateNewBin(r.Context(), callerOwner(r))
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		slog.ErrorContext(r.Context(), "creating bin", "error", err)
+		writeError(w, err)
 		return
 	}
 	component := wrapComponentTemplate(
@@ -59,86 +92,247 @@ func (c *Controllers) NewBin(w http.ResponseWriter, r *http.Request) {
 		r,
 	)
 
-	err = component.Render(context.Background(), w)
+	err = component.Render(r.Context(), w)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(r.Context(), "rendering template", "error", err)
 	}
 
 	w.Header().Set("Content-Type", "text/html")
 }
 
 func (c *Controllers) LogRequest(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("Error reading request body: %s", err.Error())))
-		return
-	}
-
 	urlBinId := chi.URLParam(r, "binId")
 	binId, err := strconv.ParseInt(urlBinId, 10, 64)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(r.Context(), "parsing bin id", "error", err)
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(fmt.Sprintf("Error parsing bin id: %s", err.Error())))
 		return
 	}
+	subPath := chi.URLParam(r, "*")
+	if subPath != "" {
+		subPath = "/" + subPath
+	}
 	reqToLog := models.Request{
-		Bin:        binId,
-		RecievedAt: time.Now(),
-		Headers:    string(body),
-		Body:       string(body),
-		Host:       r.Host,
-		RemoteAddr: r.RemoteAddr,
-		RequestUri: r.RequestURI,
-		Method:     r.Method,
+		Bin:              binId,
+		RecievedAt:       time.Now(),
+		Host:             r.Host,
+		RemoteAddr:       r.RemoteAddr,
+		RequestUri:       r.RequestURI,
+		Method:           r.Method,
+		SubPath:          subPath,
+		Proto:            r.Proto,
+		ContentLength:    r.ContentLength,
+		TransferEncoding: strings.Join(r.TransferEncoding, ", "),
 	}
 	reqToLog.SetHeaders(r.Header)
 
-	err = c.services.LogRequest(reqToLog)
+	// flooding clients are refused before their bodies are read
+	err = c.services.AdmitRequest(r.Context(), reqToLog)
 	if err != nil {
-		log.Println(err)
+		writeError(w, err)
+		return
+	}
+
+	if websocket.IsUpgrade(r) {
+		c.captureWebSocket(w, r, reqToLog)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "reading request body", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Error reading request body: %s", err.Error())))
+		return
+	}
+	reqToLog.Body = string(body)
+	// the body has been read, so trailers are known
+	reqToLog.Trailers = r.Trailer
+	setConnectionDetails(r, &reqToLog)
+
+	response, err := c.services.LogRequest(r.Context(), reqToLog)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "logging request", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
+
+	for key, value := range response.Headers {
+		w.Header().Set(key, value)
+	}
+	for key := range response.Trailers {
+		w.Header().Add("Trailer", key)
+	}
+	w.WriteHeader(response.Status)
+	w.Write([]byte(response.Body))
+	for key, value := range response.Trailers {
+		w.Header().Set(key, value)
+	}
+}
+
+// setConnectionDetails sets the TLS details of a request, and how it was
+// read off the wire, once its body has been read.
+func setConnectionDetails(r *http.Request, request *models.Request) {
+	if r.TLS != nil {
+		request.TLSVersion = tls.VersionName(r.TLS.Version)
+		request.TLSCipherSuite = tls.CipherSuiteName(r.TLS.CipherSuite)
+		request.TLSServerName = r.TLS.ServerName
+		if len(r.TLS.PeerCertificates) > 0 {
+			request.TLSClientSubject = r.TLS.PeerCertificates[0].Subject.String()
+		}
+	}
+	if record, ok := wire.FromContext(r.Context()); ok {
+		request.RawHead = record.Head
+		request.RawChunkSizes = record.ChunkSizes
+		request.RawTrailers = record.Trailers
+	}
 }
 
 func (c *Controllers) ViewBinContents(w http.ResponseWriter, r *http.Request) {
-	log.Printf("should print view bin contents: %+v", r)
 	urlBinId := chi.URLParam(r, "binId")
 	binId, err := strconv.ParseInt(urlBinId, 10, 64)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(r.Context(), "parsing bin id", "error", err)
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(fmt.Sprintf("Error parsing bin id: %s", err.Error())))
 		return
 	}
 
-	requests, err := c.services.GetRequestsInBin(binId)
+	filter, err := requestFilterFromQuery(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "parsing request filter", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	page, err := pageFromQuery(r)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(r.Context(), "parsing page", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	requests, err := c.services.GetRequestsInBin(r.Context(), binId, filter, page)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting requests", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	for _, request := range requests {
-		log.Printf("request: %+v", request)
+	// requests may be captured for bins that do not exist, which are
+	// shown empty
+	bin, err := c.services.GetBin(r.Context(), binId)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "getting bin", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	ports, err := c.services.GetCapturePorts(r.Context(), binId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting capture ports", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
 	}
 
 	reqParams := templates.ViewBinParams{
-		BinId:    strconv.FormatInt(binId, 10),
-		Hostname: r.Host,
-		Requests: requests,
+		BinId:       strconv.FormatInt(binId, 10),
+		Dropped:     bin.Dropped,
+		Ports:       ports,
+		Hostname:    r.Host,
+		Requests:    requests,
+		Filter:      filter,
+		NextPageUrl: nextPageUrl(r, page, requests),
 	}
 	component := templates.Layout(templates.ViewBinContents(reqParams))
-	log.Printf("should print view bin html: %+v", component)
+	switch r.Header.Get("HX-Target") {
+	case "request-list":
+		// the filter bar only swaps the list of requests
+		component = templates.RequestList(reqParams)
+	case "next-page":
+		// scrolling to the end of the list appends the next page
+		component = templates.RequestPage(reqParams)
+	}
+
+	err = component.Render(r.Context(), w)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "rendering template", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+}
+
+// ViewRequest shows the full detail page of a single captured request.
+func (c *Controllers) ViewRequest(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "parsing bin id", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Error parsing bin id: %s", err.Error())))
+		return
+	}
+	requestId, err := requestIdParam(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "parsing request id", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Error parsing request id: %s", err.Error())))
+		return
+	}
+
+	request, err := c.services.GetRequest(r.Context(), binId, requestId)
+	if errors.Is(err, models.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting request", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	frames, err := c.services.GetFrames(r.Context(), binId, requestId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting frames", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var messages []models.GrpcMessage
+	if services.IsGrpcCall(request) {
+		messages, err = c.services.GetGrpcMessages(r.Context(), binId, requestId)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "getting grpc messages", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	var mail models.MailMessage
+	if request.IsMail() {
+		mail, err = c.services.GetMailMessage(r.Context(), binId, requestId)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "getting mail", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	component := wrapComponentTemplate(templates.RequestDetail(request, frames, messages, mail), r)
 
-	err = component.Render(context.Background(), w)
+	err = component.Render(r.Context(), w)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(r.Context(), "rendering template", "error", err)
 	}
 
 	w.Header().Set("Content-Type", "text/html")
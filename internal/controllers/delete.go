@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"app/internal/templates"
+)
+
+// The delete handlers serve both the htmx buttons of the viewer and the
+// API. htmx requests are answered with the markup replacing what was
+// deleted, API requests with no content.
+
+// DeleteRequest deletes a single request of a bin.
+func (c *Controllers) DeleteRequest(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeDeleteError(w, r, http.StatusBadRequest, fmt.Errorf("Error parsing bin id: %w", err))
+		return
+	}
+	requestId, err := requestIdParam(r)
+	if err != nil {
+		writeDeleteError(w, r, http.StatusBadRequest, fmt.Errorf("Error parsing request id: %w", err))
+		return
+	}
+
+	err = c.services.DeleteRequest(r.Context(), callerOwner(r), binId, requestId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "deleting request", "error", err)
+		writeDeleteError(w, r, errorStatus(err), err)
+		return
+	}
+
+	switch {
+	case !isHtmxRequest(r):
+		w.WriteHeader(http.StatusNoContent)
+	case r.Header.Get("HX-Target") == "request-detail":
+		// the detail page of the request is gone
+		w.Header().Set("HX-Redirect", fmt.Sprintf("/bin/%d/contents", binId))
+	default:
+		// the list item is swapped for nothing
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// ClearBin deletes all requests of a bin.
+func (c *Controllers) ClearBin(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeDeleteError(w, r, http.StatusBadRequest, fmt.Errorf("Error parsing bin id: %w", err))
+		return
+	}
+
+	err = c.services.ClearBin(r.Context(), callerOwner(r), binId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "clearing bin", "error", err)
+		writeDeleteError(w, r, errorStatus(err), err)
+		return
+	}
+
+	if !isHtmxRequest(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	component := templates.RequestList(templates.ViewBinParams{
+		BinId:    strconv.FormatInt(binId, 10),
+		Hostname: r.Host,
+	})
+	err = component.Render(r.Context(), w)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "rendering template", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+}
+
+// DeleteBin deletes a bin with its requests and rules.
+func (c *Controllers) DeleteBin(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeDeleteError(w, r, http.StatusBadRequest, fmt.Errorf("Error parsing bin id: %w", err))
+		return
+	}
+
+	err = c.services.DeleteBin(r.Context(), callerOwner(r), binId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "deleting bin", "error", err)
+		writeDeleteError(w, r, errorStatus(err), err)
+		return
+	}
+
+	if !isHtmxRequest(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("HX-Redirect", "/")
+}
+
+func writeDeleteError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if !isHtmxRequest(r) {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(err.Error()))
+}
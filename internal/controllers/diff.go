@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"app/internal/models"
+	"app/internal/templates"
+)
+
+// DiffRequests compares a request to the one of the query parameters bin,
+// the same bin by default, and request.
+func (c *Controllers) DiffRequests(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+	requestId, err := requestIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing request id: %s", err.Error())})
+		return
+	}
+	otherBinId, otherRequestId, err := diffedRequestFromQuery(r, binId)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	diff, err := c.services.DiffRequests(r.Context(), binId, requestId, otherBinId, otherRequestId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "diffing requests", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, diff)
+}
+
+// ViewRequestDiff shows the differences between a request and the one of
+// the query parameters bin and request, as DiffRequests.
+func (c *Controllers) ViewRequestDiff(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Error parsing bin id: %s", err.Error())))
+		return
+	}
+	requestId, err := requestIdParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Error parsing request id: %s", err.Error())))
+		return
+	}
+	otherBinId, otherRequestId, err := diffedRequestFromQuery(r, binId)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	diff, err := c.services.DiffRequests(r.Context(), binId, requestId, otherBinId, otherRequestId)
+	if err != nil {
+		if !errors.Is(err, models.ErrNotFound) {
+			slog.ErrorContext(r.Context(), "diffing requests", "error", err)
+		}
+		writeError(w, err)
+		return
+	}
+
+	component := wrapComponentTemplate(templates.RequestDiff(diff), r)
+
+	err = component.Render(r.Context(), w)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "rendering template", "error", err)
+	}
+}
+
+// diffedRequestFromQuery reads the bin and request a request is compared to
+// from the query parameters bin, binId when missing, and request.
+func diffedRequestFromQuery(r *http.Request, binId int64) (int64, int64, error) {
+	query := r.URL.Query()
+	otherBinId := binId
+	if bin := query.Get("bin"); bin != "" {
+		var err error
+		if otherBinId, err = strconv.ParseInt(bin, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("Error parsing bin: %w", err)
+		}
+	}
+	otherRequestId, err := strconv.ParseInt(query.Get("request"), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Error parsing request: %w", err)
+	}
+	return otherBinId, otherRequestId, nil
+}
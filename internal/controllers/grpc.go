@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"app/internal/models"
+	"app/internal/services"
+)
+
+// maxGrpcSettingsSize bounds the JSON of gRPC settings, whose descriptor
+// set is base64 encoded.
+const maxGrpcSettingsSize = 2 * services.MaxDescriptorSet
+
+// GetGrpcMessages lists the messages of the gRPC call captured as a
+// request, decoded with the descriptor set of the bin.
+func (c *Controllers) GetGrpcMessages(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+	requestId, err := requestIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing request id: %s", err.Error())})
+		return
+	}
+
+	messages, err := c.services.GetGrpcMessages(r.Context(), binId, requestId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting grpc messages", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+	if messages == nil {
+		messages = []models.GrpcMessage{}
+	}
+
+	writeJSON(w, http.StatusOK, messages)
+}
+
+// GetGrpcSettings returns how a bin answers the gRPC calls it captures.
+func (c *Controllers) GetGrpcSettings(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	settings, err := c.services.GetGrpcSettings(r.Context(), binId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting grpc settings", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// SetGrpcSettings replaces how a bin answers the gRPC calls it captures with
+// the settings in the body.
+func (c *Controllers) SetGrpcSettings(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	var settings models.GrpcSettings
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGrpcSettingsSize)).Decode(&settings); err != nil {
+		writeJSON(w, decodeStatus(err), map[string]string{"error": fmt.Sprintf("Error parsing grpc settings: %s", err.Error())})
+		return
+	}
+
+	err = c.services.SetGrpcSettings(r.Context(), callerOwner(r), binId, settings)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "setting grpc settings", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, settings)
+}
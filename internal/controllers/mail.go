@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"app/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GetMailMessage returns the mail captured as a request, with its bodies
+// and attachments.
+func (c *Controllers) GetMailMessage(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+	requestId, err := requestIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing request id: %s", err.Error())})
+		return
+	}
+
+	message, err := c.services.GetMailMessage(r.Context(), binId, requestId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting mail", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+	if message.Attachments == nil {
+		message.Attachments = []models.MailAttachment{}
+	}
+
+	writeJSON(w, http.StatusOK, message)
+}
+
+// DownloadAttachment serves an attachment of a captured mail, by its index
+// among the attachments.
+func (c *Controllers) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Error parsing bin id: %s", err.Error())))
+		return
+	}
+	requestId, err := requestIdParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Error parsing request id: %s", err.Error())))
+		return
+	}
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Error parsing attachment index: %s", err.Error())))
+		return
+	}
+
+	message, err := c.services.GetMailMessage(r.Context(), binId, requestId)
+	if err != nil {
+		if !errors.Is(err, models.ErrNotFound) {
+			slog.ErrorContext(r.Context(), "getting mail", "error", err)
+		}
+		writeError(w, err)
+		return
+	}
+	if index < 0 || index >= len(message.Attachments) {
+		http.NotFound(w, r)
+		return
+	}
+	attachment := message.Attachments[index]
+
+	// attachments are never rendered by the browser, as they are written by
+	// whoever sent the mail
+	filename := attachment.Filename
+	if filename == "" {
+		filename = "attachment-" + strconv.Itoa(index)
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(attachment.Content)
+}
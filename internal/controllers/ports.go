@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"app/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxCapturePortSize bounds the JSON of the port to allocate.
+const maxCapturePortSize = 1 << 10
+
+// GetCapturePorts lists the TCP and UDP ports allocated to a bin.
+func (c *Controllers) GetCapturePorts(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	ports, err := c.services.GetCapturePorts(r.Context(), binId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting capture ports", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+	if ports == nil {
+		ports = []models.CapturePort{}
+	}
+
+	writeJSON(w, http.StatusOK, ports)
+}
+
+// AllocateCapturePort allocates a free port of the protocol in the body,
+// {"protocol": "tcp"} or {"protocol": "udp"}, to a bin.
+func (c *Controllers) AllocateCapturePort(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	var body struct {
+		Protocol string `json:"protocol"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCapturePortSize)).Decode(&body); err != nil {
+		writeJSON(w, decodeStatus(err), map[string]string{"error": fmt.Sprintf("Error parsing capture port: %s", err.Error())})
+		return
+	}
+
+	port, err := c.services.AllocateCapturePort(r.Context(), callerOwner(r), binId, body.Protocol)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "allocating capture port", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, port)
+}
+
+// ReleaseCapturePort stops capturing a port of a bin.
+func (c *Controllers) ReleaseCapturePort(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+	port, err := strconv.Atoi(chi.URLParam(r, "port"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing port: %s", err.Error())})
+		return
+	}
+
+	err = c.services.ReleaseCapturePort(r.Context(), callerOwner(r), binId, chi.URLParam(r, "protocol"), port)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "releasing capture port", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
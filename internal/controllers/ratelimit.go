@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// maxRateLimitSize bounds the JSON of the rate limit of a bin.
+const maxRateLimitSize = 1 << 10
+
+// binRateLimit is the rate limit of a bin as JSON, as events/duration, empty
+// for the default limit.
+type binRateLimit struct {
+	Limit string `json:"limit"`
+}
+
+// GetBinRateLimit returns the rate limit of a bin.
+func (c *Controllers) GetBinRateLimit(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	limit, err := c.services.GetBinRateLimit(r.Context(), binId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting bin rate limit", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, binRateLimit{Limit: limit})
+}
+
+// SetBinRateLimit sets the rate limit of a bin from the body, e.g.
+// {"limit": "10/1s"}, or {"limit": ""} for the default limit.
+func (c *Controllers) SetBinRateLimit(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	var body binRateLimit
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRateLimitSize)).Decode(&body); err != nil {
+		writeJSON(w, decodeStatus(err), map[string]string{"error": fmt.Sprintf("Error parsing rate limit: %s", err.Error())})
+		return
+	}
+
+	err = c.services.SetBinRateLimit(r.Context(), callerOwner(r), binId, body.Limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "setting bin rate limit", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"app/internal/models"
+	"app/internal/services"
+)
+
+// maxBinSchemaSize bounds the JSON of a bin schema, whose documents are up
+// to services.MaxSchemaSize once set.
+const maxBinSchemaSize = 2 * services.MaxSchemaSize
+
+// GetBinSchema returns the schema the request bodies of a bin are
+// validated against.
+func (c *Controllers) GetBinSchema(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	schema, err := c.services.GetBinSchema(r.Context(), binId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting bin schema", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, schema)
+}
+
+// SetBinSchema replaces the schema the request bodies of a bin are
+// validated against with the one in the body.
+func (c *Controllers) SetBinSchema(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	var schema models.BinSchema
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBinSchemaSize)).Decode(&schema); err != nil {
+		writeJSON(w, decodeStatus(err), map[string]string{"error": fmt.Sprintf("Error parsing bin schema: %s", err.Error())})
+		return
+	}
+
+	err = c.services.SetBinSchema(r.Context(), callerOwner(r), binId, schema)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "setting bin schema", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, schema)
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"app/internal/services"
+)
+
+// streamKeepAlive is how often idle streams are written to, so proxies do
+// not time them out.
+const streamKeepAlive = 15 * time.Second
+
+// StreamRequests streams the requests a bin captures as server-sent events,
+// each a "request" event whose data is the request as returned by
+// GetRequest, and whose id is the id of the request. Clients reconnecting
+// with the Last-Event-ID header are first sent the requests they missed,
+// all those of the bin when it is 0.
+func (c *Controllers) StreamRequests(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	lastId := int64(services.NoResume)
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		lastId, err = strconv.ParseInt(header, 10, 64)
+		if err == nil && lastId < 0 {
+			err = fmt.Errorf("negative id %d", lastId)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing Last-Event-ID: %s", err.Error())})
+			return
+		}
+	}
+
+	requests, err := c.services.StreamRequests(r.Context(), binId, lastId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "streaming requests", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx would buffer the events otherwise
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		slog.ErrorContext(r.Context(), "flushing stream", "error", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case request, ok := <-requests:
+			if !ok {
+				// the client reconnects to catch up, or to another server
+				return
+			}
+			data, err := json.Marshal(request)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "encoding request", "error", err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: request\ndata: %s\n\n", request.Id, data)
+			if err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
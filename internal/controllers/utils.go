@@ -1,10 +1,20 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/a-h/templ"
+	"github.com/go-chi/chi/v5"
 
+	"app/internal/models"
+	"app/internal/services"
 	"app/internal/templates"
 )
 
@@ -18,3 +28,163 @@ func wrapComponentTemplate(component templ.Component, r *http.Request) templ.Com
 	}
 	return component
 }
+
+func binIdParam(r *http.Request) (int64, error) {
+	return strconv.ParseInt(chi.URLParam(r, "binId"), 10, 64)
+}
+
+func requestIdParam(r *http.Request) (int64, error) {
+	return strconv.ParseInt(chi.URLParam(r, "requestId"), 10, 64)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("writing json response", "error", err)
+	}
+}
+
+// decodeStatus is the status of the response to a body failing to be
+// decoded: bodies over their limit are too large, others bad requests.
+func decodeStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// writeJSONError responds with the error as JSON, see errorStatus.
+func writeJSONError(w http.ResponseWriter, err error) {
+	setRetryAfter(w, err)
+	writeJSON(w, errorStatus(err), map[string]string{"error": err.Error()})
+}
+
+// errorStatus is the status of the response to a failed service call.
+// Errors from rejected input are reported as bad requests, missing bins and
+// requests as not found, denied changes as forbidden, exhausted capture
+// ports as unavailable and everything else as an internal error.
+func errorStatus(err error) int {
+	var validationErr services.ValidationError
+	var rateLimitErr services.RateLimitError
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest
+	case errors.As(err, &rateLimitErr):
+		return http.StatusTooManyRequests
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrNoFreePort):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// writeError responds with the error as text, telling clients refused by a
+// rate limit when to retry.
+func writeError(w http.ResponseWriter, err error) {
+	setRetryAfter(w, err)
+	w.WriteHeader(errorStatus(err))
+	w.Write([]byte(err.Error()))
+}
+
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var rateLimitErr services.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		w.Header().Set("Retry-After", strconv.FormatInt(rateLimitErr.RetryAfterSeconds(), 10))
+	}
+}
+
+// callerOwner returns the owner key of the bearer token of the request, if
+// any.
+func callerOwner(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return services.TokenOwner(strings.TrimSpace(token))
+}
+
+// requestFilterFromQuery reads a request filter from the query parameters
+// method, path, header ("Name: value"), body, remoteAddr, from and to. Times
+// are RFC 3339 or, as sent by datetime-local inputs, UTC minutes.
+func requestFilterFromQuery(r *http.Request) (models.RequestFilter, error) {
+	query := r.URL.Query()
+	filter := models.RequestFilter{
+		Method:     strings.TrimSpace(query.Get("method")),
+		Path:       query.Get("path"),
+		Body:       query.Get("body"),
+		RemoteAddr: query.Get("remoteAddr"),
+		Validation: query.Get("validation"),
+	}
+
+	name, value, _ := strings.Cut(query.Get("header"), ":")
+	filter.HeaderName = strings.TrimSpace(name)
+	filter.HeaderValue = strings.TrimSpace(value)
+
+	switch filter.Validation {
+	case "", models.Passed, models.Failed, models.NotValidated:
+	default:
+		return models.RequestFilter{}, fmt.Errorf("invalid validation: %q", filter.Validation)
+	}
+
+	var err error
+	if filter.From, err = parseFilterTime(query.Get("from")); err != nil {
+		return models.RequestFilter{}, fmt.Errorf("invalid from: %w", err)
+	}
+	if filter.To, err = parseFilterTime(query.Get("to")); err != nil {
+		return models.RequestFilter{}, fmt.Errorf("invalid to: %w", err)
+	}
+
+	return filter, nil
+}
+
+func parseFilterTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02T15:04", value)
+}
+
+// pageFromQuery reads the page of requests to list from the query
+// parameters before (a cursor) and limit.
+func pageFromQuery(r *http.Request) (models.Page, error) {
+	query := r.URL.Query()
+	page := models.Page{Limit: services.DefaultPageSize}
+
+	if before := query.Get("before"); before != "" {
+		cursor, err := models.ParseCursor(before)
+		if err != nil {
+			return models.Page{}, err
+		}
+		page.Before = cursor
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 {
+			return models.Page{}, fmt.Errorf("invalid limit %q", limit)
+		}
+		page.Limit = min(l, services.MaxPageSize)
+	}
+
+	return page, nil
+}
+
+// nextPageUrl returns the url of the page following a full page of
+// requests, or an empty string when there are no more requests.
+func nextPageUrl(r *http.Request, page models.Page, requests []models.Request) string {
+	if len(requests) < page.Limit {
+		return ""
+	}
+
+	query := r.URL.Query()
+	query.Set("before", requests[len(requests)-1].Cursor().String())
+	return r.URL.Path + "?" + query.Encode()
+}
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"app/internal/models"
+	"app/internal/services"
+	"app/internal/websocket"
+	"app/internal/wire"
+)
+
+// webSocketIdleTimeout closes the WebSocket sessions whose client sent
+// nothing for that long.
+const webSocketIdleTimeout = 5 * time.Minute
+
+// maxWebSocketScriptSize bounds the JSON of the WebSocket script of a bin.
+const maxWebSocketScriptSize = 1 << 20
+
+// captureWebSocket upgrades a request made to a bin to a WebSocket, whose
+// handshake is captured as the request, and whose messages are recorded and
+// answered until either side closes it.
+func (c *Controllers) captureWebSocket(w http.ResponseWriter, r *http.Request, request models.Request) {
+	ctx := r.Context()
+	setConnectionDetails(r, &request)
+	session, err := c.services.OpenWebSocket(ctx, request)
+	if err != nil {
+		slog.ErrorContext(ctx, "capturing websocket session", "error", err)
+		writeError(w, err)
+		return
+	}
+	defer session.End()
+
+	conn, err := websocket.Upgrade(w, r, services.MaxWebSocketMessage)
+	if errors.Is(err, websocket.ErrNotWebSocket) {
+		// the invalid handshake is still captured
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Error upgrading to websocket: %s", err.Error())))
+		session.Answered(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "upgrading to websocket", "error", err)
+		session.Answered(0)
+		return
+	}
+	session.Answered(http.StatusSwitchingProtocols)
+	wire.Hijacked(ctx)
+	defer conn.Close(websocket.CloseNormal, "")
+	// the context of the request is canceled as soon as the connection
+	// closes, while its close is still to be recorded
+	ctx = context.WithoutCancel(ctx)
+
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-session.Done():
+			conn.Close(websocket.CloseGoingAway, "server shutting down")
+		case <-closed:
+		}
+	}()
+
+	greetings, err := session.Open(ctx)
+	if err != nil {
+		c.closeWebSocket(r, conn, session, err)
+		return
+	}
+	for _, frame := range greetings {
+		if err := conn.WriteMessage(frame.Kind, frame.Payload); err != nil {
+			return
+		}
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(webSocketIdleTimeout))
+		kind, message, err := conn.ReadMessage()
+		var closeErr websocket.CloseError
+		var protocolErr websocket.ProtocolError
+		switch {
+		case errors.As(err, &closeErr):
+			session.Closed(ctx, services.FrameIn, closeErr.Code, closeErr.Reason)
+			return
+		case errors.As(err, &protocolErr):
+			session.Closed(ctx, services.FrameOut, protocolErr.Code, protocolErr.Reason)
+			return
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			conn.Close(websocket.CloseGoingAway, "idle")
+			session.Closed(ctx, services.FrameOut, websocket.CloseGoingAway, "idle")
+			return
+		case err != nil:
+			select {
+			case <-session.Done():
+				session.Closed(ctx, services.FrameOut, websocket.CloseGoingAway, "server shutting down")
+			default:
+				// the client went away without closing
+			}
+			return
+		}
+
+		replies, err := session.Receive(ctx, kind, message)
+		for _, frame := range replies {
+			if err := conn.WriteMessage(frame.Kind, frame.Payload); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			c.closeWebSocket(r, conn, session, err)
+			return
+		}
+	}
+}
+
+// closeWebSocket closes a session which failed to be recorded.
+func (c *Controllers) closeWebSocket(r *http.Request, conn *websocket.Conn, session *services.WebSocketSession, err error) {
+	if errors.Is(err, services.ErrSessionFull) {
+		conn.Close(websocket.ClosePolicyViolation, "too many messages")
+		return
+	}
+	slog.ErrorContext(r.Context(), "recording websocket frame", "request", session.Request().Id, "error", err)
+	conn.Close(websocket.CloseInternalError, "")
+}
+
+// GetFrames lists the messages of the WebSocket session captured as a
+// request, in the order they were sent.
+func (c *Controllers) GetFrames(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+	requestId, err := requestIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing request id: %s", err.Error())})
+		return
+	}
+
+	frames, err := c.services.GetFrames(r.Context(), binId, requestId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting frames", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+	if frames == nil {
+		frames = []models.Frame{}
+	}
+
+	writeJSON(w, http.StatusOK, frames)
+}
+
+// GetWebSocketScript returns how a bin answers the WebSocket sessions it
+// captures.
+func (c *Controllers) GetWebSocketScript(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	script, err := c.services.GetWebSocketScript(r.Context(), binId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "getting websocket script", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, script)
+}
+
+// SetWebSocketScript replaces how a bin answers the WebSocket sessions it
+// captures with the script in the body.
+func (c *Controllers) SetWebSocketScript(w http.ResponseWriter, r *http.Request) {
+	binId, err := binIdParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error parsing bin id: %s", err.Error())})
+		return
+	}
+
+	var script models.WebSocketScript
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebSocketScriptSize)).Decode(&script); err != nil {
+		writeJSON(w, decodeStatus(err), map[string]string{"error": fmt.Sprintf("Error parsing websocket script: %s", err.Error())})
+		return
+	}
+
+	err = c.services.SetWebSocketScript(r.Context(), callerOwner(r), binId, script)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "setting websocket script", "error", err)
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, script)
+}
@@ -4,7 +4,11 @@ import (
 	"app/internal/models"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -15,43 +19,104 @@ type DbConn interface {
 	Prepare(query string) (*sql.Stmt, error)
 	ExecContext(context.Context, string, ...any) (sql.Result, error)
 	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+	BeginTx(context.Context, *sql.TxOptions) (*sql.Tx, error)
 	Ping() error
 }
 
+// SchemaVersion is the user_version set by db-schema.sql. It is bumped with
+// every change to the schema.
+const SchemaVersion = 9
+
 type Db struct {
-	conn       DbConn
-	driverType string
-	connStr    string
+	conn           DbConn
+	driverType     string
+	connStr        string
+	fullTextSearch atomic.Bool
 }
 
+// NewDb opens the database, connections are only made once it is used.
 func NewDb(driverType, connStr string) (*Db, error) {
+	conn, err := sql.Open(driverType, connStr)
+	if err != nil {
+		return nil, err
+	}
 	return &Db{
+		conn:       conn,
 		driverType: driverType,
 		connStr:    connStr,
 	}, nil
 }
 
-func (db *Db) Connect() error {
-	var err error
-	if db.conn == nil {
-		db.conn, err = sql.Open(db.driverType, db.connStr)
-		if err != nil {
-			return err
-		}
+// Connect prepares the database, migrating databases of older versions to
+// SchemaVersion. Requests may be served meanwhile, bodies are then searched
+// without the full text index.
+func (db *Db) Connect(ctx context.Context) error {
+	_, err := db.conn.ExecContext(ctx, "PRAGMA foreign_keys=ON;")
+	if err != nil {
+		return err
 	}
 
-	_, err = db.conn.ExecContext(context.Background(), "PRAGMA foreign_keys=ON;")
+	if err := db.migrate(ctx); err != nil {
+		return err
+	}
+
+	fullTextSearch, err := db.setupFullTextSearch(ctx)
+	if err != nil {
+		return err
+	}
+	db.fullTextSearch.Store(fullTextSearch)
+
+	return nil
+}
+
+// Ping checks the database can be reached.
+func (db *Db) Ping() error {
+	return db.conn.Ping()
+}
+
+// CheckSchema checks the schema of the database is the one the app expects,
+// see SchemaVersion.
+func (db *Db) CheckSchema(ctx context.Context) error {
+	rows, err := db.conn.QueryContext(ctx, "PRAGMA user_version")
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
 
+	var version int
+	if !rows.Next() {
+		return errors.New("no schema version")
+	}
+	if err := rows.Scan(&version); err != nil {
+		return err
+	}
+	if version != SchemaVersion {
+		return fmt.Errorf("schema version %d, expected %d", version, SchemaVersion)
+	}
 	return nil
 }
 
-func (db *Db) CreateBin(bin models.Bin) (int64, error) {
+// Size returns the size of the database in bytes.
+func (db *Db) Size(ctx context.Context) (int64, error) {
+	query := "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()"
+	rows, err := db.conn.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var size int64
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+	err = rows.Scan(&size)
+	return size, err
+}
+
+func (db *Db) CreateBin(ctx context.Context, bin models.Bin) (int64, error) {
 	query := "INSERT INTO bins (created_at, owner) VALUES (?, ?)"
 	res, err := db.conn.ExecContext(
-		context.Background(),
+		ctx,
 		query,
 		models.TimeToString(time.Now()),
 		bin.Owner)
@@ -69,12 +134,89 @@ func (db *Db) CreateBin(bin models.Bin) (int64, error) {
 	return id, nil
 }
 
-func (db *Db) InsertRequest(request models.Request) error {
-	query := "INSERT INTO requests (timestamp, headers, body, host, remoteAddr, requestUri, method, bin) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
-	_, err := db.conn.ExecContext(
-		context.Background(),
+// GetBin returns a bin, or an error wrapping models.ErrNotFound.
+func (db *Db) GetBin(ctx context.Context, binId int64) (models.Bin, error) {
+	query := "SELECT bin_id, created_at, owner, dropped, rate_limit FROM bins WHERE bin_id = ?"
+	rows, err := db.conn.QueryContext(ctx, query, binId)
+	if err != nil {
+		return models.Bin{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return models.Bin{}, err
+		}
+		return models.Bin{}, fmt.Errorf("bin %d: %w", binId, models.ErrNotFound)
+	}
+
+	var bin models.Bin
+	var owner sql.NullString
+	if err := rows.Scan(&bin.BinId, &bin.CreatedAt, &owner, &bin.Dropped, &bin.RateLimit); err != nil {
+		return models.Bin{}, err
+	}
+	bin.Owner = owner.String
+
+	return bin, nil
+}
+
+func (db *Db) InsertRequest(ctx context.Context, request models.Request) (int64, error) {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := insertRequest(ctx, tx, request)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// InsertRequests inserts a batch of requests, all of them or none.
+func (db *Db) InsertRequests(ctx context.Context, requests []models.Request) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, request := range requests {
+		if _, err := insertRequest(ctx, tx, request); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func insertRequest(ctx context.Context, tx *sql.Tx, request models.Request) (int64, error) {
+	headers, err := request.GetHeaders()
+	if err != nil {
+		return 0, err
+	}
+
+	trailers, err := encodeValues(request.Trailers)
+	if err != nil {
+		return 0, err
+	}
+
+	var validationErrors []byte
+	if len(request.ValidationErrors) > 0 {
+		if validationErrors, err = json.Marshal(request.ValidationErrors); err != nil {
+			return 0, err
+		}
+	}
+
+	query := "INSERT INTO requests (timestamp, headers, body, host, remoteAddr, requestUri, method, bin, subPath, ruleId, responseError, proto, contentLength, transferEncoding, trailers, rawHead, rawChunkSizes, rawTrailers, clientIp, tlsVersion, tlsCipherSuite, tlsServerName, tlsClientSubject, closedAt, mailFrom, mailTo, validation, validationErrors) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	res, err := tx.ExecContext(
+		ctx,
 		query,
-		request.RecievedAt,
+		request.RecievedAt.UTC(),
 		request.Headers,
 		request.Body,
 		request.Host,
@@ -82,42 +224,436 @@ func (db *Db) InsertRequest(request models.Request) error {
 		request.RequestUri,
 		request.Method,
 		request.Bin,
+		request.SubPath,
+		request.RuleId,
+		request.ResponseError,
+		request.Proto,
+		request.ContentLength,
+		request.TransferEncoding,
+		trailers,
+		request.RawHead,
+		strings.Join(request.RawChunkSizes, "\n"),
+		request.RawTrailers,
+		request.ClientIp,
+		request.TLSVersion,
+		request.TLSCipherSuite,
+		request.TLSServerName,
+		request.TLSClientSubject,
+		sql.NullTime{Time: request.ClosedAt.UTC(), Valid: !request.ClosedAt.IsZero()},
+		request.MailFrom,
+		strings.Join(request.MailTo, "\n"),
+		request.Validation,
+		string(validationErrors),
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	// headers are also stored one per row so they can be searched
+	query = "INSERT INTO request_headers (request, name, value) VALUES (?, ?, ?)"
+	for name, values := range headers {
+		for _, value := range values {
+			_, err = tx.ExecContext(ctx, query, id, strings.ToLower(name), value)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return id, nil
+}
+
+// GetBinContents returns a page of the requests of a bin matching the
+// filter, newest first.
+func (db *Db) GetBinContents(ctx context.Context, binId int64, filter models.RequestFilter, page models.Page) ([]models.Request, error) {
+	conditions, args := db.filterConditions(filter)
+	query := "SELECT * FROM requests WHERE bin = ?" + conditions
+	args = append([]any{binId}, args...)
+	// timestamps are compared as julian days, as they are not always stored
+	// in the same text format
+	if !page.Before.IsZero() {
+		before := page.Before.RecievedAt.UTC()
+		query += " AND (julianday(timestamp) < julianday(?) OR (julianday(timestamp) = julianday(?) AND id < ?))"
+		args = append(args, before, before, page.Before.Id)
+	}
+	query += " ORDER BY julianday(timestamp) DESC, id DESC LIMIT ?"
+	args = append(args, page.Limit)
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []models.Request
+	for rows.Next() {
+		request, err := scanRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		requests = append(requests, request)
+	}
+
+	return requests, nil
+}
+
+// GetRequest returns a single request of a bin, or an error wrapping
+// models.ErrNotFound when the bin holds no request with that id.
+func (db *Db) GetRequest(ctx context.Context, binId, requestId int64) (models.Request, error) {
+	query := "SELECT * FROM requests WHERE bin = ? AND id = ?"
+	rows, err := db.conn.QueryContext(ctx, query, binId, requestId)
+	if err != nil {
+		return models.Request{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return models.Request{}, err
+		}
+		return models.Request{}, fmt.Errorf("request %d in bin %d: %w", requestId, binId, models.ErrNotFound)
+	}
+
+	return scanRequest(rows)
+}
+
+// GetRequestsAfter returns up to limit requests of a bin captured after the
+// request afterId, in the order they were captured.
+func (db *Db) GetRequestsAfter(ctx context.Context, binId, afterId int64, limit int) ([]models.Request, error) {
+	query := "SELECT * FROM requests WHERE bin = ? AND id > ? ORDER BY id LIMIT ?"
+	rows, err := db.conn.QueryContext(ctx, query, binId, afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []models.Request
+	for rows.Next() {
+		request, err := scanRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		requests = append(requests, request)
+	}
+
+	return requests, rows.Err()
+}
+
+// scanRequest reads a row of the requests table, selected with all of its
+// columns.
+func scanRequest(rows *sql.Rows) (models.Request, error) {
+	var request models.Request
+	var trailers, rawChunkSizes, mailTo, validationErrors string
+	var closedAt sql.NullTime
+	err := rows.Scan(
+		&request.Id,
+		&request.RecievedAt,
+		&request.Headers,
+		&request.Body,
+		&request.Host,
+		&request.RemoteAddr,
+		&request.RequestUri,
+		&request.Method,
+		&request.Bin,
+		&request.SubPath,
+		&request.RuleId,
+		&request.ResponseError,
+		&request.Proto,
+		&request.ContentLength,
+		&request.TransferEncoding,
+		&trailers,
+		&request.RawHead,
+		&rawChunkSizes,
+		&request.RawTrailers,
+		&request.ClientIp,
+		&request.TLSVersion,
+		&request.TLSCipherSuite,
+		&request.TLSServerName,
+		&request.TLSClientSubject,
+		&closedAt,
+		&request.MailFrom,
+		&mailTo,
+		&request.Validation,
+		&validationErrors,
 	)
+	if err != nil {
+		return models.Request{}, err
+	}
+
+	if request.Trailers, err = decodeValues(trailers); err != nil {
+		return models.Request{}, err
+	}
+	if rawChunkSizes != "" {
+		request.RawChunkSizes = strings.Split(rawChunkSizes, "\n")
+	}
+	request.ClosedAt = closedAt.Time
+	if mailTo != "" {
+		request.MailTo = strings.Split(mailTo, "\n")
+	}
+	if validationErrors != "" {
+		if err := json.Unmarshal([]byte(validationErrors), &request.ValidationErrors); err != nil {
+			return models.Request{}, err
+		}
+	}
+
+	return request, nil
+}
+
+// DeleteRequest deletes a request of a bin, or returns an error wrapping
+// models.ErrNotFound when the bin holds no request with that id.
+func (db *Db) DeleteRequest(ctx context.Context, binId, requestId int64) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// dependent rows are deleted explicitly, as foreign keys are only
+	// enforced on the connections that enabled them
+	_, err = tx.ExecContext(ctx, "DELETE FROM request_headers WHERE request IN (SELECT id FROM requests WHERE bin = ? AND id = ?)", binId, requestId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM websocket_frames WHERE request IN (SELECT id FROM requests WHERE bin = ? AND id = ?)", binId, requestId)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM requests WHERE bin = ? AND id = ?", binId, requestId)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return fmt.Errorf("request %d in bin %d: %w", requestId, binId, models.ErrNotFound)
+	}
+
+	return tx.Commit()
+}
+
+// ClearBin deletes all requests of a bin.
+func (db *Db) ClearBin(ctx context.Context, binId int64) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := clearBin(ctx, tx, binId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteBin deletes a bin along with its requests, rules, notification
+// targets, WebSocket script, gRPC settings and capture ports.
+func (db *Db) DeleteBin(ctx context.Context, binId int64) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := clearBin(ctx, tx, binId); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM rules WHERE bin = ?", binId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM notification_targets WHERE bin = ?", binId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM websocket_scripts WHERE bin = ?", binId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM grpc_settings WHERE bin = ?", binId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM bin_schemas WHERE bin = ?", binId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM capture_ports WHERE bin = ?", binId)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM bins WHERE bin_id = ?", binId)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return fmt.Errorf("bin %d: %w", binId, models.ErrNotFound)
+	}
+
+	return tx.Commit()
+}
+
+// AddDroppedRequests adds to the counts of requests dropped by bins. Counts
+// of bins that do not exist are ignored.
+func (db *Db) AddDroppedRequests(ctx context.Context, dropped map[int64]int64) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
+	for binId, count := range dropped {
+		_, err := tx.ExecContext(ctx, "UPDATE bins SET dropped = dropped + ? WHERE bin_id = ?", count, binId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetBinRateLimit sets the rate limit of a bin, empty for the default, or
+// returns an error wrapping models.ErrNotFound.
+func (db *Db) SetBinRateLimit(ctx context.Context, binId int64, limit string) error {
+	result, err := db.conn.ExecContext(ctx, "UPDATE bins SET rate_limit = ? WHERE bin_id = ?", limit, binId)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return fmt.Errorf("bin %d: %w", binId, models.ErrNotFound)
+	}
 	return nil
 }
 
-func (db *Db) GetBinContents(binId int64) ([]models.Request, error) {
-	query := "SELECT * FROM requests WHERE bin = ?"
-	rows, err := db.conn.QueryContext(context.Background(), query, binId)
+func clearBin(ctx context.Context, tx *sql.Tx, binId int64) error {
+	// dependent rows are deleted explicitly, as foreign keys are only
+	// enforced on the connections that enabled them
+	_, err := tx.ExecContext(ctx, "DELETE FROM request_headers WHERE request IN (SELECT id FROM requests WHERE bin = ?)", binId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM websocket_frames WHERE request IN (SELECT id FROM requests WHERE bin = ?)", binId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM requests WHERE bin = ?", binId)
+	return err
+}
+
+// SetRules replaces the ordered list of response rules of a bin.
+func (db *Db) SetRules(ctx context.Context, binId int64, rules []models.Rule) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM rules WHERE bin = ?", binId)
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO rules (bin, position, method, pathGlob, headers, query, bodyFields, status, responseHeaders, responseBody) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	for position, rule := range rules {
+		headers, err := encodeMap(rule.Headers)
+		if err != nil {
+			return err
+		}
+		queryParams, err := encodeMap(rule.Query)
+		if err != nil {
+			return err
+		}
+		bodyFields, err := encodeMap(rule.BodyFields)
+		if err != nil {
+			return err
+		}
+		responseHeaders, err := encodeMap(rule.ResponseHeaders)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			query,
+			binId,
+			position,
+			rule.Method,
+			rule.PathGlob,
+			headers,
+			queryParams,
+			bodyFields,
+			rule.Status,
+			responseHeaders,
+			rule.ResponseBody,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetRules returns the response rules of a bin in evaluation order.
+func (db *Db) GetRules(ctx context.Context, binId int64) ([]models.Rule, error) {
+	query := "SELECT id, bin, position, method, pathGlob, headers, query, bodyFields, status, responseHeaders, responseBody FROM rules WHERE bin = ? ORDER BY position"
+	rows, err := db.conn.QueryContext(ctx, query, binId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var requests []models.Request
+	var rules []models.Rule
 	for rows.Next() {
-		var request models.Request
+		var rule models.Rule
+		var headers, queryParams, bodyFields, responseHeaders string
 		err := rows.Scan(
-			&request.Id,
-			&request.RecievedAt,
-			&request.Headers,
-			&request.Body,
-			&request.Host,
-			&request.RemoteAddr,
-			&request.RequestUri,
-			&request.Method,
-			&request.Bin,
+			&rule.Id,
+			&rule.Bin,
+			&rule.Position,
+			&rule.Method,
+			&rule.PathGlob,
+			&headers,
+			&queryParams,
+			&bodyFields,
+			&rule.Status,
+			&responseHeaders,
+			&rule.ResponseBody,
 		)
 		if err != nil {
 			return nil, err
 		}
 
-		requests = append(requests, request)
+		if rule.Headers, err = decodeMap(headers); err != nil {
+			return nil, err
+		}
+		if rule.Query, err = decodeMap(queryParams); err != nil {
+			return nil, err
+		}
+		if rule.BodyFields, err = decodeMap(bodyFields); err != nil {
+			return nil, err
+		}
+		if rule.ResponseHeaders, err = decodeMap(responseHeaders); err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, rule)
 	}
 
-	return requests, nil
+	return rules, nil
 }
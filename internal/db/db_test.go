@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -20,7 +21,7 @@ import (
 func testDbSetup(t *testing.T) *Db {
 	db, err := NewDb("sqlite3", ":memory:")
 	assert.NoError(t, err)
-	err = db.Connect()
+	err = db.Connect(context.Background())
 	assert.NoError(t, err)
 
 	path := filepath.Join("./../../", "db-schema.sql")
@@ -32,6 +33,11 @@ func testDbSetup(t *testing.T) *Db {
 	_, err = db.conn.ExecContext(context.Background(), sql)
 	assert.NoError(t, err)
 
+	// the schema did not exist yet when connecting
+	fullTextSearch, err := db.setupFullTextSearch(context.Background())
+	assert.NoError(t, err)
+	db.fullTextSearch.Store(fullTextSearch)
+
 	return db
 }
 
@@ -64,18 +70,18 @@ func Test_Connect(t *testing.T) {
 		db, err := NewDb("sqlite3", ":memory:")
 		assert.NoError(t, err)
 
-		err = db.Connect()
+		err = db.Connect(context.Background())
 		assert.NoError(t, err)
 	})
 
 	t.Run("error opening db", func(t *testing.T) {
 		db, err := NewDb("sqlite3", ":memory:")
 		assert.NoError(t, err)
-		err = db.Connect()
+		err = db.Connect(context.Background())
 		assert.NoError(t, err)
 
 		db.conn.Close() // closed sqlite in memory dbs can not longer be connected to
-		err = db.Connect()
+		err = db.Connect(context.Background())
 		assert.Error(t, err)
 	})
 
@@ -106,7 +112,7 @@ func Test_Connect(t *testing.T) {
 		}
 		db.conn = conn
 
-		err = db.Connect()
+		err = db.Connect(context.Background())
 		assert.Error(t, err)
 
 		conn.VerifyCallCounts(t, &fake.Conn{
@@ -115,6 +121,177 @@ func Test_Connect(t *testing.T) {
 	})
 }
 
+// baselineSchema is the schema of the databases created before the schema
+// had a version.
+const baselineSchema = `CREATE TABLE [bins] (
+	bin_id INTEGER PRIMARY KEY,
+	created_at DATETIME NOT NULL,
+	owner TEXT
+);
+CREATE TABLE [requests] (
+	id INTEGER PRIMARY KEY,
+	timestamp DATETIME NOT NULL,
+	headers TEXT NOT NULL,
+	body TEXT NOT NULL,
+	host TEXT NOT NULL,
+	remoteAddr TEXT NOT NULL,
+	requestUri TEXT NOT NULL,
+	"method" TEXT NOT NULL,
+	bin INTEGER NOT NULL,
+	FOREIGN KEY (bin) REFERENCES bins(bin_id)
+);`
+
+// schemaColumns describes the columns of every table and the indexes of a
+// database, in an order not depending on how they were created.
+func schemaColumns(t *testing.T, db *Db) []string {
+	t.Helper()
+	rows, err := db.conn.QueryContext(context.Background(), `SELECT m.type, m.name, coalesce(c.name, ''), coalesce(c.type, ''), coalesce(c."notnull", 0), coalesce(c.dflt_value, ''), coalesce(c.pk, 0)
+FROM sqlite_master m LEFT JOIN pragma_table_info(m.name) c ON m.type = 'table'
+WHERE m.name NOT LIKE 'sqlite_%'
+ORDER BY m.type, m.name, c.name`)
+	assert.NoError(t, err)
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var kind, table, name, typ, defaultValue string
+		var notNull, pk int
+		assert.NoError(t, rows.Scan(&kind, &table, &name, &typ, &notNull, &defaultValue, &pk))
+		columns = append(columns, fmt.Sprintf("%s %s %s %s %d %s %d", kind, table, name, typ, notNull, defaultValue, pk))
+	}
+	assert.NoError(t, rows.Err())
+	return columns
+}
+
+func schemaVersion(t *testing.T, db *Db) int {
+	t.Helper()
+	var version int
+	rows, err := db.conn.QueryContext(context.Background(), "PRAGMA user_version")
+	assert.NoError(t, err)
+	defer rows.Close()
+	if assert.True(t, rows.Next()) {
+		assert.NoError(t, rows.Scan(&version))
+	}
+	return version
+}
+
+func Test_migrate(t *testing.T) {
+	t.Run("from the baseline schema", func(t *testing.T) {
+		db, err := NewDb("sqlite3", ":memory:")
+		assert.NoError(t, err)
+		defer teardownTestDb(t, db)
+		_, err = db.conn.ExecContext(context.Background(), baselineSchema)
+		assert.NoError(t, err)
+
+		request := models.Request{}
+		assert.NoError(t, request.SetHeaders(map[string][]string{"X-Event": {"order.created"}}))
+		_, err = db.conn.ExecContext(context.Background(), "INSERT INTO bins (created_at) VALUES ('2023-01-01 00:00:00')")
+		assert.NoError(t, err)
+		_, err = db.conn.ExecContext(
+			context.Background(),
+			"INSERT INTO requests (timestamp, headers, body, host, remoteAddr, requestUri, method, bin) VALUES ('2023-01-01 00:00:00', ?, 'body', 'host', 'remoteAddr', '/bin/1', 'POST', 1)",
+			request.Headers,
+		)
+		assert.NoError(t, err)
+
+		err = db.Connect(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, len(migrations), schemaVersion(t, db))
+		// headers of requests captured before are searchable
+		assert.Equal(t, 1, countRows(t, db, "request_headers", "name = ? AND value = ?", "x-event", "order.created"))
+
+		// migrated once
+		err = db.Connect(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, len(migrations), schemaVersion(t, db))
+		assert.Equal(t, 1, countRows(t, db, "request_headers", "name = ?", "x-event"))
+	})
+
+	t.Run("same schema as db-schema.sql", func(t *testing.T) {
+		migrated, err := NewDb("sqlite3", ":memory:")
+		assert.NoError(t, err)
+		defer teardownTestDb(t, migrated)
+		_, err = migrated.conn.ExecContext(context.Background(), baselineSchema)
+		assert.NoError(t, err)
+		_, err = migrated.conn.ExecContext(context.Background(), "INSERT INTO bins (created_at) VALUES ('2023-01-01 00:00:00')")
+		assert.NoError(t, err)
+		_, err = migrated.conn.ExecContext(
+			context.Background(),
+			"INSERT INTO requests (timestamp, headers, body, host, remoteAddr, requestUri, method, bin) VALUES ('2023-01-01 00:00:00', '', 'body', 'host', 'remoteAddr', '/bin/1', 'POST', 1)",
+		)
+		assert.NoError(t, err)
+		assert.NoError(t, migrated.Connect(context.Background()))
+
+		created := testDbSetup(t)
+		defer teardownTestDb(t, created)
+
+		assert.Equal(t, SchemaVersion, len(migrations))
+		assert.NoError(t, migrated.CheckSchema(context.Background()))
+		assert.Equal(t, schemaColumns(t, created), schemaColumns(t, migrated))
+
+		request, err := migrated.GetRequest(context.Background(), 1, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "body", request.Body)
+		assert.Equal(t, int64(-1), request.ContentLength)
+	})
+
+	t.Run("current schema", func(t *testing.T) {
+		db := testDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		err := db.Connect(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, SchemaVersion, schemaVersion(t, db))
+	})
+
+	t.Run("no schema", func(t *testing.T) {
+		db, err := NewDb("sqlite3", ":memory:")
+		assert.NoError(t, err)
+		defer teardownTestDb(t, db)
+
+		err = db.Connect(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, schemaVersion(t, db))
+		assert.Error(t, db.CheckSchema(context.Background()))
+	})
+}
+
+func Test_Size(t *testing.T) {
+	db := populatedTestDbSetup(t)
+	defer teardownTestDb(t, db)
+
+	size, err := db.Size(context.Background())
+	assert.NoError(t, err)
+	assert.Positive(t, size)
+}
+
+func Test_Ping(t *testing.T) {
+	db := testDbSetup(t)
+	assert.NoError(t, db.Ping())
+
+	db.conn.Close()
+	assert.Error(t, db.Ping())
+}
+
+func Test_CheckSchema(t *testing.T) {
+	t.Run("schema applied", func(t *testing.T) {
+		db := testDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		assert.NoError(t, db.CheckSchema(context.Background()))
+	})
+
+	t.Run("schema version mismatch", func(t *testing.T) {
+		db := testDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		_, err := db.conn.ExecContext(context.Background(), "PRAGMA user_version = 0")
+		assert.NoError(t, err)
+
+		err = db.CheckSchema(context.Background())
+		assert.EqualError(t, err, fmt.Sprintf("schema version 0, expected %d", SchemaVersion))
+	})
+}
+
 func Test_CreateBin(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		db := testDbSetup(t)
@@ -123,7 +300,7 @@ func Test_CreateBin(t *testing.T) {
 		currentTime := time.Now()
 		owner := "owner"
 
-		id, err := db.CreateBin(models.Bin{
+		id, err := db.CreateBin(context.Background(), models.Bin{
 			CreatedAt: time.Now(),
 			Owner:     "owner",
 		})
@@ -134,7 +311,7 @@ func Test_CreateBin(t *testing.T) {
 		rows, err := db.conn.QueryContext(context.Background(), query)
 		for rows.Next() {
 			var bin models.Bin
-			err = rows.Scan(&bin.BinId, &bin.CreatedAt, &bin.Owner)
+			err = rows.Scan(&bin.BinId, &bin.CreatedAt, &bin.Owner, &bin.Dropped, &bin.RateLimit)
 			assert.NoError(t, err)
 			assert.Equal(t, int64(1), bin.BinId)
 			assert.NotEqual(t, currentTime, bin.CreatedAt)
@@ -150,7 +327,7 @@ func Test_CreateBin(t *testing.T) {
 		err := db.conn.Close()
 		assert.NoError(t, err)
 
-		_, err = db.CreateBin(models.Bin{
+		_, err = db.CreateBin(context.Background(), models.Bin{
 			Owner: "owner",
 		})
 		assert.Error(t, err)
@@ -164,18 +341,31 @@ func Test_InsertRequest(t *testing.T) {
 
 		currentTime := time.Now()
 		req := models.Request{
-			RecievedAt: currentTime,
-			Body:       "new-body",
-			Host:       "new-host",
-			RemoteAddr: "new-remoteAddr",
-			RequestUri: "new-requestUri",
-			Method:     "new-method",
-			Bin:        1,
+			RecievedAt:    currentTime,
+			Body:          "new-body",
+			Host:          "new-host",
+			RemoteAddr:    "new-remoteAddr",
+			RequestUri:    "new-requestUri",
+			Method:        "new-method",
+			Bin:           1,
+			SubPath:       "/new/sub/path",
+			RuleId:        7,
+			ResponseError: "template: body:1: unexpected EOF",
+			Proto:         "HTTP/1.1",
+			ContentLength: -1,
+			Trailers:      map[string][]string{"X-Checksum": {"42"}},
+			RawHead:       "POST /new-requestUri HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n",
+			RawChunkSizes: []string{"8", "0"},
+			RawTrailers:   "X-Checksum: 42\r\n",
+			ClientIp:      "203.0.113.7",
+			TLSVersion:    "TLS 1.3",
+			TLSServerName: "bins.example.com",
 		}
 		_ = req.SetHeaders(map[string][]string{"headers": {"header"}})
-		err := db.InsertRequest(req)
+		id, err := db.InsertRequest(context.Background(), req)
 
 		assert.NoError(t, err)
+		assert.NotZero(t, id)
 
 		query := "SELECT * FROM requests WHERE bin = ?"
 		rows, err := db.conn.QueryContext(context.Background(), query, req.Bin)
@@ -183,24 +373,14 @@ func Test_InsertRequest(t *testing.T) {
 
 		var newRequest models.Request
 		for rows.Next() {
-			var request models.Request
-			err = rows.Scan(
-				&request.Id,
-				&request.RecievedAt,
-				&request.Headers,
-				&request.Body,
-				&request.Host,
-				&request.RemoteAddr,
-				&request.RequestUri,
-				&request.Method,
-				&request.Bin,
-			)
+			request, err := scanRequest(rows)
 			assert.NoError(t, err)
 			if request.Method == "new-method" {
 				newRequest = request
 			}
 		}
 		assert.NotNil(t, newRequest)
+		assert.Equal(t, id, newRequest.Id)
 		assert.Equal(t, currentTime.UTC(), newRequest.RecievedAt.UTC())
 		assert.Equal(t, req.Headers, newRequest.Headers)
 		assert.Equal(t, req.Body, newRequest.Body)
@@ -209,6 +389,94 @@ func Test_InsertRequest(t *testing.T) {
 		assert.Equal(t, req.RemoteAddr, newRequest.RemoteAddr)
 		assert.Equal(t, req.RequestUri, newRequest.RequestUri)
 		assert.Equal(t, req.Bin, newRequest.Bin)
+		assert.Equal(t, req.SubPath, newRequest.SubPath)
+		assert.Equal(t, req.RuleId, newRequest.RuleId)
+		assert.Equal(t, req.ResponseError, newRequest.ResponseError)
+		assert.Equal(t, req.Proto, newRequest.Proto)
+		assert.Equal(t, req.ContentLength, newRequest.ContentLength)
+		assert.Equal(t, req.Trailers, newRequest.Trailers)
+		assert.Equal(t, req.RawHead, newRequest.RawHead)
+		assert.Equal(t, req.RawChunkSizes, newRequest.RawChunkSizes)
+		assert.Equal(t, req.RawTrailers, newRequest.RawTrailers)
+		assert.Equal(t, req.ClientIp, newRequest.ClientIp)
+		assert.Equal(t, req.TLSVersion, newRequest.TLSVersion)
+		assert.Equal(t, req.TLSServerName, newRequest.TLSServerName)
+		assert.True(t, newRequest.ClosedAt.IsZero())
+		assert.Empty(t, newRequest.MailFrom)
+		assert.Nil(t, newRequest.MailTo)
+		assert.Empty(t, newRequest.Validation)
+		assert.Nil(t, newRequest.ValidationErrors)
+	})
+
+	t.Run("tcp connection", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		openedAt := time.Now()
+		req := models.Request{
+			RecievedAt: openedAt,
+			Body:       "<34>Oct 11 22:14:15 host su: 'su root' failed\n",
+			Method:     "TCP",
+			Proto:      models.TCP,
+			Bin:        1,
+			ClosedAt:   openedAt.Add(time.Second),
+		}
+		_ = req.SetHeaders(map[string][]string{})
+		id, err := db.InsertRequest(context.Background(), req)
+		assert.NoError(t, err)
+
+		newRequest, err := db.GetRequest(context.Background(), 1, id)
+		assert.NoError(t, err)
+		assert.Equal(t, req.Body, newRequest.Body)
+		assert.Equal(t, req.ClosedAt.UTC(), newRequest.ClosedAt.UTC())
+	})
+
+	t.Run("mail", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		req := models.Request{
+			RecievedAt: time.Now(),
+			Body:       "Subject: Order shipped\r\n\r\nOn its way.\r\n",
+			Method:     "MAIL",
+			Proto:      models.SMTP,
+			Bin:        1,
+			MailFrom:   "shop@example.com",
+			MailTo:     []string{"1@bins.example.com", "2@bins.example.com"},
+		}
+		_ = req.SetHeaders(map[string][]string{"Subject": {"Order shipped"}})
+		id, err := db.InsertRequest(context.Background(), req)
+		assert.NoError(t, err)
+
+		newRequest, err := db.GetRequest(context.Background(), 1, id)
+		assert.NoError(t, err)
+		assert.Equal(t, req.MailFrom, newRequest.MailFrom)
+		assert.Equal(t, req.MailTo, newRequest.MailTo)
+	})
+
+	t.Run("failed validation", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		req := models.Request{
+			RecievedAt: time.Now(),
+			Body:       `{"amount": "12"}`,
+			Method:     "POST",
+			Bin:        1,
+			Validation: models.Failed,
+			ValidationErrors: []models.SchemaError{
+				{Path: "/amount", Keyword: "/properties/amount/type", Message: "got string, want number"},
+				{Keyword: "/required", Message: "missing property 'currency'"},
+			},
+		}
+		_ = req.SetHeaders(map[string][]string{})
+		id, err := db.InsertRequest(context.Background(), req)
+		assert.NoError(t, err)
+
+		newRequest, err := db.GetRequest(context.Background(), 1, id)
+		assert.NoError(t, err)
+		assert.Equal(t, req.Validation, newRequest.Validation)
+		assert.Equal(t, req.ValidationErrors, newRequest.ValidationErrors)
 	})
 
 	t.Run("error inserting request", func(t *testing.T) {
@@ -227,18 +495,52 @@ func Test_InsertRequest(t *testing.T) {
 		}
 		_ = req.SetHeaders(map[string][]string{"headers": {"header"}})
 
-		err := db.InsertRequest(req)
+		_, err := db.InsertRequest(context.Background(), req)
 		assert.Error(t, err)
 	})
 }
 
+func Test_InsertRequests(t *testing.T) {
+	newRequest := func(bin int64, body string) models.Request {
+		request := models.Request{RecievedAt: time.Now(), Body: body, Method: "POST", Bin: bin}
+		_ = request.SetHeaders(map[string][]string{"X-Imported": {"true"}})
+		return request
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		err := db.InsertRequests(context.Background(), []models.Request{
+			newRequest(1, "first"),
+			newRequest(1, "second"),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, countRows(t, db, "requests", "bin = ? AND body IN ('first', 'second')", 1))
+		assert.Equal(t, 2, countRows(t, db, "request_headers", "name = ?", "x-imported"))
+	})
+
+	t.Run("none inserted when one fails", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		err := db.InsertRequests(context.Background(), []models.Request{
+			newRequest(1, "first"),
+			newRequest(9999, "second"),
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 0, countRows(t, db, "requests", "body IN ('first', 'second')"))
+		assert.Equal(t, 0, countRows(t, db, "request_headers", "name = ?", "x-imported"))
+	})
+}
+
 func Test_GetBinContents(t *testing.T) {
 	t.Run("happy path - multiple requests present in db", func(t *testing.T) {
 		db := populatedTestDbSetup(t)
 		defer teardownTestDb(t, db)
 
 		binId := int64(1)
-		requests, err := db.GetBinContents(binId)
+		requests, err := db.GetBinContents(context.Background(), binId, models.RequestFilter{}, models.Page{Limit: 10})
 		assert.NoError(t, err)
 		assert.Len(t, requests, 2)
 
@@ -258,11 +560,48 @@ func Test_GetBinContents(t *testing.T) {
 		id, err := sql.Result.LastInsertId(res)
 		assert.NoError(t, err)
 
-		requests, err := db.GetBinContents(id)
+		requests, err := db.GetBinContents(context.Background(), id, models.RequestFilter{}, models.Page{Limit: 10})
 		assert.Nil(t, err)
 		assert.Len(t, requests, 0)
 	})
 
+	t.Run("happy path - paged newest first", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		receivedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		for i := range 5 {
+			req := models.Request{
+				// the last two requests are received at the same time
+				RecievedAt: receivedAt.Add(time.Duration(min(i, 3)) * time.Second),
+				Body:       "body",
+				Host:       "host",
+				RequestUri: fmt.Sprintf("/bin/2/%d", i),
+				Method:     "POST",
+				Bin:        2,
+			}
+			_ = req.SetHeaders(map[string][]string{})
+			_, err := db.InsertRequest(context.Background(), req)
+			assert.NoError(t, err)
+		}
+
+		var uris []string
+		page := models.Page{Limit: 2}
+		for range 4 {
+			requests, err := db.GetBinContents(context.Background(), 2, models.RequestFilter{}, page)
+			assert.NoError(t, err)
+			for _, request := range requests {
+				uris = append(uris, request.RequestUri)
+			}
+			if len(requests) < page.Limit {
+				break
+			}
+			page.Before = requests[len(requests)-1].Cursor()
+		}
+
+		assert.Equal(t, []string{"/bin/2/4", "/bin/2/3", "/bin/2/2", "/bin/2/1", "/bin/2/0", "requestUri"}, uris)
+	})
+
 	t.Run("error getting bin contents", func(t *testing.T) {
 		db := populatedTestDbSetup(t)
 		defer teardownTestDb(t, db)
@@ -270,10 +609,578 @@ func Test_GetBinContents(t *testing.T) {
 		err := db.conn.Close()
 		assert.NoError(t, err)
 
-		requests, err := db.GetBinContents(1)
+		requests, err := db.GetBinContents(context.Background(), 1, models.RequestFilter{}, models.Page{Limit: 10})
 		assert.Nil(t, requests)
 		assert.Error(t, err)
 	})
 
-	t.Run("new test", func(t *testing.T) {})
+	t.Run("happy path - filtered", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		receivedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		for i, method := range []string{"POST", "GET", "POST"} {
+			req := models.Request{
+				RecievedAt: receivedAt.Add(time.Duration(i) * time.Hour),
+				Body:       fmt.Sprintf(`{"event": "order.created", "index": %d}`, i),
+				Host:       "host",
+				RemoteAddr: fmt.Sprintf("10.0.0.%d:5000", i),
+				RequestUri: fmt.Sprintf("/bin/2/events/%d", i),
+				Method:     method,
+				Bin:        2,
+				Validation: []string{models.Passed, models.Failed, ""}[i],
+			}
+			_ = req.SetHeaders(map[string][]string{"X-Event": {fmt.Sprintf("order-%d", i)}})
+			_, err := db.InsertRequest(context.Background(), req)
+			assert.NoError(t, err)
+		}
+
+		for name, tc := range map[string]struct {
+			filter   models.RequestFilter
+			expected []string
+		}{
+			"method":        {models.RequestFilter{Method: "post"}, []string{"10.0.0.0:5000", "10.0.0.2:5000"}},
+			"path":          {models.RequestFilter{Path: "events/1"}, []string{"10.0.0.1:5000"}},
+			"header":        {models.RequestFilter{HeaderName: "x-event", HeaderValue: "order-2"}, []string{"10.0.0.2:5000"}},
+			"header name":   {models.RequestFilter{HeaderName: "X-Event"}, []string{"10.0.0.0:5000", "10.0.0.1:5000", "10.0.0.2:5000"}},
+			"body":          {models.RequestFilter{Body: `"index": 1`}, []string{"10.0.0.1:5000"}},
+			"remote addr":   {models.RequestFilter{RemoteAddr: "10.0.0.2"}, []string{"10.0.0.2:5000"}},
+			"time range":    {models.RequestFilter{From: receivedAt.Add(30 * time.Minute), To: receivedAt.Add(2 * time.Hour)}, []string{"10.0.0.1:5000", "10.0.0.2:5000"}},
+			"combined":      {models.RequestFilter{Method: "POST", From: receivedAt.Add(time.Minute)}, []string{"10.0.0.2:5000"}},
+			"passed":        {models.RequestFilter{Validation: models.Passed}, []string{"10.0.0.0:5000"}},
+			"failed":        {models.RequestFilter{Validation: models.Failed}, []string{"10.0.0.1:5000"}},
+			"not validated": {models.RequestFilter{Validation: models.NotValidated}, []string{"10.0.0.2:5000", "remoteAddr"}},
+			"no such value": {models.RequestFilter{HeaderName: "X-Missing"}, nil},
+		} {
+			t.Run(name, func(t *testing.T) {
+				requests, err := db.GetBinContents(context.Background(), 2, tc.filter, models.Page{Limit: 10})
+				assert.NoError(t, err)
+
+				var remoteAddrs []string
+				for _, request := range requests {
+					remoteAddrs = append(remoteAddrs, request.RemoteAddr)
+				}
+				assert.ElementsMatch(t, tc.expected, remoteAddrs)
+			})
+		}
+	})
+}
+
+func Test_GetRequest(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		request, err := db.GetRequest(context.Background(), 2, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), request.Id)
+		assert.Equal(t, int64(2), request.Bin)
+		assert.Equal(t, "requestUri", request.RequestUri)
+	})
+
+	t.Run("request of another bin", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		_, err := db.GetRequest(context.Background(), 1, 2)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+
+	t.Run("error getting request", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		err := db.conn.Close()
+		assert.NoError(t, err)
+
+		_, err = db.GetRequest(context.Background(), 1, 1)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, models.ErrNotFound)
+	})
+}
+
+func Test_GetBin(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		bin, err := db.GetBin(context.Background(), 2)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Bin{
+			BinId:     2,
+			CreatedAt: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC),
+			Owner:     "owner-2",
+		}, bin)
+	})
+
+	t.Run("missing bin", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		_, err := db.GetBin(context.Background(), 9999)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+}
+
+func Test_AddDroppedRequests(t *testing.T) {
+	db := populatedTestDbSetup(t)
+	defer teardownTestDb(t, db)
+
+	err := db.AddDroppedRequests(context.Background(), map[int64]int64{1: 3, 9999: 1})
+	assert.NoError(t, err)
+	err = db.AddDroppedRequests(context.Background(), map[int64]int64{1: 2})
+	assert.NoError(t, err)
+
+	bin, err := db.GetBin(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), bin.Dropped)
+	bin, err = db.GetBin(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), bin.Dropped)
+}
+
+func Test_SetBinRateLimit(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		err := db.SetBinRateLimit(context.Background(), 1, "10/1m0s")
+		assert.NoError(t, err)
+		bin, err := db.GetBin(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "10/1m0s", bin.RateLimit)
+
+		// back to the default limit
+		err = db.SetBinRateLimit(context.Background(), 1, "")
+		assert.NoError(t, err)
+		bin, err = db.GetBin(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "", bin.RateLimit)
+	})
+
+	t.Run("missing bin", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		err := db.SetBinRateLimit(context.Background(), 9999, "10/1m0s")
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+}
+
+// countRows counts the rows of a table matching the condition.
+func countRows(t *testing.T, db *Db, table, condition string, args ...any) int {
+	rows, err := db.conn.QueryContext(context.Background(), "SELECT COUNT(*) FROM "+table+" WHERE "+condition, args...)
+	assert.NoError(t, err)
+	defer rows.Close()
+
+	var count int
+	assert.True(t, rows.Next())
+	assert.NoError(t, rows.Scan(&count))
+	return count
+}
+
+func Test_GetRequestsAfter(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		var ids []int64
+		for range 3 {
+			req := models.Request{RecievedAt: time.Now(), Method: "POST", Bin: 1}
+			_ = req.SetHeaders(map[string][]string{})
+			id, err := db.InsertRequest(context.Background(), req)
+			assert.NoError(t, err)
+			ids = append(ids, id)
+		}
+
+		requests, err := db.GetRequestsAfter(context.Background(), 1, ids[0], 10)
+		assert.NoError(t, err)
+		assert.Len(t, requests, 2)
+		assert.Equal(t, ids[1], requests[0].Id)
+		assert.Equal(t, ids[2], requests[1].Id)
+
+		requests, err = db.GetRequestsAfter(context.Background(), 1, 0, 2)
+		assert.NoError(t, err)
+		assert.Len(t, requests, 2)
+		for _, request := range requests {
+			assert.Equal(t, int64(1), request.Bin)
+		}
+		assert.Less(t, requests[0].Id, requests[1].Id)
+	})
+
+	t.Run("error getting requests", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		err := db.conn.Close()
+		assert.NoError(t, err)
+
+		_, err = db.GetRequestsAfter(context.Background(), 1, 0, 10)
+		assert.Error(t, err)
+	})
+}
+
+func Test_DeleteRequest(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		req := models.Request{RecievedAt: time.Now(), Method: "POST", Bin: 1}
+		_ = req.SetHeaders(map[string][]string{"X-Id": {"1"}})
+		id, err := db.InsertRequest(context.Background(), req)
+		assert.NoError(t, err)
+		_, err = db.InsertFrame(context.Background(), models.Frame{Request: id, ReceivedAt: time.Now(), Direction: "in", Kind: "text"})
+		assert.NoError(t, err)
+
+		err = db.DeleteRequest(context.Background(), 1, id)
+		assert.NoError(t, err)
+
+		_, err = db.GetRequest(context.Background(), 1, id)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		assert.Equal(t, 0, countRows(t, db, "request_headers", "request = ?", id))
+		assert.Equal(t, 0, countRows(t, db, "websocket_frames", "request = ?", id))
+		assert.Equal(t, 2, countRows(t, db, "requests", "bin = ?", 1))
+	})
+
+	t.Run("request of another bin", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		err := db.DeleteRequest(context.Background(), 1, 2)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		assert.Equal(t, 1, countRows(t, db, "requests", "id = ?", 2))
+	})
+}
+
+func Test_ClearBin(t *testing.T) {
+	db := populatedTestDbSetup(t)
+	defer teardownTestDb(t, db)
+
+	_, err := db.InsertFrame(context.Background(), models.Frame{Request: 1, ReceivedAt: time.Now(), Direction: "in", Kind: "text"})
+	assert.NoError(t, err)
+
+	err = db.ClearBin(context.Background(), 1)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 0, countRows(t, db, "requests", "bin = ?", 1))
+	assert.Equal(t, 0, countRows(t, db, "websocket_frames", "request = ?", 1))
+	assert.Equal(t, 1, countRows(t, db, "requests", "bin = ?", 2))
+	assert.Equal(t, 1, countRows(t, db, "bins", "bin_id = ?", 1))
+}
+
+func Test_DeleteBin(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		assert.NoError(t, db.SetRules(context.Background(), 1, []models.Rule{{Status: 201}}))
+		assert.NoError(t, db.SetNotificationTargets(context.Background(), 1, []models.NotificationTarget{{Kind: "webhook"}}))
+		assert.NoError(t, db.SetWebSocketScript(context.Background(), 1, models.WebSocketScript{Echo: true}))
+		assert.NoError(t, db.SetGrpcSettings(context.Background(), 1, models.GrpcSettings{Status: 12}))
+		assert.NoError(t, db.SetBinSchema(context.Background(), 1, models.BinSchema{Schema: []byte(`{"type": "object"}`)}))
+		assert.NoError(t, db.InsertCapturePort(context.Background(), models.CapturePort{Protocol: models.UDP, Port: 5140, Bin: 1}))
+
+		err := db.DeleteBin(context.Background(), 1)
+		assert.NoError(t, err)
+
+		_, err = db.GetBin(context.Background(), 1)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		assert.Equal(t, 0, countRows(t, db, "requests", "bin = ?", 1))
+		assert.Equal(t, 0, countRows(t, db, "rules", "bin = ?", 1))
+		assert.Equal(t, 0, countRows(t, db, "notification_targets", "bin = ?", 1))
+		assert.Equal(t, 0, countRows(t, db, "websocket_scripts", "bin = ?", 1))
+		assert.Equal(t, 0, countRows(t, db, "grpc_settings", "bin = ?", 1))
+		assert.Equal(t, 0, countRows(t, db, "bin_schemas", "bin = ?", 1))
+		assert.Equal(t, 0, countRows(t, db, "capture_ports", "bin = ?", 1))
+		assert.Equal(t, 1, countRows(t, db, "requests", "bin = ?", 2))
+	})
+
+	t.Run("missing bin", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		err := db.DeleteBin(context.Background(), 9999)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+}
+
+func Test_SetRules(t *testing.T) {
+	t.Run("happy path - rules are replaced in order", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		err := db.SetRules(context.Background(), 1, []models.Rule{{Status: 500}})
+		assert.NoError(t, err)
+
+		err = db.SetRules(context.Background(), 1, []models.Rule{
+			{
+				Method:          "POST",
+				PathGlob:        "/events/*",
+				Headers:         map[string]string{"X-Event": "order"},
+				Query:           map[string]string{"source": "shop"},
+				BodyFields:      map[string]string{"$.id": "42"},
+				Status:          201,
+				ResponseHeaders: map[string]string{"Content-Type": "application/json"},
+				ResponseBody:    `{"ok": true}`,
+			},
+			{Status: 404},
+		})
+		assert.NoError(t, err)
+
+		rules, err := db.GetRules(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Len(t, rules, 2)
+		assert.Equal(t, 0, rules[0].Position)
+		assert.Equal(t, int64(1), rules[0].Bin)
+		assert.Equal(t, "POST", rules[0].Method)
+		assert.Equal(t, "/events/*", rules[0].PathGlob)
+		assert.Equal(t, map[string]string{"X-Event": "order"}, rules[0].Headers)
+		assert.Equal(t, map[string]string{"source": "shop"}, rules[0].Query)
+		assert.Equal(t, map[string]string{"$.id": "42"}, rules[0].BodyFields)
+		assert.Equal(t, 201, rules[0].Status)
+		assert.Equal(t, map[string]string{"Content-Type": "application/json"}, rules[0].ResponseHeaders)
+		assert.Equal(t, `{"ok": true}`, rules[0].ResponseBody)
+		assert.Equal(t, 1, rules[1].Position)
+		assert.Equal(t, 404, rules[1].Status)
+		assert.Empty(t, rules[1].Headers)
+
+		rules, err = db.GetRules(context.Background(), 2)
+		assert.NoError(t, err)
+		assert.Len(t, rules, 0)
+	})
+
+	t.Run("error setting rules of missing bin", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		err := db.SetRules(context.Background(), 9999, []models.Rule{{Status: 200}})
+		assert.Error(t, err)
+	})
+
+	t.Run("error getting rules", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		err := db.conn.Close()
+		assert.NoError(t, err)
+
+		rules, err := db.GetRules(context.Background(), 1)
+		assert.Nil(t, rules)
+		assert.Error(t, err)
+	})
+}
+
+func Test_SetNotificationTargets(t *testing.T) {
+	db := populatedTestDbSetup(t)
+	defer teardownTestDb(t, db)
+
+	err := db.SetNotificationTargets(context.Background(), 1, []models.NotificationTarget{{Kind: "email", To: []string{"old@example.com"}}})
+	assert.NoError(t, err)
+
+	err = db.SetNotificationTargets(context.Background(), 1, []models.NotificationTarget{
+		{
+			Kind:    "webhook",
+			Url:     "https://hooks.example.com/services/T0",
+			Headers: map[string]string{"Authorization": "Bearer token"},
+			Payload: `{"text": "{{.Method}} {{.SubPath}}"}`,
+		},
+		{
+			Kind:    "email",
+			To:      []string{"a@example.com", "b@example.com"},
+			Subject: "Bin {{.Request.Bin}}",
+			Payload: "{{.Body}}",
+		},
+	})
+	assert.NoError(t, err)
+
+	targets, err := db.GetNotificationTargets(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Len(t, targets, 2)
+	assert.Equal(t, int64(1), targets[0].Bin)
+	assert.Equal(t, "webhook", targets[0].Kind)
+	assert.Equal(t, "https://hooks.example.com/services/T0", targets[0].Url)
+	assert.Equal(t, map[string]string{"Authorization": "Bearer token"}, targets[0].Headers)
+	assert.Equal(t, `{"text": "{{.Method}} {{.SubPath}}"}`, targets[0].Payload)
+	assert.Empty(t, targets[0].To)
+	assert.Equal(t, "email", targets[1].Kind)
+	assert.Equal(t, []string{"a@example.com", "b@example.com"}, targets[1].To)
+	assert.Equal(t, "Bin {{.Request.Bin}}", targets[1].Subject)
+
+	targets, err = db.GetNotificationTargets(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Empty(t, targets)
+}
+
+func Test_InsertFrame(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		receivedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+		frames := []models.Frame{
+			{Request: 1, ReceivedAt: receivedAt, Direction: "in", Kind: "text", Size: 5, Payload: []byte("hello")},
+			{Request: 1, ReceivedAt: receivedAt.Add(time.Second), Direction: "out", Kind: "binary", Size: 3, Payload: []byte{0, 1, 255}},
+			{Request: 1, ReceivedAt: receivedAt.Add(2 * time.Second), Direction: "in", Kind: "close", Size: 2},
+		}
+		for _, frame := range frames {
+			id, err := db.InsertFrame(context.Background(), frame)
+			assert.NoError(t, err)
+			assert.NotZero(t, id)
+		}
+
+		got, err := db.GetFrames(context.Background(), 1, 1)
+		assert.NoError(t, err)
+		assert.Len(t, got, 3)
+		for i, frame := range got {
+			assert.Equal(t, int64(1), frame.Request)
+			assert.Equal(t, frames[i].Direction, frame.Direction)
+			assert.Equal(t, frames[i].Kind, frame.Kind)
+			assert.Equal(t, frames[i].Size, frame.Size)
+			assert.True(t, frames[i].ReceivedAt.Equal(frame.ReceivedAt))
+		}
+		assert.Equal(t, []byte("hello"), got[0].Payload)
+		assert.Equal(t, []byte{0, 1, 255}, got[1].Payload)
+		assert.Empty(t, got[2].Payload)
+		assert.Less(t, got[0].Id, got[1].Id)
+	})
+
+	t.Run("frames of a request of another bin", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		_, err := db.InsertFrame(context.Background(), models.Frame{Request: 1, ReceivedAt: time.Now(), Direction: "in", Kind: "text"})
+		assert.NoError(t, err)
+
+		frames, err := db.GetFrames(context.Background(), 2, 1)
+		assert.NoError(t, err)
+		assert.Empty(t, frames)
+	})
+
+	t.Run("error getting frames", func(t *testing.T) {
+		db := populatedTestDbSetup(t)
+		defer teardownTestDb(t, db)
+
+		err := db.conn.Close()
+		assert.NoError(t, err)
+
+		_, err = db.GetFrames(context.Background(), 1, 1)
+		assert.Error(t, err)
+	})
+}
+
+func Test_SetWebSocketScript(t *testing.T) {
+	db := populatedTestDbSetup(t)
+	defer teardownTestDb(t, db)
+
+	script, err := db.GetWebSocketScript(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, models.WebSocketScript{}, script)
+
+	err = db.SetWebSocketScript(context.Background(), 1, models.WebSocketScript{Echo: true})
+	assert.NoError(t, err)
+
+	want := models.WebSocketScript{
+		OnConnect: []string{"welcome", "line\nbreak"},
+		Reply:     `{"got": {{json .Message}}}`,
+	}
+	err = db.SetWebSocketScript(context.Background(), 1, want)
+	assert.NoError(t, err)
+
+	script, err = db.GetWebSocketScript(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, want, script)
+	assert.Equal(t, 1, countRows(t, db, "websocket_scripts", "bin = ?", 1))
+
+	err = db.SetWebSocketScript(context.Background(), 9999, want)
+	assert.Error(t, err)
+}
+
+func Test_SetGrpcSettings(t *testing.T) {
+	db := populatedTestDbSetup(t)
+	defer teardownTestDb(t, db)
+
+	settings, err := db.GetGrpcSettings(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, models.GrpcSettings{}, settings)
+
+	err = db.SetGrpcSettings(context.Background(), 1, models.GrpcSettings{Status: 12})
+	assert.NoError(t, err)
+
+	want := models.GrpcSettings{
+		Status:        5,
+		Message:       "no such event",
+		Responses:     map[string]string{"/events.Bus/Publish": `{"ok": true}`},
+		DescriptorSet: []byte{0x0a, 0x00},
+	}
+	err = db.SetGrpcSettings(context.Background(), 1, want)
+	assert.NoError(t, err)
+
+	settings, err = db.GetGrpcSettings(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, want, settings)
+	assert.Equal(t, 1, countRows(t, db, "grpc_settings", "bin = ?", 1))
+
+	err = db.SetGrpcSettings(context.Background(), 9999, want)
+	assert.Error(t, err)
+}
+
+func Test_SetBinSchema(t *testing.T) {
+	db := populatedTestDbSetup(t)
+	defer teardownTestDb(t, db)
+
+	schema, err := db.GetBinSchema(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, models.BinSchema{}, schema)
+
+	err = db.SetBinSchema(context.Background(), 1, models.BinSchema{Schema: []byte(`{"type": "object"}`)})
+	assert.NoError(t, err)
+
+	want := models.BinSchema{
+		OpenAPI:   []byte(`{"openapi": "3.1.0", "paths": {}}`),
+		Operation: "createOrder",
+	}
+	err = db.SetBinSchema(context.Background(), 1, want)
+	assert.NoError(t, err)
+
+	schema, err = db.GetBinSchema(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, want, schema)
+	assert.Equal(t, 1, countRows(t, db, "bin_schemas", "bin = ?", 1))
+
+	err = db.SetBinSchema(context.Background(), 9999, want)
+	assert.Error(t, err)
+}
+
+func Test_CapturePorts(t *testing.T) {
+	db := populatedTestDbSetup(t)
+	defer teardownTestDb(t, db)
+
+	ports := []models.CapturePort{
+		{Protocol: models.UDP, Port: 5140, Bin: 1},
+		{Protocol: models.TCP, Port: 5140, Bin: 1},
+		{Protocol: models.TCP, Port: 5141, Bin: 2},
+	}
+	for _, port := range ports {
+		assert.NoError(t, db.InsertCapturePort(context.Background(), port))
+	}
+	// a port is allocated once
+	err := db.InsertCapturePort(context.Background(), models.CapturePort{Protocol: models.TCP, Port: 5141, Bin: 1})
+	assert.Error(t, err)
+
+	binPorts, err := db.GetCapturePorts(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.CapturePort{ports[1], ports[0]}, binPorts)
+
+	allPorts, err := db.GetCapturePorts(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.CapturePort{ports[1], ports[2], ports[0]}, allPorts)
+
+	err = db.DeleteCapturePort(context.Background(), ports[0])
+	assert.NoError(t, err)
+	err = db.DeleteCapturePort(context.Background(), ports[0])
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	// ports of another bin are not released
+	err = db.DeleteCapturePort(context.Background(), models.CapturePort{Protocol: models.TCP, Port: 5141, Bin: 1})
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	assert.Equal(t, 2, countRows(t, db, "capture_ports", "1 = ?", 1))
 }
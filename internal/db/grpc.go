@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+
+	"app/internal/models"
+)
+
+// SetGrpcSettings replaces how a bin answers gRPC calls.
+func (db *Db) SetGrpcSettings(ctx context.Context, binId int64, settings models.GrpcSettings) error {
+	responses, err := json.Marshal(settings.Responses)
+	if err != nil {
+		return err
+	}
+	descriptorSet := settings.DescriptorSet
+	if descriptorSet == nil {
+		descriptorSet = []byte{}
+	}
+
+	query := "INSERT INTO grpc_settings (bin, status, message, responses, descriptorSet) VALUES (?, ?, ?, ?, ?) ON CONFLICT (bin) DO UPDATE SET status = excluded.status, message = excluded.message, responses = excluded.responses, descriptorSet = excluded.descriptorSet"
+	_, err = db.conn.ExecContext(ctx, query, binId, settings.Status, settings.Message, string(responses), descriptorSet)
+	return err
+}
+
+// GetGrpcSettings returns how a bin answers gRPC calls, the zero settings
+// when never set.
+func (db *Db) GetGrpcSettings(ctx context.Context, binId int64) (models.GrpcSettings, error) {
+	query := "SELECT status, message, responses, descriptorSet FROM grpc_settings WHERE bin = ?"
+	rows, err := db.conn.QueryContext(ctx, query, binId)
+	if err != nil {
+		return models.GrpcSettings{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return models.GrpcSettings{}, rows.Err()
+	}
+
+	var settings models.GrpcSettings
+	var responses string
+	if err := rows.Scan(&settings.Status, &settings.Message, &responses, &settings.DescriptorSet); err != nil {
+		return models.GrpcSettings{}, err
+	}
+
+	if err := json.Unmarshal([]byte(responses), &settings.Responses); err != nil {
+		return models.GrpcSettings{}, err
+	}
+	if len(settings.DescriptorSet) == 0 {
+		settings.DescriptorSet = nil
+	}
+	return settings, nil
+}
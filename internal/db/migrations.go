@@ -0,0 +1,310 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"app/internal/models"
+)
+
+// migration upgrades the schema of a database by one version, within the
+// transaction recording the new version.
+type migration func(ctx context.Context, tx *sql.Tx) error
+
+// migrations upgrade databases created by older versions of the app, the
+// one at index i from user_version i to i+1. Every change to db-schema.sql
+// comes with a migration making the same change, see SchemaVersion.
+//
+// Foreign keys are declared when tables are created, so the tables of
+// older databases keep theirs without ON DELETE CASCADE. Rows depending on
+// bins and requests are deleted explicitly for that reason.
+var migrations = []migration{
+	// 1: the schema of the databases created before it had a version, up to
+	// readiness probes
+	func(ctx context.Context, tx *sql.Tx) error {
+		err := addColumns(ctx, tx, "requests",
+			"subPath TEXT NOT NULL DEFAULT ''",
+			"ruleId INTEGER NOT NULL DEFAULT 0",
+			"responseError TEXT NOT NULL DEFAULT ''",
+			"proto TEXT NOT NULL DEFAULT ''",
+			"contentLength INTEGER NOT NULL DEFAULT -1",
+			"transferEncoding TEXT NOT NULL DEFAULT ''",
+			"trailers TEXT NOT NULL DEFAULT '{}'",
+			"rawHead TEXT NOT NULL DEFAULT ''",
+			"rawChunkSizes TEXT NOT NULL DEFAULT ''",
+			"rawTrailers TEXT NOT NULL DEFAULT ''",
+			"clientIp TEXT NOT NULL DEFAULT ''",
+			"tlsVersion TEXT NOT NULL DEFAULT ''",
+			"tlsCipherSuite TEXT NOT NULL DEFAULT ''",
+			"tlsServerName TEXT NOT NULL DEFAULT ''",
+			"tlsClientSubject TEXT NOT NULL DEFAULT ''",
+		)
+		if err != nil {
+			return err
+		}
+		indexHeaders, err := tableExists(ctx, tx, "request_headers")
+		if err != nil {
+			return err
+		}
+		err = execAll(ctx, tx,
+			`CREATE TABLE IF NOT EXISTS [rules] (
+	id INTEGER PRIMARY KEY,
+	bin INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	"method" TEXT NOT NULL DEFAULT '',
+	pathGlob TEXT NOT NULL DEFAULT '',
+	headers TEXT NOT NULL DEFAULT '{}',
+	query TEXT NOT NULL DEFAULT '{}',
+	bodyFields TEXT NOT NULL DEFAULT '{}',
+	status INTEGER NOT NULL,
+	responseHeaders TEXT NOT NULL DEFAULT '{}',
+	responseBody TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (bin) REFERENCES bins(bin_id) ON DELETE CASCADE
+)`,
+			`CREATE TABLE IF NOT EXISTS [request_headers] (
+	request INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	value TEXT NOT NULL,
+	FOREIGN KEY (request) REFERENCES requests(id) ON DELETE CASCADE
+)`,
+			"CREATE INDEX IF NOT EXISTS requests_bin_timestamp ON requests (bin, julianday(timestamp))",
+			`CREATE INDEX IF NOT EXISTS requests_bin_method ON requests (bin, "method")`,
+			"CREATE INDEX IF NOT EXISTS request_headers_request ON request_headers (request)",
+			"CREATE INDEX IF NOT EXISTS request_headers_name ON request_headers (name, value)",
+		)
+		if err != nil {
+			return err
+		}
+		if !indexHeaders {
+			return indexRequestHeaders(ctx, tx)
+		}
+		return nil
+	},
+	// 2: requests dropped by rate limits
+	func(ctx context.Context, tx *sql.Tx) error {
+		return addColumns(ctx, tx, "bins", "dropped INTEGER NOT NULL DEFAULT 0")
+	},
+	// 3: notification targets
+	func(ctx context.Context, tx *sql.Tx) error {
+		return execAll(ctx, tx,
+			`CREATE TABLE IF NOT EXISTS [notification_targets] (
+	id INTEGER PRIMARY KEY,
+	bin INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	kind TEXT NOT NULL,
+	url TEXT NOT NULL DEFAULT '',
+	headers TEXT NOT NULL DEFAULT '{}',
+	recipients TEXT NOT NULL DEFAULT '',
+	subject TEXT NOT NULL DEFAULT '',
+	payload TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (bin) REFERENCES bins(bin_id) ON DELETE CASCADE
+)`,
+			"CREATE INDEX IF NOT EXISTS notification_targets_bin ON notification_targets (bin, position)",
+		)
+	},
+	// 4: WebSocket frames and scripts
+	func(ctx context.Context, tx *sql.Tx) error {
+		return execAll(ctx, tx,
+			`CREATE TABLE IF NOT EXISTS [websocket_frames] (
+	id INTEGER PRIMARY KEY,
+	request INTEGER NOT NULL,
+	timestamp DATETIME NOT NULL,
+	direction TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	payload BLOB NOT NULL,
+	FOREIGN KEY (request) REFERENCES requests(id) ON DELETE CASCADE
+)`,
+			"CREATE INDEX IF NOT EXISTS websocket_frames_request ON websocket_frames (request, id)",
+			`CREATE TABLE IF NOT EXISTS [websocket_scripts] (
+	bin INTEGER PRIMARY KEY,
+	echo INTEGER NOT NULL DEFAULT 0,
+	onConnect TEXT NOT NULL DEFAULT '[]',
+	reply TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (bin) REFERENCES bins(bin_id) ON DELETE CASCADE
+)`,
+		)
+	},
+	// 5: gRPC settings
+	func(ctx context.Context, tx *sql.Tx) error {
+		return execAll(ctx, tx,
+			`CREATE TABLE IF NOT EXISTS [grpc_settings] (
+	bin INTEGER PRIMARY KEY,
+	status INTEGER NOT NULL DEFAULT 0,
+	message TEXT NOT NULL DEFAULT '',
+	responses TEXT NOT NULL DEFAULT '{}',
+	descriptorSet BLOB NOT NULL DEFAULT x'',
+	FOREIGN KEY (bin) REFERENCES bins(bin_id) ON DELETE CASCADE
+)`,
+		)
+	},
+	// 6: TCP and UDP ports of bins
+	func(ctx context.Context, tx *sql.Tx) error {
+		if err := addColumns(ctx, tx, "requests", "closedAt DATETIME"); err != nil {
+			return err
+		}
+		return execAll(ctx, tx,
+			`CREATE TABLE IF NOT EXISTS [capture_ports] (
+	protocol TEXT NOT NULL,
+	port INTEGER NOT NULL,
+	bin INTEGER NOT NULL,
+	PRIMARY KEY (protocol, port),
+	FOREIGN KEY (bin) REFERENCES bins(bin_id) ON DELETE CASCADE
+)`,
+			"CREATE INDEX IF NOT EXISTS capture_ports_bin ON capture_ports (bin)",
+		)
+	},
+	// 7: envelopes of mails
+	func(ctx context.Context, tx *sql.Tx) error {
+		return addColumns(ctx, tx, "requests",
+			"mailFrom TEXT NOT NULL DEFAULT ''",
+			"mailTo TEXT NOT NULL DEFAULT ''",
+		)
+	},
+	// 8: validation of request bodies
+	func(ctx context.Context, tx *sql.Tx) error {
+		err := addColumns(ctx, tx, "requests",
+			"validation TEXT NOT NULL DEFAULT ''",
+			"validationErrors TEXT NOT NULL DEFAULT ''",
+		)
+		if err != nil {
+			return err
+		}
+		return execAll(ctx, tx,
+			`CREATE TABLE IF NOT EXISTS [bin_schemas] (
+	bin INTEGER PRIMARY KEY,
+	schema TEXT NOT NULL DEFAULT '',
+	openapi TEXT NOT NULL DEFAULT '',
+	operation TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (bin) REFERENCES bins(bin_id) ON DELETE CASCADE
+)`,
+		)
+	},
+	// 9: rate limits of bins
+	func(ctx context.Context, tx *sql.Tx) error {
+		return addColumns(ctx, tx, "bins", "rate_limit TEXT NOT NULL DEFAULT ''")
+	},
+}
+
+// migrate applies the migrations the database is missing. Databases with
+// no schema yet are left for db-schema.sql to create, and those of newer
+// versions as they are, failing CheckSchema.
+func (db *Db) migrate(ctx context.Context) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	hasSchema, err := tableExists(ctx, tx, "requests")
+	if err != nil || !hasSchema {
+		return err
+	}
+	var version int
+	if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		if err := migrations[version](ctx, tx); err != nil {
+			return fmt.Errorf("migrating to schema version %d: %w", version+1, err)
+		}
+		// pragmas take no parameters
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func execAll(ctx context.Context, tx *sql.Tx, statements ...string) error {
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tableExists(ctx context.Context, tx *sql.Tx, table string) (bool, error) {
+	var count int
+	err := tx.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count)
+	return count > 0, err
+}
+
+// addColumns adds the columns, given by their definition, a table is
+// missing.
+func addColumns(ctx context.Context, tx *sql.Tx, table string, columns ...string) error {
+	rows, err := tx.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[strings.ToLower(name)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		name, _, _ := strings.Cut(column, " ")
+		if existing[strings.ToLower(name)] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexRequestHeaders stores the headers of the requests captured before
+// they were searchable one per row, as InsertRequest does.
+func indexRequestHeaders(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id, headers FROM requests")
+	if err != nil {
+		return err
+	}
+	type indexed struct {
+		id      int64
+		headers map[string][]string
+	}
+	var requests []indexed
+	for rows.Next() {
+		var request models.Request
+		if err := rows.Scan(&request.Id, &request.Headers); err != nil {
+			rows.Close()
+			return err
+		}
+		// requests whose headers can not be decoded are not searchable by
+		// them, as when listed
+		if headers, err := request.GetHeaders(); err == nil {
+			requests = append(requests, indexed{request.Id, headers})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	query := "INSERT INTO request_headers (request, name, value) VALUES (?, ?, ?)"
+	for _, request := range requests {
+		for name, values := range request.headers {
+			for _, value := range values {
+				if _, err := tx.ExecContext(ctx, query, request.id, strings.ToLower(name), value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
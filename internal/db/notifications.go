@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"app/internal/models"
+)
+
+// SetNotificationTargets replaces the notification targets of a bin.
+func (db *Db) SetNotificationTargets(ctx context.Context, binId int64, targets []models.NotificationTarget) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM notification_targets WHERE bin = ?", binId)
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO notification_targets (bin, position, kind, url, headers, recipients, subject, payload) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	for position, target := range targets {
+		headers, err := encodeMap(target.Headers)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			query,
+			binId,
+			position,
+			target.Kind,
+			target.Url,
+			headers,
+			strings.Join(target.To, "\n"),
+			target.Subject,
+			target.Payload,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetNotificationTargets returns the notification targets of a bin, in the
+// order they were set.
+func (db *Db) GetNotificationTargets(ctx context.Context, binId int64) ([]models.NotificationTarget, error) {
+	query := "SELECT id, bin, kind, url, headers, recipients, subject, payload FROM notification_targets WHERE bin = ? ORDER BY position"
+	rows, err := db.conn.QueryContext(ctx, query, binId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []models.NotificationTarget
+	for rows.Next() {
+		var target models.NotificationTarget
+		var headers, recipients string
+		err := rows.Scan(
+			&target.Id,
+			&target.Bin,
+			&target.Kind,
+			&target.Url,
+			&headers,
+			&recipients,
+			&target.Subject,
+			&target.Payload,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if target.Headers, err = decodeMap(headers); err != nil {
+			return nil, err
+		}
+		if recipients != "" {
+			target.To = strings.Split(recipients, "\n")
+		}
+
+		targets = append(targets, target)
+	}
+
+	return targets, rows.Err()
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"app/internal/models"
+)
+
+// InsertCapturePort allocates a port to a bin, failing when it is allocated
+// already.
+func (db *Db) InsertCapturePort(ctx context.Context, port models.CapturePort) error {
+	query := "INSERT INTO capture_ports (protocol, port, bin) VALUES (?, ?, ?)"
+	_, err := db.conn.ExecContext(ctx, query, port.Protocol, port.Port, port.Bin)
+	return err
+}
+
+// GetCapturePorts returns the ports allocated to a bin, or to all bins when
+// binId is 0, by protocol and port.
+func (db *Db) GetCapturePorts(ctx context.Context, binId int64) ([]models.CapturePort, error) {
+	query := "SELECT protocol, port, bin FROM capture_ports WHERE ? = 0 OR bin = ? ORDER BY protocol, port"
+	rows, err := db.conn.QueryContext(ctx, query, binId, binId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ports []models.CapturePort
+	for rows.Next() {
+		var port models.CapturePort
+		if err := rows.Scan(&port.Protocol, &port.Port, &port.Bin); err != nil {
+			return nil, err
+		}
+
+		ports = append(ports, port)
+	}
+
+	return ports, rows.Err()
+}
+
+// DeleteCapturePort releases a port of a bin, or returns an error wrapping
+// models.ErrNotFound when the port is not allocated to the bin.
+func (db *Db) DeleteCapturePort(ctx context.Context, port models.CapturePort) error {
+	query := "DELETE FROM capture_ports WHERE protocol = ? AND port = ? AND bin = ?"
+	res, err := db.conn.ExecContext(ctx, query, port.Protocol, port.Port, port.Bin)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%s port %d of bin %d: %w", port.Protocol, port.Port, port.Bin, models.ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"context"
+
+	"app/internal/models"
+)
+
+// SetBinSchema replaces the schema request bodies of a bin are validated
+// against.
+func (db *Db) SetBinSchema(ctx context.Context, binId int64, schema models.BinSchema) error {
+	query := "INSERT INTO bin_schemas (bin, schema, openapi, operation) VALUES (?, ?, ?, ?) ON CONFLICT (bin) DO UPDATE SET schema = excluded.schema, openapi = excluded.openapi, operation = excluded.operation"
+	_, err := db.conn.ExecContext(ctx, query, binId, string(schema.Schema), string(schema.OpenAPI), schema.Operation)
+	return err
+}
+
+// GetBinSchema returns the schema request bodies of a bin are validated
+// against, the zero schema when never set.
+func (db *Db) GetBinSchema(ctx context.Context, binId int64) (models.BinSchema, error) {
+	query := "SELECT schema, openapi, operation FROM bin_schemas WHERE bin = ?"
+	rows, err := db.conn.QueryContext(ctx, query, binId)
+	if err != nil {
+		return models.BinSchema{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return models.BinSchema{}, rows.Err()
+	}
+
+	var schema models.BinSchema
+	var jsonSchema, openAPI string
+	if err := rows.Scan(&jsonSchema, &openAPI, &schema.Operation); err != nil {
+		return models.BinSchema{}, err
+	}
+	if jsonSchema != "" {
+		schema.Schema = []byte(jsonSchema)
+	}
+	if openAPI != "" {
+		schema.OpenAPI = []byte(openAPI)
+	}
+	return schema, nil
+}
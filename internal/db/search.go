@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"app/internal/models"
+)
+
+// fullTextSearchSchema indexes request bodies with FTS5. The sqlite driver
+// only includes FTS5 when built with the sqlite_fts5 tag; without it body
+// searches fall back to substring matching.
+var fullTextSearchSchema = []string{
+	"CREATE VIRTUAL TABLE IF NOT EXISTS requests_fts USING fts5(body, content='requests', content_rowid='id')",
+	`CREATE TRIGGER IF NOT EXISTS requests_fts_insert AFTER INSERT ON requests BEGIN
+		INSERT INTO requests_fts(rowid, body) VALUES (new.id, new.body);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS requests_fts_delete AFTER DELETE ON requests BEGIN
+		INSERT INTO requests_fts(requests_fts, rowid, body) VALUES ('delete', old.id, old.body);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS requests_fts_update AFTER UPDATE OF body ON requests BEGIN
+		INSERT INTO requests_fts(requests_fts, rowid, body) VALUES ('delete', old.id, old.body);
+		INSERT INTO requests_fts(rowid, body) VALUES (new.id, new.body);
+	END`,
+}
+
+// setupFullTextSearch creates the body search index when the schema is in
+// place and the driver supports FTS5, and reports whether it is usable.
+func (db *Db) setupFullTextSearch(ctx context.Context) (bool, error) {
+	rows, err := db.conn.QueryContext(
+		ctx,
+		"SELECT name FROM sqlite_master WHERE type = 'table' AND name IN ('requests', 'requests_fts')",
+	)
+	if err != nil {
+		return false, err
+	}
+	tables := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return false, err
+		}
+		tables[name] = true
+	}
+	rows.Close()
+
+	if !tables["requests"] {
+		return false, nil
+	}
+
+	for _, statement := range fullTextSearchSchema {
+		if _, err := db.conn.ExecContext(ctx, statement); err != nil {
+			if strings.Contains(err.Error(), "no such module: fts5") {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+
+	if !tables["requests_fts"] {
+		// index the requests captured before the index existed
+		_, err := db.conn.ExecContext(ctx, "INSERT INTO requests_fts(requests_fts) VALUES ('rebuild')")
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// filterConditions translates a filter into SQL conditions on the requests
+// table and their arguments.
+func (db *Db) filterConditions(filter models.RequestFilter) (string, []any) {
+	var conditions []string
+	var args []any
+
+	if filter.Method != "" {
+		conditions = append(conditions, "method = ?")
+		args = append(args, strings.ToUpper(filter.Method))
+	}
+	if filter.Path != "" {
+		conditions = append(conditions, "instr(requestUri, ?) > 0")
+		args = append(args, filter.Path)
+	}
+	if filter.HeaderName != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM request_headers WHERE request_headers.request = requests.id AND request_headers.name = ? AND instr(request_headers.value, ?) > 0)")
+		args = append(args, strings.ToLower(filter.HeaderName), filter.HeaderValue)
+	}
+	if filter.Body != "" {
+		if db.fullTextSearch.Load() {
+			conditions = append(conditions, "id IN (SELECT rowid FROM requests_fts WHERE requests_fts MATCH ?)")
+			args = append(args, fullTextQuery(filter.Body))
+		} else {
+			conditions = append(conditions, "instr(body, ?) > 0")
+			args = append(args, filter.Body)
+		}
+	}
+	if filter.RemoteAddr != "" {
+		conditions = append(conditions, "instr(remoteAddr, ?) > 0")
+		args = append(args, filter.RemoteAddr)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "julianday(timestamp) >= julianday(?)")
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "julianday(timestamp) <= julianday(?)")
+		args = append(args, filter.To.UTC())
+	}
+	switch filter.Validation {
+	case models.Passed, models.Failed:
+		conditions = append(conditions, "validation = ?")
+		args = append(args, filter.Validation)
+	case models.NotValidated:
+		conditions = append(conditions, "validation = ''")
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " AND " + strings.Join(conditions, " AND "), args
+}
+
+// fullTextQuery quotes every term of a search so that user input is never
+// interpreted as FTS5 query syntax. All terms must be present to match.
+func fullTextQuery(search string) string {
+	terms := strings.Fields(search)
+	for i, term := range terms {
+		terms[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(terms, " ")
+}
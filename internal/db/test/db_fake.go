@@ -78,6 +78,8 @@ type Conn struct {
 	CountOfExecContext  int
 	QueryContextFake    func(context.Context, string, ...any) (*sql.Rows, error)
 	CountOfQueryContext int
+	BeginTxFake         func(context.Context, *sql.TxOptions) (*sql.Tx, error)
+	CountOfBeginTx      int
 	PingFake            func() error
 	CountOfPing         int
 	CloseFake           func() error
@@ -99,6 +101,11 @@ func (dbConnFake *Conn) QueryContext(ctx context.Context, query string, args ...
 	return dbConnFake.QueryContextFake(ctx, query, args...)
 }
 
+func (dbConnFake *Conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	dbConnFake.CountOfBeginTx++
+	return dbConnFake.BeginTxFake(ctx, opts)
+}
+
 func (dbConnFake *Conn) Ping() error {
 	dbConnFake.CountOfPing++
 	return dbConnFake.PingFake()
@@ -113,36 +120,229 @@ func (dbConnFake *Conn) VerifyCallCounts(t *testing.T, expected *Conn) {
 	assert.Equal(t, expected.CountOfPrepare, dbConnFake.CountOfPrepare)
 	assert.Equal(t, expected.CountOfExecContext, dbConnFake.CountOfExecContext)
 	assert.Equal(t, expected.CountOfQueryContext, dbConnFake.CountOfQueryContext)
+	assert.Equal(t, expected.CountOfBeginTx, dbConnFake.CountOfBeginTx)
 	assert.Equal(t, expected.CountOfPing, dbConnFake.CountOfPing)
 	assert.Equal(t, expected.CountOfClose, dbConnFake.CountOfClose)
 }
 
 type Db struct {
-	CreateBinFake         func(bin models.Bin) (int64, error)
-	CountOfCreateBin      int
-	InsertRequestFake     func(request models.Request) error
-	CountOfInsertRequest  int
-	GetBinContentsFake    func(binId int64) ([]models.Request, error)
-	CountOfGetBinContents int
+	CreateBinFake                 func(bin models.Bin) (int64, error)
+	CountOfCreateBin              int
+	InsertRequestFake             func(request models.Request) (int64, error)
+	CountOfInsertRequest          int
+	InsertRequestsFake            func(requests []models.Request) error
+	CountOfInsertRequests         int
+	GetBinContentsFake            func(binId int64, filter models.RequestFilter, page models.Page) ([]models.Request, error)
+	CountOfGetBinContents         int
+	GetRequestFake                func(binId, requestId int64) (models.Request, error)
+	CountOfGetRequest             int
+	SetRulesFake                  func(binId int64, rules []models.Rule) error
+	CountOfSetRules               int
+	GetRulesFake                  func(binId int64) ([]models.Rule, error)
+	CountOfGetRules               int
+	GetBinFake                    func(binId int64) (models.Bin, error)
+	CountOfGetBin                 int
+	DeleteRequestFake             func(binId, requestId int64) error
+	CountOfDeleteRequest          int
+	ClearBinFake                  func(binId int64) error
+	CountOfClearBin               int
+	DeleteBinFake                 func(binId int64) error
+	CountOfDeleteBin              int
+	AddDroppedRequestsFake        func(dropped map[int64]int64) error
+	CountOfAddDroppedRequests     int
+	SetBinRateLimitFake           func(binId int64, limit string) error
+	CountOfSetBinRateLimit        int
+	GetRequestsAfterFake          func(binId, afterId int64, limit int) ([]models.Request, error)
+	CountOfGetRequestsAfter       int
+	SetNotificationTargetsFake    func(binId int64, targets []models.NotificationTarget) error
+	CountOfSetNotificationTargets int
+	GetNotificationTargetsFake    func(binId int64) ([]models.NotificationTarget, error)
+	CountOfGetNotificationTargets int
+	InsertFrameFake               func(frame models.Frame) (int64, error)
+	CountOfInsertFrame            int
+	GetFramesFake                 func(binId, requestId int64) ([]models.Frame, error)
+	CountOfGetFrames              int
+	SetWebSocketScriptFake        func(binId int64, script models.WebSocketScript) error
+	CountOfSetWebSocketScript     int
+	GetWebSocketScriptFake        func(binId int64) (models.WebSocketScript, error)
+	CountOfGetWebSocketScript     int
+	SetGrpcSettingsFake           func(binId int64, settings models.GrpcSettings) error
+	CountOfSetGrpcSettings        int
+	GetGrpcSettingsFake           func(binId int64) (models.GrpcSettings, error)
+	CountOfGetGrpcSettings        int
+	InsertCapturePortFake         func(port models.CapturePort) error
+	CountOfInsertCapturePort      int
+	GetCapturePortsFake           func(binId int64) ([]models.CapturePort, error)
+	CountOfGetCapturePorts        int
+	DeleteCapturePortFake         func(port models.CapturePort) error
+	CountOfDeleteCapturePort      int
+	SetBinSchemaFake              func(binId int64, schema models.BinSchema) error
+	CountOfSetBinSchema           int
+	GetBinSchemaFake              func(binId int64) (models.BinSchema, error)
+	CountOfGetBinSchema           int
 }
 
-func (db *Db) CreateBin(bin models.Bin) (int64, error) {
+func (db *Db) CreateBin(ctx context.Context, bin models.Bin) (int64, error) {
 	db.CountOfCreateBin++
 	return db.CreateBinFake(bin)
 }
 
-func (db *Db) InsertRequest(request models.Request) error {
+func (db *Db) InsertRequest(ctx context.Context, request models.Request) (int64, error) {
 	db.CountOfInsertRequest++
 	return db.InsertRequestFake(request)
 }
 
-func (db *Db) GetBinContents(binId int64) ([]models.Request, error) {
+func (db *Db) InsertRequests(ctx context.Context, requests []models.Request) error {
+	db.CountOfInsertRequests++
+	return db.InsertRequestsFake(requests)
+}
+
+func (db *Db) GetBinContents(ctx context.Context, binId int64, filter models.RequestFilter, page models.Page) ([]models.Request, error) {
 	db.CountOfGetBinContents++
-	return db.GetBinContentsFake(binId)
+	return db.GetBinContentsFake(binId, filter, page)
+}
+
+func (db *Db) GetRequest(ctx context.Context, binId, requestId int64) (models.Request, error) {
+	db.CountOfGetRequest++
+	return db.GetRequestFake(binId, requestId)
+}
+
+func (db *Db) SetRules(ctx context.Context, binId int64, rules []models.Rule) error {
+	db.CountOfSetRules++
+	return db.SetRulesFake(binId, rules)
+}
+
+func (db *Db) GetRules(ctx context.Context, binId int64) ([]models.Rule, error) {
+	db.CountOfGetRules++
+	return db.GetRulesFake(binId)
+}
+
+func (db *Db) GetBin(ctx context.Context, binId int64) (models.Bin, error) {
+	db.CountOfGetBin++
+	return db.GetBinFake(binId)
+}
+
+func (db *Db) DeleteRequest(ctx context.Context, binId, requestId int64) error {
+	db.CountOfDeleteRequest++
+	return db.DeleteRequestFake(binId, requestId)
+}
+
+func (db *Db) ClearBin(ctx context.Context, binId int64) error {
+	db.CountOfClearBin++
+	return db.ClearBinFake(binId)
+}
+
+func (db *Db) AddDroppedRequests(ctx context.Context, dropped map[int64]int64) error {
+	db.CountOfAddDroppedRequests++
+	return db.AddDroppedRequestsFake(dropped)
+}
+
+func (db *Db) SetBinRateLimit(ctx context.Context, binId int64, limit string) error {
+	db.CountOfSetBinRateLimit++
+	return db.SetBinRateLimitFake(binId, limit)
+}
+
+func (db *Db) GetRequestsAfter(ctx context.Context, binId, afterId int64, limit int) ([]models.Request, error) {
+	db.CountOfGetRequestsAfter++
+	return db.GetRequestsAfterFake(binId, afterId, limit)
+}
+
+func (db *Db) SetNotificationTargets(ctx context.Context, binId int64, targets []models.NotificationTarget) error {
+	db.CountOfSetNotificationTargets++
+	return db.SetNotificationTargetsFake(binId, targets)
+}
+
+func (db *Db) GetNotificationTargets(ctx context.Context, binId int64) ([]models.NotificationTarget, error) {
+	db.CountOfGetNotificationTargets++
+	return db.GetNotificationTargetsFake(binId)
+}
+
+func (db *Db) InsertFrame(ctx context.Context, frame models.Frame) (int64, error) {
+	db.CountOfInsertFrame++
+	return db.InsertFrameFake(frame)
+}
+
+func (db *Db) GetFrames(ctx context.Context, binId, requestId int64) ([]models.Frame, error) {
+	db.CountOfGetFrames++
+	return db.GetFramesFake(binId, requestId)
+}
+
+func (db *Db) SetWebSocketScript(ctx context.Context, binId int64, script models.WebSocketScript) error {
+	db.CountOfSetWebSocketScript++
+	return db.SetWebSocketScriptFake(binId, script)
+}
+
+func (db *Db) GetWebSocketScript(ctx context.Context, binId int64) (models.WebSocketScript, error) {
+	db.CountOfGetWebSocketScript++
+	return db.GetWebSocketScriptFake(binId)
+}
+
+func (db *Db) SetGrpcSettings(ctx context.Context, binId int64, settings models.GrpcSettings) error {
+	db.CountOfSetGrpcSettings++
+	return db.SetGrpcSettingsFake(binId, settings)
+}
+
+func (db *Db) GetGrpcSettings(ctx context.Context, binId int64) (models.GrpcSettings, error) {
+	db.CountOfGetGrpcSettings++
+	return db.GetGrpcSettingsFake(binId)
+}
+
+func (db *Db) InsertCapturePort(ctx context.Context, port models.CapturePort) error {
+	db.CountOfInsertCapturePort++
+	return db.InsertCapturePortFake(port)
+}
+
+func (db *Db) GetCapturePorts(ctx context.Context, binId int64) ([]models.CapturePort, error) {
+	db.CountOfGetCapturePorts++
+	return db.GetCapturePortsFake(binId)
+}
+
+func (db *Db) DeleteCapturePort(ctx context.Context, port models.CapturePort) error {
+	db.CountOfDeleteCapturePort++
+	return db.DeleteCapturePortFake(port)
+}
+
+func (db *Db) SetBinSchema(ctx context.Context, binId int64, schema models.BinSchema) error {
+	db.CountOfSetBinSchema++
+	return db.SetBinSchemaFake(binId, schema)
+}
+
+func (db *Db) GetBinSchema(ctx context.Context, binId int64) (models.BinSchema, error) {
+	db.CountOfGetBinSchema++
+	return db.GetBinSchemaFake(binId)
+}
+
+func (db *Db) DeleteBin(ctx context.Context, binId int64) error {
+	db.CountOfDeleteBin++
+	return db.DeleteBinFake(binId)
 }
 
 func (db *Db) VerifyCallCounts(t *testing.T, expected *Db) {
 	assert.Equal(t, expected.CountOfCreateBin, db.CountOfCreateBin)
 	assert.Equal(t, expected.CountOfInsertRequest, db.CountOfInsertRequest)
+	assert.Equal(t, expected.CountOfInsertRequests, db.CountOfInsertRequests)
 	assert.Equal(t, expected.CountOfGetBinContents, db.CountOfGetBinContents)
+	assert.Equal(t, expected.CountOfGetRequest, db.CountOfGetRequest)
+	assert.Equal(t, expected.CountOfSetRules, db.CountOfSetRules)
+	assert.Equal(t, expected.CountOfGetRules, db.CountOfGetRules)
+	assert.Equal(t, expected.CountOfGetBin, db.CountOfGetBin)
+	assert.Equal(t, expected.CountOfDeleteRequest, db.CountOfDeleteRequest)
+	assert.Equal(t, expected.CountOfClearBin, db.CountOfClearBin)
+	assert.Equal(t, expected.CountOfDeleteBin, db.CountOfDeleteBin)
+	assert.Equal(t, expected.CountOfAddDroppedRequests, db.CountOfAddDroppedRequests)
+	assert.Equal(t, expected.CountOfSetBinRateLimit, db.CountOfSetBinRateLimit)
+	assert.Equal(t, expected.CountOfGetRequestsAfter, db.CountOfGetRequestsAfter)
+	assert.Equal(t, expected.CountOfSetNotificationTargets, db.CountOfSetNotificationTargets)
+	assert.Equal(t, expected.CountOfGetNotificationTargets, db.CountOfGetNotificationTargets)
+	assert.Equal(t, expected.CountOfInsertFrame, db.CountOfInsertFrame)
+	assert.Equal(t, expected.CountOfGetFrames, db.CountOfGetFrames)
+	assert.Equal(t, expected.CountOfSetWebSocketScript, db.CountOfSetWebSocketScript)
+	assert.Equal(t, expected.CountOfGetWebSocketScript, db.CountOfGetWebSocketScript)
+	assert.Equal(t, expected.CountOfSetGrpcSettings, db.CountOfSetGrpcSettings)
+	assert.Equal(t, expected.CountOfGetGrpcSettings, db.CountOfGetGrpcSettings)
+	assert.Equal(t, expected.CountOfInsertCapturePort, db.CountOfInsertCapturePort)
+	assert.Equal(t, expected.CountOfGetCapturePorts, db.CountOfGetCapturePorts)
+	assert.Equal(t, expected.CountOfDeleteCapturePort, db.CountOfDeleteCapturePort)
+	assert.Equal(t, expected.CountOfSetBinSchema, db.CountOfSetBinSchema)
+	assert.Equal(t, expected.CountOfGetBinSchema, db.CountOfGetBinSchema)
 }
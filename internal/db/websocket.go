@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+
+	"app/internal/models"
+)
+
+// InsertFrame stores a message of a WebSocket session and returns its id.
+func (db *Db) InsertFrame(ctx context.Context, frame models.Frame) (int64, error) {
+	query := "INSERT INTO websocket_frames (request, timestamp, direction, kind, size, payload) VALUES (?, ?, ?, ?, ?, ?)"
+	payload := frame.Payload
+	if payload == nil {
+		payload = []byte{}
+	}
+	res, err := db.conn.ExecContext(
+		ctx,
+		query,
+		frame.Request,
+		frame.ReceivedAt.UTC(),
+		frame.Direction,
+		frame.Kind,
+		frame.Size,
+		payload,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetFrames returns the messages of the WebSocket session captured as a
+// request of a bin, in the order they were sent.
+func (db *Db) GetFrames(ctx context.Context, binId, requestId int64) ([]models.Frame, error) {
+	query := "SELECT f.id, f.request, f.timestamp, f.direction, f.kind, f.size, f.payload FROM websocket_frames f JOIN requests r ON r.id = f.request WHERE r.bin = ? AND f.request = ? ORDER BY f.id"
+	rows, err := db.conn.QueryContext(ctx, query, binId, requestId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var frames []models.Frame
+	for rows.Next() {
+		var frame models.Frame
+		err := rows.Scan(
+			&frame.Id,
+			&frame.Request,
+			&frame.ReceivedAt,
+			&frame.Direction,
+			&frame.Kind,
+			&frame.Size,
+			&frame.Payload,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, frame)
+	}
+
+	return frames, rows.Err()
+}
+
+// SetWebSocketScript replaces how a bin answers WebSocket sessions.
+func (db *Db) SetWebSocketScript(ctx context.Context, binId int64, script models.WebSocketScript) error {
+	onConnect, err := json.Marshal(script.OnConnect)
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO websocket_scripts (bin, echo, onConnect, reply) VALUES (?, ?, ?, ?) ON CONFLICT (bin) DO UPDATE SET echo = excluded.echo, onConnect = excluded.onConnect, reply = excluded.reply"
+	_, err = db.conn.ExecContext(ctx, query, binId, script.Echo, string(onConnect), script.Reply)
+	return err
+}
+
+// GetWebSocketScript returns how a bin answers WebSocket sessions, the zero
+// script when never set.
+func (db *Db) GetWebSocketScript(ctx context.Context, binId int64) (models.WebSocketScript, error) {
+	query := "SELECT echo, onConnect, reply FROM websocket_scripts WHERE bin = ?"
+	rows, err := db.conn.QueryContext(ctx, query, binId)
+	if err != nil {
+		return models.WebSocketScript{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return models.WebSocketScript{}, rows.Err()
+	}
+
+	var script models.WebSocketScript
+	var onConnect string
+	if err := rows.Scan(&script.Echo, &onConnect, &script.Reply); err != nil {
+		return models.WebSocketScript{}, err
+	}
+
+	if err := json.Unmarshal([]byte(onConnect), &script.OnConnect); err != nil {
+		return models.WebSocketScript{}, err
+	}
+	return script, nil
+}
@@ -1,11 +1,18 @@
 package app
 
+import "context"
+
 type Db interface {
-	Connect() error
+	Connect(ctx context.Context) error
 }
 
-type Services interface{}
+type Services interface {
+	RecordDroppedRequests(ctx context.Context)
+	CloseStreams(ctx context.Context)
+	OpenCapturePorts(ctx context.Context) error
+}
 
 type Server interface {
 	Start() error
+	Shutdown(ctx context.Context) error
 }
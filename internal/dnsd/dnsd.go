@@ -0,0 +1,365 @@
+// Package dnsd answers DNS queries for the names of a zone, and captures
+// each query for <bin>.<zone>, or a name below it, as a request of the bin.
+package dnsd
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"app/internal/models"
+)
+
+// ErrServerClosed is returned by Serve and ServeTCP once the server is shut
+// down.
+var ErrServerClosed = errors.New("dnsd: server closed")
+
+// Capture stores a query for a bin, once answered.
+type Capture = func(ctx context.Context, request models.Request)
+
+type Options struct {
+	// Addr is the address the server listens on, over UDP and TCP.
+	Addr string
+	// Zone is the domain the names of bins are in.
+	Zone string
+	// Answers are the addresses A and AAAA queries for the names of the zone
+	// are answered with, by their version.
+	Answers []netip.Addr
+	// Timeout closes TCP connections waiting that long for a query.
+	Timeout time.Duration
+	// MaxQueries are answered at once, those beyond it are dropped over UDP
+	// and refused over TCP.
+	MaxQueries int
+	// Accept, when set, checks that a bin accepts queries. Names whose bin
+	// is not found, see models.ErrNotFound, do not exist, and others failing
+	// the check are answered with a server failure.
+	Accept func(ctx context.Context, binId int64) error
+}
+
+// DefaultOptions are the options used in place of zero ones.
+var DefaultOptions = Options{
+	Zone:       "localhost",
+	Timeout:    10 * time.Second,
+	MaxQueries: 100,
+}
+
+// maxMessage bounds the size of queries, larger than those sent over UDP
+// with EDNS.
+const maxMessage = 4 << 10
+
+// ttl of the answers, none so that every lookup reaches the server.
+const ttl = 0
+
+type Server struct {
+	options Options
+	capture Capture
+	// open counts the queries and connections being served, bounded by
+	// MaxQueries
+	open chan struct{}
+
+	mu         sync.Mutex
+	packetConn net.PacketConn
+	listener   net.Listener
+	conns      map[net.Conn]struct{}
+	closed     bool
+	serving    sync.WaitGroup
+}
+
+func New(options Options, capture Capture) *Server {
+	options.Zone = strings.TrimSuffix(strings.ToLower(options.Zone), ".")
+	if options.Zone == "" {
+		options.Zone = DefaultOptions.Zone
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultOptions.Timeout
+	}
+	if options.MaxQueries <= 0 {
+		options.MaxQueries = DefaultOptions.MaxQueries
+	}
+	return &Server{
+		options: options,
+		capture: capture,
+		open:    make(chan struct{}, options.MaxQueries),
+		conns:   map[net.Conn]struct{}{},
+	}
+}
+
+// ListenAndServe listens on Addr over UDP and TCP, and serves until the
+// server is shut down or fails.
+func (s *Server) ListenAndServe() error {
+	packetConn, err := net.ListenPacket("udp", s.options.Addr)
+	if err != nil {
+		return err
+	}
+	// the same port over TCP, when Addr picks one
+	listener, err := net.Listen("tcp", packetConn.LocalAddr().String())
+	if err != nil {
+		packetConn.Close()
+		return err
+	}
+	slog.Info("serving dns", "addr", packetConn.LocalAddr().String(), "zone", s.options.Zone)
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- s.Serve(packetConn)
+	}()
+	go func() {
+		errs <- s.ServeTCP(listener)
+	}()
+	return <-errs
+}
+
+// Serve answers the queries received on the packet connection until the
+// server is shut down, returning ErrServerClosed then.
+func (s *Server) Serve(packetConn net.PacketConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		packetConn.Close()
+		return ErrServerClosed
+	}
+	s.packetConn = packetConn
+	s.mu.Unlock()
+
+	buffer := make([]byte, maxMessage)
+	for {
+		n, remote, err := packetConn.ReadFrom(buffer)
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		select {
+		case s.open <- struct{}{}:
+		default:
+			// resolvers retry queries left unanswered
+			continue
+		}
+		if !s.track(nil) {
+			<-s.open
+			return ErrServerClosed
+		}
+		packet := make([]byte, n)
+		copy(packet, buffer[:n])
+		go func() {
+			defer s.serving.Done()
+			defer func() { <-s.open }()
+			answer, captured := s.answer(context.Background(), packet, remote)
+			if answer == nil {
+				return
+			}
+			if _, err := packetConn.WriteTo(answer, remote); err != nil {
+				slog.Debug("answering dns query", "remote", remote.String(), "error", err)
+			}
+			if captured != nil {
+				s.capture(context.Background(), *captured)
+			}
+		}()
+	}
+}
+
+// ServeTCP answers the queries of the connections accepted on the listener
+// until the server is shut down, returning ErrServerClosed then.
+func (s *Server) ServeTCP(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		select {
+		case s.open <- struct{}{}:
+		default:
+			conn.Close()
+			continue
+		}
+		if !s.track(conn) {
+			<-s.open
+			conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.serving.Done()
+			defer func() { <-s.open }()
+			defer s.untrack(conn)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn answers the queries of a TCP connection, each prefixed with its
+// length, until it is closed or times out.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		conn.SetDeadline(time.Now().Add(s.options.Timeout))
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		packet := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, packet); err != nil {
+			return
+		}
+		answer, captured := s.answer(context.Background(), packet, conn.RemoteAddr())
+		if answer == nil {
+			return
+		}
+		if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(answer)))); err != nil {
+			return
+		}
+		if _, err := conn.Write(answer); err != nil {
+			return
+		}
+		if captured != nil {
+			s.capture(context.Background(), *captured)
+		}
+	}
+}
+
+// track registers a connection, or a UDP query when nil, to wait for on
+// Shutdown, unless shut down already.
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if conn != nil {
+		s.conns[conn] = struct{}{}
+	}
+	s.serving.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Shutdown stops receiving queries and waits for those being answered until
+// ctx is done, when the remaining connections are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	if s.packetConn != nil {
+		s.packetConn.Close()
+	}
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.serving.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+// answer returns the response to a query, nil when it is dropped, and the
+// request to capture once answered, nil when the name is not of a bin.
+func (s *Server) answer(ctx context.Context, packet []byte, remote net.Addr) ([]byte, *models.Request) {
+	q, err := parseQuery(packet)
+	if err != nil {
+		return nil, nil
+	}
+	switch {
+	case q.opcode != 0:
+		return response(q, rcodeNotImpl, nil, ttl), nil
+	case q.questions != 1 || q.question == nil:
+		return response(q, rcodeFormat, nil, ttl), nil
+	}
+
+	if q.name == s.options.Zone {
+		return response(q, rcodeSuccess, s.answers(q), ttl), nil
+	}
+	rest, ok := strings.CutSuffix(q.name, "."+s.options.Zone)
+	if !ok {
+		return response(q, rcodeRefused, nil, ttl), nil
+	}
+	// the label next to the zone names the bin
+	binId, err := strconv.ParseInt(rest[strings.LastIndexByte(rest, '.')+1:], 10, 64)
+	if err != nil || binId <= 0 {
+		return response(q, rcodeNameErr, nil, ttl), nil
+	}
+	if s.options.Accept != nil {
+		if err := s.options.Accept(ctx, binId); errors.Is(err, models.ErrNotFound) {
+			return response(q, rcodeNameErr, nil, ttl), nil
+		} else if err != nil {
+			slog.WarnContext(ctx, "accepting dns query", "bin", binId, "error", err)
+			return response(q, rcodeServerFail, nil, ttl), nil
+		}
+	}
+
+	request := models.Request{
+		Bin:           binId,
+		RecievedAt:    time.Now(),
+		Host:          s.options.Zone,
+		RemoteAddr:    remote.String(),
+		RequestUri:    q.name,
+		Method:        typeName(q.qtype),
+		Proto:         models.DNS,
+		ContentLength: int64(len(packet)),
+	}
+	request.SetHeaders(map[string][]string{})
+	return response(q, rcodeSuccess, s.answers(q), ttl), &request
+}
+
+// answers returns the addresses of the type queried, none for other types.
+func (s *Server) answers(q query) []netip.Addr {
+	if q.qclass != classINET || (q.qtype != typeA && q.qtype != typeAAAA) {
+		return nil
+	}
+	var answers []netip.Addr
+	for _, addr := range s.options.Answers {
+		if addr.Is4() == (q.qtype == typeA) {
+			answers = append(answers, addr)
+		}
+	}
+	return answers
+}
@@ -0,0 +1,295 @@
+package dnsd
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"app/internal/models"
+)
+
+type captured struct {
+	mu       sync.Mutex
+	requests []models.Request
+}
+
+func (c *captured) capture(ctx context.Context, request models.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, request)
+}
+
+func (c *captured) get() []models.Request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]models.Request(nil), c.requests...)
+}
+
+// serve returns the addresses the server listens on over UDP and TCP.
+func serve(t *testing.T, options Options) (string, string, *captured) {
+	t.Helper()
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	captured := &captured{}
+	options.Zone = "Bins.Example.com."
+	options.Answers = []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")}
+	server := New(options, captured.capture)
+	go server.Serve(packetConn)
+	go server.ServeTCP(listener)
+	t.Cleanup(func() {
+		server.Shutdown(context.Background())
+	})
+	return packetConn.LocalAddr().String(), listener.Addr().String(), captured
+}
+
+// newQuery encodes a recursive query for a name.
+func newQuery(id uint16, name string, qtype uint16) []byte {
+	packet := []byte{byte(id >> 8), byte(id), 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		packet = append(packet, byte(len(label)))
+		packet = append(packet, label...)
+	}
+	packet = append(packet, 0)
+	packet = binary.BigEndian.AppendUint16(packet, qtype)
+	return binary.BigEndian.AppendUint16(packet, classINET)
+}
+
+type answer struct {
+	id      uint16
+	rcode   int
+	answers []netip.Addr
+}
+
+func parseAnswer(t *testing.T, packet []byte) answer {
+	t.Helper()
+	if !assert.GreaterOrEqual(t, len(packet), headerSize) {
+		return answer{}
+	}
+	a := answer{
+		id:    binary.BigEndian.Uint16(packet),
+		rcode: int(packet[3] & 0xf),
+	}
+	assert.NotZero(t, packet[2]&0x80, "response flag")
+	offset := headerSize
+	if binary.BigEndian.Uint16(packet[4:]) == 1 {
+		_, end, err := parseName(packet, offset)
+		assert.NoError(t, err)
+		offset = end + 4
+	}
+	for range binary.BigEndian.Uint16(packet[6:]) {
+		_, end, err := parseName(packet, offset)
+		offset = end
+		assert.NoError(t, err)
+		length := int(binary.BigEndian.Uint16(packet[offset+8:]))
+		addr, _ := netip.AddrFromSlice(packet[offset+10 : offset+10+length])
+		a.answers = append(a.answers, addr)
+		offset += 10 + length
+	}
+	return a
+}
+
+func exchangeUDP(t *testing.T, addr string, query []byte) answer {
+	t.Helper()
+	conn, err := net.Dial("udp", addr)
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write(query)
+	assert.NoError(t, err)
+	buffer := make([]byte, 512)
+	n, err := conn.Read(buffer)
+	assert.NoError(t, err)
+	return parseAnswer(t, buffer[:n])
+}
+
+func Test_Server(t *testing.T) {
+	t.Run("queries for bins", func(t *testing.T) {
+		addr, _, captured := serve(t, Options{})
+
+		for _, exchange := range []struct {
+			name    string
+			qtype   uint16
+			answers []netip.Addr
+		}{
+			{"ssrf.1.bins.example.com", typeA, []netip.Addr{netip.MustParseAddr("192.0.2.1")}},
+			{"2.BINS.example.com", typeAAAA, []netip.Addr{netip.MustParseAddr("2001:db8::1")}},
+			{"a.b.1.bins.example.com", 16, nil},
+		} {
+			a := exchangeUDP(t, addr, newQuery(7, exchange.name, exchange.qtype))
+			assert.Equal(t, answer{id: 7, rcode: rcodeSuccess, answers: exchange.answers}, a, exchange.name)
+		}
+
+		assert.Eventually(t, func() bool { return len(captured.get()) == 3 }, time.Second, 10*time.Millisecond)
+		requests := captured.get()
+		// captured once answered, in any order
+		slices.SortFunc(requests, func(a, b models.Request) int { return a.RecievedAt.Compare(b.RecievedAt) })
+		if assert.Len(t, requests, 3) {
+			request := requests[0]
+			assert.Equal(t, int64(1), request.Bin)
+			assert.Equal(t, "A", request.Method)
+			assert.Equal(t, models.DNS, request.Proto)
+			assert.Equal(t, "ssrf.1.bins.example.com", request.RequestUri)
+			assert.Equal(t, "bins.example.com", request.Host)
+			assert.True(t, strings.HasPrefix(request.RemoteAddr, "127.0.0.1:"), request.RemoteAddr)
+			assert.Equal(t, int64(len(newQuery(7, "ssrf.1.bins.example.com", typeA))), request.ContentLength)
+			assert.True(t, request.IsDNS())
+
+			assert.Equal(t, int64(2), requests[1].Bin)
+			assert.Equal(t, "2.bins.example.com", requests[1].RequestUri)
+			assert.Equal(t, "AAAA", requests[1].Method)
+			assert.Equal(t, "TXT", requests[2].Method)
+		}
+	})
+
+	t.Run("names not of bins", func(t *testing.T) {
+		addr, _, captured := serve(t, Options{})
+
+		for name, rcode := range map[string]int{
+			"bins.example.com":        rcodeSuccess,
+			"example.com":             rcodeRefused,
+			"1.example.org":           rcodeRefused,
+			"www.bins.example.com":    rcodeNameErr,
+			"1.www.bins.example.com":  rcodeNameErr,
+			"0.bins.example.com":      rcodeNameErr,
+			"1.bins.example.com.evil": rcodeRefused,
+		} {
+			a := exchangeUDP(t, addr, newQuery(1, name, typeA))
+			assert.Equal(t, rcode, a.rcode, name)
+		}
+		time.Sleep(50 * time.Millisecond)
+		assert.Empty(t, captured.get())
+	})
+
+	t.Run("missing bin", func(t *testing.T) {
+		addr, _, captured := serve(t, Options{
+			Accept: func(ctx context.Context, binId int64) error {
+				switch binId {
+				case 1:
+					return nil
+				case 2:
+					return errors.New("database is locked")
+				}
+				return models.ErrNotFound
+			},
+		})
+
+		for name, rcode := range map[string]int{
+			"1.bins.example.com": rcodeSuccess,
+			"2.bins.example.com": rcodeServerFail,
+			"3.bins.example.com": rcodeNameErr,
+		} {
+			a := exchangeUDP(t, addr, newQuery(1, name, typeA))
+			assert.Equal(t, rcode, a.rcode, name)
+		}
+		assert.Eventually(t, func() bool { return len(captured.get()) == 1 }, time.Second, 10*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		assert.Len(t, captured.get(), 1)
+	})
+
+	t.Run("malformed queries", func(t *testing.T) {
+		addr, _, _ := serve(t, Options{})
+
+		noQuestion := newQuery(3, "1.bins.example.com", typeA)[:headerSize]
+		noQuestion[5] = 0
+		a := exchangeUDP(t, addr, noQuestion)
+		assert.Equal(t, rcodeFormat, a.rcode)
+
+		notify := newQuery(4, "1.bins.example.com", typeA)
+		notify[2] |= 4 << 3
+		a = exchangeUDP(t, addr, notify)
+		assert.Equal(t, rcodeNotImpl, a.rcode)
+	})
+
+	t.Run("over tcp", func(t *testing.T) {
+		_, tcpAddr, captured := serve(t, Options{})
+		conn, err := net.Dial("tcp", tcpAddr)
+		assert.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		for id, name := range []string{"x.1.bins.example.com", "y.1.bins.example.com"} {
+			query := newQuery(uint16(id), name, typeA)
+			_, err = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...))
+			assert.NoError(t, err)
+
+			var length [2]byte
+			_, err = io.ReadFull(conn, length[:])
+			assert.NoError(t, err)
+			packet := make([]byte, binary.BigEndian.Uint16(length[:]))
+			_, err = io.ReadFull(conn, packet)
+			assert.NoError(t, err)
+			a := parseAnswer(t, packet)
+			assert.Equal(t, uint16(id), a.id)
+			assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.1")}, a.answers)
+		}
+		assert.Eventually(t, func() bool { return len(captured.get()) == 2 }, time.Second, 10*time.Millisecond)
+	})
+}
+
+func Test_parseName(t *testing.T) {
+	// www.example.com, then ftp. pointing to example.com
+	packet := []byte{3, 'W', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 3, 'f', 't', 'p', 0xc0, 4}
+	name, end, err := parseName(packet, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "www.example.com", name)
+	assert.Equal(t, 17, end)
+
+	name, end, err = parseName(packet, 17)
+	assert.NoError(t, err)
+	assert.Equal(t, "ftp.example.com", name)
+	assert.Equal(t, len(packet), end)
+
+	name, _, err = parseName([]byte{3, 'a', '.', 0xff, 0}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, `a\.\255`, name)
+
+	for _, malformed := range [][]byte{
+		{3, 'w', 'w'},
+		{0xc0, 0},
+		{3, 'w', 'w', 'w', 0xc0, 4},
+		{0x40, 0},
+	} {
+		_, _, err := parseName(malformed, 0)
+		assert.ErrorIs(t, err, errMalformed, malformed)
+	}
+}
+
+func Test_Shutdown(t *testing.T) {
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := New(Options{}, func(context.Context, models.Request) {})
+	served := make(chan error, 2)
+	go func() {
+		served <- server.Serve(packetConn)
+	}()
+	go func() {
+		served <- server.ServeTCP(listener)
+	}()
+
+	// an idle connection is closed once the shutdown times out
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, <-served, ErrServerClosed)
+	assert.ErrorIs(t, <-served, ErrServerClosed)
+}
@@ -0,0 +1,216 @@
+package dnsd
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Response codes.
+const (
+	rcodeSuccess    = 0
+	rcodeFormat     = 1
+	rcodeServerFail = 2
+	rcodeNameErr    = 3
+	rcodeNotImpl    = 4
+	rcodeRefused    = 5
+)
+
+const (
+	headerSize    = 12
+	maxNameLength = 255
+	classINET     = 1
+)
+
+// Types of the records answered.
+const (
+	typeA    = 1
+	typeAAAA = 28
+)
+
+// typeNames are the names of the common query types, others are written
+// TYPE<n>.
+var typeNames = map[uint16]string{
+	1:   "A",
+	2:   "NS",
+	5:   "CNAME",
+	6:   "SOA",
+	12:  "PTR",
+	15:  "MX",
+	16:  "TXT",
+	28:  "AAAA",
+	33:  "SRV",
+	35:  "NAPTR",
+	43:  "DS",
+	48:  "DNSKEY",
+	64:  "SVCB",
+	65:  "HTTPS",
+	255: "ANY",
+	257: "CAA",
+}
+
+func typeName(qtype uint16) string {
+	if name, ok := typeNames[qtype]; ok {
+		return name
+	}
+	return "TYPE" + strconv.Itoa(int(qtype))
+}
+
+var errMalformed = errors.New("malformed message")
+
+// query is the question of a DNS query.
+type query struct {
+	id     uint16
+	opcode uint8
+	// recursion is the RD flag, copied to the response
+	recursion bool
+	// questions counts the questions, of which only the first one is
+	// parsed.
+	questions uint16
+	name      string
+	qtype     uint16
+	qclass    uint16
+	// question is the question section as received, echoed in responses
+	question []byte
+}
+
+// parseQuery parses the header and first question of a query. Queries
+// whose header can not be read are errMalformed, those whose question can
+// not are returned with no name.
+func parseQuery(packet []byte) (query, error) {
+	if len(packet) < headerSize {
+		return query{}, errMalformed
+	}
+	flags := binary.BigEndian.Uint16(packet[2:])
+	q := query{
+		id:        binary.BigEndian.Uint16(packet),
+		opcode:    uint8(flags>>11) & 0xf,
+		recursion: flags&0x0100 != 0,
+		questions: binary.BigEndian.Uint16(packet[4:]),
+	}
+	if flags&0x8000 != 0 {
+		// a response
+		return query{}, errMalformed
+	}
+	if q.questions == 0 {
+		return q, nil
+	}
+
+	name, end, err := parseName(packet, headerSize)
+	if err != nil || end+4 > len(packet) {
+		return q, nil
+	}
+	q.name = name
+	q.qtype = binary.BigEndian.Uint16(packet[end:])
+	q.qclass = binary.BigEndian.Uint16(packet[end+2:])
+	q.question = packet[headerSize : end+4]
+	return q, nil
+}
+
+// parseName reads the name at offset, following compression pointers, and
+// returns it lower-cased without its trailing dot, with the offset past it.
+func parseName(packet []byte, offset int) (string, int, error) {
+	var labels []string
+	end := -1
+	length := 0
+	// pointers only point backwards, which bounds their number
+	for jumps := 0; jumps < len(packet); jumps++ {
+		if offset >= len(packet) {
+			return "", 0, errMalformed
+		}
+		size := int(packet[offset])
+		switch {
+		case size == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case size&0xc0 == 0xc0:
+			if offset+1 >= len(packet) {
+				return "", 0, errMalformed
+			}
+			pointer := int(binary.BigEndian.Uint16(packet[offset:]) & 0x3fff)
+			if pointer >= offset {
+				return "", 0, errMalformed
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = pointer
+		case size&0xc0 != 0:
+			return "", 0, errMalformed
+		default:
+			if offset+1+size > len(packet) {
+				return "", 0, errMalformed
+			}
+			length += size + 1
+			if length > maxNameLength {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, escapeLabel(packet[offset+1:offset+1+size]))
+			offset += 1 + size
+		}
+	}
+	return "", 0, errMalformed
+}
+
+// escapeLabel writes a label as in zone files, lower-cased, with dots,
+// backslashes and unprintable bytes escaped.
+func escapeLabel(label []byte) string {
+	var escaped strings.Builder
+	for _, b := range label {
+		switch {
+		case b == '.' || b == '\\':
+			escaped.WriteByte('\\')
+			escaped.WriteByte(b)
+		case b < '!' || b > '~':
+			escaped.WriteString("\\" + strconv.Itoa(int(b)))
+		case b >= 'A' && b <= 'Z':
+			escaped.WriteByte(b + 'a' - 'A')
+		default:
+			escaped.WriteByte(b)
+		}
+	}
+	return escaped.String()
+}
+
+// response encodes the response to a query, answering it with the addresses
+// of its type.
+func response(q query, rcode uint8, answers []netip.Addr, ttl uint32) []byte {
+	flags := uint16(0x8000) | uint16(q.opcode)<<11 | uint16(rcode)
+	if rcode != rcodeRefused && rcode != rcodeNotImpl {
+		// authoritative
+		flags |= 0x0400
+	}
+	if q.recursion {
+		flags |= 0x0100
+	}
+	questions := uint16(0)
+	if q.question != nil {
+		questions = 1
+	}
+
+	packet := make([]byte, headerSize, 512)
+	binary.BigEndian.PutUint16(packet, q.id)
+	binary.BigEndian.PutUint16(packet[2:], flags)
+	binary.BigEndian.PutUint16(packet[4:], questions)
+	binary.BigEndian.PutUint16(packet[6:], uint16(len(answers)))
+	packet = append(packet, q.question...)
+	for _, addr := range answers {
+		qtype := uint16(typeA)
+		if addr.Is6() {
+			qtype = typeAAAA
+		}
+		rdata := addr.AsSlice()
+		// the name points to the question
+		packet = binary.BigEndian.AppendUint16(packet, 0xc000|headerSize)
+		packet = binary.BigEndian.AppendUint16(packet, qtype)
+		packet = binary.BigEndian.AppendUint16(packet, classINET)
+		packet = binary.BigEndian.AppendUint32(packet, ttl)
+		packet = binary.BigEndian.AppendUint16(packet, uint16(len(rdata)))
+		packet = append(packet, rdata...)
+	}
+	return packet
+}
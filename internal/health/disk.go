@@ -0,0 +1,27 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var errUnsupported = errors.New("disk free space unsupported")
+
+// MinDiskFree checks the file system holding dir has at least min bytes
+// available. Where the free space can not be read, the check passes.
+func MinDiskFree(dir string, min uint64) Check {
+	return func(context.Context) error {
+		free, err := diskFree(dir)
+		if err == errUnsupported {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if free < min {
+			return fmt.Errorf("%d bytes free, below %d", free, min)
+		}
+		return nil
+	}
+}
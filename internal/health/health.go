@@ -0,0 +1,127 @@
+// Package health serves the liveness and readiness probes of the app.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// State is where the app is in its lifecycle. Only a Ready app is checked
+// and reported ready.
+type State int32
+
+const (
+	Starting State = iota
+	Ready
+	Draining
+)
+
+func (s State) String() string {
+	switch s {
+	case Starting:
+		return "starting"
+	case Ready:
+		return "ready"
+	case Draining:
+		return "draining"
+	}
+	return "unknown"
+}
+
+// checkTimeout bounds the time a readiness check may take.
+const checkTimeout = 2 * time.Second
+
+// Check reports why the app can not serve requests, nil when it can.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Health tracks the state of the app and the checks it must pass to be
+// ready.
+type Health struct {
+	state  atomic.Int32
+	mu     sync.Mutex
+	checks []namedCheck
+}
+
+func New() *Health {
+	return &Health{}
+}
+
+func (h *Health) SetState(state State) {
+	h.state.Store(int32(state))
+}
+
+func (h *Health) State() State {
+	return State(h.state.Load())
+}
+
+// AddCheck adds a check run on every readiness probe.
+func (h *Health) AddCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, namedCheck{name, check})
+}
+
+// Report is the body of the readiness probe.
+type Report struct {
+	Status string `json:"status"`
+	// Checks maps the name of every check to "ok" or its error.
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Check runs the checks of a ready app, and reports whether they all pass.
+func (h *Health) Check(ctx context.Context) (Report, bool) {
+	state := h.State()
+	if state != Ready {
+		return Report{Status: state.String()}, false
+	}
+
+	h.mu.Lock()
+	checks := h.checks
+	h.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	report := Report{Status: "ready", Checks: map[string]string{}}
+	ok := true
+	for _, c := range checks {
+		if err := c.check(ctx); err != nil {
+			report.Checks[c.name] = err.Error()
+			ok = false
+			continue
+		}
+		report.Checks[c.name] = "ok"
+	}
+	if !ok {
+		report.Status = "unavailable"
+	}
+	return report, ok
+}
+
+// Live answers as long as the process serves requests.
+func (h *Health) Live(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// Ready answers 200 when the app is ready and passes its checks, 503
+// otherwise.
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	report, ok := h.Check(r.Context())
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
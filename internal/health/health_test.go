@@ -0,0 +1,75 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func probe(t *testing.T, h *Health) (int, Report) {
+	recorder := httptest.NewRecorder()
+	h.Ready(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	var report Report
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	return recorder.Code, report
+}
+
+func Test_Ready(t *testing.T) {
+	t.Run("not ready while starting or draining", func(t *testing.T) {
+		h := New()
+		checked := false
+		h.AddCheck("db", func(context.Context) error {
+			checked = true
+			return nil
+		})
+
+		status, report := probe(t, h)
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, Report{Status: "starting"}, report)
+
+		h.SetState(Draining)
+		status, report = probe(t, h)
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, Report{Status: "draining"}, report)
+		assert.False(t, checked)
+	})
+
+	t.Run("ready when all checks pass", func(t *testing.T) {
+		h := New()
+		h.SetState(Ready)
+		h.AddCheck("db", func(context.Context) error { return nil })
+
+		status, report := probe(t, h)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, Report{Status: "ready", Checks: map[string]string{"db": "ok"}}, report)
+	})
+
+	t.Run("unavailable when a check fails", func(t *testing.T) {
+		h := New()
+		h.SetState(Ready)
+		h.AddCheck("db", func(context.Context) error { return nil })
+		h.AddCheck("schema", func(context.Context) error { return errors.New("schema version 0, expected 1") })
+
+		status, report := probe(t, h)
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, Report{
+			Status: "unavailable",
+			Checks: map[string]string{"db": "ok", "schema": "schema version 0, expected 1"},
+		}, report)
+	})
+}
+
+func Test_MinDiskFree(t *testing.T) {
+	if _, err := diskFree(t.TempDir()); err == errUnsupported {
+		t.Skip(err)
+	}
+
+	assert.NoError(t, MinDiskFree(t.TempDir(), 0)(context.Background()))
+	assert.ErrorContains(t, MinDiskFree(t.TempDir(), 1<<62)(context.Background()), "below")
+	assert.Error(t, MinDiskFree("/does/not/exist", 0)(context.Background()))
+}
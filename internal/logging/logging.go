@@ -0,0 +1,126 @@
+// Package logging sets up the app's structured logs and the middlewares
+// tying log records to the HTTP request they were made for.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestIdHeader carries the id of a request, both ways.
+const RequestIdHeader = "X-Request-Id"
+
+// maxRequestIdLength bounds the ids taken from incoming requests.
+const maxRequestIdLength = 64
+
+// ParseFormat checks a log format, either "text" or "json".
+func ParseFormat(format string) (string, error) {
+	switch format {
+	case "text", "json":
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid log format %q, expected text or json", format)
+}
+
+// New returns a logger writing records of level and above to w in format,
+// see ParseFormat. Records logged with a context carry the id of its
+// request.
+func New(w io.Writer, level slog.Level, format string) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(w, options)
+	if format == "json" {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	return slog.New(contextHandler{handler})
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestId(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type requestIdKey struct{}
+
+// RequestId returns the id of the request being served, if any.
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// RequestIds gives every request an id, the one sent by the client or a
+// proxy in front of the app when it looks sane, a random one otherwise. The
+// id is sent back with the response.
+func RequestIds(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIdHeader)
+		if !validRequestId(id) {
+			id = newRequestId()
+		}
+		w.Header().Set(RequestIdHeader, id)
+		ctx := context.WithValue(r.Context(), requestIdKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for _, c := range id {
+		// ids end up in log lines and response headers
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AccessLog logs every request served. Only the path of the URL is logged,
+// as query strings, headers and bodies sent to bins are the users' data.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		slog.InfoContext(r.Context(), "http request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+		)
+	})
+}
@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"app/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureLogs logs to a JSON buffer for the duration of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(New(&buf, slog.LevelDebug, "json"))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func Test_RequestIds(t *testing.T) {
+	serve := func(r *http.Request) (string, string) {
+		var seen string
+		handler := RequestIds(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = RequestId(r.Context())
+		}))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return seen, recorder.Header().Get(RequestIdHeader)
+	}
+
+	t.Run("incoming ids are kept", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(RequestIdHeader, "abc-123")
+		seen, sent := serve(r)
+		assert.Equal(t, "abc-123", seen)
+		assert.Equal(t, "abc-123", sent)
+	})
+
+	for _, id := range []string{"", "with space", "line\nbreak", strings.Repeat("a", maxRequestIdLength+1)} {
+		t.Run("ids are generated in place of "+strings.ReplaceAll(id, "\n", `\n`), func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set(RequestIdHeader, id)
+			seen, sent := serve(r)
+			assert.Len(t, seen, 16)
+			assert.Equal(t, seen, sent)
+		})
+	}
+}
+
+func Test_AccessLog(t *testing.T) {
+	buf := captureLogs(t)
+	handler := RequestIds(AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slog.InfoContext(r.Context(), "handling")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("ok"))
+	})))
+	r := httptest.NewRequest("POST", "/bin/1/hook?token=secret", nil)
+	r.Header.Set(RequestIdHeader, "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	logged := records(t, buf)
+	assert.Len(t, logged, 2)
+	assert.Equal(t, "handling", logged[0]["msg"])
+	assert.Equal(t, "req-1", logged[0]["request_id"])
+
+	access := logged[1]
+	assert.Equal(t, "http request", access["msg"])
+	assert.Equal(t, "req-1", access["request_id"])
+	assert.Equal(t, "POST", access["method"])
+	assert.Equal(t, "/bin/1/hook", access["path"])
+	assert.Equal(t, float64(http.StatusCreated), access["status"])
+	assert.Equal(t, float64(2), access["bytes"])
+	assert.NotContains(t, buf.String(), "secret")
+}
+
+func Test_RequestRedaction(t *testing.T) {
+	buf := captureLogs(t)
+	request := models.Request{Id: 7, Bin: 1, Method: "POST", SubPath: "/hook", Body: `{"password":"hunter2"}`}
+	request.SetHeaders(map[string][]string{"Authorization": {"Bearer secret"}, "Accept": {"*/*"}})
+	slog.Info("captured", "request", request)
+
+	assert.NotContains(t, buf.String(), "hunter2")
+	assert.NotContains(t, buf.String(), "secret")
+	assert.Equal(t, map[string]any{
+		"id":       float64(7),
+		"bin":      float64(1),
+		"method":   "POST",
+		"subPath":  "/hook",
+		"bodySize": float64(22),
+		"headers":  []any{"Accept", "Authorization"},
+	}, records(t, buf)[0]["request"])
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Metrics are the metrics of the app, served by its Registry.
+type Metrics struct {
+	*Registry
+	// RequestsCaptured counts captured requests by method and the status
+	// the bin answered with.
+	RequestsCaptured *CounterVec
+	// RequestsDropped counts the requests refused by rate limits, by the
+	// limit refusing them: bin, client or new_bin.
+	RequestsDropped       *CounterVec
+	BinsCreated           *CounterVec
+	LogRequestDuration    *HistogramVec
+	InsertRequestDuration *HistogramVec
+	// NotificationsSent counts the notifications of captured requests by
+	// kind of target and result: sent, failed or dropped.
+	NotificationsSent *CounterVec
+	// Waiters are the clients waiting on a bin for new requests.
+	Waiters *Gauge
+	// WebSocketSessions are the WebSocket sessions being captured.
+	WebSocketSessions *Gauge
+	// HTTPRequests and HTTPRequestDuration cover every request served, by
+	// route pattern.
+	HTTPRequests        *CounterVec
+	HTTPRequestDuration *HistogramVec
+}
+
+func New() *Metrics {
+	r := &Registry{}
+	return &Metrics{
+		Registry:              r,
+		RequestsCaptured:      NewCounterVec(r, "httpbin_requests_captured_total", "Requests captured by bins.", "method", "status"),
+		RequestsDropped:       NewCounterVec(r, "httpbin_requests_dropped_total", "Requests refused by rate limits.", "limit"),
+		BinsCreated:           NewCounterVec(r, "httpbin_bins_created_total", "Bins created."),
+		LogRequestDuration:    NewHistogramVec(r, "httpbin_log_request_duration_seconds", "Time taken to capture a request and render its response.", DurationBuckets),
+		InsertRequestDuration: NewHistogramVec(r, "httpbin_db_insert_request_duration_seconds", "Time taken to store a captured request.", DurationBuckets),
+		NotificationsSent:     NewCounterVec(r, "httpbin_notifications_total", "Notifications of captured requests.", "kind", "result"),
+		Waiters:               NewGauge(r, "httpbin_waiters", "Clients streaming or long polling a bin for new requests."),
+		WebSocketSessions:     NewGauge(r, "httpbin_websocket_sessions", "WebSocket sessions being captured."),
+		HTTPRequests:          NewCounterVec(r, "httpbin_http_requests_total", "HTTP requests served.", "method", "route", "status"),
+		HTTPRequestDuration:   NewHistogramVec(r, "httpbin_http_request_duration_seconds", "Time taken to serve HTTP requests.", DurationBuckets, "route"),
+	}
+}
+
+// WatchDbSize reports the size in bytes of the database, read when the
+// metrics are written.
+func (m *Metrics) WatchDbSize(size func() (int64, error)) {
+	NewGaugeFunc(m.Registry, "httpbin_db_size_bytes", "Size of the database.", func() (float64, error) {
+		bytes, err := size()
+		return float64(bytes), err
+	})
+}
+
+// Since returns the seconds elapsed since start, for histograms.
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// MethodLabel keeps the methods counted to the standard ones, as clients
+// may send any method.
+func MethodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// Middleware counts and times the requests served by a chi router, by the
+// pattern of the route they matched.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		m.HTTPRequests.Inc(MethodLabel(r.Method), route, strconv.Itoa(recorder.status))
+		m.HTTPRequestDuration.Observe(Since(start), route)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush streamed responses.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,263 @@
+// Package metrics exposes the app's metrics in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DurationBuckets are the upper bounds, in seconds, of latency histograms.
+var DurationBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry writes the metrics registered with it.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// ServeHTTP writes all metrics in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.mu.Lock()
+	collectors := slices.Clone(r.collectors)
+	r.mu.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+type desc struct {
+	name       string
+	help       string
+	labelNames []string
+}
+
+func (d desc) writeHeader(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, kind)
+}
+
+// labels formats the label pairs of a sample, extra pairs last.
+func (d desc) labels(values []string, extra ...string) string {
+	var pairs []string
+	for i, name := range d.labelNames {
+		pairs = append(pairs, name+`="`+escape(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// series holds the values of a metric per combination of label values.
+type series[T any] struct {
+	mu     sync.Mutex
+	values map[string]*T
+	labels map[string][]string
+	new    func() *T
+}
+
+func (s *series[T]) with(values []string) *T {
+	key := strings.Join(values, "\xff")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = map[string]*T{}
+		s.labels = map[string][]string{}
+	}
+	v, ok := s.values[key]
+	if !ok {
+		v = s.new()
+		s.values[key] = v
+		s.labels[key] = slices.Clone(values)
+	}
+	return v
+}
+
+// each calls f for every series, ordered by label values.
+func (s *series[T]) each(f func(labels []string, v *T)) {
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	values := make([]*T, len(keys))
+	labels := make([][]string, len(keys))
+	for i, key := range keys {
+		values[i], labels[i] = s.values[key], s.labels[key]
+	}
+	s.mu.Unlock()
+
+	for i := range keys {
+		f(labels[i], values[i])
+	}
+}
+
+// CounterVec counts events per combination of label values.
+type CounterVec struct {
+	desc
+	series series[atomic.Uint64]
+}
+
+func NewCounterVec(r *Registry, name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: name, help: help, labelNames: labelNames},
+		series: series[atomic.Uint64]{new: func() *atomic.Uint64 { return new(atomic.Uint64) }},
+	}
+	r.register(c)
+	return c
+}
+
+// Inc counts an event with the label values, in the order of the label
+// names.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.series.with(labelValues).Add(1)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.writeHeader(w, "counter")
+	if len(c.labelNames) == 0 {
+		// counters without labels are reported from zero
+		c.series.with(nil)
+	}
+	c.series.each(func(labels []string, v *atomic.Uint64) {
+		fmt.Fprintf(w, "%s%s %d\n", c.name, c.labels(labels), v.Load())
+	})
+}
+
+type histogram struct {
+	mu      sync.Mutex
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// HistogramVec samples observations into buckets per combination of label
+// values.
+type HistogramVec struct {
+	desc
+	bounds []float64
+	series series[histogram]
+}
+
+func NewHistogramVec(r *Registry, name, help string, bounds []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:   desc{name: name, help: help, labelNames: labelNames},
+		bounds: bounds,
+	}
+	h.series.new = func() *histogram {
+		return &histogram{buckets: make([]uint64, len(bounds))}
+	}
+	r.register(h)
+	return h
+}
+
+// Observe samples a value with the label values, in the order of the label
+// names.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	s := h.series.with(labelValues)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, bound := range h.bounds {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.writeHeader(w, "histogram")
+	if len(h.labelNames) == 0 {
+		h.series.with(nil)
+	}
+	h.series.each(func(labels []string, s *histogram) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, bound := range h.bounds {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(labels, "le", formatFloat(bound)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labels(labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labels(labels), s.count)
+	})
+}
+
+// Gauge is a value going up and down.
+type Gauge struct {
+	desc
+	value atomic.Int64
+}
+
+func NewGauge(r *Registry, name, help string) *Gauge {
+	g := &Gauge{desc: desc{name: name, help: help}}
+	r.register(g)
+	return g
+}
+
+func (g *Gauge) Inc() {
+	g.value.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.value.Add(-1)
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.writeHeader(w, "gauge")
+	fmt.Fprintf(w, "%s %d\n", g.name, g.value.Load())
+}
+
+// GaugeFunc is a value read when the metrics are written. Failing reads
+// leave the gauge out.
+type GaugeFunc struct {
+	desc
+	read func() (float64, error)
+}
+
+func NewGaugeFunc(r *Registry, name, help string, read func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help}, read: read}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	value, err := g.read()
+	if err != nil {
+		return
+	}
+	g.writeHeader(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(value))
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape(r *Registry) string {
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	return recorder.Body.String()
+}
+
+func Test_Registry(t *testing.T) {
+	t.Run("counters", func(t *testing.T) {
+		r := &Registry{}
+		NewCounterVec(r, "plain_total", "Plain counter.")
+		labelled := NewCounterVec(r, "labelled_total", "Labelled counter.", "method", "status")
+		labelled.Inc("POST", "201")
+		labelled.Inc("GET", "200")
+		labelled.Inc("POST", "201")
+
+		assert.Equal(t, `# HELP plain_total Plain counter.
+# TYPE plain_total counter
+plain_total 0
+# HELP labelled_total Labelled counter.
+# TYPE labelled_total counter
+labelled_total{method="GET",status="200"} 1
+labelled_total{method="POST",status="201"} 2
+`, scrape(r))
+	})
+
+	t.Run("histograms", func(t *testing.T) {
+		r := &Registry{}
+		h := NewHistogramVec(r, "latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+		h.Observe(0.05, `/bin/"{binId}"`)
+		h.Observe(0.5, `/bin/"{binId}"`)
+
+		assert.Equal(t, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/bin/\"{binId}\"",le="0.1"} 1
+latency_seconds_bucket{route="/bin/\"{binId}\"",le="1"} 2
+latency_seconds_bucket{route="/bin/\"{binId}\"",le="+Inf"} 2
+latency_seconds_sum{route="/bin/\"{binId}\""} 0.55
+latency_seconds_count{route="/bin/\"{binId}\""} 2
+`, scrape(r))
+	})
+
+	t.Run("gauges", func(t *testing.T) {
+		r := &Registry{}
+		g := NewGauge(r, "waiters", "Waiters.")
+		g.Inc()
+		g.Inc()
+		g.Dec()
+		NewGaugeFunc(r, "size_bytes", "Size.", func() (float64, error) { return 4096, nil })
+		NewGaugeFunc(r, "broken", "Broken.", func() (float64, error) { return 0, errors.New("broken") })
+
+		assert.Equal(t, `# HELP waiters Waiters.
+# TYPE waiters gauge
+waiters 1
+# HELP size_bytes Size.
+# TYPE size_bytes gauge
+size_bytes 4096
+`, scrape(r))
+	})
+}
+
+func Test_Middleware(t *testing.T) {
+	m := New()
+	router := chi.NewRouter()
+	router.Use(m.Middleware)
+	router.HandleFunc("/bin/{binId}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	for _, method := range []string{"POST", "PURGE", "X-ANYTHING-12345"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/bin/1", nil))
+	}
+
+	metrics := scrape(m.Registry)
+	assert.Contains(t, metrics, `httpbin_http_requests_total{method="POST",route="/bin/{binId}",status="201"} 1`)
+	assert.Contains(t, metrics, `httpbin_http_requests_total{method="OTHER",route="unmatched",status="405"} 2`)
+	assert.NotContains(t, metrics, "PURGE")
+}
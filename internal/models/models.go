@@ -1,25 +1,360 @@
 package models
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// ErrNotFound is wrapped by the errors returned when a bin or request does
+// not exist.
+var ErrNotFound = errors.New("not found")
+
 type Bin struct {
 	BinId     int64
 	CreatedAt time.Time
 	Owner     string
+	// Dropped counts the requests to the bin refused by rate limits.
+	Dropped int64
+	// RateLimit limits the requests the bin captures, as events/duration,
+	// in place of the default limit of bins when not empty.
+	RateLimit string
 }
 
 type Request struct {
-	Id         int64
-	RecievedAt time.Time
-	Headers    string
-	RemoteAddr string
+	Id         int64     `json:"id"`
+	RecievedAt time.Time `json:"receivedAt"`
+	// Headers holds the encoded request headers, see GetHeaders.
+	Headers    string `json:"-"`
+	RemoteAddr string `json:"remoteAddr"`
+	Body       string `json:"body"`
+	Host       string `json:"host"`
+	RequestUri string `json:"requestUri"`
+	Method     string `json:"method"`
+	Bin        int64  `json:"bin"`
+	SubPath    string `json:"subPath"`
+	RuleId     int64  `json:"ruleId"`
+	// ResponseError is set when the response of the matched rule could not
+	// be rendered.
+	ResponseError string `json:"responseError"`
+	// Proto, ContentLength, TransferEncoding and Trailers are as parsed by
+	// net/http. ContentLength is -1 when unknown.
+	Proto            string              `json:"proto"`
+	ContentLength    int64               `json:"contentLength"`
+	TransferEncoding string              `json:"transferEncoding"`
+	Trailers         map[string][]string `json:"trailers"`
+	// RawHead, RawChunkSizes and RawTrailers are the request as read off the
+	// wire, see package wire. They are empty when the request was not
+	// recorded, e.g. for HTTP/2 requests.
+	RawHead       string   `json:"rawHead"`
+	RawChunkSizes []string `json:"rawChunkSizes"`
+	RawTrailers   string   `json:"rawTrailers"`
+	// ClientIp is the IP of the client, resolved through trusted proxies.
+	ClientIp string `json:"clientIp"`
+	// TLS details are empty for requests not served over TLS.
+	TLSVersion       string `json:"tlsVersion"`
+	TLSCipherSuite   string `json:"tlsCipherSuite"`
+	TLSServerName    string `json:"tlsServerName"`
+	TLSClientSubject string `json:"tlsClientSubject"`
+	// ClosedAt is when the TCP connection captured as the request closed,
+	// zero for other requests.
+	ClosedAt time.Time `json:"closedAt"`
+	// MailFrom and MailTo are the envelope sender and recipients of the mail
+	// captured as the request over SMTP, empty for other requests.
+	MailFrom string   `json:"mailFrom"`
+	MailTo   []string `json:"mailTo"`
+	// Validation is Passed or Failed once the body was validated against
+	// the schema of the bin, empty when the bin has none or the body is
+	// empty. ValidationErrors tell why a body failed.
+	Validation       string        `json:"validation"`
+	ValidationErrors []SchemaError `json:"validationErrors"`
+}
+
+// RequestFilter narrows down the requests listed for a bin. Zero valued
+// fields do not filter.
+type RequestFilter struct {
+	Method string
+	// Path, RemoteAddr and HeaderValue match substrings.
+	Path        string
+	HeaderName  string
+	HeaderValue string
+	// Body matches requests whose body contains all of its words.
 	Body       string
-	Host       string
-	RequestUri string
-	Method     string
-	Bin        int64
+	RemoteAddr string
+	From       time.Time
+	To         time.Time
+	// Validation is Passed or Failed, or NotValidated for requests whose
+	// body was not validated.
+	Validation string
+}
+
+// Page selects up to Limit requests older than the cursor Before, newest
+// first. A zero Before starts from the newest request.
+type Page struct {
+	Before Cursor
+	Limit  int
+}
+
+// Cursor is the position of a request in the newest first ordering of a
+// bin's requests.
+type Cursor struct {
+	RecievedAt time.Time
+	Id         int64
+}
+
+func (c Cursor) IsZero() bool {
+	return c.Id == 0
+}
+
+func (c Cursor) String() string {
+	return fmt.Sprintf("%d-%d", c.RecievedAt.UnixNano(), c.Id)
+}
+
+func ParseCursor(s string) (Cursor, error) {
+	nanos, id, found := strings.Cut(s, "-")
+	if !found {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", s)
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", s)
+	}
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", s)
+	}
+	return Cursor{RecievedAt: time.Unix(0, n).UTC(), Id: i}, nil
+}
+
+// Cursor returns the position of the request, to page past it.
+func (r *Request) Cursor() Cursor {
+	return Cursor{RecievedAt: r.RecievedAt, Id: r.Id}
+}
+
+// Rule decides how a bin responds to the requests it captures. A bin's rules
+// are evaluated in order of Position and the first one whose conditions all
+// match the request produces the response. Empty conditions match anything.
+type Rule struct {
+	Id       int64  `json:"id"`
+	Bin      int64  `json:"bin"`
+	Position int    `json:"position"`
+	Method   string `json:"method"`
+	// PathGlob is matched against the request's sub-path using path.Match.
+	PathGlob string            `json:"pathGlob"`
+	Headers  map[string]string `json:"headers"`
+	Query    map[string]string `json:"query"`
+	// BodyFields maps JSONPath expressions, e.g. $.data.items[0].id, to the
+	// value expected at that location of a JSON request body.
+	BodyFields map[string]string `json:"bodyFields"`
+	Status     int               `json:"status"`
+	// ResponseHeaders values and ResponseBody are text/template templates
+	// rendered with the captured request.
+	ResponseHeaders map[string]string `json:"responseHeaders"`
+	ResponseBody    string            `json:"responseBody"`
+}
+
+// NotificationTarget is notified of every request its bin captures.
+type NotificationTarget struct {
+	Id  int64 `json:"id"`
+	Bin int64 `json:"bin"`
+	// Kind is "webhook", POSTing Payload to Url with Headers, or "email",
+	// mailing Payload to the To recipients with Subject.
+	Kind    string            `json:"kind"`
+	Url     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	To      []string          `json:"to,omitempty"`
+	// Subject, Payload and Headers values are text/template templates
+	// rendered with the captured request, as rule responses are.
+	Subject string `json:"subject,omitempty"`
+	Payload string `json:"payload"`
+}
+
+// Frame is a message of a WebSocket session, whose handshake was captured
+// as the request Request.
+type Frame struct {
+	Id         int64     `json:"id"`
+	Request    int64     `json:"request"`
+	ReceivedAt time.Time `json:"receivedAt"`
+	// Direction is "in" for messages of the client, "out" for replies.
+	Direction string `json:"direction"`
+	// Kind is "text", "binary" or "close", whose payload is the status
+	// code followed by the reason.
+	Kind string `json:"kind"`
+	// Size is the size of the message, of which Payload may only hold the
+	// start.
+	Size    int    `json:"size"`
+	Payload []byte `json:"payload"`
+}
+
+// WebSocketScript is how a bin answers the WebSocket sessions it captures.
+// The zero value never answers.
+type WebSocketScript struct {
+	// Echo sends every message back as it was received.
+	Echo bool `json:"echo"`
+	// OnConnect are text messages sent once the session opens.
+	OnConnect []string `json:"onConnect"`
+	// Reply, unless empty, is a text/template template rendered with the
+	// handshake and every text .Message received, and sent back as a text
+	// message.
+	Reply string `json:"reply"`
+}
+
+// GrpcSettings is how a bin answers the gRPC and gRPC-Web calls it
+// captures. The zero value answers every call OK with an empty message.
+type GrpcSettings struct {
+	// Status and Message are the gRPC status of every call, 0 being OK.
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	// Responses maps full method names, e.g. /events.Bus/Publish, to the
+	// JSON of the message answered by OK calls, encoded with the types of
+	// DescriptorSet. Calls to other methods are answered an empty message.
+	Responses map[string]string `json:"responses"`
+	// DescriptorSet is a serialized FileDescriptorSet, as written by protoc
+	// --descriptor_set_out --include_imports, decoding captured messages.
+	DescriptorSet []byte `json:"descriptorSet"`
+}
+
+// GrpcMessage is a length-prefixed message of a captured gRPC call.
+type GrpcMessage struct {
+	Compressed bool   `json:"compressed"`
+	Size       int    `json:"size"`
+	Payload    []byte `json:"payload"`
+	// Decoded is the message as JSON, when the descriptor set of its bin
+	// describes the method called. DecodeError tells why it is not.
+	Decoded     string `json:"decoded,omitempty"`
+	DecodeError string `json:"decodeError,omitempty"`
+}
+
+// Protocols of the ports of bins.
+const (
+	TCP = "tcp"
+	UDP = "udp"
+)
+
+// CapturePort is a port allocated to a bin, on which every TCP connection
+// or UDP datagram received is captured as a request of the bin, whose
+// method is TCP or UDP and body the bytes received.
+type CapturePort struct {
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+	Bin      int64  `json:"bin"`
+}
+
+// SMTP is the protocol of the mails captured by bins, whose method is MAIL
+// and body the message as received.
+const SMTP = "smtp"
+
+// DNS is the protocol of the queries captured by bins, whose method is the
+// type queried, such as A or TXT, and URI the name queried.
+const DNS = "dns"
+
+// MailMessage is a mail captured as a request, parsed from its body.
+type MailMessage struct {
+	// Subject is decoded from its RFC 2047 encoded words.
+	Subject string `json:"subject"`
+	// Text and HTML are the first plain text and HTML bodies of the
+	// message, decoded to UTF-8.
+	Text        string           `json:"text"`
+	HTML        string           `json:"html"`
+	Attachments []MailAttachment `json:"attachments"`
+	// ParseError tells why the message was not fully parsed, the parts
+	// before the error are still set.
+	ParseError string `json:"parseError,omitempty"`
+}
+
+// MailAttachment is a part of a mail that is not one of its bodies.
+type MailAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Size        int    `json:"size"`
+	Content     []byte `json:"content"`
+}
+
+// IsMail reports whether the request is a mail captured over SMTP.
+func (r *Request) IsMail() bool {
+	return r.Proto == SMTP
+}
+
+// IsDNS reports whether the request is a DNS query captured by the zone of
+// bins.
+func (r *Request) IsDNS() bool {
+	return r.Proto == DNS
+}
+
+// IsRaw reports whether the request is a TCP connection or UDP datagram
+// captured on a port of its bin, rather than an HTTP request.
+func (r *Request) IsRaw() bool {
+	return r.Proto == TCP || r.Proto == UDP
+}
+
+// Changes between the two sides of a RequestDiff.
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// RequestDiff compares two captured requests, of the same bin or not, from
+// one to the other.
+type RequestDiff struct {
+	From DiffedRequest `json:"from"`
+	To   DiffedRequest `json:"to"`
+	// Headers are those added, removed or changed, sorted by name.
+	Headers []HeaderChange `json:"headers"`
+	Body    BodyDiff       `json:"body"`
+}
+
+// DiffedRequest is a request compared by a RequestDiff.
+type DiffedRequest struct {
+	Bin        int64     `json:"bin"`
+	Id         int64     `json:"id"`
+	Method     string    `json:"method"`
+	RequestUri string    `json:"requestUri"`
+	RecievedAt time.Time `json:"receivedAt"`
+	Body       string    `json:"body"`
+}
+
+// HeaderChange is a header added, removed or whose values changed.
+type HeaderChange struct {
+	Name   string   `json:"name"`
+	Change string   `json:"change"`
+	From   []string `json:"from"`
+	To     []string `json:"to"`
+}
+
+// BodyDiff compares the bodies of two requests, structurally when both are
+// JSON.
+type BodyDiff struct {
+	Equal bool `json:"equal"`
+	JSON  bool `json:"json"`
+	// Changes are the values added, removed or changed in JSON bodies, by
+	// their JSON pointer.
+	Changes []JSONChange `json:"changes"`
+	// Truncated is set when more changes were found than listed.
+	Truncated bool `json:"truncated"`
+}
+
+// JSONChange is a value added, removed or changed at a path of a JSON body,
+// From being nil when added and To when removed.
+type JSONChange struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	From   any    `json:"from"`
+	To     any    `json:"to"`
+}
+
+// Response is what a bin answers to a captured request.
+type Response struct {
+	Status  int
+	Headers map[string]string
+	Body    string
+	// Trailers are sent after the body, e.g. the status of gRPC calls.
+	Trailers map[string]string
 }
 
 func (r *Request) GetHeaders() (map[string][]string, error) {
@@ -34,3 +369,83 @@ func (r *Request) SetHeaders(reqHeaders map[string][]string) error {
 	r.Headers = headers
 	return nil
 }
+
+// MarshalJSON encodes the request with its headers decoded.
+func (r Request) MarshalJSON() ([]byte, error) {
+	type request Request
+	// requests whose headers can not be decoded are still listed
+	headers, _ := r.GetHeaders()
+	return json.Marshal(struct {
+		request
+		Headers map[string][]string `json:"headers"`
+	}{request(r), headers})
+}
+
+func (r *Request) UnmarshalJSON(data []byte) error {
+	type request Request
+	decoded := struct {
+		*request
+		Headers map[string][]string `json:"headers"`
+	}{request: (*request)(r)}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	return r.SetHeaders(decoded.Headers)
+}
+
+// LogValue logs a captured request without the values of its headers and its
+// body, which may hold the secrets of whoever sent it.
+func (r Request) LogValue() slog.Value {
+	var names []string
+	if headers, err := r.GetHeaders(); err == nil {
+		for name := range headers {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+	}
+	return slog.GroupValue(
+		slog.Int64("id", r.Id),
+		slog.Int64("bin", r.Bin),
+		slog.String("method", r.Method),
+		slog.String("subPath", r.SubPath),
+		slog.Int("bodySize", len(r.Body)),
+		slog.Any("headers", names),
+	)
+}
+
+// Outcomes of the validation of a request body against the schema of its
+// bin.
+const (
+	Passed       = "passed"
+	Failed       = "failed"
+	NotValidated = "none"
+)
+
+// BinSchema is the contract the JSON bodies of the requests of a bin are
+// validated against, either a JSON Schema or the request body schema of an
+// operation of an OpenAPI document. The zero value validates nothing.
+type BinSchema struct {
+	// Schema is a JSON Schema, of draft 2020-12 unless its $schema says
+	// otherwise.
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// OpenAPI is an OpenAPI 3 document, in JSON, and Operation the
+	// operationId, or method and path such as "POST /orders", of the
+	// operation whose JSON request body schema is used.
+	OpenAPI   json.RawMessage `json:"openapi,omitempty"`
+	Operation string          `json:"operation,omitempty"`
+}
+
+// IsZero reports whether the schema validates nothing.
+func (s BinSchema) IsZero() bool {
+	return len(s.Schema) == 0 && len(s.OpenAPI) == 0
+}
+
+// SchemaError is a reason a request body failed validation.
+type SchemaError struct {
+	// Path is the JSON pointer of the invalid value in the body, empty
+	// for the body itself.
+	Path string `json:"path"`
+	// Keyword is the JSON pointer of the failing keyword in the schema.
+	Keyword string `json:"keyword"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,220 @@
+// Package notify sends the notifications of captured requests to webhooks
+// and email recipients, off the capturing goroutine.
+package notify
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"app/internal/metrics"
+)
+
+// Message is a rendered notification, ready to be sent.
+type Message struct {
+	// Bin and Target identify where the message comes from, for logs.
+	Bin    int64
+	Target int64
+	// Kind is the kind of the target, see models.NotificationTarget.
+	Kind string
+	// Url and Headers of webhook messages, POSTed with Body.
+	Url     string
+	Headers map[string]string
+	// To and Subject of email messages, whose text is Body.
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Sender delivers messages.
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// permanentError is an error retrying will not fix.
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+// Permanent marks an error as one retrying will not fix.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+type Options struct {
+	Sender Sender
+	// Workers send messages concurrently, QueueSize messages wait for them
+	// at most. Messages enqueued beyond it are dropped.
+	Workers   int
+	QueueSize int
+	// MaxAttempts is how many times a message is sent before it is given
+	// up. Retries wait Backoff, doubled after every attempt up to
+	// MaxBackoff.
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	// SendTimeout bounds every attempt.
+	SendTimeout time.Duration
+	// Metrics count the messages, unexposed ones when nil.
+	Metrics *metrics.Metrics
+}
+
+// DefaultOptions are the options used in place of zero ones.
+var DefaultOptions = Options{
+	Workers:     4,
+	QueueSize:   1000,
+	MaxAttempts: 5,
+	Backoff:     time.Second,
+	MaxBackoff:  5 * time.Minute,
+	SendTimeout: 30 * time.Second,
+}
+
+type job struct {
+	message Message
+	attempt int
+}
+
+// Queue sends messages on a bounded number of workers, retrying failed
+// attempts with exponential backoff.
+type Queue struct {
+	options Options
+	metrics *metrics.Metrics
+	jobs    chan job
+
+	mu      sync.Mutex
+	retries map[*time.Timer]struct{}
+}
+
+func NewQueue(options Options) *Queue {
+	if options.Workers <= 0 {
+		options.Workers = DefaultOptions.Workers
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultOptions.QueueSize
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DefaultOptions.MaxAttempts
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = DefaultOptions.Backoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = DefaultOptions.MaxBackoff
+	}
+	if options.SendTimeout <= 0 {
+		options.SendTimeout = DefaultOptions.SendTimeout
+	}
+	m := options.Metrics
+	if m == nil {
+		m = metrics.New()
+	}
+	return &Queue{
+		options: options,
+		metrics: m,
+		jobs:    make(chan job, options.QueueSize),
+		retries: map[*time.Timer]struct{}{},
+	}
+}
+
+// Enqueue queues a message without waiting, and reports whether there was
+// room for it.
+func (q *Queue) Enqueue(message Message) bool {
+	return q.enqueue(job{message: message, attempt: 1})
+}
+
+func (q *Queue) enqueue(j job) bool {
+	select {
+	case q.jobs <- j:
+		return true
+	default:
+		q.metrics.NotificationsSent.Inc(j.message.Kind, "dropped")
+		slog.Warn("notification queue full, dropping notification",
+			"bin", j.message.Bin, "target", j.message.Target)
+		return false
+	}
+}
+
+// Run sends the queued messages until ctx is done. Messages still queued or
+// waiting for a retry then are given up.
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range q.options.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+
+	q.mu.Lock()
+	for timer := range q.retries {
+		timer.Stop()
+	}
+	abandoned := len(q.jobs) + len(q.retries)
+	q.mu.Unlock()
+	if abandoned > 0 {
+		slog.Warn("notifications abandoned on shutdown", "count", abandoned)
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-q.jobs:
+			q.send(ctx, j)
+		}
+	}
+}
+
+func (q *Queue) send(ctx context.Context, j job) {
+	sendCtx, cancel := context.WithTimeout(ctx, q.options.SendTimeout)
+	err := q.options.Sender.Send(sendCtx, j.message)
+	cancel()
+	if err == nil {
+		q.metrics.NotificationsSent.Inc(j.message.Kind, "sent")
+		return
+	}
+
+	logger := slog.With("bin", j.message.Bin, "target", j.message.Target, "attempt", j.attempt, "error", err)
+	if errors.As(err, &permanentError{}) || j.attempt >= q.options.MaxAttempts || ctx.Err() != nil {
+		q.metrics.NotificationsSent.Inc(j.message.Kind, "failed")
+		logger.Error("sending notification failed")
+		return
+	}
+	delay := q.backoff(j.attempt)
+	logger.Warn("sending notification failed, retrying", "delay", delay)
+	q.retry(job{message: j.message, attempt: j.attempt + 1}, delay)
+}
+
+// backoff is the delay before the attempt following attempt, jittered so
+// the retries of a failing target spread out.
+func (q *Queue) backoff(attempt int) time.Duration {
+	delay := q.options.Backoff << (attempt - 1)
+	if delay <= 0 || delay > q.options.MaxBackoff {
+		delay = q.options.MaxBackoff
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (q *Queue) retry(j job, delay time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		delete(q.retries, timer)
+		q.mu.Unlock()
+		q.enqueue(j)
+	})
+	q.retries[timer] = struct{}{}
+}
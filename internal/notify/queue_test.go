@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"app/internal/metrics"
+)
+
+type fakeSender struct {
+	mu       sync.Mutex
+	errs     []error
+	attempts int
+	sent     chan Message
+}
+
+func (s *fakeSender) Send(ctx context.Context, message Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	s.sent <- message
+	return nil
+}
+
+func (s *fakeSender) Attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts
+}
+
+func runQueue(t *testing.T, queue *Queue) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		queue.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func Test_Queue(t *testing.T) {
+	t.Run("failed sends are retried", func(t *testing.T) {
+		sender := &fakeSender{
+			errs: []error{errors.New("connection refused"), errors.New("connection refused")},
+			sent: make(chan Message, 1),
+		}
+		m := metrics.New()
+		queue := NewQueue(Options{Sender: sender, Backoff: time.Millisecond, Metrics: m})
+		runQueue(t, queue)
+
+		assert.True(t, queue.Enqueue(Message{Kind: KindWebhook, Body: "body"}))
+		select {
+		case message := <-sender.sent:
+			assert.Equal(t, "body", message.Body)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message was not sent")
+		}
+		assert.Equal(t, 3, sender.Attempts())
+
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		assert.Contains(t, recorder.Body.String(), `httpbin_notifications_total{kind="webhook",result="sent"} 1`)
+	})
+	t.Run("permanent errors are not retried", func(t *testing.T) {
+		sender := &fakeSender{
+			errs: []error{Permanent(errors.New("bad request"))},
+			sent: make(chan Message, 1),
+		}
+		m := metrics.New()
+		queue := NewQueue(Options{Sender: sender, Backoff: time.Millisecond, Metrics: m})
+		runQueue(t, queue)
+
+		queue.Enqueue(Message{Kind: KindWebhook})
+		assert.Eventually(t, func() bool {
+			recorder := httptest.NewRecorder()
+			m.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+			return strings.Contains(recorder.Body.String(), `httpbin_notifications_total{kind="webhook",result="failed"} 1`)
+		}, 5*time.Second, time.Millisecond)
+		assert.Equal(t, 1, sender.Attempts())
+	})
+	t.Run("attempts are bounded", func(t *testing.T) {
+		failing := errors.New("connection refused")
+		sender := &fakeSender{
+			errs: []error{failing, failing, failing},
+			sent: make(chan Message, 1),
+		}
+		queue := NewQueue(Options{Sender: sender, MaxAttempts: 2, Backoff: time.Millisecond})
+		runQueue(t, queue)
+
+		queue.Enqueue(Message{Kind: KindWebhook})
+		assert.Eventually(t, func() bool {
+			return sender.Attempts() == 2
+		}, 5*time.Second, time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, 2, sender.Attempts())
+	})
+	t.Run("full queues drop messages", func(t *testing.T) {
+		queue := NewQueue(Options{Sender: &fakeSender{}, QueueSize: 1})
+
+		assert.True(t, queue.Enqueue(Message{Kind: KindEmail}))
+		assert.False(t, queue.Enqueue(Message{Kind: KindEmail}))
+	})
+}
+
+func Test_Backoff(t *testing.T) {
+	queue := NewQueue(Options{Backoff: time.Second, MaxBackoff: 5 * time.Second})
+
+	for attempt, expected := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		4:  5 * time.Second,
+		70: 5 * time.Second,
+	} {
+		delay := queue.backoff(attempt)
+		assert.GreaterOrEqual(t, delay, expected/2)
+		assert.LessOrEqual(t, delay, expected)
+	}
+}
@@ -0,0 +1,195 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/smtp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Kinds of notification targets.
+const (
+	KindWebhook = "webhook"
+	KindEmail   = "email"
+)
+
+// Senders sends every message with the sender of its kind.
+type Senders map[string]Sender
+
+func (s Senders) Send(ctx context.Context, message Message) error {
+	sender, ok := s[message.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("no sender of %s notifications", message.Kind))
+	}
+	return sender.Send(ctx, message)
+}
+
+// errPrivateAddress is returned when a webhook resolves to an address of
+// a private network.
+var errPrivateAddress = errors.New("private network addresses are not allowed")
+
+// Webhook POSTs messages to their Url.
+type Webhook struct {
+	Client *http.Client
+}
+
+// NewWebhook returns a webhook sender. Unless allowPrivate, it refuses to
+// connect to loopback, private and link-local addresses, as anyone may
+// set the targets of a bin.
+func NewWebhook(allowPrivate bool) *Webhook {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		// checked on the resolved address, so host names can not get around it
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if isPrivate(addrPort.Addr()) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+	return &Webhook{Client: &http.Client{
+		Transport: transport,
+		// redirects are not followed, they would be sent with the body
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+func isPrivate(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsUnspecified() || addr.IsMulticast()
+}
+
+func (s *Webhook) Send(ctx context.Context, message Message) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, message.Url, strings.NewReader(message.Body))
+	if err != nil {
+		return Permanent(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "httpbin-notifications")
+	for key, value := range message.Headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := s.Client.Do(request)
+	if errors.Is(err, errPrivateAddress) {
+		return Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 1<<16))
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("webhook answered %s", response.Status)
+	switch {
+	case response.StatusCode == http.StatusRequestTimeout,
+		response.StatusCode == http.StatusTooManyRequests,
+		response.StatusCode >= 500:
+		return err
+	}
+	return Permanent(err)
+}
+
+// SMTP emails messages through a mail server.
+type SMTP struct {
+	// Addr is the host:port of the server, STARTTLS is used when it
+	// supports it.
+	Addr string
+	From string
+	// Username and Password authenticate with PLAIN auth, when set.
+	Username string
+	Password string
+}
+
+func (s *SMTP) Send(ctx context.Context, message Message) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return Permanent(err)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range message.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.email(message)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// email formats a message as a plain text email.
+func (s *SMTP) email(message Message) []byte {
+	// rendered subjects must not add headers
+	subject := strings.Join(strings.Fields(message.Subject), " ")
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(message.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	body := strings.ReplaceAll(message.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
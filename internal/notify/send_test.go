@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Webhook(t *testing.T) {
+	t.Run("posts the body", func(t *testing.T) {
+		var body, header string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			body = string(b)
+			header = r.Header.Get("X-Bin")
+		}))
+		defer server.Close()
+
+		err := NewWebhook(true).Send(context.Background(), Message{
+			Url:     server.URL,
+			Headers: map[string]string{"X-Bin": "1"},
+			Body:    `{"text": "captured"}`,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"text": "captured"}`, body)
+		assert.Equal(t, "1", header)
+	})
+	t.Run("private addresses are refused", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("private address was reached")
+		}))
+		defer server.Close()
+
+		err := NewWebhook(false).Send(context.Background(), Message{Url: server.URL})
+		assert.ErrorIs(t, err, errPrivateAddress)
+		assert.ErrorAs(t, err, &permanentError{})
+	})
+	t.Run("statuses", func(t *testing.T) {
+		for status, permanent := range map[int]bool{
+			http.StatusBadRequest:         true,
+			http.StatusNotFound:           true,
+			http.StatusFound:              true,
+			http.StatusTooManyRequests:    false,
+			http.StatusServiceUnavailable: false,
+		} {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Location", "/elsewhere")
+				w.WriteHeader(status)
+			}))
+
+			err := NewWebhook(true).Send(context.Background(), Message{Url: server.URL})
+			assert.Error(t, err, status)
+			assert.Equal(t, permanent, errors.As(err, &permanentError{}), status)
+			server.Close()
+		}
+	})
+}
+
+func Test_SMTPEmail(t *testing.T) {
+	sender := &SMTP{From: "httpbin@example.com"}
+
+	email := string(sender.email(Message{
+		To:      []string{"ops@example.com", "dev@example.com"},
+		Subject: "POST captured\r\nBcc: victim@example.com",
+		Body:    "line\nline",
+	}))
+	assert.Contains(t, email, "From: httpbin@example.com\r\n")
+	assert.Contains(t, email, "To: ops@example.com, dev@example.com\r\n")
+	assert.Contains(t, email, "Subject: POST captured Bcc: victim@example.com\r\n")
+	assert.NotContains(t, email, "\r\nBcc:")
+	assert.True(t, strings.HasSuffix(email, "\r\n\r\nline\r\nline\r\n"))
+}
@@ -0,0 +1,125 @@
+// Package ratelimit limits the rate of events per key with token buckets.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Events per Per on average, in bursts of up to Events. The
+// zero Limit allows everything.
+type Limit struct {
+	Events int
+	Per    time.Duration
+}
+
+// ParseLimit parses a limit written as events/duration, e.g. 100/1s or
+// 30/1m. Empty and "off" are the zero Limit.
+func ParseLimit(s string) (Limit, error) {
+	if s == "" || s == "off" {
+		return Limit{}, nil
+	}
+	events, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid limit %q, expected events/duration", s)
+	}
+	n, err := strconv.Atoi(events)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q, expected a positive number of events", s)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q, expected a positive duration", s)
+	}
+	return Limit{Events: n, Per: d}, nil
+}
+
+func (l Limit) String() string {
+	if l.unlimited() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Events, l.Per)
+}
+
+func (l Limit) unlimited() bool {
+	return l.Events <= 0 || l.Per <= 0
+}
+
+// sweepInterval is how often the buckets refilled since are forgotten.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Limiter holds a token bucket per key, filled at the rate of its Limit.
+type Limiter struct {
+	limit Limit
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func New(limit Limit) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Allow takes a token from the bucket of key. When it is empty, Allow
+// reports how long until a token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l.limit.unlimited() {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Events), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := math.Ceil((1 - b.tokens) * float64(l.limit.Per) / float64(l.limit.Events))
+	return false, time.Duration(wait)
+}
+
+// rate is the number of tokens added per nanosecond.
+func (l *Limiter) rate() float64 {
+	return float64(l.limit.Events) / float64(l.limit.Per)
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	elapsed := now.Sub(b.updated)
+	return min(float64(l.limit.Events), b.tokens+float64(elapsed)*l.rate())
+}
+
+// sweep forgets the full buckets, which are the same as new ones.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.limit.Events) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseLimit(t *testing.T) {
+	for s, expected := range map[string]Limit{
+		"":       {},
+		"off":    {},
+		"100/1s": {Events: 100, Per: time.Second},
+		"30/1m":  {Events: 30, Per: time.Minute},
+	} {
+		limit, err := ParseLimit(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, limit, s)
+	}
+
+	for _, s := range []string{"100", "0/1s", "-1/1s", "10/0s", "10/s", "x/1s"} {
+		_, err := ParseLimit(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func Test_Limiter(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := New(Limit{Events: 2, Per: time.Second})
+	limiter.now = func() time.Time { return clock }
+
+	t.Run("bursts up to the limit", func(t *testing.T) {
+		for range 2 {
+			ok, _ := limiter.Allow("a")
+			assert.True(t, ok)
+		}
+		ok, wait := limiter.Allow("a")
+		assert.False(t, ok)
+		assert.Equal(t, 500*time.Millisecond, wait)
+	})
+
+	t.Run("keys have their own bucket", func(t *testing.T) {
+		ok, _ := limiter.Allow("b")
+		assert.True(t, ok)
+	})
+
+	t.Run("buckets refill at the rate of the limit", func(t *testing.T) {
+		clock = clock.Add(250 * time.Millisecond)
+		ok, wait := limiter.Allow("a")
+		assert.False(t, ok)
+		assert.Equal(t, 250*time.Millisecond, wait)
+
+		clock = clock.Add(250 * time.Millisecond)
+		ok, _ = limiter.Allow("a")
+		assert.True(t, ok)
+	})
+
+	t.Run("full buckets are forgotten", func(t *testing.T) {
+		clock = clock.Add(sweepInterval)
+		limiter.Allow("c")
+		assert.Len(t, limiter.buckets, 1)
+	})
+
+	t.Run("the zero limit allows everything", func(t *testing.T) {
+		unlimited := New(Limit{})
+		for range 100 {
+			ok, _ := unlimited.Allow("a")
+			assert.True(t, ok)
+		}
+	})
+}
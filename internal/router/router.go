@@ -1,7 +1,15 @@
 package router
 
 import (
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"app/internal/health"
+	"app/internal/logging"
+	"app/internal/metrics"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
@@ -12,20 +20,79 @@ type Handlers interface {
 	NewBin(w http.ResponseWriter, r *http.Request)
 	LogRequest(w http.ResponseWriter, r *http.Request)
 	ViewBinContents(w http.ResponseWriter, r *http.Request)
+	ViewRequest(w http.ResponseWriter, r *http.Request)
+	CreateBin(w http.ResponseWriter, r *http.Request)
+	GetRequests(w http.ResponseWriter, r *http.Request)
+	ImportRequests(w http.ResponseWriter, r *http.Request)
+	StreamRequests(w http.ResponseWriter, r *http.Request)
+	GetRequest(w http.ResponseWriter, r *http.Request)
+	GetRules(w http.ResponseWriter, r *http.Request)
+	SetRules(w http.ResponseWriter, r *http.Request)
+	DeleteRequest(w http.ResponseWriter, r *http.Request)
+	ClearBin(w http.ResponseWriter, r *http.Request)
+	DeleteBin(w http.ResponseWriter, r *http.Request)
+	GetNotificationTargets(w http.ResponseWriter, r *http.Request)
+	SetNotificationTargets(w http.ResponseWriter, r *http.Request)
+	GetFrames(w http.ResponseWriter, r *http.Request)
+	GetWebSocketScript(w http.ResponseWriter, r *http.Request)
+	SetWebSocketScript(w http.ResponseWriter, r *http.Request)
+	GetGrpcMessages(w http.ResponseWriter, r *http.Request)
+	GetGrpcSettings(w http.ResponseWriter, r *http.Request)
+	SetGrpcSettings(w http.ResponseWriter, r *http.Request)
+	GetBinSchema(w http.ResponseWriter, r *http.Request)
+	SetBinSchema(w http.ResponseWriter, r *http.Request)
+	GetBinRateLimit(w http.ResponseWriter, r *http.Request)
+	SetBinRateLimit(w http.ResponseWriter, r *http.Request)
+	GetCapturePorts(w http.ResponseWriter, r *http.Request)
+	AllocateCapturePort(w http.ResponseWriter, r *http.Request)
+	ReleaseCapturePort(w http.ResponseWriter, r *http.Request)
+	GetMailMessage(w http.ResponseWriter, r *http.Request)
+	DownloadAttachment(w http.ResponseWriter, r *http.Request)
+	DiffRequests(w http.ResponseWriter, r *http.Request)
+	ViewRequestDiff(w http.ResponseWriter, r *http.Request)
 }
 
-func Routes(h Handlers) http.Handler {
+// reservedBinPaths are the sub-paths of /bin/{binId} served by the app itself,
+// requests made to them are never captured.
+var reservedBinPaths = []string{"contents", "requests"}
+
+// Options configure the routes beyond their handlers.
+type Options struct {
+	// BinDomain, when set, has the requests made to {binId}.BinDomain
+	// captured by the bin on any path, as those made to /bin/{binId}.
+	BinDomain string
+}
+
+// GrpcBinHeader holds the bin of gRPC calls made to the path of their
+// method, as most gRPC clients can not prefix it with /bin/{binId}.
+const GrpcBinHeader = "X-Bin-Id"
+
+func Routes(h Handlers, m *metrics.Metrics, hc *health.Health, options Options) http.Handler {
+	root := chi.NewRouter()
+	root.Use(routeGrpcCalls)
+	// probes are neither logged nor counted
+	root.Get("/healthz", hc.Live)
+	root.Get("/readyz", hc.Ready)
+
 	router := chi.NewRouter()
+	root.Mount("/", router)
 
+	router.Use(logging.RequestIds, logging.AccessLog)
 	router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"HEAD", "GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
 		AllowedHeaders:   []string{"*"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", logging.RequestIdHeader, "Grpc-Status", "Grpc-Message"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
+	router.Use(m.Middleware)
+	if options.BinDomain != "" {
+		router.Use(routeBinHosts(options.BinDomain, h.LogRequest))
+	}
+	router.Get("/metrics", m.ServeHTTP)
+
 	fileServer := http.FileServer(http.Dir("./static"))
 	router.Handle("/static/*", http.StripPrefix("/static/", fileServer))
 
@@ -33,8 +100,106 @@ func Routes(h Handlers) http.Handler {
 		router.Get("/", h.Index)
 		router.Get("/new-bin", h.NewBin)
 		router.HandleFunc("/bin/{binId}", h.LogRequest)
+		router.HandleFunc("/bin/{binId}/*", excludeReservedPaths(h.LogRequest))
 		router.Get("/bin/{binId}/contents", h.ViewBinContents)
+		router.Get("/bin/{binId}/requests/{requestId}", h.ViewRequest)
+		router.Get("/bin/{binId}/requests/{requestId}/attachments/{index}", h.DownloadAttachment)
+		router.Get("/bin/{binId}/requests/{requestId}/diff", h.ViewRequestDiff)
+		router.Delete("/bin/{binId}/requests", h.ClearBin)
+		router.Delete("/bin/{binId}/requests/{requestId}", h.DeleteRequest)
+	})
+
+	router.Group(func(router chi.Router) {
+		router.Post("/api/bins", h.CreateBin)
+		router.Get("/api/bins/{binId}/requests", h.GetRequests)
+		router.Post("/api/bins/{binId}/requests", h.ImportRequests)
+		router.Get("/api/bins/{binId}/stream", h.StreamRequests)
+		router.Delete("/api/bins/{binId}", h.DeleteBin)
+		router.Delete("/api/bins/{binId}/requests", h.ClearBin)
+		router.Get("/api/bins/{binId}/requests/{requestId}", h.GetRequest)
+		router.Delete("/api/bins/{binId}/requests/{requestId}", h.DeleteRequest)
+		router.Get("/api/bins/{binId}/rules", h.GetRules)
+		router.Put("/api/bins/{binId}/rules", h.SetRules)
+		router.Get("/api/bins/{binId}/notifications", h.GetNotificationTargets)
+		router.Put("/api/bins/{binId}/notifications", h.SetNotificationTargets)
+		router.Get("/api/bins/{binId}/requests/{requestId}/frames", h.GetFrames)
+		router.Get("/api/bins/{binId}/websocket", h.GetWebSocketScript)
+		router.Put("/api/bins/{binId}/websocket", h.SetWebSocketScript)
+		router.Get("/api/bins/{binId}/requests/{requestId}/grpc", h.GetGrpcMessages)
+		router.Get("/api/bins/{binId}/requests/{requestId}/mail", h.GetMailMessage)
+		router.Get("/api/bins/{binId}/requests/{requestId}/diff", h.DiffRequests)
+		router.Get("/api/bins/{binId}/grpc", h.GetGrpcSettings)
+		router.Put("/api/bins/{binId}/grpc", h.SetGrpcSettings)
+		router.Get("/api/bins/{binId}/schema", h.GetBinSchema)
+		router.Put("/api/bins/{binId}/schema", h.SetBinSchema)
+		router.Get("/api/bins/{binId}/ratelimit", h.GetBinRateLimit)
+		router.Put("/api/bins/{binId}/ratelimit", h.SetBinRateLimit)
+		router.Get("/api/bins/{binId}/ports", h.GetCapturePorts)
+		router.Post("/api/bins/{binId}/ports", h.AllocateCapturePort)
+		router.Delete("/api/bins/{binId}/ports/{protocol}/{port}", h.ReleaseCapturePort)
 	})
 
-	return router
+	return root
+}
+
+func excludeReservedPaths(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		firstSegment, _, _ := strings.Cut(chi.URLParam(r, "*"), "/")
+		if slices.Contains(reservedBinPaths, firstSegment) {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// routeGrpcCalls routes the gRPC calls made to the path of their method to
+// the bin of their GrpcBinHeader, keeping the URI they were made to.
+func routeGrpcCalls(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		binId := r.Header.Get(GrpcBinHeader)
+		isGrpc := strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+		if binId != "" && isGrpc && !strings.HasPrefix(r.URL.Path, "/bin/") {
+			r = r.Clone(r.Context())
+			r.URL.Path = "/bin/" + url.PathEscape(binId) + r.URL.Path
+			r.URL.RawPath = ""
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeBinHosts captures the requests made to {binId}.domain by the bin,
+// whatever their path, including those of the routes of the app. Hosts whose
+// label is not a bin id, such as www.domain, are routed as usual.
+func routeBinHosts(domain string, logRequest http.HandlerFunc) func(http.Handler) http.Handler {
+	suffix := "." + strings.TrimSuffix(strings.ToLower(domain), ".")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			binId, ok := binHost(r.Host, suffix)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rctx := chi.RouteContext(r.Context())
+			rctx.URLParams.Add("binId", binId)
+			rctx.URLParams.Add("*", strings.TrimPrefix(r.URL.Path, "/"))
+			// in place of the /* the app is mounted on
+			rctx.RoutePatterns = []string{"{binId}" + suffix + "/*"}
+			logRequest(w, r)
+		})
+	}
+}
+
+// binHost returns the bin id of a host made of it and the suffix, with or
+// without a port.
+func binHost(host, suffix string) (string, bool) {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	label, ok := strings.CutSuffix(host, suffix)
+	if !ok || label == "" || strings.Trim(label, "0123456789") != "" {
+		return "", false
+	}
+	return label, true
 }
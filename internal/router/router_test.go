@@ -0,0 +1,49 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_routeBinHosts(t *testing.T) {
+	var captured []string
+	logRequest := func(w http.ResponseWriter, r *http.Request) {
+		captured = append(captured, chi.URLParam(r, "binId")+" "+chi.URLParam(r, "*"))
+	}
+	router := chi.NewRouter()
+	router.Use(routeBinHosts("Bins.example.com.", logRequest))
+	router.Get("/bin/{binId}/contents", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("contents of " + chi.URLParam(r, "binId")))
+	})
+
+	for _, test := range []struct {
+		host     string
+		path     string
+		captured string
+	}{
+		{"1.bins.example.com", "/", "1 "},
+		{"12.BINS.example.com:8080", "/hooks/github", "12 hooks/github"},
+		{"3.bins.example.com.", "/bin/2/contents", "3 bin/2/contents"},
+		{"www.bins.example.com", "/bin/2/contents", ""},
+		{"1.www.bins.example.com", "/bin/2/contents", ""},
+		{"bins.example.com", "/bin/2/contents", ""},
+		{"1.bins.example.com.evil", "/bin/2/contents", ""},
+		{"localhost:3000", "/bin/2/contents", ""},
+	} {
+		captured = nil
+		request := httptest.NewRequest(http.MethodGet, "http://"+test.host+test.path, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		if test.captured != "" {
+			assert.Equal(t, []string{test.captured}, captured, test.host)
+		} else {
+			assert.Empty(t, captured, test.host)
+			assert.Equal(t, "contents of 2", response.Body.String(), test.host)
+		}
+	}
+}
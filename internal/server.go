@@ -1,27 +1,153 @@
 package app
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"log/slog"
+	"net"
 	"net/http"
+
+	"app/internal/dnsd"
+	"app/internal/smtpd"
+	"app/internal/wire"
 )
 
+type ServerOptions struct {
+	// Addr is the address plain HTTP is served on, none when empty.
+	Addr string
+	// TLSAddr is the address HTTPS is served on with TLSConfig, none when
+	// empty.
+	TLSAddr   string
+	TLSConfig *tls.Config
+	// GrpcAddr is the address unencrypted HTTP/2, for gRPC calls, is served
+	// on along with HTTP/1.1, none when empty.
+	GrpcAddr string
+	// Mail receives mails over SMTP, none when nil.
+	Mail *smtpd.Server
+	// DNS answers queries for the names of bins, none when nil.
+	DNS *dnsd.Server
+}
+
 type HttpServer struct {
 	httpServer *http.Server
+	tlsServer  *http.Server
+	grpcServer *http.Server
+	mailServer *smtpd.Server
+	dnsServer  *dnsd.Server
 }
 
-func NewServer(addr string, handler http.Handler) *HttpServer {
-	return &HttpServer{
-		httpServer: &http.Server{
-			Addr:    addr,
-			Handler: handler,
-		},
+func NewServer(options ServerOptions, handler http.Handler) *HttpServer {
+	hs := &HttpServer{mailServer: options.Mail, dnsServer: options.DNS}
+	if options.Addr != "" {
+		hs.httpServer = &http.Server{
+			Addr:        options.Addr,
+			Handler:     wire.Handler(handler),
+			ConnContext: wire.ConnContext,
+			ErrorLog:    serverErrorLog(),
+		}
 	}
+	if options.TLSAddr != "" {
+		// TLS connections are not recorded by package wire, as net/http only
+		// reports the TLS state of unwrapped *tls.Conn connections
+		hs.tlsServer = &http.Server{
+			Addr:      options.TLSAddr,
+			Handler:   handler,
+			TLSConfig: options.TLSConfig,
+			ErrorLog:  serverErrorLog(),
+		}
+	}
+	if options.GrpcAddr != "" {
+		// gRPC clients speak HTTP/2 with prior knowledge, which package wire
+		// does not record either
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		hs.grpcServer = &http.Server{
+			Addr:      options.GrpcAddr,
+			Handler:   handler,
+			Protocols: protocols,
+			ErrorLog:  serverErrorLog(),
+		}
+	}
+	return hs
+}
+
+// serverErrorLog sends the errors of net/http, such as failed TLS
+// handshakes, to the default slog logger.
+func serverErrorLog() *log.Logger {
+	return slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn)
 }
 
+// Start serves until one of the servers fails or is shut down.
 func (hs *HttpServer) Start() error {
-	err := hs.httpServer.ListenAndServe()
+	errs := make(chan error, 5)
+	if hs.httpServer != nil {
+		go func() {
+			errs <- hs.serve()
+		}()
+	}
+	if hs.tlsServer != nil {
+		go func() {
+			errs <- hs.serveTLS()
+		}()
+	}
+	if hs.grpcServer != nil {
+		go func() {
+			slog.Info("serving grpc", "addr", hs.grpcServer.Addr)
+			errs <- hs.grpcServer.ListenAndServe()
+		}()
+	}
+	if hs.mailServer != nil {
+		go func() {
+			errs <- hs.mailServer.ListenAndServe()
+		}()
+	}
+	if hs.dnsServer != nil {
+		go func() {
+			errs <- hs.dnsServer.ListenAndServe()
+		}()
+	}
+
+	err := <-errs
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, smtpd.ErrServerClosed) || errors.Is(err, dnsd.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops the servers from accepting connections and waits for the
+// requests, mails and queries being served until ctx is done.
+func (hs *HttpServer) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, server := range []*http.Server{hs.httpServer, hs.tlsServer, hs.grpcServer} {
+		if server != nil {
+			errs = append(errs, server.Shutdown(ctx))
+		}
+	}
+	if hs.mailServer != nil {
+		errs = append(errs, hs.mailServer.Shutdown(ctx))
+	}
+	if hs.dnsServer != nil {
+		errs = append(errs, hs.dnsServer.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+func (hs *HttpServer) serve() error {
+	listener, err := net.Listen("tcp", hs.httpServer.Addr)
 	if err != nil {
 		return err
 	}
+	slog.Info("serving http", "addr", listener.Addr().String())
+
+	// requests are recorded as read off the wire, see package wire
+	return hs.httpServer.Serve(wire.NewListener(listener))
+}
 
-	return nil
+func (hs *HttpServer) serveTLS() error {
+	slog.Info("serving https", "addr", hs.tlsServer.Addr)
+	// the certificate is part of TLSConfig
+	return hs.tlsServer.ListenAndServeTLS("", "")
 }
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"app/internal/models"
+)
+
+// ErrForbidden is returned when a caller may not change a bin.
+var ErrForbidden = errors.New("forbidden")
+
+// Authorize decides whether the caller, identified by the owner key of its
+// token, may change a bin. An empty caller has no token.
+type Authorize func(caller string, bin models.Bin) error
+
+// OwnerOnly lets only the owner of a bin change it. Bins without an owner
+// can be changed by anyone.
+func OwnerOnly(caller string, bin models.Bin) error {
+	if bin.Owner == "" || bin.Owner == caller {
+		return nil
+	}
+	return ErrForbidden
+}
+
+// TokenOwner returns the owner key of a token, as stored with the bins the
+// token creates. The token itself is never stored.
+func TokenOwner(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authorizeBin checks that the caller may change an existing bin.
+func (s *Services) authorizeBin(ctx context.Context, caller string, binId int64) error {
+	_, err := s.authorizedBin(ctx, caller, binId)
+	return err
+}
+
+// authorizedBin returns an existing bin the caller may change.
+func (s *Services) authorizedBin(ctx context.Context, caller string, binId int64) (models.Bin, error) {
+	if err := BinIdValidation(binId); err != nil {
+		return models.Bin{}, err
+	}
+	bin, err := s.db.GetBin(ctx, binId)
+	if err != nil {
+		return models.Bin{}, err
+	}
+	return bin, s.authorize(caller, bin)
+}
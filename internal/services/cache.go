@@ -0,0 +1,66 @@
+package services
+
+import "sync"
+
+// Bounds of the bins whose values are cached, which may take up to a few
+// MiB each.
+const (
+	maxCachedSchemas  = 1000
+	maxCachedGrpcBins = 100
+)
+
+// binCache keeps a value derived from the settings of bins, e.g. a compiled
+// schema, so that it is not derived again for every request. Values are
+// invalidated when the settings of their bin change or it is deleted.
+type binCache[V any] struct {
+	mu     sync.Mutex
+	values map[int64]V
+	limit  int
+	// generation changes with every invalidation, so that a value loaded
+	// from settings since changed is not kept.
+	generation uint64
+}
+
+// newBinCache returns a cache of the values of up to limit bins.
+func newBinCache[V any](limit int) *binCache[V] {
+	return &binCache[V]{values: map[int64]V{}, limit: limit}
+}
+
+// load returns the value of a bin, loading it when it is not cached.
+// Errors are not cached.
+func (c *binCache[V]) load(binId int64, load func() (V, error)) (V, error) {
+	c.mu.Lock()
+	if value, ok := c.values[binId]; ok {
+		c.mu.Unlock()
+		return value, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		if len(c.values) >= c.limit {
+			// makes room for the value, dropping any other
+			for cached := range c.values {
+				delete(c.values, cached)
+				break
+			}
+		}
+		c.values[binId] = value
+	}
+	return value, nil
+}
+
+// invalidate drops the value of a bin, loaded again when next needed.
+func (c *binCache[V]) invalidate(binId int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.values, binId)
+	c.generation++
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"app/internal/models"
+)
+
+// clientIp resolves the IP of the client that made a request. Requests from
+// trusted proxies were made by the last address before the proxies in the
+// Forwarded header or, lacking one, the X-Forwarded-For header.
+func clientIp(request models.Request, trustedProxies []netip.Prefix) string {
+	peer := request.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	if !isTrustedProxy(peer, trustedProxies) {
+		return peer
+	}
+
+	headers, _ := request.GetHeaders()
+	chain := forwardedFor(http.Header(headers))
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		client = chain[i]
+		if !isTrustedProxy(client, trustedProxies) {
+			break
+		}
+	}
+	return client
+}
+
+func isTrustedProxy(ip string, trustedProxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the addresses a request was forwarded for, client
+// first, as listed by the Forwarded header (RFC 7239) or X-Forwarded-For.
+func forwardedFor(headers http.Header) []string {
+	var chain []string
+	for _, value := range headers.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(key, "for") {
+					chain = append(chain, forwardedHost(strings.Trim(value, `"`)))
+				}
+			}
+		}
+	}
+	if len(chain) > 0 {
+		return chain
+	}
+
+	for _, value := range headers.Values("X-Forwarded-For") {
+		for _, address := range strings.Split(value, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				chain = append(chain, forwardedHost(address))
+			}
+		}
+	}
+	return chain
+}
+
+// forwardedHost strips the port and IPv6 brackets from a forwarded node,
+// e.g. "[2001:db8::1]:4711". Obfuscated identifiers are kept as they are.
+func forwardedHost(node string) string {
+	if _, err := netip.ParseAddr(node); err == nil {
+		return node
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.Trim(node, "[]")
+}
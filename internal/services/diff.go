@@ -0,0 +1,195 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"app/internal/models"
+)
+
+// maxDiffChanges bounds the changes listed between two JSON bodies.
+const maxDiffChanges = 500
+
+// DiffRequests compares a request of a bin to another, of the same bin or
+// not: their headers, and their bodies structurally when both are JSON.
+func (s *Services) DiffRequests(ctx context.Context, binId, requestId, otherBinId, otherRequestId int64) (models.RequestDiff, error) {
+	from, err := s.GetRequest(ctx, binId, requestId)
+	if err != nil {
+		return models.RequestDiff{}, err
+	}
+	to, err := s.GetRequest(ctx, otherBinId, otherRequestId)
+	if err != nil {
+		return models.RequestDiff{}, err
+	}
+	return diffRequests(from, to)
+}
+
+func diffRequests(from, to models.Request) (models.RequestDiff, error) {
+	diff := models.RequestDiff{
+		From:    diffedRequest(from),
+		To:      diffedRequest(to),
+		Headers: []models.HeaderChange{},
+		Body:    diffBodies(from.Body, to.Body),
+	}
+
+	fromHeaders, err := from.GetHeaders()
+	if err != nil {
+		return models.RequestDiff{}, err
+	}
+	toHeaders, err := to.GetHeaders()
+	if err != nil {
+		return models.RequestDiff{}, err
+	}
+	var names []string
+	for name := range fromHeaders {
+		names = append(names, name)
+	}
+	for name := range toHeaders {
+		if _, ok := fromHeaders[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fromValues, inFrom := fromHeaders[name]
+		toValues, inTo := toHeaders[name]
+		change := models.HeaderChange{Name: name, From: fromValues, To: toValues}
+		switch {
+		case !inFrom:
+			change.Change = models.Added
+		case !inTo:
+			change.Change = models.Removed
+		case !slices.Equal(fromValues, toValues):
+			change.Change = models.Changed
+		default:
+			continue
+		}
+		diff.Headers = append(diff.Headers, change)
+	}
+	return diff, nil
+}
+
+func diffedRequest(request models.Request) models.DiffedRequest {
+	return models.DiffedRequest{
+		Bin:        request.Bin,
+		Id:         request.Id,
+		Method:     request.Method,
+		RequestUri: request.RequestUri,
+		RecievedAt: request.RecievedAt,
+		Body:       request.Body,
+	}
+}
+
+// diffBodies compares two bodies, structurally when both are JSON and as
+// text otherwise.
+func diffBodies(from, to string) models.BodyDiff {
+	diff := models.BodyDiff{Equal: from == to, Changes: []models.JSONChange{}}
+	fromValue, fromErr := decodeJSON(from)
+	toValue, toErr := decodeJSON(to)
+	if fromErr != nil || toErr != nil {
+		return diff
+	}
+	diff.JSON = true
+	diffJSON(&diff, "", fromValue, toValue)
+	diff.Equal = len(diff.Changes) == 0
+	return diff
+}
+
+// decodeJSON decodes a JSON body, keeping the text of its numbers.
+func decodeJSON(body string) (any, error) {
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("trailing data after JSON value")
+	}
+	return value, nil
+}
+
+// diffJSON adds the changes from one JSON value to another at a path to the
+// diff. Objects are compared by key, arrays by index, and other values by
+// type and JSON text, 1 and 1.0 being different.
+func diffJSON(diff *models.BodyDiff, path string, from, to any) {
+	if len(diff.Changes) >= maxDiffChanges {
+		diff.Truncated = true
+		return
+	}
+	switch from := from.(type) {
+	case map[string]any:
+		if to, ok := to.(map[string]any); ok {
+			var keys []string
+			for key := range from {
+				keys = append(keys, key)
+			}
+			for key := range to {
+				if _, ok := from[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			slices.Sort(keys)
+			for _, key := range keys {
+				fromValue, inFrom := from[key]
+				toValue, inTo := to[key]
+				keyPath := path + "/" + escapePointer(key)
+				switch {
+				case !inFrom:
+					addChange(diff, models.JSONChange{Path: keyPath, Change: models.Added, To: toValue})
+				case !inTo:
+					addChange(diff, models.JSONChange{Path: keyPath, Change: models.Removed, From: fromValue})
+				default:
+					diffJSON(diff, keyPath, fromValue, toValue)
+				}
+			}
+			return
+		}
+	case []any:
+		if to, ok := to.([]any); ok {
+			for i := range max(len(from), len(to)) {
+				indexPath := path + "/" + strconv.Itoa(i)
+				switch {
+				case i >= len(from):
+					addChange(diff, models.JSONChange{Path: indexPath, Change: models.Added, To: to[i]})
+				case i >= len(to):
+					addChange(diff, models.JSONChange{Path: indexPath, Change: models.Removed, From: from[i]})
+				default:
+					diffJSON(diff, indexPath, from[i], to[i])
+				}
+			}
+			return
+		}
+	default:
+		if sameJSON(from, to) {
+			return
+		}
+	}
+	addChange(diff, models.JSONChange{Path: path, Change: models.Changed, From: from, To: to})
+}
+
+func addChange(diff *models.BodyDiff, change models.JSONChange) {
+	if len(diff.Changes) >= maxDiffChanges {
+		diff.Truncated = true
+		return
+	}
+	diff.Changes = append(diff.Changes, change)
+}
+
+// sameJSON reports whether a scalar JSON value is the same as another.
+func sameJSON(from, to any) bool {
+	fromText, _ := json.Marshal(from)
+	toText, _ := json.Marshal(to)
+	return bytes.Equal(fromText, toText)
+}
+
+// escapePointer escapes a key as a token of a JSON pointer, RFC 6901.
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
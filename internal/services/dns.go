@@ -0,0 +1,14 @@
+package services
+
+import (
+	"context"
+
+	"app/internal/models"
+)
+
+// CaptureDNSQuery captures a DNS query for the name of a bin, once
+// answered, unless the rate limits of its resolver or bin refuse it.
+func (s *Services) CaptureDNSQuery(ctx context.Context, request models.Request) {
+	// queries are answered by the DNS server rather than rules
+	s.captureUnanswered(ctx, request)
+}
@@ -0,0 +1,397 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"app/internal/models"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Protocols of the gRPC calls captured, told apart by their content type.
+const (
+	GrpcProtocol        = "grpc"
+	GrpcWebProtocol     = "grpc-web"
+	GrpcWebTextProtocol = "grpc-web-text"
+)
+
+// gRPC status codes.
+const (
+	grpcStatusOK       = 0
+	grpcStatusInternal = 13
+	maxGrpcStatus      = 16
+)
+
+const (
+	// MaxDescriptorSet is the size of the largest descriptor set of a bin.
+	MaxDescriptorSet = 4 << 20
+	// maxDecompressedMessage bounds the size of compressed messages once
+	// decompressed, as gRPC servers do by default.
+	maxDecompressedMessage = 4 << 20
+)
+
+// grpcBin is the gRPC settings of a bin, with the registry of its
+// descriptor set, which is parsed once rather than for every call.
+type grpcBin struct {
+	settings models.GrpcSettings
+	// files are the files of the descriptor set, nil when there is none
+	files    *protoregistry.Files
+	filesErr error
+}
+
+// IsGrpcCall reports whether a request is a gRPC call, by its content type.
+func IsGrpcCall(request models.Request) bool {
+	return grpcCallProtocol(request) != ""
+}
+
+// grpcCallProtocol returns the gRPC protocol a request calls with, none
+// when it is not a gRPC call.
+func grpcCallProtocol(request models.Request) string {
+	headers, err := request.GetHeaders()
+	if err != nil {
+		return ""
+	}
+	mediaType, _, _ := strings.Cut(http.Header(headers).Get("Content-Type"), ";")
+	// the message format follows a +, e.g. application/grpc+proto
+	mediaType, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(mediaType)), "+")
+	switch protocol := strings.TrimPrefix(mediaType, "application/"); protocol {
+	case GrpcProtocol, GrpcWebProtocol, GrpcWebTextProtocol:
+		return protocol
+	}
+	return ""
+}
+
+// logGrpcCall captures a gRPC call and returns the response of the bin,
+// with the status of its gRPC settings.
+func (s *Services) logGrpcCall(ctx context.Context, request models.Request, protocol string) (models.Response, error) {
+	bin, err := s.grpcBin(ctx, request.Bin)
+	if err != nil {
+		return models.Response{}, err
+	}
+	response := grpcResponse(bin, request, protocol)
+
+	request, err = s.captureRequest(ctx, request)
+	if err != nil {
+		return models.Response{}, err
+	}
+	slog.DebugContext(ctx, "grpc call captured", "request", request, "status", bin.settings.Status)
+
+	return response, nil
+}
+
+// grpcBin returns the gRPC settings of a bin, parsed when first needed
+// since they were set.
+func (s *Services) grpcBin(ctx context.Context, binId int64) (grpcBin, error) {
+	return s.grpcBins.load(binId, func() (grpcBin, error) {
+		settings, err := s.db.GetGrpcSettings(ctx, binId)
+		if err != nil {
+			return grpcBin{}, err
+		}
+		return newGrpcBin(settings), nil
+	})
+}
+
+func newGrpcBin(settings models.GrpcSettings) grpcBin {
+	bin := grpcBin{settings: settings}
+	if len(settings.DescriptorSet) > 0 {
+		bin.files, bin.filesErr = parseDescriptorSet(settings.DescriptorSet)
+		// only the registry is used from then on
+		bin.settings.DescriptorSet = nil
+	}
+	return bin
+}
+
+// grpcResponse answers a gRPC call with the status of the settings of its
+// bin, and for OK calls the response message of the method called. gRPC-Web
+// responses carry their trailers as a last message, base64 encoded with the
+// rest of the body for text calls.
+func grpcResponse(bin grpcBin, request models.Request, protocol string) models.Response {
+	status, message := bin.settings.Status, bin.settings.Message
+	var body []byte
+	if status == grpcStatusOK {
+		reply, err := encodeGrpcResponse(bin, request.SubPath)
+		if err != nil {
+			// the call is still captured, failing as servers do
+			status, message = grpcStatusInternal, fmt.Sprintf("encoding response: %s", err.Error())
+		} else {
+			body = appendGrpcMessage(body, 0, reply)
+		}
+	}
+
+	trailers := map[string]string{"Grpc-Status": strconv.Itoa(status)}
+	if message != "" {
+		trailers["Grpc-Message"] = encodeGrpcMessage(message)
+	}
+	response := models.Response{
+		Status:  http.StatusOK,
+		Headers: map[string]string{"Content-Type": "application/" + protocol},
+	}
+	if protocol == GrpcProtocol {
+		response.Body = string(body)
+		response.Trailers = trailers
+		return response
+	}
+
+	var block strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(trailers)) {
+		fmt.Fprintf(&block, "%s: %s\r\n", strings.ToLower(name), trailers[name])
+	}
+	body = appendGrpcMessage(body, 0x80, []byte(block.String()))
+	if protocol == GrpcWebTextProtocol {
+		body = base64.StdEncoding.AppendEncode(nil, body)
+	}
+	response.Body = string(body)
+	return response
+}
+
+// encodeGrpcResponse encodes the response message of a method, empty when
+// the settings have none.
+func encodeGrpcResponse(bin grpcBin, fullMethod string) ([]byte, error) {
+	response, ok := bin.settings.Responses[fullMethod]
+	if !ok {
+		return nil, nil
+	}
+	method, err := bin.findMethod(fullMethod)
+	if err != nil {
+		return nil, err
+	}
+	message := dynamicpb.NewMessage(method.Output())
+	if err := protojson.Unmarshal([]byte(response), message); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(message)
+}
+
+// appendGrpcMessage appends a message prefixed with its flags and length.
+func appendGrpcMessage(body []byte, flags byte, message []byte) []byte {
+	body = append(body, flags)
+	body = binary.BigEndian.AppendUint32(body, uint32(len(message)))
+	return append(body, message...)
+}
+
+// encodeGrpcMessage percent-encodes a status message as the grpc-message
+// header is.
+func encodeGrpcMessage(message string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		} else {
+			encoded.WriteByte(c)
+		}
+	}
+	return encoded.String()
+}
+
+// parseGrpcMessages splits the body of a gRPC call into its messages. A
+// truncated message ends the list with the bytes left.
+func parseGrpcMessages(body []byte) []models.GrpcMessage {
+	var messages []models.GrpcMessage
+	for len(body) > 0 {
+		if len(body) < 5 || uint64(len(body)-5) < uint64(binary.BigEndian.Uint32(body[1:5])) {
+			return append(messages, models.GrpcMessage{
+				Size:        len(body),
+				Payload:     body,
+				DecodeError: "truncated message",
+			})
+		}
+		flags, size := body[0], int(binary.BigEndian.Uint32(body[1:5]))
+		payload := body[5 : 5+size]
+		body = body[5+size:]
+		if flags&0x80 != 0 {
+			// trailers of gRPC-Web
+			continue
+		}
+		messages = append(messages, models.GrpcMessage{
+			Compressed: flags&0x01 != 0,
+			Size:       size,
+			Payload:    payload,
+		})
+	}
+	return messages
+}
+
+// parseDescriptorSet builds the registry of the files of a serialized
+// FileDescriptorSet.
+func parseDescriptorSet(descriptorSet []byte) (*protoregistry.Files, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(descriptorSet, &set); err != nil {
+		return nil, err
+	}
+	return protodesc.NewFiles(&set)
+}
+
+// findMethod finds the descriptor of a method, named as gRPC calls it e.g.
+// /events.Bus/Publish, in the descriptor set of the bin.
+func (bin grpcBin) findMethod(fullMethod string) (protoreflect.MethodDescriptor, error) {
+	if bin.filesErr != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %w", bin.filesErr)
+	}
+	if bin.files == nil {
+		return nil, errors.New("no descriptor set")
+	}
+
+	serviceName, methodName, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid method %q", fullMethod)
+	}
+	descriptor, err := bin.files.FindDescriptorByName(protoreflect.FullName(serviceName))
+	if err != nil {
+		return nil, fmt.Errorf("service %s not in descriptor set", serviceName)
+	}
+	service, ok := descriptor.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", serviceName)
+	}
+	method := service.Methods().ByName(protoreflect.Name(methodName))
+	if method == nil {
+		return nil, fmt.Errorf("method %s not in service %s", methodName, serviceName)
+	}
+	return method, nil
+}
+
+// GetGrpcMessages returns the messages of a gRPC call captured as a request
+// of a bin, decoded with the descriptor set of the bin. Requests which are
+// not gRPC calls have none.
+func (s *Services) GetGrpcMessages(ctx context.Context, binId, requestId int64) ([]models.GrpcMessage, error) {
+	request, err := s.GetRequest(ctx, binId, requestId)
+	if err != nil {
+		return nil, err
+	}
+	protocol := grpcCallProtocol(request)
+	if protocol == "" {
+		return nil, nil
+	}
+
+	body := []byte(request.Body)
+	if protocol == GrpcWebTextProtocol {
+		if body, err = base64.StdEncoding.DecodeString(request.Body); err != nil {
+			return []models.GrpcMessage{{
+				Size:        len(request.Body),
+				Payload:     []byte(request.Body),
+				DecodeError: fmt.Sprintf("invalid base64: %s", err.Error()),
+			}}, nil
+		}
+	}
+	messages := parseGrpcMessages(body)
+
+	bin, err := s.grpcBin(ctx, binId)
+	if err != nil {
+		return nil, err
+	}
+	if bin.files == nil && bin.filesErr == nil {
+		return messages, nil
+	}
+	method, err := bin.findMethod(request.SubPath)
+	headers, _ := request.GetHeaders()
+	encoding := http.Header(headers).Get("Grpc-Encoding")
+	for i := range messages {
+		if messages[i].DecodeError != "" {
+			continue
+		}
+		if err != nil {
+			messages[i].DecodeError = err.Error()
+			continue
+		}
+		decoded, decodeErr := decodeGrpcMessage(method, messages[i], encoding)
+		if decodeErr != nil {
+			messages[i].DecodeError = decodeErr.Error()
+			continue
+		}
+		messages[i].Decoded = decoded
+	}
+	return messages, nil
+}
+
+// decodeGrpcMessage decodes a request message of a method as JSON, once
+// decompressed with the encoding of the call.
+func decodeGrpcMessage(method protoreflect.MethodDescriptor, message models.GrpcMessage, encoding string) (string, error) {
+	payload := message.Payload
+	if message.Compressed {
+		if encoding != "gzip" {
+			return "", fmt.Errorf("unsupported compression %q", encoding)
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return "", err
+		}
+		payload, err = io.ReadAll(io.LimitReader(reader, maxDecompressedMessage+1))
+		if err != nil {
+			return "", err
+		}
+		if len(payload) > maxDecompressedMessage {
+			return "", fmt.Errorf("decompressed message exceeds %d bytes", maxDecompressedMessage)
+		}
+	}
+
+	decoded := dynamicpb.NewMessage(method.Input())
+	if err := proto.Unmarshal(payload, decoded); err != nil {
+		return "", err
+	}
+	encoded, err := protojson.Marshal(decoded)
+	if err != nil {
+		return "", err
+	}
+	// protojson varies its spacing from build to build
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, encoded); err != nil {
+		return "", err
+	}
+	return compacted.String(), nil
+}
+
+// SetGrpcSettings replaces how a bin the caller may change answers the gRPC
+// calls it captures.
+func (s *Services) SetGrpcSettings(ctx context.Context, caller string, binId int64, settings models.GrpcSettings) error {
+	if err := s.authorizeBin(ctx, caller, binId); err != nil {
+		return err
+	}
+	if settings.Status < grpcStatusOK || settings.Status > maxGrpcStatus {
+		return ValidationError(fmt.Sprintf("invalid grpc status: %d", settings.Status))
+	}
+	if len(settings.DescriptorSet) > MaxDescriptorSet {
+		return ValidationError(fmt.Sprintf("descriptor set exceeds %d bytes", MaxDescriptorSet))
+	}
+	bin := newGrpcBin(settings)
+	if bin.filesErr != nil {
+		return ValidationError(fmt.Sprintf("invalid descriptor set: %s", bin.filesErr.Error()))
+	}
+	for method := range settings.Responses {
+		if _, err := encodeGrpcResponse(bin, method); err != nil {
+			return ValidationError(fmt.Sprintf("invalid response of %s: %s", method, err.Error()))
+		}
+	}
+
+	if err := s.db.SetGrpcSettings(ctx, binId, settings); err != nil {
+		return err
+	}
+	s.grpcBins.invalidate(binId)
+	return nil
+}
+
+func (s *Services) GetGrpcSettings(ctx context.Context, binId int64) (models.GrpcSettings, error) {
+	if err := BinIdValidation(binId); err != nil {
+		return models.GrpcSettings{}, err
+	}
+	return s.db.GetGrpcSettings(ctx, binId)
+}
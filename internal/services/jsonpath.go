@@ -0,0 +1,100 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep is a single member name or array index of a JSONPath.
+type jsonPathStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parseJSONPath parses the subset of JSONPath made of dotted member names,
+// bracketed quoted member names and array indexes, e.g. $.items[0]['the id'].
+func parseJSONPath(expr string) ([]jsonPathStep, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("invalid json path %q: must start with $", expr)
+	}
+
+	var steps []jsonPathStep
+	rest := expr[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("invalid json path %q: empty member name", expr)
+			}
+			steps = append(steps, jsonPathStep{key: key})
+			rest = rest[end+1:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("invalid json path %q: unterminated [", expr)
+			}
+			inner := rest[1:end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				steps = append(steps, jsonPathStep{key: inner[1 : len(inner)-1]})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid json path %q: bad index %q", expr, inner)
+				}
+				steps = append(steps, jsonPathStep{index: index, isIndex: true})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid json path %q: unexpected %q", expr, rest[0])
+		}
+	}
+
+	return steps, nil
+}
+
+// lookupJSONPath walks a document decoded by encoding/json along steps.
+func lookupJSONPath(doc any, steps []jsonPathStep) (any, bool) {
+	current := doc
+	for _, step := range steps {
+		if step.isIndex {
+			items, ok := current.([]any)
+			if !ok || step.index >= len(items) {
+				return nil, false
+			}
+			current = items[step.index]
+			continue
+		}
+
+		members, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		current, ok = members[step.key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// jsonValueString renders a decoded JSON value for comparison with the
+// expected values of rules: strings as is, everything else as JSON.
+func jsonValueString(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
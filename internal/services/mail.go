@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+
+	"app/internal/models"
+)
+
+// maxMailDepth bounds the nesting of the multipart bodies of mails.
+const maxMailDepth = 10
+
+// CaptureMail captures a mail sent to a bin over SMTP, unless the rate
+// limits of its client or bin refuse it.
+func (s *Services) CaptureMail(ctx context.Context, request models.Request) error {
+	// mails are answered by SMTP replies rather than statuses
+	return s.captureUnanswered(ctx, request)
+}
+
+// GetMailMessage returns the mail captured as a request of a bin, parsed
+// from its body. Requests which are not mails are not found.
+func (s *Services) GetMailMessage(ctx context.Context, binId, requestId int64) (models.MailMessage, error) {
+	request, err := s.GetRequest(ctx, binId, requestId)
+	if err != nil {
+		return models.MailMessage{}, err
+	}
+	if !request.IsMail() {
+		return models.MailMessage{}, fmt.Errorf("mail %d in bin %d: %w", requestId, binId, models.ErrNotFound)
+	}
+	return parseMail(request.Body), nil
+}
+
+// parseMail parses the bodies and attachments of a mail. Parsing stops at
+// the first malformed part.
+func parseMail(body string) models.MailMessage {
+	var message models.MailMessage
+	msg, err := mail.ReadMessage(strings.NewReader(body))
+	if err != nil {
+		message.ParseError = err.Error()
+		return message
+	}
+	message.Subject = decodeMailHeader(msg.Header.Get("Subject"))
+
+	err = parseMailPart(&message, textproto.MIMEHeader(msg.Header), msg.Body, 0)
+	if err != nil {
+		message.ParseError = err.Error()
+	}
+	return message
+}
+
+// parseMailPart adds a part of a mail, or the parts it is made of, to the
+// message.
+func parseMailPart(message *models.MailMessage, header textproto.MIMEHeader, body io.Reader, depth int) error {
+	mediaType, params := "text/plain", map[string]string{}
+	if contentType := header.Get("Content-Type"); contentType != "" {
+		var err error
+		if mediaType, params, err = mime.ParseMediaType(contentType); err != nil {
+			mediaType = "application/octet-stream"
+		}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxMailDepth {
+			return errors.New("multipart bodies nested too deeply")
+		}
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			// parts are decoded as their Content-Transfer-Encoding says
+			// below, for all encodings alike
+			part, err := reader.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := parseMailPart(message, part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := io.ReadAll(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return err
+	}
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	inline := disposition != "attachment"
+	switch {
+	case inline && mediaType == "text/plain" && message.Text == "":
+		message.Text = decodeCharset(params["charset"], content)
+	case inline && mediaType == "text/html" && message.HTML == "":
+		message.HTML = decodeCharset(params["charset"], content)
+	default:
+		filename := dispositionParams["filename"]
+		if filename == "" {
+			filename = params["name"]
+		}
+		message.Attachments = append(message.Attachments, models.MailAttachment{
+			Filename:    decodeMailHeader(filename),
+			ContentType: mediaType,
+			Size:        len(content),
+			Content:     content,
+		})
+	}
+	return nil
+}
+
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		// the decoder skips line breaks
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// decodeCharset decodes text in the Latin-1 and Windows-1252 charsets to
+// UTF-8, and keeps text in the others as is.
+func decodeCharset(charset string, content []byte) string {
+	var charsetMap *charmap.Charmap
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1":
+		charsetMap = charmap.ISO8859_1
+	case "windows-1252", "cp1252":
+		charsetMap = charmap.Windows1252
+	default:
+		return string(content)
+	}
+	if utf8.Valid(content) {
+		return string(content)
+	}
+	decoded, err := charsetMap.NewDecoder().Bytes(content)
+	if err != nil {
+		return string(content)
+	}
+	return string(decoded)
+}
+
+// decodeMailHeader decodes the RFC 2047 encoded words of a header, kept as
+// is when they are malformed.
+func decodeMailHeader(value string) string {
+	decoder := mime.WordDecoder{CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		content, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(decodeCharset(charset, content)), nil
+	}}
+	decoded, err := decoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
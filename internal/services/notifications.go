@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"app/internal/models"
+	"app/internal/notify"
+)
+
+// Bounds of the notification targets of a bin.
+const (
+	maxNotificationTargets = 10
+	maxRecipients          = 10
+)
+
+// Default templates of the notification targets set without them.
+const (
+	defaultWebhookPayload = `{"bin": {{.Request.Bin}}, "method": {{json .Method}}, "subPath": {{json .SubPath}}, "receivedAt": {{json .Request.RecievedAt}}}`
+	defaultEmailSubject   = `{{.Method}} request captured by bin {{.Request.Bin}}`
+	defaultEmailPayload   = "{{.Method}} {{.Request.RequestUri}}\n\n{{.Body}}"
+)
+
+// ErrUnownedBinEmails is returned when setting email targets on a bin
+// without an owner, which anyone could otherwise send mails through.
+var ErrUnownedBinEmails = fmt.Errorf("%w: email targets are only set on bins created with a token", ErrForbidden)
+
+// Notifier sends the notifications of captured requests without waiting
+// for them, see notify.Queue.
+type Notifier interface {
+	Enqueue(message notify.Message) bool
+}
+
+// SetNotificationTargets replaces the notification targets of a bin the
+// caller may change. Email targets are only set on bins with an owner.
+func (s *Services) SetNotificationTargets(ctx context.Context, caller string, binId int64, targets []models.NotificationTarget) error {
+	bin, err := s.authorizedBin(ctx, caller, binId)
+	if err != nil {
+		return err
+	}
+	if err := validateNotificationTargets(targets, s.emails, s.emailDomains); err != nil {
+		return err
+	}
+	if bin.Owner == "" && slices.ContainsFunc(targets, func(target models.NotificationTarget) bool {
+		return target.Kind == notify.KindEmail
+	}) {
+		return ErrUnownedBinEmails
+	}
+
+	for i := range targets {
+		setNotificationDefaults(&targets[i])
+	}
+
+	return s.db.SetNotificationTargets(ctx, binId, targets)
+}
+
+// GetNotificationTargets returns the notification targets of a bin the
+// caller may change, as they may hold secrets.
+func (s *Services) GetNotificationTargets(ctx context.Context, caller string, binId int64) ([]models.NotificationTarget, error) {
+	if err := s.authorizeBin(ctx, caller, binId); err != nil {
+		return nil, err
+	}
+	return s.db.GetNotificationTargets(ctx, binId)
+}
+
+// validateNotificationTargets checks targets, of which emails only when
+// they can be sent, to recipients of the domains allowed when any are.
+func validateNotificationTargets(targets []models.NotificationTarget, emails bool, domains []string) error {
+	if len(targets) > maxNotificationTargets {
+		return ValidationError(fmt.Sprintf("a bin has at most %d notification targets", maxNotificationTargets))
+	}
+
+	for i, target := range targets {
+		switch target.Kind {
+		case notify.KindWebhook:
+			uri, err := url.Parse(target.Url)
+			if err != nil || (uri.Scheme != "http" && uri.Scheme != "https") || uri.Host == "" {
+				return ValidationError(fmt.Sprintf("target %d: invalid webhook url %q", i, target.Url))
+			}
+			for key, value := range target.Headers {
+				if _, err := parseResponseTemplate(key, value); err != nil {
+					return ValidationError(fmt.Sprintf("target %d: %s", i, err))
+				}
+			}
+		case notify.KindEmail:
+			if !emails {
+				return ValidationError(fmt.Sprintf("target %d: email notifications are not configured", i))
+			}
+			if len(target.To) == 0 || len(target.To) > maxRecipients {
+				return ValidationError(fmt.Sprintf("target %d: emails have 1 to %d recipients", i, maxRecipients))
+			}
+			for _, to := range target.To {
+				if address, err := mail.ParseAddress(to); err != nil || address.Address != to {
+					return ValidationError(fmt.Sprintf("target %d: invalid recipient %q", i, to))
+				}
+				domain := to[strings.LastIndex(to, "@")+1:]
+				if len(domains) > 0 && !slices.ContainsFunc(domains, func(allowed string) bool {
+					return strings.EqualFold(allowed, domain)
+				}) {
+					return ValidationError(fmt.Sprintf("target %d: recipient %q is not of an allowed domain", i, to))
+				}
+			}
+			if _, err := parseResponseTemplate("subject", target.Subject); err != nil {
+				return ValidationError(fmt.Sprintf("target %d: %s", i, err))
+			}
+		default:
+			return ValidationError(fmt.Sprintf("target %d: invalid kind %q, expected webhook or email", i, target.Kind))
+		}
+		if _, err := parseResponseTemplate("payload", target.Payload); err != nil {
+			return ValidationError(fmt.Sprintf("target %d: %s", i, err))
+		}
+	}
+
+	return nil
+}
+
+func setNotificationDefaults(target *models.NotificationTarget) {
+	switch target.Kind {
+	case notify.KindWebhook:
+		if target.Payload == "" {
+			target.Payload = defaultWebhookPayload
+		}
+	case notify.KindEmail:
+		if target.Subject == "" {
+			target.Subject = defaultEmailSubject
+		}
+		if target.Payload == "" {
+			target.Payload = defaultEmailPayload
+		}
+	}
+}
+
+// notify queues the notifications of a captured request. Failures are only
+// logged, they never fail the capture.
+func (s *Services) notify(ctx context.Context, request models.Request) {
+	targets, err := s.db.GetNotificationTargets(ctx, request.Bin)
+	if err != nil {
+		slog.ErrorContext(ctx, "getting notification targets", "error", err)
+		return
+	}
+	for _, target := range targets {
+		if target.Kind == notify.KindEmail {
+			if ok, _ := s.limiters.email.Allow(strconv.FormatInt(request.Bin, 10)); !ok {
+				slog.WarnContext(ctx, "email notification over the rate limit", "bin", request.Bin, "target", target.Id)
+				s.metrics.NotificationsSent.Inc(target.Kind, "dropped")
+				continue
+			}
+		}
+		message, err := renderNotification(target, request)
+		if err != nil {
+			slog.WarnContext(ctx, "rendering notification", "bin", request.Bin, "target", target.Id, "error", err)
+			continue
+		}
+		s.notifier.Enqueue(message)
+	}
+}
+
+// renderNotification renders the templates of a target with the captured
+// request.
+func renderNotification(target models.NotificationTarget, request models.Request) (notify.Message, error) {
+	data, err := newTemplateData(request)
+	if err != nil {
+		return notify.Message{}, err
+	}
+
+	message := notify.Message{
+		Bin:    request.Bin,
+		Target: target.Id,
+		Kind:   target.Kind,
+		Url:    target.Url,
+		To:     target.To,
+	}
+	if message.Body, err = renderTemplate("payload", target.Payload, data); err != nil {
+		return notify.Message{}, err
+	}
+	if message.Subject, err = renderTemplate("subject", target.Subject, data); err != nil {
+		return notify.Message{}, err
+	}
+	message.Headers = make(map[string]string, len(target.Headers))
+	for key, value := range target.Headers {
+		if message.Headers[key], err = renderTemplate(key, value, data); err != nil {
+			return notify.Message{}, err
+		}
+	}
+	return message, nil
+}
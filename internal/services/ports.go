@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"app/internal/models"
+)
+
+// maxCapturePorts bounds the ports allocated to a bin.
+const maxCapturePorts = 4
+
+// ErrUnownedBin is returned when allocating a port to a bin without an
+// owner, which anyone could otherwise allocate every port to.
+var ErrUnownedBin = fmt.Errorf("%w: ports are only allocated to bins created with a token", ErrForbidden)
+
+// ErrNoFreePort is returned when no port of the range is left to allocate,
+// or no range is configured.
+var ErrNoFreePort = errors.New("no free port")
+
+// PortRange is the range of ports allocated to bins, First to Last
+// included. The zero value allocates none.
+type PortRange struct {
+	First int
+	Last  int
+}
+
+// Listeners capture what the ports allocated to bins receive, see
+// capture.Listeners.
+type Listeners interface {
+	Listen(port models.CapturePort, capture func(ctx context.Context, request models.Request)) error
+	Release(protocol string, port int)
+}
+
+// AllocateCapturePort allocates the first free port of the range to a bin
+// the caller owns, and starts capturing what it receives.
+func (s *Services) AllocateCapturePort(ctx context.Context, caller string, binId int64, protocol string) (models.CapturePort, error) {
+	if protocol != models.TCP && protocol != models.UDP {
+		return models.CapturePort{}, ValidationError(fmt.Sprintf("invalid protocol %q, expected tcp or udp", protocol))
+	}
+	bin, err := s.authorizedBin(ctx, caller, binId)
+	if err != nil {
+		return models.CapturePort{}, err
+	}
+	if bin.Owner == "" {
+		return models.CapturePort{}, ErrUnownedBin
+	}
+	if s.listeners == nil || s.capturePorts.First <= 0 {
+		return models.CapturePort{}, ErrNoFreePort
+	}
+
+	s.portsMu.Lock()
+	defer s.portsMu.Unlock()
+	allocated, err := s.db.GetCapturePorts(ctx, 0)
+	if err != nil {
+		return models.CapturePort{}, err
+	}
+	used := map[models.CapturePort]bool{}
+	binPorts := 0
+	for _, port := range allocated {
+		if port.Bin == binId {
+			binPorts++
+		}
+		used[models.CapturePort{Protocol: port.Protocol, Port: port.Port}] = true
+	}
+	if binPorts >= maxCapturePorts {
+		return models.CapturePort{}, ValidationError(fmt.Sprintf("at most %d ports are allocated to a bin", maxCapturePorts))
+	}
+
+	for number := s.capturePorts.First; number <= s.capturePorts.Last; number++ {
+		if used[models.CapturePort{Protocol: protocol, Port: number}] {
+			continue
+		}
+		port := models.CapturePort{Protocol: protocol, Port: number, Bin: binId}
+		if err := s.listeners.Listen(port, s.captureRaw); err != nil {
+			// taken by another process
+			slog.WarnContext(ctx, "listening on capture port", "protocol", protocol, "port", number, "error", err)
+			continue
+		}
+		if err := s.db.InsertCapturePort(ctx, port); err != nil {
+			s.listeners.Release(protocol, number)
+			return models.CapturePort{}, err
+		}
+		return port, nil
+	}
+	return models.CapturePort{}, ErrNoFreePort
+}
+
+// GetCapturePorts returns the ports allocated to a bin.
+func (s *Services) GetCapturePorts(ctx context.Context, binId int64) ([]models.CapturePort, error) {
+	if err := BinIdValidation(binId); err != nil {
+		return nil, err
+	}
+	return s.db.GetCapturePorts(ctx, binId)
+}
+
+// ReleaseCapturePort stops capturing a port of a bin the caller may change,
+// left free to allocate again.
+func (s *Services) ReleaseCapturePort(ctx context.Context, caller string, binId int64, protocol string, port int) error {
+	if err := s.authorizeBin(ctx, caller, binId); err != nil {
+		return err
+	}
+
+	s.portsMu.Lock()
+	defer s.portsMu.Unlock()
+	if err := s.db.DeleteCapturePort(ctx, models.CapturePort{Protocol: protocol, Port: port, Bin: binId}); err != nil {
+		return err
+	}
+	if s.listeners != nil {
+		s.listeners.Release(protocol, port)
+	}
+	return nil
+}
+
+// OpenCapturePorts starts capturing the ports allocated to bins, once the
+// app starts. Ports failing to be listened on are logged and skipped.
+func (s *Services) OpenCapturePorts(ctx context.Context) error {
+	if s.listeners == nil {
+		return nil
+	}
+	ports, err := s.db.GetCapturePorts(ctx, 0)
+	if err != nil {
+		return err
+	}
+	for _, port := range ports {
+		if err := s.listeners.Listen(port, s.captureRaw); err != nil {
+			slog.WarnContext(ctx, "listening on capture port", "protocol", port.Protocol, "port", port.Port, "bin", port.Bin, "error", err)
+		}
+	}
+	return nil
+}
+
+// releaseBinPorts stops capturing the ports of a deleted bin.
+func (s *Services) releaseBinPorts(ports []models.CapturePort) {
+	if s.listeners == nil {
+		return
+	}
+	for _, port := range ports {
+		s.listeners.Release(port.Protocol, port.Port)
+	}
+}
+
+// captureRaw captures a TCP connection or UDP datagram received on a port
+// of a bin, unless the rate limits of its client or bin refuse it.
+func (s *Services) captureRaw(ctx context.Context, request models.Request) {
+	// nothing is answered on the ports
+	s.captureUnanswered(ctx, request)
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"app/internal/models"
+	"app/internal/ratelimit"
+)
+
+// droppedFlushInterval is how often the counts of dropped requests are
+// stored, rather than writing to the database for every request refused.
+const droppedFlushInterval = time.Second
+
+// RateLimitError is returned when a rate limit refuses a request.
+type RateLimitError struct {
+	// RetryAfter is how long until the request would be allowed.
+	RetryAfter time.Duration
+}
+
+func (e RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry in %ds", e.RetryAfterSeconds())
+}
+
+// RetryAfterSeconds is RetryAfter rounded up to whole seconds, at least one,
+// as sent in Retry-After headers.
+func (e RateLimitError) RetryAfterSeconds() int64 {
+	return max(int64(math.Ceil(e.RetryAfter.Seconds())), 1)
+}
+
+// RateLimits limit the rate of requests, zero limits allow everything.
+type RateLimits struct {
+	// Bin limits the requests captured by each bin, Client the requests
+	// made to bins by each client IP.
+	Bin    ratelimit.Limit
+	Client ratelimit.Limit
+	// NewBin limits the bins created, by all clients together.
+	NewBin ratelimit.Limit
+	// Email limits the email notifications sent for each bin.
+	Email ratelimit.Limit
+}
+
+// maxCachedBinLimiters bounds the bins whose own rate limiters are kept.
+// Those dropped start again with full buckets.
+const maxCachedBinLimiters = 10000
+
+type rateLimiters struct {
+	bin    *ratelimit.Limiter
+	client *ratelimit.Limiter
+	newBin *ratelimit.Limiter
+	email  *ratelimit.Limiter
+	// binLimit is the default limit of bins, which the limits of bins may
+	// only lower.
+	binLimit ratelimit.Limit
+	// bins are the limiters of bins with a limit of their own, nil for the
+	// others, limited by bin.
+	bins *binCache[*ratelimit.Limiter]
+
+	mu      sync.Mutex
+	dropped map[int64]int64
+}
+
+func newRateLimiters(limits RateLimits) *rateLimiters {
+	return &rateLimiters{
+		bin:      ratelimit.New(limits.Bin),
+		client:   ratelimit.New(limits.Client),
+		newBin:   ratelimit.New(limits.NewBin),
+		email:    ratelimit.New(limits.Email),
+		binLimit: limits.Bin,
+		bins:     newBinCache[*ratelimit.Limiter](maxCachedBinLimiters),
+		dropped:  map[int64]int64{},
+	}
+}
+
+func (l *rateLimiters) drop(binId int64, count int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dropped[binId] += count
+}
+
+func (l *rateLimiters) takeDropped() map[int64]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	dropped := l.dropped
+	l.dropped = map[int64]int64{}
+	return dropped
+}
+
+// AdmitRequest checks a request to capture against the rate limits of its
+// client and bin, before its body is read. Refused requests are counted on
+// their bin, see RecordDroppedRequests.
+func (s *Services) AdmitRequest(ctx context.Context, request models.Request) error {
+	if err := BinIdValidation(request.Bin); err != nil {
+		return err
+	}
+
+	limit := "client"
+	ok, wait := s.limiters.client.Allow(clientIp(request, s.trustedProxies))
+	if ok {
+		limit = "bin"
+		limiter, err := s.binLimiter(ctx, request.Bin)
+		if err != nil {
+			return err
+		}
+		ok, wait = limiter.Allow(strconv.FormatInt(request.Bin, 10))
+	}
+	if ok {
+		return nil
+	}
+
+	s.metrics.RequestsDropped.Inc(limit)
+	s.limiters.drop(request.Bin, 1)
+	slog.DebugContext(ctx, "request dropped", "bin", request.Bin, "limit", limit)
+	return RateLimitError{RetryAfter: wait}
+}
+
+// binLimiter returns the limiter of the requests to a bin, that of its own
+// limit if it has one. Bins that do not exist are limited by default, their
+// requests are refused once captured.
+func (s *Services) binLimiter(ctx context.Context, binId int64) (*ratelimit.Limiter, error) {
+	limiter, err := s.limiters.bins.load(binId, func() (*ratelimit.Limiter, error) {
+		bin, err := s.db.GetBin(ctx, binId)
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil || bin.RateLimit == "" {
+			return nil, err
+		}
+		limit, err := ratelimit.ParseLimit(bin.RateLimit)
+		if err != nil {
+			// only valid limits are stored
+			return nil, err
+		}
+		return ratelimit.New(limit), nil
+	})
+	if err != nil || limiter == nil {
+		return s.limiters.bin, err
+	}
+	return limiter, nil
+}
+
+// GetBinRateLimit returns the rate limit of a bin, empty when it has the
+// default one.
+func (s *Services) GetBinRateLimit(ctx context.Context, binId int64) (string, error) {
+	if err := BinIdValidation(binId); err != nil {
+		return "", err
+	}
+	bin, err := s.db.GetBin(ctx, binId)
+	if err != nil {
+		return "", err
+	}
+	return bin.RateLimit, nil
+}
+
+// SetBinRateLimit sets the rate limit of a bin the caller may change, as
+// events/duration, or back to the default one when empty. It may not allow
+// more requests than the default limit.
+func (s *Services) SetBinRateLimit(ctx context.Context, caller string, binId int64, limit string) error {
+	if err := s.authorizeBin(ctx, caller, binId); err != nil {
+		return err
+	}
+	if limit != "" {
+		parsed, err := ratelimit.ParseLimit(limit)
+		if err != nil {
+			return ValidationError(err.Error())
+		}
+		if parsed == (ratelimit.Limit{}) {
+			return ValidationError(fmt.Sprintf("invalid limit %q, expected events/duration", limit))
+		}
+		if exceedsLimit(parsed, s.limiters.binLimit) {
+			return ValidationError(fmt.Sprintf("limit %s exceeds the default limit of %s", parsed, s.limiters.binLimit))
+		}
+		limit = parsed.String()
+	}
+
+	if err := s.db.SetBinRateLimit(ctx, binId, limit); err != nil {
+		return err
+	}
+	s.limiters.bins.invalidate(binId)
+	return nil
+}
+
+// exceedsLimit reports whether limit allows larger bursts or a faster rate
+// than bound. Nothing exceeds the zero Limit.
+func exceedsLimit(limit, bound ratelimit.Limit) bool {
+	if bound == (ratelimit.Limit{}) {
+		return false
+	}
+	return limit.Events > bound.Events ||
+		float64(limit.Events)/limit.Per.Seconds() > float64(bound.Events)/bound.Per.Seconds()
+}
+
+// RecordDroppedRequests stores the counts of dropped requests on their bins
+// every second until ctx is done, and once more then.
+func (s *Services) RecordDroppedRequests(ctx context.Context) {
+	ticker := time.NewTicker(droppedFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flushDropped(ctx)
+		case <-ctx.Done():
+			s.flushDropped(context.WithoutCancel(ctx))
+			return
+		}
+	}
+}
+
+func (s *Services) flushDropped(ctx context.Context) {
+	dropped := s.limiters.takeDropped()
+	if len(dropped) == 0 {
+		return
+	}
+	if err := s.db.AddDroppedRequests(ctx, dropped); err != nil {
+		slog.ErrorContext(ctx, "recording dropped requests", "error", err)
+		// counted again on the next flush
+		for binId, count := range dropped {
+			s.limiters.drop(binId, count)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/google/uuid"
+
+	"app/internal/models"
+)
+
+// Bounds of the rendering of a response template, so that a runaway
+// template can neither exhaust memory nor burn CPU on every capture.
+const (
+	maxRenderedSize = 1 << 20
+	maxRenderTime   = 100 * time.Millisecond
+)
+
+// templateData is what response templates are rendered with, e.g.
+// {{.Json.order.id}}, {{.Headers.Get "X-Request-Id"}} or {{.Query.Get "page"}}.
+type templateData struct {
+	Request models.Request
+	Method  string
+	SubPath string
+	Headers http.Header
+	Query   url.Values
+	Body    string
+	Json    any
+	// Message is the message received by a WebSocket session, for the
+	// replies of its script.
+	Message string
+
+	// deadline is when rendering fails, see tick.
+	deadline time.Time
+}
+
+var templateFuncs = template.FuncMap{
+	"uuid": uuid.NewString,
+	"now":  time.Now,
+	"random": func(min, max int) (int, error) {
+		if max <= min {
+			return 0, fmt.Errorf("random: max %d must be greater than min %d", max, min)
+		}
+		return min + rand.IntN(max-min), nil
+	},
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// tickAction is {{tick $}}, which starts every iteration of the ranges of
+// response templates.
+var tickAction = template.Must(template.New("tick").Funcs(template.FuncMap{"tick": tick}).Parse("{{tick $}}")).Tree.Root.Nodes[0]
+
+// tick fails the rendering of a template once past its deadline. Every
+// range iterating calls it, the only loops of templates.
+func tick(data templateData) (string, error) {
+	if time.Now().After(data.deadline) {
+		return "", fmt.Errorf("rendering exceeds %s", maxRenderTime)
+	}
+	return "", nil
+}
+
+// parseResponseTemplate parses a response template, whose rendering is
+// bounded by maxRenderTime, see executeTemplate. Templates may not call
+// templates, which would recurse without ranging.
+func parseResponseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Funcs(template.FuncMap{"tick": tick}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, defined := range tmpl.Templates() {
+		if defined.Tree == nil {
+			continue
+		}
+		if err := boundRanges(defined.Tree.Root); err != nil {
+			return nil, fmt.Errorf("template: %s: %w", name, err)
+		}
+	}
+	return tmpl, nil
+}
+
+// boundRanges makes the ranges under node tick, and refuses calls of
+// templates.
+func boundRanges(node parse.Node) error {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, child := range node.Nodes {
+			if err := boundRanges(child); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return boundBranch(&node.BranchNode)
+	case *parse.WithNode:
+		return boundBranch(&node.BranchNode)
+	case *parse.RangeNode:
+		node.List.Nodes = append([]parse.Node{tickAction}, node.List.Nodes...)
+		return boundBranch(&node.BranchNode)
+	case *parse.TemplateNode:
+		return fmt.Errorf("calling template %q is not allowed", node.Name)
+	}
+	return nil
+}
+
+func boundBranch(branch *parse.BranchNode) error {
+	if err := boundRanges(branch.List); err != nil {
+		return err
+	}
+	return boundRanges(branch.ElseList)
+}
+
+// renderResponse builds the response of a matched rule, rendering its body
+// and header values as templates of the captured request.
+func renderResponse(rule models.Rule, request models.Request) (models.Response, error) {
+	data, err := newTemplateData(request)
+	if err != nil {
+		return models.Response{}, err
+	}
+
+	body, err := renderTemplate("body", rule.ResponseBody, data)
+	if err != nil {
+		return models.Response{}, err
+	}
+
+	responseHeaders := make(map[string]string, len(rule.ResponseHeaders))
+	for key, value := range rule.ResponseHeaders {
+		responseHeaders[key], err = renderTemplate(key, value, data)
+		if err != nil {
+			return models.Response{}, err
+		}
+	}
+
+	return models.Response{
+		Status:  rule.Status,
+		Headers: responseHeaders,
+		Body:    body,
+	}, nil
+}
+
+func newTemplateData(request models.Request) (templateData, error) {
+	headers, err := request.GetHeaders()
+	if err != nil {
+		return templateData{}, err
+	}
+	data := templateData{
+		Request: request,
+		Method:  request.Method,
+		SubPath: request.SubPath,
+		Headers: http.Header(headers),
+		Query:   url.Values{},
+		Body:    request.Body,
+	}
+	if uri, err := url.ParseRequestURI(request.RequestUri); err == nil {
+		data.Query = uri.Query()
+	}
+	_ = json.Unmarshal([]byte(request.Body), &data.Json)
+	return data, nil
+}
+
+func renderTemplate(name, text string, data templateData) (string, error) {
+	tmpl, err := parseResponseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(tmpl, data)
+}
+
+// executeTemplate renders a template parsed by parseResponseTemplate,
+// failing once over maxRenderedSize or maxRenderTime.
+func executeTemplate(tmpl *template.Template, data templateData) (string, error) {
+	data.deadline = time.Now().Add(maxRenderTime)
+	var buf limitedBuffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > maxRenderedSize {
+		return 0, errors.New("rendered response exceeds 1MB")
+	}
+	return b.Buffer.Write(p)
+}
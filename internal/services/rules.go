@@ -0,0 +1,131 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"app/internal/models"
+)
+
+// matchRule returns the first rule, in order, whose conditions all hold for
+// the request.
+func matchRule(rules []models.Rule, request models.Request) (models.Rule, bool, error) {
+	if len(rules) == 0 {
+		return models.Rule{}, false, nil
+	}
+
+	headers, err := request.GetHeaders()
+	if err != nil {
+		return models.Rule{}, false, err
+	}
+
+	query := url.Values{}
+	if uri, err := url.ParseRequestURI(request.RequestUri); err == nil {
+		query = uri.Query()
+	}
+
+	var body any
+	isJSONBody := json.Unmarshal([]byte(request.Body), &body) == nil
+
+	for _, rule := range rules {
+		if rule.Method != "" && !strings.EqualFold(rule.Method, request.Method) {
+			continue
+		}
+		if !matchesPath(rule.PathGlob, request.SubPath) {
+			continue
+		}
+		if !matchesValues(rule.Headers, http.Header(headers).Values) {
+			continue
+		}
+		if !matchesValues(rule.Query, func(key string) []string { return query[key] }) {
+			continue
+		}
+		if !matchesBodyFields(rule.BodyFields, body, isJSONBody) {
+			continue
+		}
+
+		return rule, true, nil
+	}
+
+	return models.Rule{}, false, nil
+}
+
+func matchesPath(glob, subPath string) bool {
+	if glob == "" {
+		return true
+	}
+	if subPath == "" {
+		subPath = "/"
+	}
+	matched, err := path.Match(glob, subPath)
+	return err == nil && matched
+}
+
+func matchesValues(expected map[string]string, lookup func(key string) []string) bool {
+	for key, expectedValue := range expected {
+		found := false
+		for _, value := range lookup(key) {
+			if value == expectedValue {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchesBodyFields(expected map[string]string, body any, isJSONBody bool) bool {
+	if len(expected) == 0 {
+		return true
+	}
+	if !isJSONBody {
+		return false
+	}
+
+	for expr, expectedValue := range expected {
+		steps, err := parseJSONPath(expr)
+		if err != nil {
+			return false
+		}
+		value, ok := lookupJSONPath(body, steps)
+		if !ok || jsonValueString(value) != expectedValue {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validateRules(rules []models.Rule) error {
+	for i, rule := range rules {
+		if _, err := path.Match(rule.PathGlob, ""); err != nil {
+			return ValidationError(fmt.Sprintf("rule %d: invalid path glob %q", i, rule.PathGlob))
+		}
+		for expr := range rule.BodyFields {
+			if _, err := parseJSONPath(expr); err != nil {
+				return ValidationError(fmt.Sprintf("rule %d: %s", i, err))
+			}
+		}
+		if rule.Status != 0 && (rule.Status < 100 || rule.Status > 599) {
+			return ValidationError(fmt.Sprintf("rule %d: invalid status %d", i, rule.Status))
+		}
+		if _, err := parseResponseTemplate("body", rule.ResponseBody); err != nil {
+			return ValidationError(fmt.Sprintf("rule %d: %s", i, err))
+		}
+		for key, value := range rule.ResponseHeaders {
+			if _, err := parseResponseTemplate(key, value); err != nil {
+				return ValidationError(fmt.Sprintf("rule %d: %s", i, err))
+			}
+		}
+	}
+
+	return nil
+}
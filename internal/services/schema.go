@@ -0,0 +1,271 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"app/internal/models"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+const (
+	// MaxSchemaSize is the size of the largest schema or OpenAPI document
+	// of a bin.
+	MaxSchemaSize = 1 << 20
+	// maxSchemaErrors bounds the errors kept for a body failing validation.
+	maxSchemaErrors = 50
+)
+
+// Locations the schemas of bins are compiled at. References to anything
+// outside of them are refused rather than loaded.
+const (
+	schemaLocation  = "https://bin.invalid/schema.json"
+	openAPILocation = "https://bin.invalid/openapi.json"
+)
+
+// openAPIMethods are the fields of OpenAPI path items holding operations.
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// SetBinSchema replaces the schema the request bodies of a bin the caller
+// may change are validated against. The zero schema stops validating them.
+func (s *Services) SetBinSchema(ctx context.Context, caller string, binId int64, schema models.BinSchema) error {
+	if err := s.authorizeBin(ctx, caller, binId); err != nil {
+		return err
+	}
+	schema.Schema, schema.OpenAPI = nullAsEmpty(schema.Schema), nullAsEmpty(schema.OpenAPI)
+	if len(schema.Schema)+len(schema.OpenAPI) > MaxSchemaSize {
+		return ValidationError(fmt.Sprintf("schema exceeds %d bytes", MaxSchemaSize))
+	}
+	switch {
+	case len(schema.Schema) > 0 && len(schema.OpenAPI) > 0:
+		return ValidationError("schema and openapi are exclusive")
+	case len(schema.OpenAPI) > 0 && schema.Operation == "":
+		return ValidationError("openapi requires an operation")
+	case len(schema.OpenAPI) == 0 && schema.Operation != "":
+		return ValidationError("operation requires an openapi document")
+	}
+	if !schema.IsZero() {
+		if _, err := compileSchema(schema); err != nil {
+			return ValidationError(fmt.Sprintf("invalid schema: %s", err.Error()))
+		}
+	}
+
+	if err := s.db.SetBinSchema(ctx, binId, schema); err != nil {
+		return err
+	}
+	s.schemas.invalidate(binId)
+	return nil
+}
+
+func (s *Services) GetBinSchema(ctx context.Context, binId int64) (models.BinSchema, error) {
+	if err := BinIdValidation(binId); err != nil {
+		return models.BinSchema{}, err
+	}
+	return s.db.GetBinSchema(ctx, binId)
+}
+
+// nullAsEmpty drops documents set to null, as if left out.
+func nullAsEmpty(document json.RawMessage) json.RawMessage {
+	if string(bytes.TrimSpace(document)) == "null" {
+		return nil
+	}
+	return document
+}
+
+// validateBody validates the body of a request against the schema of its
+// bin, setting its outcome. Empty bodies and bins without a schema are not
+// validated, bodies which are not JSON fail.
+func (s *Services) validateBody(ctx context.Context, request *models.Request) error {
+	if request.Body == "" {
+		return nil
+	}
+	compiled, err := s.schemas.load(request.Bin, func() (*jsonschema.Schema, error) {
+		schema, err := s.db.GetBinSchema(ctx, request.Bin)
+		if err != nil || schema.IsZero() {
+			return nil, err
+		}
+		compiled, err := compileSchema(schema)
+		if err != nil {
+			// schemas are compiled when set, the request is still captured
+			slog.WarnContext(ctx, "compiling schema", "bin", request.Bin, "error", err)
+			return nil, nil
+		}
+		return compiled, nil
+	})
+	if err != nil || compiled == nil {
+		return err
+	}
+
+	request.Validation, request.ValidationErrors = validateJSON(compiled, request.Body)
+	return nil
+}
+
+// validateJSON returns whether a body is valid against a compiled schema,
+// and why not.
+func validateJSON(compiled *jsonschema.Schema, body string) (string, []models.SchemaError) {
+	value, err := jsonschema.UnmarshalJSON(strings.NewReader(body))
+	if err != nil {
+		return models.Failed, []models.SchemaError{{Message: fmt.Sprintf("body is not JSON: %s", err.Error())}}
+	}
+	err = compiled.Validate(value)
+	var validationErr *jsonschema.ValidationError
+	if errors.As(err, &validationErr) {
+		return models.Failed, schemaErrors(validationErr.DetailedOutput())
+	} else if err != nil {
+		return models.Failed, []models.SchemaError{{Message: err.Error()}}
+	}
+	return models.Passed, nil
+}
+
+// schemaErrors lists the innermost errors of a validation, those telling
+// which value failed which keyword.
+func schemaErrors(unit *jsonschema.OutputUnit) []models.SchemaError {
+	var errs []models.SchemaError
+	var walk func(unit *jsonschema.OutputUnit)
+	walk = func(unit *jsonschema.OutputUnit) {
+		if len(errs) == maxSchemaErrors {
+			return
+		}
+		if len(unit.Errors) == 0 {
+			message := ""
+			if unit.Error != nil {
+				message = unit.Error.String()
+			}
+			errs = append(errs, models.SchemaError{
+				Path:    unit.InstanceLocation,
+				Keyword: unit.KeywordLocation,
+				Message: message,
+			})
+			return
+		}
+		for i := range unit.Errors {
+			walk(&unit.Errors[i])
+		}
+	}
+	walk(unit)
+	return errs
+}
+
+// compileSchema compiles the JSON Schema of a bin, or the request body
+// schema of the operation of its OpenAPI document.
+func compileSchema(schema models.BinSchema) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.DefaultDraft(jsonschema.Draft2020)
+	// no scheme is loaded, so that schemas can not read files or reach
+	// other hosts
+	compiler.UseLoader(jsonschema.SchemeURLLoader{})
+
+	if len(schema.OpenAPI) > 0 {
+		document, err := jsonschema.UnmarshalJSON(bytes.NewReader(schema.OpenAPI))
+		if err != nil {
+			return nil, err
+		}
+		pointer, err := openAPISchemaPointer(document, schema.Operation)
+		if err != nil {
+			return nil, err
+		}
+		if err := compiler.AddResource(openAPILocation, document); err != nil {
+			return nil, err
+		}
+		return compiler.Compile(openAPILocation + "#" + pointer)
+	}
+
+	document, err := jsonschema.UnmarshalJSON(bytes.NewReader(schema.Schema))
+	if err != nil {
+		return nil, err
+	}
+	if err := compiler.AddResource(schemaLocation, document); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(schemaLocation)
+}
+
+// openAPISchemaPointer returns the JSON pointer of the schema of the JSON
+// request body of an operation of an OpenAPI document, named by its
+// operationId or its method and path, e.g. "POST /orders".
+func openAPISchemaPointer(document any, operation string) (string, error) {
+	paths, _ := lookupPointer(document, "/paths").(map[string]any)
+	if paths == nil {
+		return "", errors.New("openapi document has no paths")
+	}
+	wantMethod, wantPath, byPath := strings.Cut(operation, " ")
+
+	for _, path := range slices.Sorted(maps.Keys(paths)) {
+		item, _ := paths[path].(map[string]any)
+		for _, method := range openAPIMethods {
+			op, ok := item[method].(map[string]any)
+			if !ok {
+				continue
+			}
+			if byPath {
+				if !strings.EqualFold(wantMethod, method) || wantPath != path {
+					continue
+				}
+			} else if op["operationId"] != operation {
+				continue
+			}
+			return requestBodySchemaPointer(document, "/paths/"+escapePointer(path)+"/"+method+"/requestBody")
+		}
+	}
+	return "", fmt.Errorf("operation %q not found", operation)
+}
+
+// requestBodySchemaPointer returns the JSON pointer of the schema of the
+// JSON media type of the request body at pointer, following its reference
+// to the components of the document.
+func requestBodySchemaPointer(document any, pointer string) (string, error) {
+	body, _ := lookupPointer(document, pointer).(map[string]any)
+	if ref, ok := body["$ref"].(string); ok {
+		if !strings.HasPrefix(ref, "#/") {
+			return "", fmt.Errorf("request body reference %q is not local", ref)
+		}
+		pointer = ref[1:]
+		body, _ = lookupPointer(document, pointer).(map[string]any)
+	}
+	content, _ := body["content"].(map[string]any)
+	if content == nil {
+		return "", errors.New("operation has no request body")
+	}
+
+	if _, ok := content["application/json"]; ok {
+		return pointer + "/content/application~1json/schema", nil
+	}
+	for _, mediaType := range slices.Sorted(maps.Keys(content)) {
+		if isJSONMediaType(mediaType) {
+			return pointer + "/content/" + escapePointer(mediaType) + "/schema", nil
+		}
+	}
+	return "", errors.New("operation has no JSON request body")
+}
+
+// isJSONMediaType reports whether a media type of an OpenAPI document, which
+// may carry parameters, is JSON, e.g. application/json or
+// application/merge-patch+json.
+func isJSONMediaType(mediaType string) bool {
+	mediaType, _, _ = strings.Cut(strings.ToLower(mediaType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// lookupPointer returns the value at a JSON pointer of a document, nil when
+// there is none.
+func lookupPointer(document any, pointer string) any {
+	value := document
+	for _, token := range strings.Split(pointer, "/")[1:] {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		value = object[token]
+	}
+	return value
+}
@@ -1,57 +1,392 @@
 package services
 
 import (
+	"app/internal/metrics"
 	"app/internal/models"
+	"context"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
 )
 
 type Db interface {
-	CreateBin(bin models.Bin) (int64, error)
-	InsertRequest(request models.Request) error
-	GetBinContents(binId int64) ([]models.Request, error)
+	CreateBin(ctx context.Context, bin models.Bin) (int64, error)
+	InsertRequest(ctx context.Context, request models.Request) (int64, error)
+	InsertRequests(ctx context.Context, requests []models.Request) error
+	GetBinContents(ctx context.Context, binId int64, filter models.RequestFilter, page models.Page) ([]models.Request, error)
+	GetRequest(ctx context.Context, binId, requestId int64) (models.Request, error)
+	GetRequestsAfter(ctx context.Context, binId, afterId int64, limit int) ([]models.Request, error)
+	SetRules(ctx context.Context, binId int64, rules []models.Rule) error
+	GetRules(ctx context.Context, binId int64) ([]models.Rule, error)
+	GetBin(ctx context.Context, binId int64) (models.Bin, error)
+	DeleteRequest(ctx context.Context, binId, requestId int64) error
+	ClearBin(ctx context.Context, binId int64) error
+	DeleteBin(ctx context.Context, binId int64) error
+	AddDroppedRequests(ctx context.Context, dropped map[int64]int64) error
+	SetBinRateLimit(ctx context.Context, binId int64, limit string) error
+	SetNotificationTargets(ctx context.Context, binId int64, targets []models.NotificationTarget) error
+	GetNotificationTargets(ctx context.Context, binId int64) ([]models.NotificationTarget, error)
+	InsertFrame(ctx context.Context, frame models.Frame) (int64, error)
+	GetFrames(ctx context.Context, binId, requestId int64) ([]models.Frame, error)
+	SetWebSocketScript(ctx context.Context, binId int64, script models.WebSocketScript) error
+	GetWebSocketScript(ctx context.Context, binId int64) (models.WebSocketScript, error)
+	SetGrpcSettings(ctx context.Context, binId int64, settings models.GrpcSettings) error
+	GetGrpcSettings(ctx context.Context, binId int64) (models.GrpcSettings, error)
+	InsertCapturePort(ctx context.Context, port models.CapturePort) error
+	GetCapturePorts(ctx context.Context, binId int64) ([]models.CapturePort, error)
+	DeleteCapturePort(ctx context.Context, port models.CapturePort) error
+	SetBinSchema(ctx context.Context, binId int64, schema models.BinSchema) error
+	GetBinSchema(ctx context.Context, binId int64) (models.BinSchema, error)
+}
+
+// ValidationError is returned when a service rejects its input.
+type ValidationError string
+
+func (e ValidationError) Error() string {
+	return string(e)
 }
 
 type Services struct {
-	db Db
+	db             Db
+	trustedProxies []netip.Prefix
+	authorize      Authorize
+	metrics        *metrics.Metrics
+	limiters       *rateLimiters
+	notifier       Notifier
+	emails         bool
+	emailDomains   []string
+	subscribers    *subscribers
+	sessions       *webSocketSessions
+	listeners      Listeners
+	capturePorts   PortRange
+	// portsMu serializes allocating and releasing ports.
+	portsMu sync.Mutex
+	// schemas are the compiled schemas of bins, nil for those without one.
+	schemas *binCache[*jsonschema.Schema]
+	// grpcBins are the gRPC settings of bins.
+	grpcBins *binCache[grpcBin]
 }
 
 type Deps struct {
 	Db Db
+	// TrustedProxies are the networks of the proxies whose forwarding
+	// headers are believed when resolving the IP of a client.
+	TrustedProxies []netip.Prefix
+	// Authorize decides who may change a bin, OwnerOnly when nil.
+	Authorize Authorize
+	// Metrics the services are instrumented with, unexposed ones when nil.
+	Metrics *metrics.Metrics
+	// RateLimits of capturing requests and creating bins, none when zero.
+	RateLimits RateLimits
+	// Notifier sends the notifications of captured requests, none are
+	// sent when nil. EmailNotifications tells whether it sends emails,
+	// email targets being refused otherwise, and EmailDomains the domains
+	// of the recipients they may have, any when empty.
+	Notifier           Notifier
+	EmailNotifications bool
+	EmailDomains       []string
+	// Listeners capture the ports allocated to bins from CapturePorts, no
+	// port is allocated when nil.
+	Listeners    Listeners
+	CapturePorts PortRange
 }
 
 func New(deps *Deps) *Services {
+	authorize := deps.Authorize
+	if authorize == nil {
+		authorize = OwnerOnly
+	}
+	m := deps.Metrics
+	if m == nil {
+		m = metrics.New()
+	}
 	return &Services{
-		db: deps.Db,
+		db:             deps.Db,
+		trustedProxies: deps.TrustedProxies,
+		authorize:      authorize,
+		metrics:        m,
+		limiters:       newRateLimiters(deps.RateLimits),
+		notifier:       deps.Notifier,
+		emails:         deps.EmailNotifications,
+		emailDomains:   deps.EmailDomains,
+		subscribers:    newSubscribers(),
+		sessions:       newWebSocketSessions(),
+		listeners:      deps.Listeners,
+		capturePorts:   deps.CapturePorts,
+		schemas:        newBinCache[*jsonschema.Schema](maxCachedSchemas),
+		grpcBins:       newBinCache[grpcBin](maxCachedGrpcBins),
 	}
 }
 
-func (s *Services) CreateNewBin() (int64, error) {
-	binId, err := s.db.CreateBin(models.Bin{})
+// CreateNewBin creates a bin owned by owner, see TokenOwner, unless bins are
+// created faster than the NewBin rate limit allows. Bins without an owner
+// can be changed by anyone.
+func (s *Services) CreateNewBin(ctx context.Context, owner string) (int64, error) {
+	if ok, wait := s.limiters.newBin.Allow(""); !ok {
+		s.metrics.RequestsDropped.Inc("new_bin")
+		return 0, RateLimitError{RetryAfter: wait}
+	}
+
+	binId, err := s.db.CreateBin(ctx, models.Bin{Owner: owner})
 	if err != nil {
 		return 0, err
 	}
+	s.metrics.BinsCreated.Inc()
 
 	return binId, nil
 }
 
-func (s *Services) LogRequest(request models.Request) error {
+// LogRequest captures a request made to a bin and returns the response the
+// bin answers with, rendered from the first of its rules to match. Its body
+// is validated against the schema of the bin, if any. gRPC calls are
+// answered as the gRPC settings of the bin say instead.
+func (s *Services) LogRequest(ctx context.Context, request models.Request) (models.Response, error) {
+	start := time.Now()
+	response, err := s.logRequest(ctx, request)
+
+	status := response.Status
+	if err != nil {
+		// failed captures are answered with an internal error
+		status = http.StatusInternalServerError
+	}
+	s.metrics.RequestsCaptured.Inc(metrics.MethodLabel(request.Method), strconv.Itoa(status))
+	s.metrics.LogRequestDuration.Observe(metrics.Since(start))
+
+	return response, err
+}
+
+func (s *Services) logRequest(ctx context.Context, request models.Request) (models.Response, error) {
 	if err := BinIdValidation(request.Bin); err != nil {
+		return models.Response{}, err
+	}
+	request.ClientIp = clientIp(request, s.trustedProxies)
+
+	if protocol := grpcCallProtocol(request); protocol != "" {
+		return s.logGrpcCall(ctx, request, protocol)
+	}
+
+	rules, err := s.db.GetRules(ctx, request.Bin)
+	if err != nil {
+		return models.Response{}, err
+	}
+	rule, matched, err := matchRule(rules, request)
+	if err != nil {
+		return models.Response{}, err
+	}
+
+	response := models.Response{Status: http.StatusOK}
+	if matched {
+		request.RuleId = rule.Id
+		response, err = renderResponse(rule, request)
+		if err != nil {
+			// the request is still captured, with the error kept for the viewer
+			request.ResponseError = err.Error()
+			response = models.Response{
+				Status: http.StatusInternalServerError,
+				Body:   fmt.Sprintf("Error rendering response of rule %d: %s", rule.Id, err.Error()),
+			}
+		}
+	}
+
+	if err := s.validateBody(ctx, &request); err != nil {
+		return models.Response{}, err
+	}
+
+	request, err = s.captureRequest(ctx, request)
+	if err != nil {
+		return models.Response{}, err
+	}
+	slog.DebugContext(ctx, "request captured", "request", request, "rule", request.RuleId, "status", response.Status)
+
+	return response, nil
+}
+
+// captureRequest stores a request, returned with its id, and passes it on
+// to the streams and notification targets of its bin.
+func (s *Services) captureRequest(ctx context.Context, request models.Request) (models.Request, error) {
+	insertStart := time.Now()
+	id, err := s.db.InsertRequest(ctx, request)
+	s.metrics.InsertRequestDuration.Observe(metrics.Since(insertStart))
+	if err != nil {
+		return models.Request{}, err
+	}
+	request.Id = id
+
+	s.subscribers.publish(request)
+	if s.notifier != nil {
+		s.notify(ctx, request)
+	}
+	return request, nil
+}
+
+// captureUnanswered captures a request of a protocol bins do not answer
+// with a status, e.g. DNS queries, mails or what their ports receive,
+// unless the rate limits of its client or bin refuse it.
+func (s *Services) captureUnanswered(ctx context.Context, request models.Request) error {
+	if err := s.AdmitRequest(ctx, request); err != nil {
+		return err
+	}
+	request.ClientIp = clientIp(request, s.trustedProxies)
+
+	captured, err := s.captureRequest(ctx, request)
+	if err != nil {
+		slog.ErrorContext(ctx, "capturing request", "method", request.Method, "error", err)
+		return err
+	}
+	s.metrics.RequestsCaptured.Inc(metrics.MethodLabel(captured.Method), "none")
+	slog.DebugContext(ctx, "request captured", "request", captured)
+	return nil
+}
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// GetRequestsInBin returns a page of the requests of a bin matching the
+// filter, newest first. A page holding fewer requests than its limit is the
+// last one.
+func (s *Services) GetRequestsInBin(ctx context.Context, binId int64, filter models.RequestFilter, page models.Page) ([]models.Request, error) {
+	if err := BinIdValidation(binId); err != nil {
+		return nil, err
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.From.After(filter.To) {
+		return nil, ValidationError("invalid time range: from is after to")
+	}
+
+	if page.Limit <= 0 {
+		page.Limit = DefaultPageSize
+	}
+	page.Limit = min(page.Limit, MaxPageSize)
+
+	return s.db.GetBinContents(ctx, binId, filter, page)
+}
+
+func (s *Services) GetBin(ctx context.Context, binId int64) (models.Bin, error) {
+	if err := BinIdValidation(binId); err != nil {
+		return models.Bin{}, err
+	}
+
+	return s.db.GetBin(ctx, binId)
+}
+
+func (s *Services) GetRequest(ctx context.Context, binId, requestId int64) (models.Request, error) {
+	if err := BinIdValidation(binId); err != nil {
+		return models.Request{}, err
+	}
+	if requestId <= 0 {
+		return models.Request{}, ValidationError(fmt.Sprintf("invalid request id: %d", requestId))
+	}
+
+	return s.db.GetRequest(ctx, binId, requestId)
+}
+
+// MaxImportedRequests bounds the requests imported at once.
+const MaxImportedRequests = 1000
+
+// ImportRequests adds requests exported from a bin to a bin the caller may
+// change. They are given new ids and keep the time they were received at,
+// but no rule, as the rules of the bin did not answer them. Importing stops
+// at the first request failing to be stored.
+func (s *Services) ImportRequests(ctx context.Context, caller string, binId int64, requests []models.Request) error {
+	if len(requests) > MaxImportedRequests {
+		return ValidationError(fmt.Sprintf("at most %d requests are imported at once", MaxImportedRequests))
+	}
+	for i, request := range requests {
+		if request.RecievedAt.IsZero() {
+			return ValidationError(fmt.Sprintf("request %d: missing receivedAt", i))
+		}
+	}
+	if err := s.authorizeBin(ctx, caller, binId); err != nil {
+		return err
+	}
+
+	for i := range requests {
+		requests[i].Id = 0
+		requests[i].Bin = binId
+		requests[i].RuleId = 0
+	}
+	return s.db.InsertRequests(ctx, requests)
+}
+
+// DeleteRequest deletes a request of a bin the caller may change.
+func (s *Services) DeleteRequest(ctx context.Context, caller string, binId, requestId int64) error {
+	if requestId <= 0 {
+		return ValidationError(fmt.Sprintf("invalid request id: %d", requestId))
+	}
+	if err := s.authorizeBin(ctx, caller, binId); err != nil {
+		return err
+	}
+
+	return s.db.DeleteRequest(ctx, binId, requestId)
+}
+
+// ClearBin deletes all requests of a bin the caller may change.
+func (s *Services) ClearBin(ctx context.Context, caller string, binId int64) error {
+	if err := s.authorizeBin(ctx, caller, binId); err != nil {
+		return err
+	}
+
+	return s.db.ClearBin(ctx, binId)
+}
+
+// DeleteBin deletes a bin the caller may change, with its requests and
+// rules, and stops capturing its ports.
+func (s *Services) DeleteBin(ctx context.Context, caller string, binId int64) error {
+	if err := s.authorizeBin(ctx, caller, binId); err != nil {
+		return err
+	}
+
+	s.portsMu.Lock()
+	defer s.portsMu.Unlock()
+	ports, err := s.db.GetCapturePorts(ctx, binId)
+	if err != nil {
+		return err
+	}
+	if err := s.db.DeleteBin(ctx, binId); err != nil {
 		return err
 	}
+	s.schemas.invalidate(binId)
+	s.grpcBins.invalidate(binId)
+	s.limiters.bins.invalidate(binId)
+	s.releaseBinPorts(ports)
+	return nil
+}
+
+// SetRules replaces the response rules of a bin the caller may change.
+func (s *Services) SetRules(ctx context.Context, caller string, binId int64, rules []models.Rule) error {
+	if err := s.authorizeBin(ctx, caller, binId); err != nil {
+		return err
+	}
+	if err := validateRules(rules); err != nil {
+		return err
+	}
+
+	for i := range rules {
+		if rules[i].Status == 0 {
+			rules[i].Status = http.StatusOK
+		}
+	}
 
-	return s.db.InsertRequest(request)
+	return s.db.SetRules(ctx, binId, rules)
 }
 
-func (s *Services) GetRequestsInBin(binId int64) ([]models.Request, error) {
+func (s *Services) GetRules(ctx context.Context, binId int64) ([]models.Rule, error) {
 	if err := BinIdValidation(binId); err != nil {
 		return nil, err
 	}
-	return s.db.GetBinContents(binId)
+	return s.db.GetRules(ctx, binId)
 }
 
 func BinIdValidation(binId int64) error {
 	if binId <= 0 {
-		return fmt.Errorf("invalid bin id: %d", binId)
+		return ValidationError(fmt.Sprintf("invalid bin id: %d", binId))
 	}
 
 	return nil
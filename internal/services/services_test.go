@@ -1,13 +1,30 @@
 package services
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	fake "app/internal/db/test"
+	"app/internal/metrics"
 	"app/internal/models"
+	"app/internal/notify"
+	"app/internal/ratelimit"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 func generateRequest() models.Request {
@@ -28,6 +45,7 @@ func Test_CreateNewBin(t *testing.T) {
 		generatedBinId := int64(10000)
 		db := fake.Db{
 			CreateBinFake: func(bin models.Bin) (int64, error) {
+				assert.Equal(t, TokenOwner("token"), bin.Owner)
 				return generatedBinId, nil
 			},
 		}
@@ -36,7 +54,7 @@ func Test_CreateNewBin(t *testing.T) {
 			Db: &db,
 		})
 
-		binId, err := services.CreateNewBin()
+		binId, err := services.CreateNewBin(context.Background(), TokenOwner("token"))
 		assert.NoError(t, err)
 		assert.Equal(t, generatedBinId, binId)
 		db.VerifyCallCounts(t, &fake.Db{
@@ -53,12 +71,312 @@ func Test_CreateNewBin(t *testing.T) {
 			Db: &db,
 		})
 
-		_, err := services.CreateNewBin()
+		_, err := services.CreateNewBin(context.Background(), "")
 		assert.Error(t, err)
 		db.VerifyCallCounts(t, &fake.Db{
 			CountOfCreateBin: 1,
 		})
 	})
+	t.Run("bin creation is rate limited", func(t *testing.T) {
+		db := fake.Db{
+			CreateBinFake: func(bin models.Bin) (int64, error) {
+				return 1, nil
+			},
+		}
+		services := New(&Deps{
+			Db:         &db,
+			RateLimits: RateLimits{NewBin: ratelimit.Limit{Events: 2, Per: time.Hour}},
+		})
+
+		for range 2 {
+			_, err := services.CreateNewBin(context.Background(), "")
+			assert.NoError(t, err)
+		}
+		_, err := services.CreateNewBin(context.Background(), "")
+		var rateLimitErr RateLimitError
+		assert.ErrorAs(t, err, &rateLimitErr)
+		assert.InDelta(t, 30*time.Minute, rateLimitErr.RetryAfter, float64(time.Second))
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfCreateBin: 2,
+		})
+	})
+}
+
+func Test_AdmitRequest(t *testing.T) {
+	requestFrom := func(binId int64, remoteAddr string) models.Request {
+		request := generateRequest()
+		request.Bin = binId
+		request.RemoteAddr = remoteAddr
+		return request
+	}
+
+	t.Run("requests over the limits are dropped and counted", func(t *testing.T) {
+		var recorded map[int64]int64
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId}, nil
+			},
+			AddDroppedRequestsFake: func(dropped map[int64]int64) error {
+				recorded = dropped
+				return nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+			RateLimits: RateLimits{
+				Bin:    ratelimit.Limit{Events: 2, Per: time.Minute},
+				Client: ratelimit.Limit{Events: 2, Per: time.Minute},
+			},
+		})
+		ctx := context.Background()
+
+		// the bin limit is reached by two clients
+		assert.NoError(t, services.AdmitRequest(ctx, requestFrom(1, "192.0.2.1:1000")))
+		assert.NoError(t, services.AdmitRequest(ctx, requestFrom(1, "192.0.2.2:1000")))
+		assert.ErrorAs(t, services.AdmitRequest(ctx, requestFrom(1, "192.0.2.2:1000")), &RateLimitError{})
+
+		// the client limit across bins
+		assert.NoError(t, services.AdmitRequest(ctx, requestFrom(2, "192.0.2.1:1000")))
+		assert.ErrorAs(t, services.AdmitRequest(ctx, requestFrom(3, "192.0.2.1:1000")), &RateLimitError{})
+
+		services.flushDropped(ctx)
+		assert.Equal(t, map[int64]int64{1: 1, 3: 1}, recorded)
+		recorder := httptest.NewRecorder()
+		services.metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		assert.Contains(t, recorder.Body.String(), `httpbin_requests_dropped_total{limit="bin"} 1`)
+		assert.Contains(t, recorder.Body.String(), `httpbin_requests_dropped_total{limit="client"} 1`)
+
+		// nothing is written without drops
+		services.flushDropped(ctx)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin:             2,
+			CountOfAddDroppedRequests: 1,
+		})
+	})
+
+	t.Run("counts failing to be recorded are kept", func(t *testing.T) {
+		var calls []map[int64]int64
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{}, models.ErrNotFound
+			},
+			AddDroppedRequestsFake: func(dropped map[int64]int64) error {
+				calls = append(calls, dropped)
+				if len(calls) == 1 {
+					return assert.AnError
+				}
+				return nil
+			},
+		}
+		services := New(&Deps{
+			Db:         &db,
+			RateLimits: RateLimits{Bin: ratelimit.Limit{Events: 1, Per: time.Minute}},
+		})
+		ctx := context.Background()
+
+		services.AdmitRequest(ctx, requestFrom(1, "192.0.2.1:1000"))
+		services.AdmitRequest(ctx, requestFrom(1, "192.0.2.1:1000"))
+		services.flushDropped(ctx)
+		services.AdmitRequest(ctx, requestFrom(1, "192.0.2.1:1000"))
+		services.flushDropped(ctx)
+
+		assert.Equal(t, []map[int64]int64{{1: 1}, {1: 2}}, calls)
+	})
+
+	t.Run("bins with a limit of their own", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				if binId == 1 {
+					return models.Bin{BinId: binId, RateLimit: "1/1m0s"}, nil
+				}
+				return models.Bin{BinId: binId}, nil
+			},
+		}
+		services := New(&Deps{
+			Db:         &db,
+			RateLimits: RateLimits{Bin: ratelimit.Limit{Events: 2, Per: time.Minute}},
+		})
+		ctx := context.Background()
+
+		assert.NoError(t, services.AdmitRequest(ctx, requestFrom(1, "192.0.2.1:1000")))
+		assert.ErrorAs(t, services.AdmitRequest(ctx, requestFrom(1, "192.0.2.1:1000")), &RateLimitError{})
+		assert.NoError(t, services.AdmitRequest(ctx, requestFrom(2, "192.0.2.1:1000")))
+		assert.NoError(t, services.AdmitRequest(ctx, requestFrom(2, "192.0.2.1:1000")))
+		assert.ErrorAs(t, services.AdmitRequest(ctx, requestFrom(2, "192.0.2.1:1000")), &RateLimitError{})
+
+		// the limits are read once per bin
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin: 2,
+		})
+	})
+
+	t.Run("bin failing to be read", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{}, assert.AnError
+			},
+		}
+		services := New(&Deps{Db: &db})
+
+		err := services.AdmitRequest(context.Background(), requestFrom(1, "192.0.2.1:1000"))
+		assert.ErrorIs(t, err, assert.AnError)
+	})
+
+	t.Run("invalid bin id", func(t *testing.T) {
+		services := New(&Deps{Db: &fake.Db{}})
+		err := services.AdmitRequest(context.Background(), requestFrom(0, "192.0.2.1:1000"))
+		assert.ErrorAs(t, err, new(ValidationError))
+	})
+}
+
+func Test_renderTemplate(t *testing.T) {
+	request := generateRequest()
+	request.Body = `{"items": ["a", "b"]}`
+	data, err := newTemplateData(request)
+	assert.NoError(t, err)
+
+	t.Run("ranges", func(t *testing.T) {
+		rendered, err := renderTemplate("body", `{{range $i, $item := .Json.items}}{{if $i}},{{end}}{{$item}}{{else}}none{{end}}`, data)
+		assert.NoError(t, err)
+		assert.Equal(t, "a,b", rendered)
+	})
+
+	t.Run("runaway ranges", func(t *testing.T) {
+		start := time.Now()
+		_, err := renderTemplate("body", `{{range 1000000000}}{{range 1000000000}}{{end}}{{end}}`, data)
+		assert.ErrorContains(t, err, "rendering exceeds")
+		assert.Less(t, time.Since(start), maxRenderTime+time.Second)
+	})
+
+	t.Run("calling templates", func(t *testing.T) {
+		_, err := parseResponseTemplate("body", `{{define "a"}}{{template "a"}}{{template "a"}}{{end}}{{template "a"}}`)
+		assert.ErrorContains(t, err, "calling template")
+		_, err = parseResponseTemplate("body", `{{block "a" .}}{{end}}`)
+		assert.ErrorContains(t, err, "calling template")
+	})
+
+	t.Run("uuid", func(t *testing.T) {
+		rendered, err := renderTemplate("body", `{{uuid}}`, data)
+		assert.NoError(t, err)
+		assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, rendered)
+	})
+}
+
+func Test_SetBinRateLimit(t *testing.T) {
+	limits := RateLimits{Bin: ratelimit.Limit{Events: 100, Per: time.Second}}
+
+	t.Run("happy path", func(t *testing.T) {
+		for limit, stored := range map[string]string{
+			"10/1s":  "10/1s",
+			"60/1m":  "60/1m0s",
+			"100/1s": "100/1s",
+			"":       "",
+		} {
+			t.Run(limit, func(t *testing.T) {
+				db := fake.Db{
+					GetBinFake: func(binId int64) (models.Bin, error) {
+						return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+					},
+					SetBinRateLimitFake: func(binId int64, got string) error {
+						assert.Equal(t, int64(1), binId)
+						assert.Equal(t, stored, got)
+						return nil
+					},
+				}
+				services := New(&Deps{
+					Db:         &db,
+					RateLimits: limits,
+				})
+
+				err := services.SetBinRateLimit(context.Background(), TokenOwner("token"), 1, limit)
+				assert.NoError(t, err)
+				db.VerifyCallCounts(t, &fake.Db{
+					CountOfGetBin:          1,
+					CountOfSetBinRateLimit: 1,
+				})
+			})
+		}
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		for _, limit := range []string{"fast", "0/1s", "10/0s", "off", "101/1s", "10/50ms"} {
+			t.Run(limit, func(t *testing.T) {
+				db := fake.Db{
+					GetBinFake: func(binId int64) (models.Bin, error) {
+						return models.Bin{BinId: binId}, nil
+					},
+				}
+				services := New(&Deps{
+					Db:         &db,
+					RateLimits: limits,
+				})
+
+				err := services.SetBinRateLimit(context.Background(), "", 1, limit)
+				assert.ErrorAs(t, err, new(ValidationError))
+				db.VerifyCallCounts(t, &fake.Db{
+					CountOfGetBin: 1,
+				})
+			})
+		}
+	})
+
+	t.Run("any limit without a default one", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId}, nil
+			},
+			SetBinRateLimitFake: func(binId int64, limit string) error {
+				return nil
+			},
+		}
+		services := New(&Deps{Db: &db})
+
+		err := services.SetBinRateLimit(context.Background(), "", 1, "10000/1s")
+		assert.NoError(t, err)
+	})
+
+	t.Run("bin of another owner", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+			},
+		}
+		services := New(&Deps{
+			Db:         &db,
+			RateLimits: limits,
+		})
+
+		err := services.SetBinRateLimit(context.Background(), TokenOwner("other"), 1, "10/1s")
+		assert.ErrorIs(t, err, ErrForbidden)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin: 1,
+		})
+	})
+
+	t.Run("new limit applies to the next requests", func(t *testing.T) {
+		bin := models.Bin{BinId: 1}
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return bin, nil
+			},
+			SetBinRateLimitFake: func(binId int64, limit string) error {
+				bin.RateLimit = limit
+				return nil
+			},
+		}
+		services := New(&Deps{
+			Db:         &db,
+			RateLimits: limits,
+		})
+		ctx := context.Background()
+		request := generateRequest()
+		request.Bin = 1
+
+		assert.NoError(t, services.AdmitRequest(ctx, request))
+		assert.NoError(t, services.SetBinRateLimit(ctx, "", 1, "1/1m"))
+		assert.NoError(t, services.AdmitRequest(ctx, request))
+		assert.ErrorAs(t, services.AdmitRequest(ctx, request), &RateLimitError{})
+	})
 }
 
 func Test_LogRequest(t *testing.T) {
@@ -66,23 +384,159 @@ func Test_LogRequest(t *testing.T) {
 		request := generateRequest()
 
 		db := fake.Db{
-			InsertRequestFake: func(requestParams models.Request) error {
+			GetBinSchemaFake: func(binId int64) (models.BinSchema, error) {
+				return models.BinSchema{}, nil
+			},
+			GetRulesFake: func(binId int64) ([]models.Rule, error) {
+				return nil, nil
+			},
+			InsertRequestFake: func(requestParams models.Request) (int64, error) {
 				assert.Equal(t, request.Bin, requestParams.Bin)
-				return nil
+				assert.Equal(t, int64(0), requestParams.RuleId)
+				return 1, nil
+			},
+		}
+
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		response, err := services.LogRequest(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Status)
+
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetRules:      1,
+			CountOfGetBinSchema:  1,
+			CountOfInsertRequest: 1,
+		})
+	})
+	t.Run("first matching rule responds", func(t *testing.T) {
+		request := generateRequest()
+		request.Method = "POST"
+		request.SubPath = "/events/order.created"
+		request.RequestUri = "/bin/1/events/order.created?source=shop"
+		request.Body = `{"data": {"items": [{"id": 42}]}}`
+		_ = request.SetHeaders(map[string][]string{"X-Event": {"order"}})
+
+		rules := []models.Rule{
+			{Id: 1, Method: "GET", Status: http.StatusTeapot},
+			{Id: 2, PathGlob: "/events/*", BodyFields: map[string]string{"$.data.items[0].id": "7"}, Status: http.StatusConflict},
+			{
+				Id:              3,
+				Method:          "post",
+				PathGlob:        "/events/*",
+				Headers:         map[string]string{"x-event": "order"},
+				Query:           map[string]string{"source": "shop"},
+				BodyFields:      map[string]string{"$.data.items[0].id": "42"},
+				Status:          http.StatusCreated,
+				ResponseHeaders: map[string]string{"Content-Type": "application/json"},
+				ResponseBody:    `{"ok": true}`,
+			},
+			{Id: 4, Status: http.StatusAccepted},
+		}
+		db := fake.Db{
+			GetBinSchemaFake: func(binId int64) (models.BinSchema, error) {
+				return models.BinSchema{}, nil
+			},
+			GetRulesFake: func(binId int64) ([]models.Rule, error) {
+				assert.Equal(t, request.Bin, binId)
+				return rules, nil
+			},
+			InsertRequestFake: func(requestParams models.Request) (int64, error) {
+				assert.Equal(t, int64(3), requestParams.RuleId)
+				return 1, nil
 			},
 		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		response, err := services.LogRequest(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Response{
+			Status:  http.StatusCreated,
+			Headers: map[string]string{"Content-Type": "application/json"},
+			Body:    `{"ok": true}`,
+		}, response)
+	})
+	t.Run("response is rendered from the request", func(t *testing.T) {
+		request := generateRequest()
+		request.RequestUri = "/bin/1?page=3"
+		request.Body = `{"order": {"id": "ord_1"}}`
+		_ = request.SetHeaders(map[string][]string{"X-Request-Id": {"req_1"}})
 
+		db := fake.Db{
+			GetBinSchemaFake: func(binId int64) (models.BinSchema, error) {
+				return models.BinSchema{}, nil
+			},
+			GetRulesFake: func(binId int64) ([]models.Rule, error) {
+				return []models.Rule{{
+					Id:              1,
+					Status:          http.StatusOK,
+					ResponseHeaders: map[string]string{"X-Echo": `{{.Headers.Get "X-Request-Id"}}`},
+					ResponseBody:    `{{.Method}} {{.Json.order.id}} {{.Query.Get "page"}} {{random 5 6}} {{len uuid}} {{json .Json.order}}`,
+				}}, nil
+			},
+			InsertRequestFake: func(request models.Request) (int64, error) {
+				assert.Empty(t, request.ResponseError)
+				return 1, nil
+			},
+		}
 		services := New(&Deps{
 			Db: &db,
 		})
 
-		err := services.LogRequest(request)
+		response, err := services.LogRequest(context.Background(), request)
 		assert.NoError(t, err)
+		assert.Equal(t, `method ord_1 3 5 36 {"id":"ord_1"}`, response.Body)
+		assert.Equal(t, map[string]string{"X-Echo": "req_1"}, response.Headers)
+	})
+	t.Run("template error is captured with the request", func(t *testing.T) {
+		db := fake.Db{
+			GetBinSchemaFake: func(binId int64) (models.BinSchema, error) {
+				return models.BinSchema{}, nil
+			},
+			GetRulesFake: func(binId int64) ([]models.Rule, error) {
+				return []models.Rule{{Id: 1, Status: http.StatusOK, ResponseBody: `{{random 6 5}}`}}, nil
+			},
+			InsertRequestFake: func(request models.Request) (int64, error) {
+				assert.Contains(t, request.ResponseError, "max 5 must be greater than min 6")
+				return 1, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
 
+		response, err := services.LogRequest(context.Background(), generateRequest())
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, response.Status)
 		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetRules:      1,
+			CountOfGetBinSchema:  1,
 			CountOfInsertRequest: 1,
 		})
 	})
+	t.Run("error getting rules", func(t *testing.T) {
+		db := fake.Db{
+			GetBinSchemaFake: func(binId int64) (models.BinSchema, error) {
+				return models.BinSchema{}, nil
+			},
+			GetRulesFake: func(binId int64) ([]models.Rule, error) {
+				return nil, assert.AnError
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		_, err := services.LogRequest(context.Background(), generateRequest())
+		assert.Error(t, err)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetRules: 1,
+		})
+	})
 	t.Run("invalid bin request", func(t *testing.T) {
 		db := fake.Db{}
 		services := New(&Deps{
@@ -92,13 +546,19 @@ func Test_LogRequest(t *testing.T) {
 		request := generateRequest()
 		request.Bin = 0
 
-		err := services.LogRequest(request)
+		_, err := services.LogRequest(context.Background(), request)
 		assert.Error(t, err)
 	})
 	t.Run("error inserting request", func(t *testing.T) {
 		db := fake.Db{
-			InsertRequestFake: func(request models.Request) error {
-				return assert.AnError
+			GetBinSchemaFake: func(binId int64) (models.BinSchema, error) {
+				return models.BinSchema{}, nil
+			},
+			GetRulesFake: func(binId int64) ([]models.Rule, error) {
+				return nil, nil
+			},
+			InsertRequestFake: func(request models.Request) (int64, error) {
+				return 0, assert.AnError
 			},
 		}
 		services := New(&Deps{
@@ -107,12 +567,123 @@ func Test_LogRequest(t *testing.T) {
 
 		request := generateRequest()
 
-		err := services.LogRequest(request)
+		_, err := services.LogRequest(context.Background(), request)
 		assert.Error(t, err)
 		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetRules:      1,
+			CountOfGetBinSchema:  1,
 			CountOfInsertRequest: 1,
 		})
 	})
+	t.Run("captures are counted", func(t *testing.T) {
+		db := fake.Db{
+			GetBinSchemaFake: func(binId int64) (models.BinSchema, error) {
+				return models.BinSchema{}, nil
+			},
+			GetRulesFake: func(binId int64) ([]models.Rule, error) {
+				return []models.Rule{{Id: 1, Method: "PUT", Status: http.StatusAccepted}}, nil
+			},
+			InsertRequestFake: func(request models.Request) (int64, error) {
+				return 1, nil
+			},
+		}
+		m := metrics.New()
+		services := New(&Deps{
+			Db:      &db,
+			Metrics: m,
+		})
+
+		for _, method := range []string{"PUT", "POST", "BREW"} {
+			request := generateRequest()
+			request.Method = method
+			_, err := services.LogRequest(context.Background(), request)
+			assert.NoError(t, err)
+		}
+
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		assert.Contains(t, recorder.Body.String(), `httpbin_requests_captured_total{method="PUT",status="202"} 1`)
+		assert.Contains(t, recorder.Body.String(), `httpbin_requests_captured_total{method="POST",status="200"} 1`)
+		assert.Contains(t, recorder.Body.String(), `httpbin_requests_captured_total{method="OTHER",status="200"} 1`)
+		assert.Contains(t, recorder.Body.String(), "httpbin_log_request_duration_seconds_count 3")
+		assert.Contains(t, recorder.Body.String(), "httpbin_db_insert_request_duration_seconds_count 3")
+	})
+	t.Run("client ip is resolved through trusted proxies", func(t *testing.T) {
+		trustedProxies := []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("2001:db8::/32"),
+		}
+		cases := map[string]struct {
+			remoteAddr string
+			headers    map[string][]string
+			expected   string
+		}{
+			"direct client": {
+				remoteAddr: "203.0.113.7:5000",
+				headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+				expected:   "203.0.113.7",
+			},
+			"behind trusted proxies": {
+				remoteAddr: "10.0.0.1:5000",
+				headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7", "10.0.0.2"}},
+				expected:   "203.0.113.7",
+			},
+			"only trusted proxies": {
+				remoteAddr: "10.0.0.1:5000",
+				headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+				expected:   "10.0.0.3",
+			},
+			"no forwarding header": {
+				remoteAddr: "10.0.0.1:5000",
+				expected:   "10.0.0.1",
+			},
+			"forwarded takes precedence": {
+				remoteAddr: "[2001:db8::1]:443",
+				headers: map[string][]string{
+					"Forwarded":       {`for=198.51.100.1;proto=https, for="[2001:db8::2]:4711"`},
+					"X-Forwarded-For": {"203.0.113.7"},
+				},
+				expected: "198.51.100.1",
+			},
+			"obfuscated identifier": {
+				remoteAddr: "10.0.0.1:5000",
+				headers:    map[string][]string{"Forwarded": {"for=_hidden"}},
+				expected:   "_hidden",
+			},
+		}
+		for name, c := range cases {
+			t.Run(name, func(t *testing.T) {
+				request := generateRequest()
+				request.RemoteAddr = c.remoteAddr
+				_ = request.SetHeaders(c.headers)
+
+				db := fake.Db{
+					GetBinSchemaFake: func(binId int64) (models.BinSchema, error) {
+						return models.BinSchema{}, nil
+					},
+					GetRulesFake: func(binId int64) ([]models.Rule, error) {
+						return nil, nil
+					},
+					InsertRequestFake: func(requestParams models.Request) (int64, error) {
+						assert.Equal(t, c.expected, requestParams.ClientIp)
+						return 1, nil
+					},
+				}
+				services := New(&Deps{
+					Db:             &db,
+					TrustedProxies: trustedProxies,
+				})
+
+				_, err := services.LogRequest(context.Background(), request)
+				assert.NoError(t, err)
+				db.VerifyCallCounts(t, &fake.Db{
+					CountOfGetRules:      1,
+					CountOfGetBinSchema:  1,
+					CountOfInsertRequest: 1,
+				})
+			})
+		}
+	})
 }
 
 func Test_GetRequestsInBin(t *testing.T) {
@@ -120,7 +691,7 @@ func Test_GetRequestsInBin(t *testing.T) {
 		id := int64(1)
 
 		db := fake.Db{
-			GetBinContentsFake: func(binId int64) ([]models.Request, error) {
+			GetBinContentsFake: func(binId int64, filter models.RequestFilter, page models.Page) ([]models.Request, error) {
 				assert.Equal(t, id, binId)
 				loggedRequest := generateRequest()
 				return []models.Request{loggedRequest}, nil
@@ -131,7 +702,7 @@ func Test_GetRequestsInBin(t *testing.T) {
 			Db: &db,
 		})
 
-		requests, err := services.GetRequestsInBin(id)
+		requests, err := services.GetRequestsInBin(context.Background(), id, models.RequestFilter{}, models.Page{})
 		assert.NoError(t, err)
 		assert.Len(t, requests, 1)
 		db.VerifyCallCounts(t, &fake.Db{
@@ -144,15 +715,62 @@ func Test_GetRequestsInBin(t *testing.T) {
 			Db: &db,
 		})
 
-		requests, err := services.GetRequestsInBin(0)
+		requests, err := services.GetRequestsInBin(context.Background(), 0, models.RequestFilter{}, models.Page{})
 		assert.Nil(t, requests)
 		assert.Error(t, err)
 	})
+	t.Run("filter is passed to db", func(t *testing.T) {
+		filter := models.RequestFilter{Method: "POST", Body: "order"}
+		db := fake.Db{
+			GetBinContentsFake: func(binId int64, filterParam models.RequestFilter, page models.Page) ([]models.Request, error) {
+				assert.Equal(t, filter, filterParam)
+				return nil, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		_, err := services.GetRequestsInBin(context.Background(), 1, filter, models.Page{})
+		assert.NoError(t, err)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBinContents: 1,
+		})
+	})
+	t.Run("page size is bounded", func(t *testing.T) {
+		for requested, expected := range map[int]int{0: DefaultPageSize, 10: 10, 1000: MaxPageSize} {
+			cursor := models.Cursor{RecievedAt: time.Now(), Id: 3}
+			db := fake.Db{
+				GetBinContentsFake: func(binId int64, filter models.RequestFilter, page models.Page) ([]models.Request, error) {
+					assert.Equal(t, models.Page{Before: cursor, Limit: expected}, page)
+					return nil, nil
+				},
+			}
+			services := New(&Deps{
+				Db: &db,
+			})
+
+			_, err := services.GetRequestsInBin(context.Background(), 1, models.RequestFilter{}, models.Page{Before: cursor, Limit: requested})
+			assert.NoError(t, err)
+		}
+	})
+	t.Run("invalid time range", func(t *testing.T) {
+		db := fake.Db{}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		now := time.Now()
+		requests, err := services.GetRequestsInBin(context.Background(), 1, models.RequestFilter{From: now, To: now.Add(-time.Hour)}, models.Page{})
+		assert.Nil(t, requests)
+		assert.ErrorAs(t, err, new(ValidationError))
+		db.VerifyCallCounts(t, &fake.Db{})
+	})
 	t.Run("error getting requests", func(t *testing.T) {
 		id := int64(1)
 
 		db := fake.Db{
-			GetBinContentsFake: func(binId int64) ([]models.Request, error) {
+			GetBinContentsFake: func(binId int64, filter models.RequestFilter, page models.Page) ([]models.Request, error) {
 				assert.Equal(t, id, binId)
 				return nil, assert.AnError
 			},
@@ -161,8 +779,2193 @@ func Test_GetRequestsInBin(t *testing.T) {
 			Db: &db,
 		})
 
-		requests, err := services.GetRequestsInBin(id)
+		requests, err := services.GetRequestsInBin(context.Background(), id, models.RequestFilter{}, models.Page{})
 		assert.Nil(t, requests)
 		assert.Error(t, err)
 	})
 }
+
+func Test_GetRequest(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		db := fake.Db{
+			GetRequestFake: func(binId, requestId int64) (models.Request, error) {
+				assert.Equal(t, int64(1), binId)
+				assert.Equal(t, int64(7), requestId)
+				request := generateRequest()
+				request.Id = requestId
+				return request, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		request, err := services.GetRequest(context.Background(), 1, 7)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(7), request.Id)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetRequest: 1,
+		})
+	})
+	t.Run("invalid ids", func(t *testing.T) {
+		for name, ids := range map[string][2]int64{
+			"bin":     {0, 1},
+			"request": {1, 0},
+		} {
+			t.Run(name, func(t *testing.T) {
+				db := fake.Db{}
+				services := New(&Deps{
+					Db: &db,
+				})
+
+				_, err := services.GetRequest(context.Background(), ids[0], ids[1])
+				var validationErr ValidationError
+				assert.ErrorAs(t, err, &validationErr)
+				db.VerifyCallCounts(t, &fake.Db{})
+			})
+		}
+	})
+	t.Run("error getting request", func(t *testing.T) {
+		db := fake.Db{
+			GetRequestFake: func(binId, requestId int64) (models.Request, error) {
+				return models.Request{}, assert.AnError
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		_, err := services.GetRequest(context.Background(), 1, 1)
+		assert.ErrorIs(t, err, assert.AnError)
+	})
+}
+
+func Test_DeleteRequest(t *testing.T) {
+	owner := TokenOwner("token")
+	cases := map[string]struct {
+		binOwner    string
+		caller      string
+		expectedErr error
+		deletes     int
+	}{
+		"bin without owner":  {binOwner: "", caller: "", deletes: 1},
+		"owner":              {binOwner: owner, caller: owner, deletes: 1},
+		"someone else":       {binOwner: owner, caller: TokenOwner("other"), expectedErr: ErrForbidden},
+		"caller without key": {binOwner: owner, caller: "", expectedErr: ErrForbidden},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			db := fake.Db{
+				GetBinFake: func(binId int64) (models.Bin, error) {
+					return models.Bin{BinId: binId, Owner: c.binOwner}, nil
+				},
+				DeleteRequestFake: func(binId, requestId int64) error {
+					assert.Equal(t, int64(1), binId)
+					assert.Equal(t, int64(5), requestId)
+					return nil
+				},
+			}
+			services := New(&Deps{
+				Db: &db,
+			})
+
+			err := services.DeleteRequest(context.Background(), c.caller, 1, 5)
+			assert.ErrorIs(t, err, c.expectedErr)
+			db.VerifyCallCounts(t, &fake.Db{
+				CountOfGetBin:        1,
+				CountOfDeleteRequest: c.deletes,
+			})
+		})
+	}
+
+	t.Run("invalid request id", func(t *testing.T) {
+		db := fake.Db{}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.DeleteRequest(context.Background(), "", 1, 0)
+		var validationErr ValidationError
+		assert.ErrorAs(t, err, &validationErr)
+		db.VerifyCallCounts(t, &fake.Db{})
+	})
+}
+
+func Test_ClearBin(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId}, nil
+			},
+			ClearBinFake: func(binId int64) error {
+				assert.Equal(t, int64(1), binId)
+				return nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.ClearBin(context.Background(), "", 1)
+		assert.NoError(t, err)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin:   1,
+			CountOfClearBin: 1,
+		})
+	})
+	t.Run("authorization hook", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId}, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+			Authorize: func(caller string, bin models.Bin) error {
+				assert.Equal(t, "caller", caller)
+				return ErrForbidden
+			},
+		})
+
+		err := services.ClearBin(context.Background(), "caller", 1)
+		assert.ErrorIs(t, err, ErrForbidden)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin: 1,
+		})
+	})
+}
+
+func Test_DeleteBin(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+			},
+			GetCapturePortsFake: func(binId int64) ([]models.CapturePort, error) {
+				return []models.CapturePort{{Protocol: models.UDP, Port: 40000, Bin: binId}}, nil
+			},
+			DeleteBinFake: func(binId int64) error {
+				assert.Equal(t, int64(1), binId)
+				return nil
+			},
+		}
+		listeners := newFakeListeners()
+		services := New(&Deps{
+			Db:        &db,
+			Listeners: listeners,
+		})
+
+		err := services.DeleteBin(context.Background(), TokenOwner("token"), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"udp/40000"}, listeners.released)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin:          1,
+			CountOfGetCapturePorts: 1,
+			CountOfDeleteBin:       1,
+		})
+	})
+	t.Run("missing bin", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{}, models.ErrNotFound
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.DeleteBin(context.Background(), "", 1)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin: 1,
+		})
+	})
+}
+
+func Test_SetRules(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+			},
+			SetRulesFake: func(binId int64, rules []models.Rule) error {
+				assert.Equal(t, int64(1), binId)
+				assert.Len(t, rules, 2)
+				assert.Equal(t, http.StatusOK, rules[0].Status)
+				assert.Equal(t, http.StatusNotFound, rules[1].Status)
+				return nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.SetRules(context.Background(), TokenOwner("token"), 1, []models.Rule{
+			{PathGlob: "/events/*", BodyFields: map[string]string{"$['event type']": "created"}},
+			{Status: http.StatusNotFound},
+		})
+		assert.NoError(t, err)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin:   1,
+			CountOfSetRules: 1,
+		})
+	})
+	t.Run("invalid rules", func(t *testing.T) {
+		for name, rule := range map[string]models.Rule{
+			"path glob":       {PathGlob: "/events/["},
+			"json path":       {BodyFields: map[string]string{"data.id": "1"}},
+			"json index":      {BodyFields: map[string]string{"$.items[first]": "1"}},
+			"status":          {Status: 1000},
+			"body template":   {ResponseBody: "{{.Json.id"},
+			"header template": {ResponseHeaders: map[string]string{"X-Id": "{{uuid"}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				db := fake.Db{
+					GetBinFake: func(binId int64) (models.Bin, error) {
+						return models.Bin{BinId: binId}, nil
+					},
+				}
+				services := New(&Deps{
+					Db: &db,
+				})
+
+				err := services.SetRules(context.Background(), "", 1, []models.Rule{rule})
+				assert.ErrorAs(t, err, new(ValidationError))
+				db.VerifyCallCounts(t, &fake.Db{
+					CountOfGetBin: 1,
+				})
+			})
+		}
+	})
+	t.Run("invalid bin request", func(t *testing.T) {
+		db := fake.Db{}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.SetRules(context.Background(), "", 0, nil)
+		assert.Error(t, err)
+	})
+	t.Run("bin of another owner", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.SetRules(context.Background(), TokenOwner("other"), 1, nil)
+		assert.ErrorIs(t, err, ErrForbidden)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin: 1,
+		})
+	})
+}
+
+type fakeNotifier struct {
+	messages []notify.Message
+}
+
+func (n *fakeNotifier) Enqueue(message notify.Message) bool {
+	n.messages = append(n.messages, message)
+	return true
+}
+
+func Test_SetNotificationTargets(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+			},
+			SetNotificationTargetsFake: func(binId int64, targets []models.NotificationTarget) error {
+				assert.Equal(t, int64(1), binId)
+				assert.Len(t, targets, 2)
+				assert.Equal(t, defaultWebhookPayload, targets[0].Payload)
+				assert.Equal(t, defaultEmailSubject, targets[1].Subject)
+				assert.Equal(t, defaultEmailPayload, targets[1].Payload)
+				return nil
+			},
+		}
+		services := New(&Deps{
+			Db:                 &db,
+			EmailNotifications: true,
+		})
+
+		err := services.SetNotificationTargets(context.Background(), TokenOwner("token"), 1, []models.NotificationTarget{
+			{Kind: notify.KindWebhook, Url: "https://hooks.example.com/bin", Headers: map[string]string{"X-Bin": "{{.Request.Bin}}"}},
+			{Kind: notify.KindEmail, To: []string{"ops@example.com"}},
+		})
+		assert.NoError(t, err)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin:                 1,
+			CountOfSetNotificationTargets: 1,
+		})
+	})
+	t.Run("invalid targets", func(t *testing.T) {
+		for name, target := range map[string]models.NotificationTarget{
+			"kind":             {Kind: "pigeon"},
+			"webhook scheme":   {Kind: notify.KindWebhook, Url: "ftp://example.com"},
+			"webhook host":     {Kind: notify.KindWebhook, Url: "https:///path"},
+			"header template":  {Kind: notify.KindWebhook, Url: "https://example.com", Headers: map[string]string{"X-Id": "{{uuid"}},
+			"no recipients":    {Kind: notify.KindEmail},
+			"recipient":        {Kind: notify.KindEmail, To: []string{"Ops <ops@example.com>"}},
+			"subject template": {Kind: notify.KindEmail, To: []string{"ops@example.com"}, Subject: "{{.Method"},
+			"payload template": {Kind: notify.KindWebhook, Url: "https://example.com", Payload: "{{.Body"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				db := fake.Db{
+					GetBinFake: func(binId int64) (models.Bin, error) {
+						return models.Bin{BinId: binId}, nil
+					},
+				}
+				services := New(&Deps{
+					Db:                 &db,
+					EmailNotifications: true,
+				})
+
+				err := services.SetNotificationTargets(context.Background(), "", 1, []models.NotificationTarget{target})
+				assert.ErrorAs(t, err, new(ValidationError))
+				db.VerifyCallCounts(t, &fake.Db{
+					CountOfGetBin: 1,
+				})
+			})
+		}
+	})
+	t.Run("emails on a bin without an owner", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId}, nil
+			},
+		}
+		services := New(&Deps{
+			Db:                 &db,
+			EmailNotifications: true,
+		})
+
+		err := services.SetNotificationTargets(context.Background(), "", 1, []models.NotificationTarget{
+			{Kind: notify.KindEmail, To: []string{"ops@example.com"}},
+		})
+		assert.ErrorIs(t, err, ErrUnownedBinEmails)
+		assert.ErrorIs(t, err, ErrForbidden)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin: 1,
+		})
+	})
+	t.Run("recipients of allowed domains", func(t *testing.T) {
+		for to, allowed := range map[string]bool{
+			"ops@example.com":      true,
+			"ops@EXAMPLE.com":      true,
+			"ops@example.org":      false,
+			"ops@mail.example.com": false,
+		} {
+			t.Run(to, func(t *testing.T) {
+				db := fake.Db{
+					GetBinFake: func(binId int64) (models.Bin, error) {
+						return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+					},
+					SetNotificationTargetsFake: func(binId int64, targets []models.NotificationTarget) error {
+						return nil
+					},
+				}
+				services := New(&Deps{
+					Db:                 &db,
+					EmailNotifications: true,
+					EmailDomains:       []string{"example.com"},
+				})
+
+				err := services.SetNotificationTargets(context.Background(), TokenOwner("token"), 1, []models.NotificationTarget{
+					{Kind: notify.KindEmail, To: []string{to}},
+				})
+				if allowed {
+					assert.NoError(t, err)
+				} else {
+					assert.ErrorAs(t, err, new(ValidationError))
+				}
+			})
+		}
+	})
+	t.Run("emails not configured", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId}, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.SetNotificationTargets(context.Background(), "", 1, []models.NotificationTarget{
+			{Kind: notify.KindEmail, To: []string{"ops@example.com"}},
+		})
+		assert.EqualError(t, err, "target 0: email notifications are not configured")
+		assert.ErrorAs(t, err, new(ValidationError))
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin: 1,
+		})
+	})
+	t.Run("bin of another owner", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.SetNotificationTargets(context.Background(), TokenOwner("other"), 1, nil)
+		assert.ErrorIs(t, err, ErrForbidden)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin: 1,
+		})
+	})
+}
+
+func Test_LogRequestNotifications(t *testing.T) {
+	t.Run("targets are notified", func(t *testing.T) {
+		request := generateRequest()
+		request.Method = "POST"
+		request.SubPath = "/events"
+		db := fake.Db{
+			GetBinSchemaFake: func(binId int64) (models.BinSchema, error) {
+				return models.BinSchema{}, nil
+			},
+			GetRulesFake: func(binId int64) ([]models.Rule, error) {
+				return nil, nil
+			},
+			InsertRequestFake: func(requestParams models.Request) (int64, error) {
+				return 1, nil
+			},
+			GetNotificationTargetsFake: func(binId int64) ([]models.NotificationTarget, error) {
+				assert.Equal(t, request.Bin, binId)
+				return []models.NotificationTarget{
+					{Id: 1, Kind: notify.KindWebhook, Url: "https://example.com", Headers: map[string]string{"X-Method": "{{.Method}}"}, Payload: `{"text": "{{.Method}} {{.SubPath}}"}`},
+					{Id: 2, Kind: notify.KindEmail, To: []string{"ops@example.com"}, Subject: defaultEmailSubject, Payload: "{{.Body}}"},
+				}, nil
+			},
+		}
+		notifier := fakeNotifier{}
+		services := New(&Deps{
+			Db:       &db,
+			Notifier: &notifier,
+		})
+
+		_, err := services.LogRequest(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, []notify.Message{
+			{Bin: 1, Target: 1, Kind: notify.KindWebhook, Url: "https://example.com", Headers: map[string]string{"X-Method": "POST"}, Body: `{"text": "POST /events"}`},
+			{Bin: 1, Target: 2, Kind: notify.KindEmail, To: []string{"ops@example.com"}, Headers: map[string]string{}, Subject: "POST request captured by bin 1", Body: "body"},
+		}, notifier.messages)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetRules:               1,
+			CountOfGetBinSchema:           1,
+			CountOfInsertRequest:          1,
+			CountOfGetNotificationTargets: 1,
+		})
+	})
+	t.Run("emails over the rate limit are dropped", func(t *testing.T) {
+		db := fake.Db{
+			GetBinSchemaFake: func(binId int64) (models.BinSchema, error) {
+				return models.BinSchema{}, nil
+			},
+			GetRulesFake: func(binId int64) ([]models.Rule, error) {
+				return nil, nil
+			},
+			InsertRequestFake: func(requestParams models.Request) (int64, error) {
+				return 1, nil
+			},
+			GetNotificationTargetsFake: func(binId int64) ([]models.NotificationTarget, error) {
+				return []models.NotificationTarget{
+					{Id: 1, Kind: notify.KindWebhook, Url: "https://example.com", Payload: "{{.Body}}"},
+					{Id: 2, Kind: notify.KindEmail, To: []string{"ops@example.com"}, Subject: defaultEmailSubject, Payload: "{{.Body}}"},
+				}, nil
+			},
+		}
+		notifier := fakeNotifier{}
+		m := metrics.New()
+		services := New(&Deps{
+			Db:         &db,
+			Notifier:   &notifier,
+			Metrics:    m,
+			RateLimits: RateLimits{Email: ratelimit.Limit{Events: 1, Per: time.Hour}},
+		})
+
+		for range 2 {
+			_, err := services.LogRequest(context.Background(), generateRequest())
+			assert.NoError(t, err)
+		}
+		var kinds []string
+		for _, message := range notifier.messages {
+			kinds = append(kinds, message.Kind)
+		}
+		assert.Equal(t, []string{notify.KindWebhook, notify.KindEmail, notify.KindWebhook}, kinds)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		assert.Contains(t, recorder.Body.String(), `httpbin_notifications_total{kind="email",result="dropped"} 1`)
+	})
+	t.Run("failed captures are not notified", func(t *testing.T) {
+		db := fake.Db{
+			GetBinSchemaFake: func(binId int64) (models.BinSchema, error) {
+				return models.BinSchema{}, nil
+			},
+			GetRulesFake: func(binId int64) ([]models.Rule, error) {
+				return nil, nil
+			},
+			InsertRequestFake: func(requestParams models.Request) (int64, error) {
+				return 0, errors.New("database is locked")
+			},
+		}
+		notifier := fakeNotifier{}
+		services := New(&Deps{
+			Db:       &db,
+			Notifier: &notifier,
+		})
+
+		_, err := services.LogRequest(context.Background(), generateRequest())
+		assert.Error(t, err)
+		assert.Empty(t, notifier.messages)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetRules:      1,
+			CountOfGetBinSchema:  1,
+			CountOfInsertRequest: 1,
+		})
+	})
+}
+
+func Test_ImportRequests(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		receivedAt := time.Now().Add(-time.Hour)
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+			},
+			InsertRequestsFake: func(requests []models.Request) error {
+				assert.Len(t, requests, 2)
+				for _, request := range requests {
+					assert.Equal(t, int64(0), request.Id)
+					assert.Equal(t, int64(2), request.Bin)
+					assert.Equal(t, int64(0), request.RuleId)
+					assert.Equal(t, receivedAt, request.RecievedAt)
+				}
+				return nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.ImportRequests(context.Background(), TokenOwner("token"), 2, []models.Request{
+			{Id: 7, Bin: 1, RuleId: 3, RecievedAt: receivedAt},
+			{Id: 8, Bin: 1, RecievedAt: receivedAt},
+		})
+		assert.NoError(t, err)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin:         1,
+			CountOfInsertRequests: 1,
+		})
+	})
+	t.Run("invalid requests", func(t *testing.T) {
+		for name, requests := range map[string][]models.Request{
+			"missing receivedAt": {{Method: "GET"}},
+			"too many":           make([]models.Request, MaxImportedRequests+1),
+		} {
+			t.Run(name, func(t *testing.T) {
+				db := fake.Db{}
+				services := New(&Deps{
+					Db: &db,
+				})
+
+				err := services.ImportRequests(context.Background(), "", 1, requests)
+				assert.ErrorAs(t, err, new(ValidationError))
+				db.VerifyCallCounts(t, &fake.Db{})
+			})
+		}
+	})
+	t.Run("bin of another owner", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.ImportRequests(context.Background(), "", 1, []models.Request{{RecievedAt: time.Now()}})
+		assert.ErrorIs(t, err, ErrForbidden)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin: 1,
+		})
+	})
+}
+
+func Test_StreamRequests(t *testing.T) {
+	newServices := func() (*Services, *fake.Db) {
+		db := &fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId}, nil
+			},
+			GetBinSchemaFake: func(binId int64) (models.BinSchema, error) {
+				return models.BinSchema{}, nil
+			},
+			GetRulesFake: func(binId int64) ([]models.Rule, error) {
+				return nil, nil
+			},
+			InsertRequestFake: func(request models.Request) (int64, error) {
+				return 42, nil
+			},
+		}
+		return New(&Deps{Db: db}), db
+	}
+
+	t.Run("captured requests are streamed", func(t *testing.T) {
+		services, _ := newServices()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		requests, err := services.StreamRequests(ctx, 1, NoResume)
+		assert.NoError(t, err)
+		other, err := services.StreamRequests(ctx, 2, NoResume)
+		assert.NoError(t, err)
+
+		_, err = services.LogRequest(context.Background(), generateRequest())
+		assert.NoError(t, err)
+		request := <-requests
+		assert.Equal(t, int64(42), request.Id)
+		assert.Equal(t, int64(1), request.Bin)
+		assert.Empty(t, other)
+
+		recorder := httptest.NewRecorder()
+		services.metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		assert.Contains(t, recorder.Body.String(), "httpbin_waiters 2")
+
+		cancel()
+		_, ok := <-requests
+		assert.False(t, ok)
+		assert.Eventually(t, func() bool {
+			recorder := httptest.NewRecorder()
+			services.metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+			return strings.Contains(recorder.Body.String(), "httpbin_waiters 0")
+		}, time.Second, time.Millisecond)
+	})
+	t.Run("resumed streams send missed requests first", func(t *testing.T) {
+		services, db := newServices()
+		db.GetRequestsAfterFake = func(binId, afterId int64, limit int) ([]models.Request, error) {
+			assert.Equal(t, int64(1), binId)
+			if afterId >= 42 {
+				return nil, nil
+			}
+			missed := make([]models.Request, 0, limit)
+			for id := afterId + 1; id <= 42 && len(missed) < limit; id++ {
+				missed = append(missed, models.Request{Id: id, Bin: binId})
+			}
+			return missed, nil
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		requests, err := services.StreamRequests(ctx, 1, 40)
+		assert.NoError(t, err)
+		// captured while the missed requests are read
+		_, err = services.LogRequest(context.Background(), generateRequest())
+		assert.NoError(t, err)
+		db.InsertRequestFake = func(request models.Request) (int64, error) {
+			return 43, nil
+		}
+		_, err = services.LogRequest(context.Background(), generateRequest())
+		assert.NoError(t, err)
+
+		var ids []int64
+		for range 3 {
+			ids = append(ids, (<-requests).Id)
+		}
+		assert.Equal(t, []int64{41, 42, 43}, ids)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin:           1,
+			CountOfGetRules:         2,
+			CountOfGetBinSchema:     1,
+			CountOfInsertRequest:    2,
+			CountOfGetRequestsAfter: 1,
+		})
+	})
+	t.Run("streams resumed from an empty bin send all its requests", func(t *testing.T) {
+		services, db := newServices()
+		db.GetRequestsAfterFake = func(binId, afterId int64, limit int) ([]models.Request, error) {
+			if afterId == 0 {
+				return []models.Request{{Id: 7, Bin: binId}}, nil
+			}
+			return nil, nil
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		requests, err := services.StreamRequests(ctx, 1, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(7), (<-requests).Id)
+	})
+	t.Run("invalid last id", func(t *testing.T) {
+		services, _ := newServices()
+
+		_, err := services.StreamRequests(context.Background(), 1, -2)
+		assert.ErrorAs(t, err, new(ValidationError))
+	})
+	t.Run("slow subscribers are disconnected", func(t *testing.T) {
+		services, _ := newServices()
+
+		requests, err := services.StreamRequests(context.Background(), 1, NoResume)
+		assert.NoError(t, err)
+		for range streamBuffer + 1 {
+			_, err = services.LogRequest(context.Background(), generateRequest())
+			assert.NoError(t, err)
+		}
+
+		count := 0
+		for range requests {
+			count++
+		}
+		assert.Equal(t, streamBuffer, count)
+	})
+	t.Run("closed streams", func(t *testing.T) {
+		services, _ := newServices()
+
+		requests, err := services.StreamRequests(context.Background(), 1, NoResume)
+		assert.NoError(t, err)
+		services.CloseStreams(context.Background())
+		_, ok := <-requests
+		assert.False(t, ok)
+
+		requests, err = services.StreamRequests(context.Background(), 1, NoResume)
+		assert.NoError(t, err)
+		_, ok = <-requests
+		assert.False(t, ok)
+	})
+	t.Run("missing bin", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{}, models.ErrNotFound
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		_, err := services.StreamRequests(context.Background(), 1, NoResume)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+}
+
+func Test_OpenWebSocket(t *testing.T) {
+	newServices := func(script models.WebSocketScript) (*Services, *fake.Db, *[]models.Frame) {
+		var frames []models.Frame
+		db := &fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId}, nil
+			},
+			GetWebSocketScriptFake: func(binId int64) (models.WebSocketScript, error) {
+				assert.Equal(t, int64(1), binId)
+				return script, nil
+			},
+			InsertRequestFake: func(request models.Request) (int64, error) {
+				return 42, nil
+			},
+			InsertFrameFake: func(frame models.Frame) (int64, error) {
+				frames = append(frames, frame)
+				return int64(len(frames)), nil
+			},
+		}
+		return New(&Deps{Db: db}), db, &frames
+	}
+
+	t.Run("happy path - messages are recorded and answered", func(t *testing.T) {
+		services, db, frames := newServices(models.WebSocketScript{
+			Echo:      true,
+			OnConnect: []string{"welcome"},
+			Reply:     `{"bin": {{.Request.Bin}}, "got": {{json .Message}}}`,
+		})
+		ctx := context.Background()
+		requests, err := services.StreamRequests(ctx, 1, NoResume)
+		assert.NoError(t, err)
+
+		session, err := services.OpenWebSocket(ctx, generateRequest())
+		assert.NoError(t, err)
+		defer session.End()
+		assert.Equal(t, int64(42), session.Request().Id)
+		assert.Equal(t, int64(42), (<-requests).Id)
+
+		greetings, err := session.Open(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, greetings, 1)
+		assert.Equal(t, FrameText, greetings[0].Kind)
+		assert.Equal(t, "welcome", string(greetings[0].Payload))
+
+		replies, err := session.Receive(ctx, FrameText, []byte("hi"))
+		assert.NoError(t, err)
+		assert.Len(t, replies, 2)
+		assert.Equal(t, "hi", string(replies[0].Payload))
+		assert.Equal(t, `{"bin": 1, "got": "hi"}`, string(replies[1].Payload))
+
+		// binary messages are only echoed
+		replies, err = session.Receive(ctx, FrameBinary, []byte{0xff})
+		assert.NoError(t, err)
+		assert.Len(t, replies, 1)
+
+		assert.NoError(t, session.Closed(ctx, FrameIn, 1000, "bye"))
+
+		assert.Len(t, *frames, 7)
+		for i, want := range []struct{ direction, kind, payload string }{
+			{FrameOut, FrameText, "welcome"},
+			{FrameIn, FrameText, "hi"},
+			{FrameOut, FrameText, "hi"},
+			{FrameOut, FrameText, `{"bin": 1, "got": "hi"}`},
+			{FrameIn, FrameBinary, "\xff"},
+			{FrameOut, FrameBinary, "\xff"},
+			{FrameIn, FrameClose, "\x03\xe8bye"},
+		} {
+			frame := (*frames)[i]
+			assert.Equal(t, int64(42), frame.Request)
+			assert.Equal(t, want.direction, frame.Direction)
+			assert.Equal(t, want.kind, frame.Kind)
+			assert.Equal(t, want.payload, string(frame.Payload))
+			assert.Equal(t, len(want.payload), frame.Size)
+		}
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin:             1,
+			CountOfGetWebSocketScript: 1,
+			CountOfInsertRequest:      1,
+			CountOfInsertFrame:        7,
+		})
+	})
+
+	t.Run("large messages are recorded in part", func(t *testing.T) {
+		services, _, frames := newServices(models.WebSocketScript{Echo: true})
+		session, err := services.OpenWebSocket(context.Background(), generateRequest())
+		assert.NoError(t, err)
+		defer session.End()
+
+		message := []byte(strings.Repeat("a", maxRecordedPayload+10))
+		replies, err := session.Receive(context.Background(), FrameBinary, message)
+		assert.NoError(t, err)
+		assert.Equal(t, message, replies[0].Payload)
+		assert.Equal(t, FrameBinary, replies[0].Kind)
+		assert.Len(t, (*frames)[0].Payload, maxRecordedPayload)
+		assert.Equal(t, len(message), (*frames)[0].Size)
+	})
+
+	t.Run("sessions stop recording once full", func(t *testing.T) {
+		services, _, frames := newServices(models.WebSocketScript{})
+		session, err := services.OpenWebSocket(context.Background(), generateRequest())
+		assert.NoError(t, err)
+		defer session.End()
+
+		for range maxSessionFrames {
+			_, err := session.Receive(context.Background(), FrameText, []byte("a"))
+			assert.NoError(t, err)
+		}
+		_, err = session.Receive(context.Background(), FrameText, []byte("a"))
+		assert.ErrorIs(t, err, ErrSessionFull)
+		assert.Len(t, *frames, maxSessionFrames)
+	})
+
+	t.Run("sessions are closed on shutdown", func(t *testing.T) {
+		services, _, _ := newServices(models.WebSocketScript{})
+		session, err := services.OpenWebSocket(context.Background(), generateRequest())
+		assert.NoError(t, err)
+
+		closed := make(chan struct{})
+		go func() {
+			services.CloseStreams(context.Background())
+			close(closed)
+		}()
+		select {
+		case <-session.Done():
+		case <-time.After(time.Second):
+			t.Fatal("session was not closed")
+		}
+		// closing waits for the session to end
+		select {
+		case <-closed:
+			t.Fatal("closing did not wait for the session")
+		case <-time.After(10 * time.Millisecond):
+		}
+		session.End()
+		<-closed
+
+		late, err := services.OpenWebSocket(context.Background(), generateRequest())
+		assert.NoError(t, err)
+		defer late.End()
+		_, open := <-late.Done()
+		assert.False(t, open)
+	})
+
+	t.Run("sessions not ending are waited for until ctx is done", func(t *testing.T) {
+		services, _, _ := newServices(models.WebSocketScript{})
+		session, err := services.OpenWebSocket(context.Background(), generateRequest())
+		assert.NoError(t, err)
+		defer session.End()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		closed := make(chan struct{})
+		go func() {
+			services.CloseStreams(ctx)
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("closing did not return once ctx was done")
+		}
+	})
+
+	t.Run("handshakes are counted with the status answered", func(t *testing.T) {
+		_, db, _ := newServices(models.WebSocketScript{})
+		m := metrics.New()
+		services := New(&Deps{Db: db, Metrics: m})
+
+		for _, status := range []int{http.StatusSwitchingProtocols, http.StatusBadRequest, 0} {
+			session, err := services.OpenWebSocket(context.Background(), generateRequest())
+			assert.NoError(t, err)
+			session.Answered(status)
+			session.End()
+		}
+		// not captured, not counted
+		db.InsertRequestFake = func(request models.Request) (int64, error) {
+			return 0, errors.New("error")
+		}
+		_, err := services.OpenWebSocket(context.Background(), generateRequest())
+		assert.Error(t, err)
+
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		assert.Contains(t, recorder.Body.String(), `httpbin_requests_captured_total{method="OTHER",status="101"} 1`)
+		assert.Contains(t, recorder.Body.String(), `httpbin_requests_captured_total{method="OTHER",status="400"} 1`)
+		assert.Contains(t, recorder.Body.String(), `httpbin_requests_captured_total{method="OTHER",status="none"} 1`)
+		assert.Equal(t, 3, strings.Count(recorder.Body.String(), "httpbin_requests_captured_total{"))
+	})
+
+	t.Run("error getting script", func(t *testing.T) {
+		db := &fake.Db{
+			GetWebSocketScriptFake: func(binId int64) (models.WebSocketScript, error) {
+				return models.WebSocketScript{}, errors.New("error")
+			},
+		}
+		services := New(&Deps{Db: db})
+
+		_, err := services.OpenWebSocket(context.Background(), generateRequest())
+		assert.Error(t, err)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetWebSocketScript: 1,
+		})
+	})
+}
+
+func Test_SetWebSocketScript(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+			},
+			SetWebSocketScriptFake: func(binId int64, script models.WebSocketScript) error {
+				assert.Equal(t, int64(1), binId)
+				assert.True(t, script.Echo)
+				return nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.SetWebSocketScript(context.Background(), TokenOwner("token"), 1, models.WebSocketScript{Echo: true, Reply: "{{.Message}}"})
+		assert.NoError(t, err)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin:             1,
+			CountOfSetWebSocketScript: 1,
+		})
+	})
+	t.Run("invalid scripts", func(t *testing.T) {
+		for name, script := range map[string]models.WebSocketScript{
+			"reply template":      {Reply: "{{.Message"},
+			"too many greetings":  {OnConnect: make([]string, maxOnConnectMessages+1)},
+			"too large greetings": {OnConnect: []string{strings.Repeat("a", MaxWebSocketMessage+1)}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				db := fake.Db{
+					GetBinFake: func(binId int64) (models.Bin, error) {
+						return models.Bin{BinId: binId}, nil
+					},
+				}
+				services := New(&Deps{
+					Db: &db,
+				})
+
+				err := services.SetWebSocketScript(context.Background(), "", 1, script)
+				assert.ErrorAs(t, err, new(ValidationError))
+				db.VerifyCallCounts(t, &fake.Db{
+					CountOfGetBin: 1,
+				})
+			})
+		}
+	})
+	t.Run("bin of another owner", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.SetWebSocketScript(context.Background(), TokenOwner("other"), 1, models.WebSocketScript{Echo: true})
+		assert.ErrorIs(t, err, ErrForbidden)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin: 1,
+		})
+	})
+}
+
+// testDescriptorSet describes the service events.Bus, whose Publish method
+// takes an Event and answers an Ack.
+func testDescriptorSet(t *testing.T) []byte {
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     kind.Enum(),
+		}
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("events.proto"),
+		Package: proto.String("events"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Event"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+					field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+				},
+			},
+			{
+				Name:  proto.String("Ack"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("ok", 1, descriptorpb.FieldDescriptorProto_TYPE_BOOL)},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Bus"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Publish"),
+				InputType:  proto.String(".events.Event"),
+				OutputType: proto.String(".events.Ack"),
+			}},
+		}},
+	}
+	set, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	assert.NoError(t, err)
+	return set
+}
+
+// event is the encoding of the Event {"name": "a", "count": 2}.
+var event = []byte{0x0a, 0x01, 'a', 0x10, 0x02}
+
+func generateGrpcCall(contentType string, body []byte) models.Request {
+	request := generateRequest()
+	request.Method = http.MethodPost
+	request.SubPath = "/events.Bus/Publish"
+	request.Body = string(body)
+	_ = request.SetHeaders(map[string][]string{"Content-Type": {contentType}, "Grpc-Encoding": {"gzip"}})
+	return request
+}
+
+func Test_LogGrpcCall(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		settings    models.GrpcSettings
+		expected    models.Response
+	}{
+		{
+			name:        "response of the method",
+			contentType: "application/grpc+proto",
+			settings: models.GrpcSettings{
+				Responses:     map[string]string{"/events.Bus/Publish": `{"ok": true}`},
+				DescriptorSet: testDescriptorSet(t),
+			},
+			expected: models.Response{
+				Status:   http.StatusOK,
+				Headers:  map[string]string{"Content-Type": "application/grpc"},
+				Body:     "\x00\x00\x00\x00\x02\x08\x01",
+				Trailers: map[string]string{"Grpc-Status": "0"},
+			},
+		},
+		{
+			name:        "status of the bin",
+			contentType: "application/grpc",
+			settings:    models.GrpcSettings{Status: 5, Message: "no such event"},
+			expected: models.Response{
+				Status:   http.StatusOK,
+				Headers:  map[string]string{"Content-Type": "application/grpc"},
+				Trailers: map[string]string{"Grpc-Status": "5", "Grpc-Message": "no such event"},
+			},
+		},
+		{
+			name:        "response failing to encode",
+			contentType: "application/grpc",
+			settings:    models.GrpcSettings{Responses: map[string]string{"/events.Bus/Publish": `{"ok": true}`}},
+			expected: models.Response{
+				Status:   http.StatusOK,
+				Headers:  map[string]string{"Content-Type": "application/grpc"},
+				Trailers: map[string]string{"Grpc-Status": "13", "Grpc-Message": "encoding response: no descriptor set"},
+			},
+		},
+		{
+			name:        "grpc-web trailers in the body",
+			contentType: "application/grpc-web+proto",
+			expected: models.Response{
+				Status:  http.StatusOK,
+				Headers: map[string]string{"Content-Type": "application/grpc-web"},
+				Body:    "\x00\x00\x00\x00\x00\x80\x00\x00\x00\x10grpc-status: 0\r\n",
+			},
+		},
+		{
+			name:        "grpc-web text encoded",
+			contentType: "application/grpc-web-text",
+			settings:    models.GrpcSettings{Status: 3, Message: "100% wrong"},
+			expected: models.Response{
+				Status:  http.StatusOK,
+				Headers: map[string]string{"Content-Type": "application/grpc-web-text"},
+				Body:    base64.StdEncoding.EncodeToString([]byte("\x80\x00\x00\x00\x2cgrpc-message: 100%25 wrong\r\ngrpc-status: 3\r\n")),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := generateGrpcCall(tt.contentType, append([]byte{0, 0, 0, 0, 5}, event...))
+			db := fake.Db{
+				GetGrpcSettingsFake: func(binId int64) (models.GrpcSettings, error) {
+					assert.Equal(t, request.Bin, binId)
+					return tt.settings, nil
+				},
+				InsertRequestFake: func(requestParams models.Request) (int64, error) {
+					assert.Equal(t, request.Body, requestParams.Body)
+					return 1, nil
+				},
+			}
+			services := New(&Deps{
+				Db: &db,
+			})
+
+			response, err := services.LogRequest(context.Background(), request)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, response)
+			db.VerifyCallCounts(t, &fake.Db{
+				CountOfGetGrpcSettings: 1,
+				CountOfInsertRequest:   1,
+			})
+		})
+	}
+
+	t.Run("settings are read once until set again", func(t *testing.T) {
+		settings := models.GrpcSettings{
+			Responses:     map[string]string{"/events.Bus/Publish": `{"ok": true}`},
+			DescriptorSet: testDescriptorSet(t),
+		}
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId}, nil
+			},
+			GetGrpcSettingsFake: func(binId int64) (models.GrpcSettings, error) {
+				return settings, nil
+			},
+			SetGrpcSettingsFake: func(binId int64, set models.GrpcSettings) error {
+				settings = set
+				return nil
+			},
+			InsertRequestFake: func(request models.Request) (int64, error) {
+				return 1, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+		call := func() models.Response {
+			response, err := services.LogRequest(context.Background(), generateGrpcCall("application/grpc", append([]byte{0, 0, 0, 0, 5}, event...)))
+			assert.NoError(t, err)
+			return response
+		}
+
+		assert.Equal(t, "\x00\x00\x00\x00\x02\x08\x01", call().Body)
+		assert.Equal(t, "\x00\x00\x00\x00\x02\x08\x01", call().Body)
+		assert.Equal(t, 1, db.CountOfGetGrpcSettings)
+
+		err := services.SetGrpcSettings(context.Background(), "", 1, models.GrpcSettings{Status: 5})
+		assert.NoError(t, err)
+		assert.Equal(t, "5", call().Trailers["Grpc-Status"])
+		assert.Equal(t, 2, db.CountOfGetGrpcSettings)
+	})
+}
+
+func Test_GetGrpcMessages(t *testing.T) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(event)
+	writer.Close()
+
+	var body []byte
+	body = appendGrpcMessage(body, 0, event)
+	body = appendGrpcMessage(body, 1, compressed.Bytes())
+	// a message cut short
+	body = append(body, 0, 0, 0, 0, 9, 0x0a)
+
+	t.Run("happy path", func(t *testing.T) {
+		db := fake.Db{
+			GetRequestFake: func(binId, requestId int64) (models.Request, error) {
+				return generateGrpcCall("application/grpc", body), nil
+			},
+			GetGrpcSettingsFake: func(binId int64) (models.GrpcSettings, error) {
+				return models.GrpcSettings{DescriptorSet: testDescriptorSet(t)}, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		messages, err := services.GetGrpcMessages(context.Background(), 1, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, []models.GrpcMessage{
+			{Size: 5, Payload: event, Decoded: `{"name":"a","count":2}`},
+			{Compressed: true, Size: compressed.Len(), Payload: compressed.Bytes(), Decoded: `{"name":"a","count":2}`},
+			{Size: 6, Payload: []byte{0, 0, 0, 0, 9, 0x0a}, DecodeError: "truncated message"},
+		}, messages)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetRequest:      1,
+			CountOfGetGrpcSettings: 1,
+		})
+	})
+	t.Run("grpc-web text without descriptor set", func(t *testing.T) {
+		db := fake.Db{
+			GetRequestFake: func(binId, requestId int64) (models.Request, error) {
+				text := base64.StdEncoding.EncodeToString(appendGrpcMessage(nil, 0, event))
+				return generateGrpcCall("application/grpc-web-text", []byte(text)), nil
+			},
+			GetGrpcSettingsFake: func(binId int64) (models.GrpcSettings, error) {
+				return models.GrpcSettings{}, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		messages, err := services.GetGrpcMessages(context.Background(), 1, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, []models.GrpcMessage{{Size: 5, Payload: event}}, messages)
+	})
+	t.Run("method not described", func(t *testing.T) {
+		db := fake.Db{
+			GetRequestFake: func(binId, requestId int64) (models.Request, error) {
+				request := generateGrpcCall("application/grpc", appendGrpcMessage(nil, 0, event))
+				request.SubPath = "/events.Bus/Missing"
+				return request, nil
+			},
+			GetGrpcSettingsFake: func(binId int64) (models.GrpcSettings, error) {
+				return models.GrpcSettings{DescriptorSet: testDescriptorSet(t)}, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		messages, err := services.GetGrpcMessages(context.Background(), 1, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, []models.GrpcMessage{
+			{Size: 5, Payload: event, DecodeError: "method Missing not in service events.Bus"},
+		}, messages)
+	})
+	t.Run("not a grpc call", func(t *testing.T) {
+		db := fake.Db{
+			GetRequestFake: func(binId, requestId int64) (models.Request, error) {
+				return generateRequest(), nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		messages, err := services.GetGrpcMessages(context.Background(), 1, 1)
+		assert.NoError(t, err)
+		assert.Nil(t, messages)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetRequest: 1,
+		})
+	})
+}
+
+func Test_SetGrpcSettings(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		settings := models.GrpcSettings{
+			Responses:     map[string]string{"/events.Bus/Publish": `{"ok": true}`},
+			DescriptorSet: testDescriptorSet(t),
+		}
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+			},
+			SetGrpcSettingsFake: func(binId int64, got models.GrpcSettings) error {
+				assert.Equal(t, int64(1), binId)
+				assert.Equal(t, settings, got)
+				return nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.SetGrpcSettings(context.Background(), TokenOwner("token"), 1, settings)
+		assert.NoError(t, err)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin:          1,
+			CountOfSetGrpcSettings: 1,
+		})
+	})
+	t.Run("invalid settings", func(t *testing.T) {
+		for name, settings := range map[string]models.GrpcSettings{
+			"status":                     {Status: 17},
+			"descriptor set":             {DescriptorSet: []byte("not a descriptor set")},
+			"response without set":       {Responses: map[string]string{"/events.Bus/Publish": `{}`}},
+			"response of unknown method": {Responses: map[string]string{"/events.Bus/Missing": `{}`}, DescriptorSet: testDescriptorSet(t)},
+			"response of unknown field":  {Responses: map[string]string{"/events.Bus/Publish": `{"nope": 1}`}, DescriptorSet: testDescriptorSet(t)},
+		} {
+			t.Run(name, func(t *testing.T) {
+				db := fake.Db{
+					GetBinFake: func(binId int64) (models.Bin, error) {
+						return models.Bin{BinId: binId}, nil
+					},
+				}
+				services := New(&Deps{
+					Db: &db,
+				})
+
+				err := services.SetGrpcSettings(context.Background(), "", 1, settings)
+				assert.ErrorAs(t, err, new(ValidationError))
+				db.VerifyCallCounts(t, &fake.Db{
+					CountOfGetBin: 1,
+				})
+			})
+		}
+	})
+	t.Run("bin of another owner", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.SetGrpcSettings(context.Background(), TokenOwner("other"), 1, models.GrpcSettings{Status: 12})
+		assert.ErrorIs(t, err, ErrForbidden)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin: 1,
+		})
+	})
+}
+
+type fakeListeners struct {
+	// taken ports fail to be listened on
+	taken     map[string]bool
+	listening map[string]func(ctx context.Context, request models.Request)
+	released  []string
+}
+
+func newFakeListeners() *fakeListeners {
+	return &fakeListeners{
+		taken:     map[string]bool{},
+		listening: map[string]func(ctx context.Context, request models.Request){},
+	}
+}
+
+func (l *fakeListeners) Listen(port models.CapturePort, capture func(ctx context.Context, request models.Request)) error {
+	key := fmt.Sprintf("%s/%d", port.Protocol, port.Port)
+	if l.taken[key] {
+		return errors.New("address already in use")
+	}
+	l.listening[key] = capture
+	return nil
+}
+
+func (l *fakeListeners) Release(protocol string, port int) {
+	key := fmt.Sprintf("%s/%d", protocol, port)
+	delete(l.listening, key)
+	l.released = append(l.released, key)
+}
+
+func Test_AllocateCapturePort(t *testing.T) {
+	owned := func(binId int64) (models.Bin, error) {
+		return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: owned,
+			GetCapturePortsFake: func(binId int64) ([]models.CapturePort, error) {
+				assert.Equal(t, int64(0), binId)
+				return []models.CapturePort{
+					{Protocol: models.TCP, Port: 40000, Bin: 2},
+					{Protocol: models.UDP, Port: 40001, Bin: 2},
+				}, nil
+			},
+			InsertCapturePortFake: func(port models.CapturePort) error {
+				assert.Equal(t, models.CapturePort{Protocol: models.TCP, Port: 40002, Bin: 1}, port)
+				return nil
+			},
+		}
+		listeners := newFakeListeners()
+		// taken by another process
+		listeners.taken["tcp/40001"] = true
+		services := New(&Deps{
+			Db:           &db,
+			Listeners:    listeners,
+			CapturePorts: PortRange{First: 40000, Last: 40009},
+		})
+
+		port, err := services.AllocateCapturePort(context.Background(), TokenOwner("token"), 1, models.TCP)
+		assert.NoError(t, err)
+		assert.Equal(t, models.CapturePort{Protocol: models.TCP, Port: 40002, Bin: 1}, port)
+		assert.Contains(t, listeners.listening, "tcp/40002")
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin:            1,
+			CountOfGetCapturePorts:   1,
+			CountOfInsertCapturePort: 1,
+		})
+	})
+	t.Run("invalid protocol", func(t *testing.T) {
+		db := fake.Db{}
+		services := New(&Deps{
+			Db:           &db,
+			Listeners:    newFakeListeners(),
+			CapturePorts: PortRange{First: 40000, Last: 40009},
+		})
+
+		_, err := services.AllocateCapturePort(context.Background(), TokenOwner("token"), 1, "sctp")
+		var validationErr ValidationError
+		assert.ErrorAs(t, err, &validationErr)
+		db.VerifyCallCounts(t, &fake.Db{})
+	})
+	t.Run("forbidden", func(t *testing.T) {
+		db := fake.Db{GetBinFake: owned}
+		services := New(&Deps{
+			Db:           &db,
+			Listeners:    newFakeListeners(),
+			CapturePorts: PortRange{First: 40000, Last: 40009},
+		})
+
+		_, err := services.AllocateCapturePort(context.Background(), TokenOwner("other"), 1, models.TCP)
+		assert.ErrorIs(t, err, ErrForbidden)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin: 1,
+		})
+	})
+	t.Run("bin without an owner", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId}, nil
+			},
+		}
+		services := New(&Deps{
+			Db:           &db,
+			Listeners:    newFakeListeners(),
+			CapturePorts: PortRange{First: 40000, Last: 40009},
+		})
+
+		_, err := services.AllocateCapturePort(context.Background(), "", 1, models.TCP)
+		assert.ErrorIs(t, err, ErrUnownedBin)
+		assert.ErrorIs(t, err, ErrForbidden)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin: 1,
+		})
+	})
+	t.Run("no range configured", func(t *testing.T) {
+		db := fake.Db{GetBinFake: owned}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		_, err := services.AllocateCapturePort(context.Background(), TokenOwner("token"), 1, models.UDP)
+		assert.ErrorIs(t, err, ErrNoFreePort)
+	})
+	t.Run("range exhausted", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: owned,
+			GetCapturePortsFake: func(binId int64) ([]models.CapturePort, error) {
+				return []models.CapturePort{{Protocol: models.UDP, Port: 40000, Bin: 2}}, nil
+			},
+		}
+		listeners := newFakeListeners()
+		listeners.taken["udp/40001"] = true
+		services := New(&Deps{
+			Db:           &db,
+			Listeners:    listeners,
+			CapturePorts: PortRange{First: 40000, Last: 40001},
+		})
+
+		_, err := services.AllocateCapturePort(context.Background(), TokenOwner("token"), 1, models.UDP)
+		assert.ErrorIs(t, err, ErrNoFreePort)
+		assert.Empty(t, listeners.listening)
+	})
+	t.Run("too many ports", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: owned,
+			GetCapturePortsFake: func(binId int64) ([]models.CapturePort, error) {
+				var ports []models.CapturePort
+				for i := range maxCapturePorts {
+					ports = append(ports, models.CapturePort{Protocol: models.TCP, Port: 40000 + i, Bin: 1})
+				}
+				return ports, nil
+			},
+		}
+		services := New(&Deps{
+			Db:           &db,
+			Listeners:    newFakeListeners(),
+			CapturePorts: PortRange{First: 40000, Last: 40009},
+		})
+
+		_, err := services.AllocateCapturePort(context.Background(), TokenOwner("token"), 1, models.UDP)
+		var validationErr ValidationError
+		assert.ErrorAs(t, err, &validationErr)
+	})
+	t.Run("db error", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: owned,
+			GetCapturePortsFake: func(binId int64) ([]models.CapturePort, error) {
+				return nil, nil
+			},
+			InsertCapturePortFake: func(port models.CapturePort) error {
+				return errors.New("db error")
+			},
+		}
+		listeners := newFakeListeners()
+		services := New(&Deps{
+			Db:           &db,
+			Listeners:    listeners,
+			CapturePorts: PortRange{First: 40000, Last: 40009},
+		})
+
+		_, err := services.AllocateCapturePort(context.Background(), TokenOwner("token"), 1, models.TCP)
+		assert.EqualError(t, err, "db error")
+		assert.Empty(t, listeners.listening)
+		assert.Equal(t, []string{"tcp/40000"}, listeners.released)
+	})
+}
+
+func Test_ReleaseCapturePort(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId}, nil
+			},
+			DeleteCapturePortFake: func(port models.CapturePort) error {
+				assert.Equal(t, models.CapturePort{Protocol: models.TCP, Port: 40000, Bin: 1}, port)
+				return nil
+			},
+		}
+		listeners := newFakeListeners()
+		services := New(&Deps{
+			Db:        &db,
+			Listeners: listeners,
+		})
+
+		err := services.ReleaseCapturePort(context.Background(), "", 1, models.TCP, 40000)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"tcp/40000"}, listeners.released)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin:            1,
+			CountOfDeleteCapturePort: 1,
+		})
+	})
+	t.Run("port of another bin", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId}, nil
+			},
+			DeleteCapturePortFake: func(port models.CapturePort) error {
+				return models.ErrNotFound
+			},
+		}
+		listeners := newFakeListeners()
+		services := New(&Deps{
+			Db:        &db,
+			Listeners: listeners,
+		})
+
+		err := services.ReleaseCapturePort(context.Background(), "", 1, models.TCP, 40000)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		assert.Empty(t, listeners.released)
+	})
+}
+
+func Test_OpenCapturePorts(t *testing.T) {
+	var inserted []models.Request
+	db := fake.Db{
+		GetCapturePortsFake: func(binId int64) ([]models.CapturePort, error) {
+			return []models.CapturePort{
+				{Protocol: models.TCP, Port: 40000, Bin: 1},
+				{Protocol: models.UDP, Port: 40000, Bin: 2},
+			}, nil
+		},
+		GetBinFake: func(binId int64) (models.Bin, error) {
+			return models.Bin{BinId: binId}, nil
+		},
+		InsertRequestFake: func(request models.Request) (int64, error) {
+			inserted = append(inserted, request)
+			return int64(len(inserted)), nil
+		},
+	}
+	listeners := newFakeListeners()
+	// still listened on by another process, the other ports are opened
+	listeners.taken["tcp/40000"] = true
+	services := New(&Deps{
+		Db:         &db,
+		Listeners:  listeners,
+		RateLimits: RateLimits{Client: ratelimit.Limit{Events: 1, Per: time.Minute}},
+	})
+
+	err := services.OpenCapturePorts(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, listeners.listening, 1)
+
+	capture := listeners.listening["udp/40000"]
+	datagram := models.Request{Bin: 2, RemoteAddr: "192.0.2.1:5140", Method: "UDP", Proto: models.UDP, Body: "<34>su: failed"}
+	capture(context.Background(), datagram)
+	// refused by the client rate limit
+	capture(context.Background(), datagram)
+
+	if assert.Len(t, inserted, 1) {
+		assert.Equal(t, "192.0.2.1", inserted[0].ClientIp)
+		assert.Equal(t, "<34>su: failed", inserted[0].Body)
+	}
+	db.VerifyCallCounts(t, &fake.Db{
+		CountOfGetCapturePorts: 1,
+		CountOfGetBin:          1,
+		CountOfInsertRequest:   1,
+	})
+}
+
+func Test_CaptureMail(t *testing.T) {
+	var inserted []models.Request
+	db := fake.Db{
+		GetBinFake: func(binId int64) (models.Bin, error) {
+			return models.Bin{BinId: binId}, nil
+		},
+		InsertRequestFake: func(request models.Request) (int64, error) {
+			inserted = append(inserted, request)
+			return int64(len(inserted)), nil
+		},
+	}
+	services := New(&Deps{
+		Db:         &db,
+		RateLimits: RateLimits{Bin: ratelimit.Limit{Events: 1, Per: time.Minute}},
+	})
+	mail := models.Request{Bin: 1, RemoteAddr: "192.0.2.1:41000", Method: "MAIL", Proto: models.SMTP, MailFrom: "shop@example.com"}
+
+	err := services.CaptureMail(context.Background(), mail)
+	assert.NoError(t, err)
+	err = services.CaptureMail(context.Background(), mail)
+	var rateLimitErr RateLimitError
+	assert.ErrorAs(t, err, &rateLimitErr)
+
+	if assert.Len(t, inserted, 1) {
+		assert.Equal(t, "192.0.2.1", inserted[0].ClientIp)
+		assert.Equal(t, "shop@example.com", inserted[0].MailFrom)
+	}
+	db.VerifyCallCounts(t, &fake.Db{
+		CountOfGetBin:        1,
+		CountOfInsertRequest: 1,
+	})
+}
+
+func Test_CaptureDNSQuery(t *testing.T) {
+	var inserted []models.Request
+	db := fake.Db{
+		GetBinFake: func(binId int64) (models.Bin, error) {
+			return models.Bin{BinId: binId}, nil
+		},
+		InsertRequestFake: func(request models.Request) (int64, error) {
+			inserted = append(inserted, request)
+			return int64(len(inserted)), nil
+		},
+	}
+	services := New(&Deps{
+		Db:         &db,
+		RateLimits: RateLimits{Client: ratelimit.Limit{Events: 1, Per: time.Minute}},
+	})
+	query := models.Request{Bin: 1, RemoteAddr: "198.51.100.53:33512", Method: "A", Proto: models.DNS, RequestUri: "ssrf.1.bins.example.com"}
+
+	services.CaptureDNSQuery(context.Background(), query)
+	// refused by the resolver rate limit
+	services.CaptureDNSQuery(context.Background(), query)
+
+	if assert.Len(t, inserted, 1) {
+		assert.Equal(t, "198.51.100.53", inserted[0].ClientIp)
+		assert.Equal(t, "ssrf.1.bins.example.com", inserted[0].RequestUri)
+	}
+	db.VerifyCallCounts(t, &fake.Db{
+		CountOfGetBin:        1,
+		CountOfInsertRequest: 1,
+	})
+}
+
+func Test_GetMailMessage(t *testing.T) {
+	mail := func(body string) func(binId, requestId int64) (models.Request, error) {
+		return func(binId, requestId int64) (models.Request, error) {
+			return models.Request{Id: requestId, Bin: binId, Proto: models.SMTP, Method: "MAIL", Body: body}, nil
+		}
+	}
+
+	t.Run("multipart", func(t *testing.T) {
+		body := "From: shop@example.com\r\n" +
+			"Subject: =?UTF-8?Q?Commande_exp=C3=A9di=C3=A9e?=\r\n" +
+			"MIME-Version: 1.0\r\n" +
+			"Content-Type: multipart/mixed; boundary=outer\r\n" +
+			"\r\n" +
+			"--outer\r\n" +
+			"Content-Type: multipart/alternative; boundary=inner\r\n" +
+			"\r\n" +
+			"--inner\r\n" +
+			"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"Exp=E9di=E9e.\r\n" +
+			"--inner\r\n" +
+			"Content-Type: text/html; charset=utf-8\r\n" +
+			"\r\n" +
+			"<p>Shipped.</p>\r\n" +
+			"--inner--\r\n" +
+			"--outer\r\n" +
+			"Content-Type: application/pdf\r\n" +
+			"Content-Disposition: attachment; filename=\"invoice.pdf\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			"JVBERi0x\r\n" +
+			"LjQK\r\n" +
+			"--outer--\r\n"
+		db := fake.Db{GetRequestFake: mail(body)}
+		services := New(&Deps{Db: &db})
+
+		message, err := services.GetMailMessage(context.Background(), 1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, models.MailMessage{
+			Subject: "Commande expédiée",
+			Text:    "Expédiée.",
+			HTML:    "<p>Shipped.</p>",
+			Attachments: []models.MailAttachment{{
+				Filename:    "invoice.pdf",
+				ContentType: "application/pdf",
+				Size:        9,
+				Content:     []byte("%PDF-1.4\n"),
+			}},
+		}, message)
+	})
+
+	t.Run("plain text", func(t *testing.T) {
+		db := fake.Db{GetRequestFake: mail("Subject: Hi\r\n\r\nHello.\r\n")}
+		services := New(&Deps{Db: &db})
+
+		message, err := services.GetMailMessage(context.Background(), 1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, models.MailMessage{Subject: "Hi", Text: "Hello.\r\n"}, message)
+	})
+
+	t.Run("windows-1252", func(t *testing.T) {
+		body := "Subject: Hi\r\nContent-Type: text/plain; charset=windows-1252\r\n\r\n\x93Hi\x94 \x80"
+		db := fake.Db{GetRequestFake: mail(body)}
+		services := New(&Deps{Db: &db})
+
+		message, err := services.GetMailMessage(context.Background(), 1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, "“Hi” €", message.Text)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		body := "Subject: Hi\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nfirst\r\n--b\r\nContent-Type: text/html\r\n\r\n<p>never closed"
+		db := fake.Db{GetRequestFake: mail(body)}
+		services := New(&Deps{Db: &db})
+
+		message, err := services.GetMailMessage(context.Background(), 1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, "first", message.Text)
+		assert.NotEmpty(t, message.ParseError)
+	})
+
+	t.Run("not a mail", func(t *testing.T) {
+		db := fake.Db{
+			GetRequestFake: func(binId, requestId int64) (models.Request, error) {
+				return generateRequest(), nil
+			},
+		}
+		services := New(&Deps{Db: &db})
+
+		_, err := services.GetMailMessage(context.Background(), 1, 2)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+}
+
+func Test_DiffRequests(t *testing.T) {
+	request := func(headers map[string][]string, body string) models.Request {
+		request := models.Request{Method: "POST", RequestUri: "/bin/1/hooks", Body: body}
+		request.SetHeaders(headers)
+		return request
+	}
+	requests := map[int64]models.Request{
+		1: request(map[string][]string{
+			"Content-Type": {"application/json"},
+			"X-Signature":  {"v1=aa"},
+			"X-Retry":      {"0"},
+		}, `{"event": "order.paid", "amount": 10, "items": [{"sku": "a"}, {"sku": "b"}], "meta": {"a/b": 1}}`),
+		2: request(map[string][]string{
+			"Content-Type": {"application/json"},
+			"X-Signature":  {"v2=bb"},
+			"X-Version":    {"2"},
+		}, `{"event": "order.paid", "amount": 10.0, "items": [{"sku": "a", "qty": 1}], "meta": null}`),
+		3: request(map[string][]string{}, "event=order.paid"),
+	}
+	db := fake.Db{
+		GetRequestFake: func(binId, requestId int64) (models.Request, error) {
+			request, ok := requests[requestId]
+			if !ok {
+				return models.Request{}, fmt.Errorf("request %d: %w", requestId, models.ErrNotFound)
+			}
+			request.Bin, request.Id = binId, requestId
+			return request, nil
+		},
+	}
+	services := New(&Deps{Db: &db})
+
+	t.Run("json bodies", func(t *testing.T) {
+		diff, err := services.DiffRequests(context.Background(), 1, 1, 2, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), diff.From.Bin)
+		assert.Equal(t, int64(2), diff.To.Id)
+		assert.Equal(t, []models.HeaderChange{
+			{Name: "X-Retry", Change: models.Removed, From: []string{"0"}},
+			{Name: "X-Signature", Change: models.Changed, From: []string{"v1=aa"}, To: []string{"v2=bb"}},
+			{Name: "X-Version", Change: models.Added, To: []string{"2"}},
+		}, diff.Headers)
+		assert.True(t, diff.Body.JSON)
+		assert.False(t, diff.Body.Equal)
+		assert.Equal(t, []models.JSONChange{
+			{Path: "/amount", Change: models.Changed, From: json.Number("10"), To: json.Number("10.0")},
+			{Path: "/items/0/qty", Change: models.Added, To: json.Number("1")},
+			{Path: "/items/1", Change: models.Removed, From: map[string]any{"sku": "b"}},
+			{Path: "/meta", Change: models.Changed, From: map[string]any{"a/b": json.Number("1")}},
+		}, diff.Body.Changes)
+	})
+
+	t.Run("same request", func(t *testing.T) {
+		diff, err := services.DiffRequests(context.Background(), 1, 1, 1, 1)
+		assert.NoError(t, err)
+		assert.Empty(t, diff.Headers)
+		assert.True(t, diff.Body.Equal)
+		assert.Empty(t, diff.Body.Changes)
+	})
+
+	t.Run("text body", func(t *testing.T) {
+		diff, err := services.DiffRequests(context.Background(), 1, 1, 1, 3)
+		assert.NoError(t, err)
+		assert.False(t, diff.Body.JSON)
+		assert.False(t, diff.Body.Equal)
+		assert.Empty(t, diff.Body.Changes)
+		assert.Len(t, diff.Headers, 3)
+	})
+
+	t.Run("missing request", func(t *testing.T) {
+		_, err := services.DiffRequests(context.Background(), 1, 1, 2, 4)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		_, err = services.DiffRequests(context.Background(), 1, 1, 0, 2)
+		var validationErr ValidationError
+		assert.ErrorAs(t, err, &validationErr)
+	})
+}
+
+func Test_diffBodies(t *testing.T) {
+	diff := diffBodies(`{"list": [`+strings.Repeat("1,", maxDiffChanges+10)+`1]}`, `{"list": []}`)
+	assert.True(t, diff.Truncated)
+	assert.Len(t, diff.Changes, maxDiffChanges)
+	assert.Equal(t, "/list/0", diff.Changes[0].Path)
+
+	diff = diffBodies(`{"a": 1} trailing`, `{"a": 1}`)
+	assert.False(t, diff.JSON)
+
+	diff = diffBodies(`[1]`, `{"0": 1}`)
+	assert.Equal(t, []models.JSONChange{{Path: "", Change: models.Changed, From: []any{json.Number("1")}, To: map[string]any{"0": json.Number("1")}}}, diff.Changes)
+}
+
+const testOpenAPI = `{
+	"openapi": "3.1.0",
+	"paths": {
+		"/orders": {
+			"post": {
+				"operationId": "createOrder",
+				"requestBody": {"$ref": "#/components/requestBodies/Order"}
+			}
+		},
+		"/orders/{id}": {
+			"patch": {
+				"requestBody": {"content": {"application/merge-patch+json": {"schema": {"type": "object", "maxProperties": 1}}}}
+			}
+		}
+	},
+	"components": {
+		"requestBodies": {
+			"Order": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Order"}}}}
+		},
+		"schemas": {
+			"Order": {
+				"type": "object",
+				"required": ["id", "currency"],
+				"properties": {
+					"id": {"type": "integer"},
+					"items": {"type": "array", "items": {"properties": {"qty": {"minimum": 1}}}}
+				}
+			}
+		}
+	}
+}`
+
+func Test_SetBinSchema(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		for name, schema := range map[string]models.BinSchema{
+			"json schema":        {Schema: []byte(`{"type": "object", "required": ["event"]}`)},
+			"operation id":       {OpenAPI: []byte(testOpenAPI), Operation: "createOrder"},
+			"method and path":    {OpenAPI: []byte(testOpenAPI), Operation: "PATCH /orders/{id}"},
+			"no more validation": {},
+		} {
+			t.Run(name, func(t *testing.T) {
+				db := fake.Db{
+					GetBinFake: func(binId int64) (models.Bin, error) {
+						return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+					},
+					SetBinSchemaFake: func(binId int64, got models.BinSchema) error {
+						assert.Equal(t, int64(1), binId)
+						assert.Equal(t, schema, got)
+						return nil
+					},
+				}
+				services := New(&Deps{
+					Db: &db,
+				})
+
+				err := services.SetBinSchema(context.Background(), TokenOwner("token"), 1, schema)
+				assert.NoError(t, err)
+				db.VerifyCallCounts(t, &fake.Db{
+					CountOfGetBin:       1,
+					CountOfSetBinSchema: 1,
+				})
+			})
+		}
+	})
+	t.Run("invalid schema", func(t *testing.T) {
+		for name, schema := range map[string]models.BinSchema{
+			"not json":               {Schema: []byte(`{"type": `)},
+			"not a schema":           {Schema: []byte(`{"type": "nope"}`)},
+			"file reference":         {Schema: []byte(`{"$ref": "file:///etc/passwd"}`)},
+			"remote reference":       {Schema: []byte(`{"$ref": "https://example.com/order.json"}`)},
+			"schema and openapi":     {Schema: []byte(`{}`), OpenAPI: []byte(testOpenAPI), Operation: "createOrder"},
+			"openapi without op":     {OpenAPI: []byte(testOpenAPI)},
+			"op without openapi":     {Operation: "createOrder"},
+			"unknown operation":      {OpenAPI: []byte(testOpenAPI), Operation: "deleteOrder"},
+			"operation without body": {OpenAPI: []byte(`{"paths": {"/orders": {"get": {"operationId": "listOrders"}}}}`), Operation: "listOrders"},
+			"too large":              {Schema: []byte(`{"description": "` + strings.Repeat("a", MaxSchemaSize) + `"}`)},
+		} {
+			t.Run(name, func(t *testing.T) {
+				db := fake.Db{
+					GetBinFake: func(binId int64) (models.Bin, error) {
+						return models.Bin{BinId: binId}, nil
+					},
+				}
+				services := New(&Deps{
+					Db: &db,
+				})
+
+				err := services.SetBinSchema(context.Background(), "", 1, schema)
+				assert.ErrorAs(t, err, new(ValidationError))
+				db.VerifyCallCounts(t, &fake.Db{
+					CountOfGetBin: 1,
+				})
+			})
+		}
+	})
+	t.Run("bin of another owner", func(t *testing.T) {
+		db := fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId, Owner: TokenOwner("token")}, nil
+			},
+		}
+		services := New(&Deps{
+			Db: &db,
+		})
+
+		err := services.SetBinSchema(context.Background(), TokenOwner("other"), 1, models.BinSchema{Schema: []byte(`{}`)})
+		assert.ErrorIs(t, err, ErrForbidden)
+		db.VerifyCallCounts(t, &fake.Db{
+			CountOfGetBin: 1,
+		})
+	})
+}
+
+func Test_LogRequestValidation(t *testing.T) {
+	jsonSchema := models.BinSchema{Schema: []byte(`{
+		"type": "object",
+		"required": ["event"],
+		"properties": {"event": {"enum": ["order.created"]}, "amount": {"type": "number"}}
+	}`)}
+	openAPI := models.BinSchema{OpenAPI: []byte(testOpenAPI), Operation: "POST /orders"}
+
+	for name, tc := range map[string]struct {
+		schema     models.BinSchema
+		body       string
+		validation string
+		errors     []models.SchemaError
+	}{
+		"passed": {jsonSchema, `{"event": "order.created", "amount": 12.5}`, models.Passed, nil},
+		"failed": {jsonSchema, `{"event": "order.deleted", "amount": "12"}`, models.Failed, []models.SchemaError{
+			{Path: "/amount", Keyword: "/properties/amount/type", Message: "got string, want number"},
+			{Path: "/event", Keyword: "/properties/event/enum", Message: "value must be 'order.created'"},
+		}},
+		"not json": {jsonSchema, `event=order.created`, models.Failed, nil},
+		"openapi": {openAPI, `{"id": "7", "items": [{"qty": 0}]}`, models.Failed, []models.SchemaError{
+			{Keyword: "/$ref/required", Message: "missing property 'currency'"},
+			{Path: "/id", Keyword: "/$ref/properties/id/type", Message: "got string, want integer"},
+			{Path: "/items/0/qty", Keyword: "/$ref/properties/items/items/properties/qty/minimum", Message: "minimum: got 0, want 1"},
+		}},
+		"no schema":  {models.BinSchema{}, `{"event": 1}`, "", nil},
+		"empty body": {jsonSchema, "", "", nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			request := generateRequest()
+			request.Body = tc.body
+
+			var captured models.Request
+			db := fake.Db{
+				GetRulesFake: func(binId int64) ([]models.Rule, error) {
+					return nil, nil
+				},
+				GetBinSchemaFake: func(binId int64) (models.BinSchema, error) {
+					assert.Equal(t, request.Bin, binId)
+					return tc.schema, nil
+				},
+				InsertRequestFake: func(request models.Request) (int64, error) {
+					captured = request
+					return 1, nil
+				},
+			}
+			services := New(&Deps{
+				Db: &db,
+			})
+
+			response, err := services.LogRequest(context.Background(), request)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, response.Status)
+			assert.Equal(t, tc.validation, captured.Validation)
+			if name == "not json" {
+				if assert.Len(t, captured.ValidationErrors, 1) {
+					assert.Contains(t, captured.ValidationErrors[0].Message, "body is not JSON")
+				}
+			} else {
+				assert.ElementsMatch(t, tc.errors, captured.ValidationErrors)
+			}
+		})
+	}
+}
+
+func Test_binCache(t *testing.T) {
+	cache := newBinCache[int](2)
+	loads := 0
+	load := func(value int) func() (int, error) {
+		return func() (int, error) {
+			loads++
+			return value, nil
+		}
+	}
+
+	for binId := range int64(3) {
+		value, err := cache.load(binId, load(int(binId)))
+		assert.NoError(t, err)
+		assert.Equal(t, int(binId), value)
+	}
+	assert.Len(t, cache.values, 2)
+
+	// errors are not cached
+	_, err := cache.load(7, func() (int, error) { return 0, errors.New("error") })
+	assert.Error(t, err)
+	assert.NotContains(t, cache.values, int64(7))
+
+	cache.invalidate(2)
+	value, err := cache.load(2, load(4))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, value)
+	assert.Equal(t, 4, loads)
+}
+
+func Test_LogRequestValidationCache(t *testing.T) {
+	newServices := func() (*Services, *fake.Db, *models.Request) {
+		captured := &models.Request{}
+		db := &fake.Db{
+			GetBinFake: func(binId int64) (models.Bin, error) {
+				return models.Bin{BinId: binId}, nil
+			},
+			GetRulesFake: func(binId int64) ([]models.Rule, error) {
+				return nil, nil
+			},
+			GetBinSchemaFake: func(binId int64) (models.BinSchema, error) {
+				return models.BinSchema{Schema: []byte(`{"type": "object"}`)}, nil
+			},
+			InsertRequestFake: func(request models.Request) (int64, error) {
+				*captured = request
+				return 1, nil
+			},
+			SetBinSchemaFake: func(binId int64, schema models.BinSchema) error {
+				return nil
+			},
+			GetCapturePortsFake: func(binId int64) ([]models.CapturePort, error) {
+				return nil, nil
+			},
+			DeleteBinFake: func(binId int64) error {
+				return nil
+			},
+		}
+		return New(&Deps{Db: db}), db, captured
+	}
+	logRequest := func(t *testing.T, services *Services, captured *models.Request) {
+		request := generateRequest()
+		request.Body = `{"event": "order.created"}`
+		_, err := services.LogRequest(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Passed, captured.Validation)
+	}
+
+	t.Run("compiled once", func(t *testing.T) {
+		services, db, captured := newServices()
+		logRequest(t, services, captured)
+		logRequest(t, services, captured)
+		assert.Equal(t, 1, db.CountOfGetBinSchema)
+	})
+	t.Run("set schema invalidates", func(t *testing.T) {
+		services, db, captured := newServices()
+		logRequest(t, services, captured)
+		assert.NoError(t, services.SetBinSchema(context.Background(), "", 1, models.BinSchema{Schema: []byte(`{"type": "object"}`)}))
+		logRequest(t, services, captured)
+		assert.Equal(t, 2, db.CountOfGetBinSchema)
+	})
+	t.Run("deleted bin invalidates", func(t *testing.T) {
+		services, db, captured := newServices()
+		logRequest(t, services, captured)
+		assert.NoError(t, services.DeleteBin(context.Background(), "", 1))
+		logRequest(t, services, captured)
+		assert.Equal(t, 2, db.CountOfGetBinSchema)
+	})
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"app/internal/models"
+)
+
+// resumePageSize is how many missed requests are read at once when a
+// stream resumes.
+const resumePageSize = 100
+
+// streamBuffer is how many captured requests a subscriber may fall behind
+// by before it is disconnected, so a slow client never holds up captures.
+const streamBuffer = 64
+
+type subscribers struct {
+	mu     sync.Mutex
+	bins   map[int64]map[chan models.Request]struct{}
+	closed bool
+}
+
+func newSubscribers() *subscribers {
+	return &subscribers{bins: map[int64]map[chan models.Request]struct{}{}}
+}
+
+// add subscribes requests to a bin, unless the streams are closed.
+func (s *subscribers) add(binId int64, requests chan models.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.bins[binId] == nil {
+		s.bins[binId] = map[chan models.Request]struct{}{}
+	}
+	s.bins[binId][requests] = struct{}{}
+	return true
+}
+
+// remove unsubscribes requests from a bin and closes it, if it still was
+// subscribed.
+func (s *subscribers) remove(binId int64, requests chan models.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(binId, requests)
+}
+
+func (s *subscribers) removeLocked(binId int64, requests chan models.Request) {
+	if _, ok := s.bins[binId][requests]; !ok {
+		return
+	}
+	delete(s.bins[binId], requests)
+	if len(s.bins[binId]) == 0 {
+		delete(s.bins, binId)
+	}
+	close(requests)
+}
+
+func (s *subscribers) publish(request models.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for requests := range s.bins[request.Bin] {
+		select {
+		case requests <- request:
+		default:
+			// the subscriber has to reconnect to catch up
+			s.removeLocked(request.Bin, requests)
+		}
+	}
+}
+
+func (s *subscribers) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for binId, bin := range s.bins {
+		for requests := range bin {
+			s.removeLocked(binId, requests)
+		}
+	}
+}
+
+// NoResume is the lastId of streams which only return the requests captured
+// from now on, see StreamRequests.
+const NoResume = -1
+
+// StreamRequests returns the requests a bin captures from now on, until ctx
+// is done. A stream resuming after the request lastId, zero for a bin which
+// had none, first returns the requests captured since, unless lastId is
+// NoResume. The channel is closed early when the subscriber falls behind or
+// the streams are closed, see CloseStreams.
+func (s *Services) StreamRequests(ctx context.Context, binId, lastId int64) (<-chan models.Request, error) {
+	if err := BinIdValidation(binId); err != nil {
+		return nil, err
+	}
+	if lastId < NoResume {
+		return nil, ValidationError(fmt.Sprintf("invalid request id: %d", lastId))
+	}
+	if _, err := s.db.GetBin(ctx, binId); err != nil {
+		return nil, err
+	}
+
+	// subscribed before the missed requests are read, so none is lost in
+	// between
+	live := make(chan models.Request, streamBuffer)
+	if !s.subscribers.add(binId, live) {
+		close(live)
+		return live, nil
+	}
+	s.metrics.Waiters.Inc()
+	context.AfterFunc(ctx, func() {
+		s.subscribers.remove(binId, live)
+		s.metrics.Waiters.Dec()
+	})
+	if lastId == NoResume {
+		return live, nil
+	}
+
+	requests := make(chan models.Request)
+	go func() {
+		defer close(requests)
+		send := func(request models.Request) bool {
+			select {
+			case requests <- request:
+				lastId = request.Id
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			missed, err := s.db.GetRequestsAfter(ctx, binId, lastId, resumePageSize)
+			if err != nil {
+				slog.ErrorContext(ctx, "getting missed requests", "error", err)
+				return
+			}
+			for _, request := range missed {
+				if !send(request) {
+					return
+				}
+			}
+			if len(missed) < resumePageSize {
+				break
+			}
+		}
+		for request := range live {
+			// already sent as missed
+			if request.Id <= lastId {
+				continue
+			}
+			if !send(request) {
+				return
+			}
+		}
+	}()
+	return requests, nil
+}
+
+// CloseStreams ends the streams of requests, on shutdown, as their clients
+// would otherwise be waited for until the shutdown times out. WebSocket
+// sessions, which the server no longer tracks once upgraded, are closed
+// too, and waited for until ctx is done.
+func (s *Services) CloseStreams(ctx context.Context) {
+	s.subscribers.closeAll()
+	s.sessions.closeAll(ctx)
+}
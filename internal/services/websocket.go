@@ -0,0 +1,305 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"app/internal/metrics"
+	"app/internal/models"
+)
+
+// Bounds of the WebSocket sessions captured.
+const (
+	// MaxWebSocketMessage is the size of the largest message received.
+	MaxWebSocketMessage = 1 << 20
+	// maxRecordedPayload is how much of a message is stored, its size is
+	// always kept.
+	maxRecordedPayload = 64 << 10
+	// maxSessionFrames is how many messages of a session are stored, the
+	// session is closed after.
+	maxSessionFrames     = 1000
+	maxOnConnectMessages = 10
+)
+
+// Directions and kinds of frames.
+const (
+	FrameIn  = "in"
+	FrameOut = "out"
+
+	FrameText   = "text"
+	FrameBinary = "binary"
+	FrameClose  = "close"
+)
+
+// ErrSessionFull is returned once a WebSocket session recorded as many
+// messages as a session may.
+var ErrSessionFull = errors.New("websocket session recorded too many messages")
+
+type webSocketSessions struct {
+	mu       sync.Mutex
+	sessions map[*WebSocketSession]struct{}
+	closed   bool
+	// open counts the sessions not yet ended, which closeAll waits for.
+	open sync.WaitGroup
+}
+
+func newWebSocketSessions() *webSocketSessions {
+	return &webSocketSessions{sessions: map[*WebSocketSession]struct{}{}}
+}
+
+// add registers a session, unless the sessions are closed.
+func (s *webSocketSessions) add(session *WebSocketSession) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.sessions[session] = struct{}{}
+	s.open.Add(1)
+	return true
+}
+
+func (s *webSocketSessions) remove(session *WebSocketSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[session]; !ok {
+		return
+	}
+	delete(s.sessions, session)
+	s.open.Done()
+}
+
+// closeAll closes the sessions and waits for them to end, so their closes
+// are recorded, until ctx is done.
+func (s *webSocketSessions) closeAll(ctx context.Context) {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		for session := range s.sessions {
+			close(session.done)
+		}
+	}
+	s.mu.Unlock()
+
+	ended := make(chan struct{})
+	go func() {
+		s.open.Wait()
+		close(ended)
+	}()
+	select {
+	case <-ended:
+	case <-ctx.Done():
+		slog.WarnContext(ctx, "websocket sessions not ended on shutdown", "error", ctx.Err())
+	}
+}
+
+// WebSocketSession records the messages of a WebSocket session and answers
+// them as the script of its bin says. Its methods are called by a single
+// goroutine.
+type WebSocketSession struct {
+	services *Services
+	request  models.Request
+	script   models.WebSocketScript
+	reply    *template.Template
+	data     templateData
+	frames   int
+	done     chan struct{}
+}
+
+// OpenWebSocket captures the handshake of a WebSocket session and returns
+// the session, to be answered and ended once the connection closes.
+func (s *Services) OpenWebSocket(ctx context.Context, request models.Request) (*WebSocketSession, error) {
+	if err := BinIdValidation(request.Bin); err != nil {
+		return nil, err
+	}
+	request.ClientIp = clientIp(request, s.trustedProxies)
+
+	script, err := s.db.GetWebSocketScript(ctx, request.Bin)
+	if err != nil {
+		return nil, err
+	}
+	session := &WebSocketSession{
+		services: s,
+		script:   script,
+		done:     make(chan struct{}),
+	}
+	if script.Reply != "" {
+		if session.reply, err = parseResponseTemplate("reply", script.Reply); err != nil {
+			return nil, err
+		}
+	}
+
+	session.request, err = s.captureRequest(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	slog.DebugContext(ctx, "websocket session captured", "request", session.request)
+	if session.data, err = newTemplateData(session.request); err != nil {
+		return nil, err
+	}
+
+	if !s.sessions.add(session) {
+		// shutting down, the session ends right away
+		close(session.done)
+	}
+	s.metrics.WebSocketSessions.Inc()
+	return session, nil
+}
+
+// Request is the captured handshake of the session.
+func (ws *WebSocketSession) Request() models.Request {
+	return ws.request
+}
+
+// Done is closed when the server shuts down, see CloseStreams.
+func (ws *WebSocketSession) Done() <-chan struct{} {
+	return ws.done
+}
+
+// Open returns the messages sent once the session opens, recorded.
+func (ws *WebSocketSession) Open(ctx context.Context) ([]models.Frame, error) {
+	var frames []models.Frame
+	for _, message := range ws.script.OnConnect {
+		frame, err := ws.record(ctx, FrameOut, FrameText, []byte(message))
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// Receive records a message of the client and returns the replies to send,
+// recorded too.
+func (ws *WebSocketSession) Receive(ctx context.Context, kind string, message []byte) ([]models.Frame, error) {
+	if _, err := ws.record(ctx, FrameIn, kind, message); err != nil {
+		return nil, err
+	}
+
+	var replies []models.Frame
+	if ws.script.Echo {
+		frame, err := ws.record(ctx, FrameOut, kind, message)
+		if err != nil {
+			return replies, err
+		}
+		replies = append(replies, frame)
+	}
+	// binary messages would not render as text
+	if ws.reply != nil && kind == FrameText {
+		data := ws.data
+		data.Message = string(message)
+		reply, err := executeTemplate(ws.reply, data)
+		if err != nil {
+			// the session goes on without the reply
+			slog.WarnContext(ctx, "rendering websocket reply", "bin", ws.request.Bin, "request", ws.request.Id, "error", err)
+			return replies, nil
+		}
+		frame, err := ws.record(ctx, FrameOut, FrameText, []byte(reply))
+		if err != nil {
+			return replies, err
+		}
+		replies = append(replies, frame)
+	}
+	return replies, nil
+}
+
+// Closed records the close of the session by either side, with the status
+// code and reason given.
+func (ws *WebSocketSession) Closed(ctx context.Context, direction string, code int, reason string) error {
+	var payload []byte
+	if code != 0 {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(code))
+	}
+	payload = append(payload, reason...)
+	_, err := ws.record(ctx, direction, FrameClose, payload)
+	return err
+}
+
+// Answered counts the captured handshake with the status it was answered
+// with, 0 when the connection failed before any was sent.
+func (ws *WebSocketSession) Answered(status int) {
+	label := "none"
+	if status != 0 {
+		label = strconv.Itoa(status)
+	}
+	ws.services.metrics.RequestsCaptured.Inc(metrics.MethodLabel(ws.request.Method), label)
+}
+
+// End stops tracking the session, once its connection closed.
+func (ws *WebSocketSession) End() {
+	ws.services.sessions.remove(ws)
+	ws.services.metrics.WebSocketSessions.Dec()
+}
+
+// record stores a frame of the session, unless it recorded the most it may.
+func (ws *WebSocketSession) record(ctx context.Context, direction, kind string, message []byte) (models.Frame, error) {
+	if ws.frames >= maxSessionFrames {
+		return models.Frame{}, ErrSessionFull
+	}
+	ws.frames++
+
+	frame := models.Frame{
+		Request:    ws.request.Id,
+		ReceivedAt: time.Now(),
+		Direction:  direction,
+		Kind:       kind,
+		Size:       len(message),
+		Payload:    message[:min(len(message), maxRecordedPayload)],
+	}
+	id, err := ws.services.db.InsertFrame(ctx, frame)
+	if err != nil {
+		return models.Frame{}, err
+	}
+	frame.Id = id
+	// replies are sent whole
+	frame.Payload = message
+	return frame, nil
+}
+
+// GetFrames returns the messages of the WebSocket session captured as a
+// request of a bin, in the order they were sent.
+func (s *Services) GetFrames(ctx context.Context, binId, requestId int64) ([]models.Frame, error) {
+	if err := BinIdValidation(binId); err != nil {
+		return nil, err
+	}
+	if requestId <= 0 {
+		return nil, ValidationError(fmt.Sprintf("invalid request id: %d", requestId))
+	}
+
+	return s.db.GetFrames(ctx, binId, requestId)
+}
+
+// SetWebSocketScript replaces how a bin the caller may change answers the
+// WebSocket sessions it captures.
+func (s *Services) SetWebSocketScript(ctx context.Context, caller string, binId int64, script models.WebSocketScript) error {
+	if err := s.authorizeBin(ctx, caller, binId); err != nil {
+		return err
+	}
+	if len(script.OnConnect) > maxOnConnectMessages {
+		return ValidationError(fmt.Sprintf("at most %d messages are sent on connect", maxOnConnectMessages))
+	}
+	for i, message := range script.OnConnect {
+		if len(message) > MaxWebSocketMessage {
+			return ValidationError(fmt.Sprintf("message %d sent on connect exceeds %d bytes", i, MaxWebSocketMessage))
+		}
+	}
+	if _, err := parseResponseTemplate("reply", script.Reply); err != nil {
+		return ValidationError(err.Error())
+	}
+
+	return s.db.SetWebSocketScript(ctx, binId, script)
+}
+
+func (s *Services) GetWebSocketScript(ctx context.Context, binId int64) (models.WebSocketScript, error) {
+	if err := BinIdValidation(binId); err != nil {
+		return models.WebSocketScript{}, err
+	}
+	return s.db.GetWebSocketScript(ctx, binId)
+}
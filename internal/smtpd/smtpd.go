@@ -0,0 +1,529 @@
+// Package smtpd receives mail over SMTP, and captures each mail as a
+// request of the bins it is sent to, <bin>@<domain>.
+package smtpd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"app/internal/models"
+)
+
+// ErrServerClosed is returned by Serve once the server is shut down.
+var ErrServerClosed = errors.New("smtpd: server closed")
+
+// Capture stores a mail sent to a bin. Mails failing to be captured by all
+// of their bins are refused with a temporary error, for their sender to
+// retry.
+type Capture = func(ctx context.Context, request models.Request) error
+
+type Options struct {
+	// Addr is the address the server listens on.
+	Addr string
+	// Domain of the addresses of bins, also greeting clients.
+	Domain string
+	// MaxMessageSize bounds the size of mails, as announced to clients.
+	MaxMessageSize int
+	// MaxRecipients bounds the recipients of a mail.
+	MaxRecipients int
+	// Timeout closes connections waiting that long for a command, or the
+	// next line of a mail.
+	Timeout time.Duration
+	// MaxConnections are open at once, those beyond it are refused.
+	MaxConnections int
+	// Accept, when set, checks that a bin accepts mails. Recipients whose
+	// bin is not found, see models.ErrNotFound, are refused, and others
+	// failing the check deferred.
+	Accept func(ctx context.Context, binId int64) error
+}
+
+// DefaultOptions are the options used in place of zero ones.
+var DefaultOptions = Options{
+	Domain:         "localhost",
+	MaxMessageSize: 10 << 20,
+	MaxRecipients:  100,
+	Timeout:        5 * time.Minute,
+	MaxConnections: 100,
+}
+
+// maxLine bounds the length of command lines, 512 bytes by RFC 5321 but
+// longer with extension parameters.
+const maxLine = 4 << 10
+
+type Server struct {
+	options Options
+	capture Capture
+	// open counts the connections being served, bounded by MaxConnections
+	open chan struct{}
+
+	mu       sync.Mutex
+	listener net.Listener
+	// conns are the connections being served, set while receiving a mail
+	conns   map[net.Conn]bool
+	closed  bool
+	serving sync.WaitGroup
+}
+
+func New(options Options, capture Capture) *Server {
+	if options.Domain == "" {
+		options.Domain = DefaultOptions.Domain
+	}
+	if options.MaxMessageSize <= 0 {
+		options.MaxMessageSize = DefaultOptions.MaxMessageSize
+	}
+	if options.MaxRecipients <= 0 {
+		options.MaxRecipients = DefaultOptions.MaxRecipients
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultOptions.Timeout
+	}
+	if options.MaxConnections <= 0 {
+		options.MaxConnections = DefaultOptions.MaxConnections
+	}
+	return &Server{
+		options: options,
+		capture: capture,
+		open:    make(chan struct{}, options.MaxConnections),
+		conns:   map[net.Conn]bool{},
+	}
+}
+
+// ListenAndServe listens on Addr and serves until the server is shut down.
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.options.Addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("serving smtp", "addr", listener.Addr().String(), "domain", s.options.Domain)
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on the listener until the server is shut down,
+// returning ErrServerClosed then.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		select {
+		case s.open <- struct{}{}:
+		default:
+			conn.SetWriteDeadline(time.Now().Add(time.Second))
+			fmt.Fprintf(conn, "421 4.3.2 %s too many connections, try again later\r\n", s.options.Domain)
+			conn.Close()
+			continue
+		}
+		if !s.track(conn) {
+			<-s.open
+			conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.serving.Done()
+			defer func() { <-s.open }()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// track registers a connection to close on Shutdown, unless shut down
+// already.
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = false
+	s.serving.Add(1)
+	return true
+}
+
+// startData marks a connection as receiving a mail, which Shutdown waits
+// for, unless shut down already.
+func (s *Server) startData(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = true
+	return true
+}
+
+// endData marks a connection as done receiving a mail, and reports whether
+// its session goes on, unless shut down meanwhile.
+func (s *Server) endData(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = false
+	return !s.closed
+}
+
+// Shutdown stops accepting connections, closes those waiting for a command
+// and waits for the mails being received until ctx is done, when the
+// remaining connections are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for conn, receiving := range s.conns {
+		if !receiving {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.serving.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+// session is the state of an SMTP connection.
+type session struct {
+	server *Server
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+
+	from string
+	// to are the recipients of the mail, bins the bins they address
+	to   []string
+	bins []int64
+	// mailing is set once MAIL FROM starts a mail
+	mailing bool
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	session := &session{
+		server: s,
+		conn:   conn,
+		reader: bufio.NewReaderSize(conn, maxLine),
+		writer: bufio.NewWriter(conn),
+	}
+	session.reply(220, "%s ESMTP httpbin", s.options.Domain)
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.options.Timeout))
+		line, err := session.reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			session.reply(500, "5.5.6 line too long")
+			return
+		}
+		if err != nil {
+			return
+		}
+		verb, args, _ := strings.Cut(strings.TrimRight(string(line), "\r\n"), " ")
+		if !session.handle(strings.ToUpper(verb), strings.TrimSpace(args)) {
+			return
+		}
+	}
+}
+
+// handle answers a command, and reports whether the session goes on.
+func (s *session) handle(verb, args string) bool {
+	// greetings carry no state, mails are told apart by their headers
+	switch verb {
+	case "HELO":
+		s.reset()
+		s.reply(250, "%s", s.server.options.Domain)
+	case "EHLO":
+		s.reset()
+		s.reply(250, "%s\n8BITMIME\nPIPELINING\nSIZE %d", s.server.options.Domain, s.server.options.MaxMessageSize)
+	case "MAIL":
+		s.mail(args)
+	case "RCPT":
+		s.rcpt(args)
+	case "DATA":
+		return s.data()
+	case "RSET":
+		s.reset()
+		s.reply(250, "2.0.0 OK")
+	case "NOOP":
+		s.reply(250, "2.0.0 OK")
+	case "VRFY":
+		s.reply(252, "2.1.5 mail <bin>@%s", s.server.options.Domain)
+	case "QUIT":
+		s.reply(221, "2.0.0 %s closing connection", s.server.options.Domain)
+		return false
+	default:
+		s.reply(502, "5.5.2 command not recognized")
+	}
+	return true
+}
+
+func (s *session) reset() {
+	s.from = ""
+	s.to = nil
+	s.bins = nil
+	s.mailing = false
+}
+
+func (s *session) mail(args string) {
+	if s.mailing {
+		s.reply(503, "5.5.1 mail already started")
+		return
+	}
+	from, params, ok := parsePath(args, "FROM:")
+	if !ok {
+		s.reply(501, "5.5.4 syntax: MAIL FROM:<address>")
+		return
+	}
+	for _, param := range params {
+		name, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(name, "SIZE") {
+			if size, err := strconv.Atoi(value); err == nil && size > s.server.options.MaxMessageSize {
+				s.reply(552, "5.3.4 message exceeds %d bytes", s.server.options.MaxMessageSize)
+				return
+			}
+		}
+	}
+	s.from = from
+	s.mailing = true
+	s.reply(250, "2.1.0 OK")
+}
+
+func (s *session) rcpt(args string) {
+	if !s.mailing {
+		s.reply(503, "5.5.1 MAIL first")
+		return
+	}
+	to, _, ok := parsePath(args, "TO:")
+	if !ok {
+		s.reply(501, "5.5.4 syntax: RCPT TO:<address>")
+		return
+	}
+	if len(s.to) >= s.server.options.MaxRecipients {
+		s.reply(452, "4.5.3 too many recipients")
+		return
+	}
+	bin, ok := s.server.binOf(to)
+	if !ok {
+		s.reply(550, "5.1.1 <%s> is not a bin, mail <bin>@%s", to, s.server.options.Domain)
+		return
+	}
+	if accept := s.server.options.Accept; accept != nil {
+		err := accept(context.Background(), bin)
+		if errors.Is(err, models.ErrNotFound) {
+			s.reply(550, "5.1.1 <%s> no such bin", to)
+			return
+		}
+		if err != nil {
+			slog.Warn("accepting mail", "bin", bin, "error", err)
+			s.reply(451, "4.3.0 <%s> try again later", to)
+			return
+		}
+	}
+	s.to = append(s.to, to)
+	if !slices.Contains(s.bins, bin) {
+		s.bins = append(s.bins, bin)
+	}
+	s.reply(250, "2.1.5 OK")
+}
+
+// data reads the mail and captures it, and reports whether the session goes
+// on.
+func (s *session) data() bool {
+	if len(s.to) == 0 {
+		s.reply(503, "5.5.1 RCPT first")
+		return true
+	}
+	if !s.server.startData(s.conn) {
+		s.reply(421, "4.3.2 %s shutting down", s.server.options.Domain)
+		return false
+	}
+	s.reply(354, "end data with <CR><LF>.<CR><LF>")
+
+	received := s.receiveMail()
+	if !s.server.endData(s.conn) {
+		if received {
+			s.reply(421, "4.3.2 %s shutting down", s.server.options.Domain)
+		}
+		return false
+	}
+	return received
+}
+
+// receiveMail reads and captures a mail, and reports whether the session
+// goes on.
+func (s *session) receiveMail() bool {
+	receivedAt := time.Now()
+	message, tooLarge, err := s.readData()
+	if err != nil {
+		return false
+	}
+	if tooLarge {
+		s.reply(552, "5.3.4 message exceeds %d bytes", s.server.options.MaxMessageSize)
+		s.reset()
+		return true
+	}
+
+	if err := s.captureMail(receivedAt, message); err != nil {
+		s.reply(451, "4.3.0 mail not captured: %s", err.Error())
+	} else {
+		s.reply(250, "2.0.0 OK captured")
+	}
+	s.reset()
+	return true
+}
+
+// readData reads a mail up to the line holding a single dot, unstuffing the
+// dots doubled at the start of lines. Mails over MaxMessageSize are read to
+// their end without being kept, and reported too large.
+func (s *session) readData() ([]byte, bool, error) {
+	var message bytes.Buffer
+	tooLarge := false
+	lineStart := true
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(s.server.options.Timeout))
+		// lines longer than the buffer are read in several chunks
+		chunk, err := s.reader.ReadSlice('\n')
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, false, err
+		}
+		if lineStart {
+			if string(chunk) == ".\r\n" || string(chunk) == ".\n" {
+				return message.Bytes(), tooLarge, nil
+			}
+			chunk = bytes.TrimPrefix(chunk, []byte("."))
+		}
+		lineStart = err == nil
+
+		if message.Len()+len(chunk) > s.server.options.MaxMessageSize {
+			tooLarge = true
+			message.Reset()
+		}
+		if !tooLarge {
+			message.Write(chunk)
+		}
+	}
+}
+
+// captureMail captures the mail in each of the bins it is sent to, failing
+// only when none captures it.
+func (s *session) captureMail(receivedAt time.Time, message []byte) error {
+	headers, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(message))).ReadMIMEHeader()
+	if headers == nil {
+		headers = textproto.MIMEHeader{}
+	}
+
+	var errs []error
+	for _, bin := range s.bins {
+		request := models.Request{
+			Bin:           bin,
+			RecievedAt:    receivedAt,
+			Host:          s.server.options.Domain,
+			RemoteAddr:    s.conn.RemoteAddr().String(),
+			RequestUri:    strconv.FormatInt(bin, 10) + "@" + s.server.options.Domain,
+			Method:        "MAIL",
+			Proto:         models.SMTP,
+			Body:          string(message),
+			ContentLength: int64(len(message)),
+			MailFrom:      s.from,
+			MailTo:        s.to,
+		}
+		request.SetHeaders(headers)
+		if err := s.server.capture(context.Background(), request); err != nil {
+			slog.Warn("capturing mail", "bin", bin, "error", err)
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(s.bins) {
+		return errs[0]
+	}
+	return nil
+}
+
+// binOf returns the bin a recipient address is of, <bin>@<Domain>.
+func (s *Server) binOf(address string) (int64, bool) {
+	local, domain, found := strings.Cut(address, "@")
+	if !found || !strings.EqualFold(domain, s.options.Domain) {
+		return 0, false
+	}
+	bin, err := strconv.ParseInt(local, 10, 64)
+	if err != nil || bin <= 0 {
+		return 0, false
+	}
+	return bin, true
+}
+
+// parsePath parses the arguments of MAIL and RCPT, the address between
+// angle brackets after prefix, followed by parameters.
+func parsePath(args, prefix string) (string, []string, bool) {
+	if len(args) < len(prefix) || !strings.EqualFold(args[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	path := strings.TrimSpace(args[len(prefix):])
+	if !strings.HasPrefix(path, "<") {
+		return "", nil, false
+	}
+	address, params, found := strings.Cut(path[1:], ">")
+	if !found {
+		return "", nil, false
+	}
+	return address, strings.Fields(params), true
+}
+
+// reply writes a reply, each line of text being a line of the reply.
+func (s *session) reply(code int, format string, args ...any) {
+	lines := strings.Split(fmt.Sprintf(format, args...), "\n")
+	s.conn.SetWriteDeadline(time.Now().Add(s.server.options.Timeout))
+	for i, line := range lines {
+		separator := "-"
+		if i == len(lines)-1 {
+			separator = " "
+		}
+		s.writer.WriteString(strconv.Itoa(code) + separator + line + "\r\n")
+	}
+	s.writer.Flush()
+}
@@ -0,0 +1,269 @@
+package smtpd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"app/internal/models"
+)
+
+type captured struct {
+	mu       sync.Mutex
+	requests []models.Request
+	// err fails the captures of bin 2
+	err error
+}
+
+func (c *captured) capture(ctx context.Context, request models.Request) error {
+	if request.Bin == 2 && c.err != nil {
+		return c.err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, request)
+	return nil
+}
+
+func serve(t *testing.T, options Options) (string, *captured) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	captured := &captured{}
+	options.Domain = "bins.example.com"
+	server := New(options, captured.capture)
+	go server.Serve(listener)
+	t.Cleanup(func() {
+		server.Shutdown(context.Background())
+	})
+	return listener.Addr().String(), captured
+}
+
+const message = "From: Shop <shop@example.com>\r\n" +
+	"To: 1@bins.example.com\r\n" +
+	"Subject: Order shipped\r\n" +
+	"\r\n" +
+	"On its way.\r\n" +
+	".leading dot\r\n"
+
+func Test_Server(t *testing.T) {
+	t.Run("mail to bins", func(t *testing.T) {
+		addr, captured := serve(t, Options{})
+
+		err := smtp.SendMail(addr, nil, "shop@example.com", []string{"1@bins.example.com", "3@BINS.example.com", "1@bins.example.com"}, []byte(message))
+		assert.NoError(t, err)
+
+		if assert.Len(t, captured.requests, 2) {
+			request := captured.requests[0]
+			assert.Equal(t, int64(1), request.Bin)
+			assert.Equal(t, "MAIL", request.Method)
+			assert.Equal(t, models.SMTP, request.Proto)
+			assert.Equal(t, "1@bins.example.com", request.RequestUri)
+			assert.Equal(t, "bins.example.com", request.Host)
+			assert.Equal(t, "shop@example.com", request.MailFrom)
+			assert.Equal(t, []string{"1@bins.example.com", "3@BINS.example.com", "1@bins.example.com"}, request.MailTo)
+			assert.Equal(t, message, request.Body)
+			assert.Equal(t, int64(len(message)), request.ContentLength)
+			assert.NotEmpty(t, request.RemoteAddr)
+			headers, err := request.GetHeaders()
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"Order shipped"}, headers["Subject"])
+
+			assert.Equal(t, int64(3), captured.requests[1].Bin)
+		}
+	})
+
+	t.Run("unknown recipient", func(t *testing.T) {
+		addr, captured := serve(t, Options{})
+
+		for _, to := range []string{"someone@bins.example.com", "1@example.com", "0@bins.example.com"} {
+			err := smtp.SendMail(addr, nil, "shop@example.com", []string{to}, []byte(message))
+			var smtpErr *textproto.Error
+			if assert.ErrorAs(t, err, &smtpErr, to) {
+				assert.Equal(t, 550, smtpErr.Code)
+			}
+		}
+		assert.Empty(t, captured.requests)
+	})
+
+	t.Run("missing bin", func(t *testing.T) {
+		addr, captured := serve(t, Options{
+			Accept: func(ctx context.Context, binId int64) error {
+				switch binId {
+				case 1:
+					return nil
+				case 2:
+					return errors.New("database is locked")
+				}
+				return models.ErrNotFound
+			},
+		})
+
+		for to, code := range map[string]int{"2@bins.example.com": 451, "3@bins.example.com": 550} {
+			err := smtp.SendMail(addr, nil, "shop@example.com", []string{to}, []byte(message))
+			var smtpErr *textproto.Error
+			if assert.ErrorAs(t, err, &smtpErr, to) {
+				assert.Equal(t, code, smtpErr.Code, to)
+			}
+		}
+		err := smtp.SendMail(addr, nil, "shop@example.com", []string{"1@bins.example.com"}, []byte(message))
+		assert.NoError(t, err)
+		assert.Len(t, captured.requests, 1)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		addr, captured := serve(t, Options{MaxMessageSize: 64})
+
+		err := smtp.SendMail(addr, nil, "shop@example.com", []string{"1@bins.example.com"}, []byte(message+strings.Repeat("x", 64)))
+		var smtpErr *textproto.Error
+		if assert.ErrorAs(t, err, &smtpErr) {
+			assert.Equal(t, 552, smtpErr.Code)
+		}
+		assert.Empty(t, captured.requests)
+	})
+
+	t.Run("not captured", func(t *testing.T) {
+		addr, captured := serve(t, Options{})
+		captured.err = errors.New("rate limit exceeded, retry in 1s")
+
+		// captured by one of the bins
+		err := smtp.SendMail(addr, nil, "shop@example.com", []string{"1@bins.example.com", "2@bins.example.com"}, []byte(message))
+		assert.NoError(t, err)
+		assert.Len(t, captured.requests, 1)
+
+		err = smtp.SendMail(addr, nil, "shop@example.com", []string{"2@bins.example.com"}, []byte(message))
+		var smtpErr *textproto.Error
+		if assert.ErrorAs(t, err, &smtpErr) {
+			assert.Equal(t, 451, smtpErr.Code)
+			assert.Contains(t, smtpErr.Msg, "rate limit exceeded")
+		}
+	})
+
+	t.Run("commands out of order", func(t *testing.T) {
+		addr, _ := serve(t, Options{})
+		conn, err := net.Dial("tcp", addr)
+		assert.NoError(t, err)
+		defer conn.Close()
+		client := textproto.NewConn(conn)
+
+		_, _, err = client.ReadResponse(220)
+		assert.NoError(t, err)
+		for _, exchange := range []struct {
+			command string
+			code    int
+		}{
+			{"HELO client", 250},
+			{"RCPT TO:<1@bins.example.com>", 503},
+			{"DATA", 503},
+			{"MAIL FROM:shop@example.com", 501},
+			{"MAIL FROM:<shop@example.com> SIZE=100", 250},
+			{"MAIL FROM:<shop@example.com>", 503},
+			{"RSET", 250},
+			{"STARTTLS", 502},
+			{"QUIT", 221},
+		} {
+			id, err := client.Cmd("%s", exchange.command)
+			assert.NoError(t, err)
+			client.StartResponse(id)
+			_, _, err = client.ReadResponse(exchange.code)
+			client.EndResponse(id)
+			assert.NoError(t, err, exchange.command)
+		}
+	})
+
+	t.Run("line too long", func(t *testing.T) {
+		addr, _ := serve(t, Options{})
+		conn, err := net.Dial("tcp", addr)
+		assert.NoError(t, err)
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reader.ReadString('\n')
+
+		conn.Write([]byte("HELO " + strings.Repeat("x", maxLine) + "\r\n"))
+		reply, _ := reader.ReadString('\n')
+		assert.True(t, strings.HasPrefix(reply, "500 "), reply)
+	})
+}
+
+func Test_Shutdown(t *testing.T) {
+	start := func(t *testing.T) (*Server, string, <-chan error) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		server := New(Options{}, func(context.Context, models.Request) error { return nil })
+		served := make(chan error, 1)
+		go func() {
+			served <- server.Serve(listener)
+		}()
+		return server, listener.Addr().String(), served
+	}
+	// dial returns a connection which sent the commands, and read their
+	// replies
+	dial := func(t *testing.T, addr string, commands ...string) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", addr)
+		assert.NoError(t, err)
+		reader := bufio.NewReader(conn)
+		reader.ReadString('\n')
+		for _, command := range commands {
+			conn.Write([]byte(command + "\r\n"))
+			reader.ReadString('\n')
+		}
+		return conn, reader
+	}
+
+	t.Run("idle connections are closed at once", func(t *testing.T) {
+		server, addr, served := start(t)
+		conn, reader := dial(t, addr, "HELO client.example.com")
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, <-served, ErrServerClosed)
+		_, err = reader.ReadString('\n')
+		assert.Error(t, err)
+	})
+
+	t.Run("mails being received are waited for", func(t *testing.T) {
+		server, addr, _ := start(t)
+		conn, reader := dial(t, addr, "HELO client.example.com", "MAIL FROM:<shop@example.com>", "RCPT TO:<1@localhost>", "DATA")
+		defer conn.Close()
+
+		shutdown := make(chan error, 1)
+		go func() {
+			shutdown <- server.Shutdown(context.Background())
+		}()
+		select {
+		case err := <-shutdown:
+			t.Fatalf("shutdown returned %v before the mail was received", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		conn.Write([]byte(message + ".\r\n"))
+		reply, _ := reader.ReadString('\n')
+		assert.True(t, strings.HasPrefix(reply, "250 "), reply)
+		reply, _ = reader.ReadString('\n')
+		assert.True(t, strings.HasPrefix(reply, "421 "), reply)
+		assert.NoError(t, <-shutdown)
+	})
+
+	t.Run("mails not received in time", func(t *testing.T) {
+		server, addr, _ := start(t)
+		conn, _ := dial(t, addr, "HELO client.example.com", "MAIL FROM:<shop@example.com>", "RCPT TO:<1@localhost>", "DATA")
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// selfSignedValidity is how long generated certificates are valid for.
+// Cached certificates are regenerated a day before they expire.
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// NewTLSConfig returns the TLS configuration of the HTTPS listener, serving
+// the supplied certificate or else a self-signed one.
+func NewTLSConfig(config Config) (*tls.Config, error) {
+	var certificate tls.Certificate
+	var err error
+	if config.TLSCertFile != "" {
+		certificate, err = tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+	} else {
+		certificate, err = selfSignedCertificate(config.TLSCacheDir, config.TLSHosts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	// without a CA, client certificates are accepted unverified so any
+	// sender's certificate can be inspected
+	switch config.TLSClientAuth {
+	case "request":
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	case "require":
+		tlsConfig.ClientAuth = tls.RequireAnyClientCert
+	}
+	if config.TLSClientCAFile != "" {
+		pemCerts, err := os.ReadFile(config.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("no certificates found in %s", config.TLSClientCAFile)
+		}
+		switch tlsConfig.ClientAuth {
+		case tls.RequestClientCert:
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		case tls.RequireAnyClientCert:
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// selfSignedCertificate returns the self-signed certificate for hosts cached
+// in dir, generating it when missing, expiring or issued for other hosts.
+func selfSignedCertificate(dir string, hosts []string) (tls.Certificate, error) {
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil && certificateCovers(certificate.Leaf, hosts) {
+		return certificate, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return tls.Certificate{}, err
+	}
+
+	certPEM, keyPEM, err := generateSelfSigned(hosts)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+func certificateCovers(leaf *x509.Certificate, hosts []string) bool {
+	if leaf == nil || time.Now().Add(24*time.Hour).After(leaf.NotAfter) {
+		return false
+	}
+	for _, host := range hosts {
+		if leaf.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func generateSelfSigned(hosts []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"httpBin self-signed"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(selfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if !slices.Contains(template.DNSNames, host) {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_selfSignedCertificate(t *testing.T) {
+	t.Run("certificate is cached", func(t *testing.T) {
+		dir := t.TempDir()
+
+		first, err := selfSignedCertificate(dir, []string{"localhost", "127.0.0.1"})
+		assert.NoError(t, err)
+		assert.NoError(t, first.Leaf.VerifyHostname("127.0.0.1"))
+
+		second, err := selfSignedCertificate(dir, []string{"localhost"})
+		assert.NoError(t, err)
+		assert.Equal(t, first.Certificate, second.Certificate)
+	})
+
+	t.Run("certificate is regenerated for new hosts", func(t *testing.T) {
+		dir := t.TempDir()
+
+		first, err := selfSignedCertificate(dir, []string{"localhost"})
+		assert.NoError(t, err)
+
+		second, err := selfSignedCertificate(dir, []string{"bins.example.com"})
+		assert.NoError(t, err)
+		assert.NotEqual(t, first.Certificate, second.Certificate)
+		assert.NoError(t, second.Leaf.VerifyHostname("bins.example.com"))
+	})
+}
+
+func Test_NewTLSConfig(t *testing.T) {
+	for clientAuth, expected := range map[string]tls.ClientAuthType{
+		"":        tls.NoClientCert,
+		"request": tls.RequestClientCert,
+		"require": tls.RequireAnyClientCert,
+	} {
+		t.Run("client auth "+clientAuth, func(t *testing.T) {
+			tlsConfig, err := NewTLSConfig(Config{
+				TLSCacheDir:   t.TempDir(),
+				TLSHosts:      []string{"localhost"},
+				TLSClientAuth: clientAuth,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, expected, tlsConfig.ClientAuth)
+			assert.Len(t, tlsConfig.Certificates, 1)
+		})
+	}
+}
@@ -0,0 +1,340 @@
+// Package websocket serves the server side of WebSocket connections, as
+// specified by RFC 6455, for the bins capturing them.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// acceptGUID is appended to the key of a handshake to compute its accept
+// header.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Opcodes of frames.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close status codes.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseTooBig          = 1009
+	CloseInternalError   = 1011
+)
+
+// Kinds of messages.
+const (
+	KindText   = "text"
+	KindBinary = "binary"
+)
+
+// Bounds of the writes to a peer which stopped reading.
+const (
+	// writeTimeout bounds the write of a message.
+	writeTimeout = 10 * time.Second
+	// closeTimeout bounds the write of a close frame, and of any message
+	// being written when closing.
+	closeTimeout = time.Second
+)
+
+// ErrNotWebSocket is returned when upgrading a request which is not a valid
+// WebSocket handshake.
+var ErrNotWebSocket = errors.New("not a websocket handshake")
+
+// CloseError is returned once the peer closed the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e CloseError) Error() string {
+	return fmt.Sprintf("websocket closed by peer with %d %s", e.Code, e.Reason)
+}
+
+// ProtocolError is returned once the connection was closed because the
+// peer broke the protocol, with the code and reason sent to it.
+type ProtocolError struct {
+	Code   int
+	Reason string
+}
+
+func (e ProtocolError) Error() string {
+	return fmt.Sprintf("websocket closed with %d %s", e.Code, e.Reason)
+}
+
+// IsUpgrade reports whether a request asks to upgrade to a WebSocket.
+func IsUpgrade(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		headerHasToken(r.Header, "Connection", "upgrade") &&
+		headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Conn is the server side of a WebSocket connection. Messages are read by a
+// single goroutine, while any may write them.
+type Conn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	maxSize int64
+
+	mu     sync.Mutex
+	writer *bufio.Writer
+	closed bool
+}
+
+// Upgrade completes the WebSocket handshake of a request and takes over its
+// connection. The first subprotocol requested, if any, is agreed to, so
+// clients insisting on one connect. Messages larger than maxSize are
+// refused.
+func Upgrade(w http.ResponseWriter, r *http.Request, maxSize int64) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); !IsUpgrade(r) || err != nil || len(decoded) != 16 {
+		return nil, ErrNotWebSocket
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrNotWebSocket, r.Header.Get("Sec-WebSocket-Version"))
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// deadlines set while serving the handshake no longer apply
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+	if protocol, _, _ := strings.Cut(r.Header.Get("Sec-WebSocket-Protocol"), ","); protocol != "" {
+		response += "Sec-WebSocket-Protocol: " + strings.TrimSpace(protocol) + "\r\n"
+	}
+	if _, err := rw.WriteString(response + "\r\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn:    conn,
+		reader:  rw.Reader,
+		writer:  rw.Writer,
+		maxSize: maxSize,
+	}, nil
+}
+
+// SetReadDeadline bounds the wait for the next message.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// ReadMessage reads the next text or binary message, reassembled from its
+// fragments. Pings are answered on the way. Once the peer closes the
+// connection, the close is acknowledged and a CloseError returned, while
+// messages breaking the protocol close it with a ProtocolError.
+func (c *Conn) ReadMessage() (string, []byte, error) {
+	var kind string
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return "", nil, err
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload, time.Now().Add(writeTimeout)); err != nil {
+				return "", nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			closeErr := CloseError{Code: CloseNoStatus}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Reason = string(payload[2:])
+			}
+			c.Close(closeErr.Code, "")
+			return "", nil, closeErr
+		case opText, opBinary:
+			if kind != "" {
+				return "", nil, c.fail(CloseProtocolError, "expected a continuation frame")
+			}
+			kind = KindBinary
+			if opcode == opText {
+				kind = KindText
+			}
+		case opContinuation:
+			if kind == "" {
+				return "", nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return "", nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+		}
+
+		if int64(len(message)+len(payload)) > c.maxSize {
+			return "", nil, c.fail(CloseTooBig, "message too big")
+		}
+		message = append(message, payload...)
+		if fin {
+			if kind == KindText && !utf8.Valid(message) {
+				return "", nil, c.fail(CloseInvalidPayload, "invalid utf-8 text")
+			}
+			return kind, message, nil
+		}
+	}
+}
+
+// readFrame reads a frame and unmasks its payload.
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := head[0] & 0x0f
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "unmasked client frame")
+	}
+
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(extended[:]) & (1<<63 - 1))
+	}
+	control := opcode&0x8 != 0
+	if control && (length > 125 || !fin) {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if length > c.maxSize {
+		return false, 0, nil, c.fail(CloseTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage sends a text or binary message, in a single frame.
+func (c *Conn) WriteMessage(kind string, message []byte) error {
+	opcode := byte(opBinary)
+	if kind == KindText {
+		opcode = opText
+	}
+	return c.writeFrame(opcode, message, time.Now().Add(writeTimeout))
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte, deadline time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.conn.SetWriteDeadline(deadline)
+
+	// server frames are not masked
+	head := []byte{0x80 | opcode, 0}
+	switch length := len(payload); {
+	case length < 126:
+		head[1] = byte(length)
+	case length <= 0xffff:
+		head[1] = 126
+		head = binary.BigEndian.AppendUint16(head, uint16(length))
+	default:
+		head[1] = 127
+		head = binary.BigEndian.AppendUint64(head, uint64(length))
+	}
+	c.writer.Write(head)
+	c.writer.Write(payload)
+	return c.writer.Flush()
+}
+
+// Close sends a close frame with code and reason, unless already sent, and
+// closes the connection.
+func (c *Conn) Close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if code == CloseNoStatus {
+		// 1005 is never sent, only reported when a close has no status
+		payload = nil
+	}
+	payload = append(payload, reason...)
+	// a message still being written to a peer which stopped reading fails
+	// by then, releasing the connection
+	deadline := time.Now().Add(closeTimeout)
+	c.conn.SetWriteDeadline(deadline)
+	err := c.writeFrame(opClose, payload, deadline)
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.conn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// fail closes the connection after a protocol violation of the peer, and
+// returns the error reported.
+func (c *Conn) fail(code int, reason string) error {
+	c.Close(code, reason)
+	return ProtocolError{Code: code, Reason: reason}
+}
@@ -0,0 +1,287 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testClient is the client side of a connection, writing frames by hand.
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dial upgrades a connection to server, returning the response to the
+// handshake.
+func dial(t *testing.T, server *httptest.Server, header http.Header) (*testClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	assert.NoError(t, err)
+	request.Header = header
+	assert.NoError(t, request.Write(conn))
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	assert.NoError(t, err)
+	return &testClient{conn: conn, reader: reader}, response
+}
+
+func handshake() http.Header {
+	return http.Header{
+		"Connection":            {"keep-alive, Upgrade"},
+		"Upgrade":               {"websocket"},
+		"Sec-Websocket-Key":     {"dGhlIHNhbXBsZSBub25jZQ=="},
+		"Sec-Websocket-Version": {"13"},
+	}
+}
+
+func (c *testClient) write(t *testing.T, fin bool, opcode byte, payload []byte, masked bool) {
+	t.Helper()
+	head := []byte{opcode, 0}
+	if fin {
+		head[0] |= 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		head[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		head[1] = 126
+		head = binary.BigEndian.AppendUint16(head, uint16(len(payload)))
+	default:
+		head[1] = 127
+		head = binary.BigEndian.AppendUint64(head, uint64(len(payload)))
+	}
+	body := payload
+	if masked {
+		head[1] |= 0x80
+		mask := []byte{1, 2, 3, 4}
+		head = append(head, mask...)
+		body = make([]byte, len(payload))
+		for i := range payload {
+			body[i] = payload[i] ^ mask[i%4]
+		}
+	}
+	_, err := c.conn.Write(append(head, body...))
+	assert.NoError(t, err)
+}
+
+func (c *testClient) read(t *testing.T) (byte, []byte) {
+	t.Helper()
+	var head [2]byte
+	_, err := io.ReadFull(c.reader, head[:])
+	assert.NoError(t, err)
+	assert.NotZero(t, head[0]&0x80, "server frames are final")
+	assert.Zero(t, head[1]&0x80, "server frames are not masked")
+	length := int(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		_, err = io.ReadFull(c.reader, extended[:])
+		assert.NoError(t, err)
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		_, err = io.ReadFull(c.reader, extended[:])
+		assert.NoError(t, err)
+		length = int(binary.BigEndian.Uint64(extended[:]))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	assert.NoError(t, err)
+	return head[0] & 0x0f, payload
+}
+
+// echoServer echoes the messages it reads, and reports the error ending
+// the connection.
+func echoServer(t *testing.T, maxSize int64) (*httptest.Server, <-chan error) {
+	ended := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, maxSize)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			ended <- err
+			return
+		}
+		for {
+			kind, message, err := conn.ReadMessage()
+			if err != nil {
+				ended <- err
+				return
+			}
+			conn.WriteMessage(kind, message)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, ended
+}
+
+func Test_Upgrade(t *testing.T) {
+	t.Run("happy path - messages are echoed", func(t *testing.T) {
+		server, ended := echoServer(t, 1<<20)
+		header := handshake()
+		header.Set("Sec-WebSocket-Protocol", "chat, superchat")
+		client, response := dial(t, server, header)
+
+		assert.Equal(t, http.StatusSwitchingProtocols, response.StatusCode)
+		assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", response.Header.Get("Sec-WebSocket-Accept"))
+		assert.Equal(t, "chat", response.Header.Get("Sec-WebSocket-Protocol"))
+
+		client.write(t, true, opText, []byte("hello"), true)
+		opcode, payload := client.read(t)
+		assert.Equal(t, byte(opText), opcode)
+		assert.Equal(t, "hello", string(payload))
+
+		// fragments are reassembled, with a ping answered in between
+		client.write(t, false, opBinary, []byte{0, 1}, true)
+		client.write(t, true, opPing, []byte("ping"), true)
+		client.write(t, true, opContinuation, []byte{2}, true)
+		opcode, payload = client.read(t)
+		assert.Equal(t, byte(opPong), opcode)
+		assert.Equal(t, "ping", string(payload))
+		opcode, payload = client.read(t)
+		assert.Equal(t, byte(opBinary), opcode)
+		assert.Equal(t, []byte{0, 1, 2}, payload)
+
+		large := strings.Repeat("a", 70000)
+		client.write(t, true, opText, []byte(large), true)
+		_, payload = client.read(t)
+		assert.Equal(t, large, string(payload))
+
+		client.write(t, true, opClose, append(binary.BigEndian.AppendUint16(nil, CloseNormal), "bye"...), true)
+		opcode, payload = client.read(t)
+		assert.Equal(t, byte(opClose), opcode)
+		assert.Equal(t, binary.BigEndian.AppendUint16(nil, CloseNormal), payload)
+
+		var closeErr CloseError
+		assert.ErrorAs(t, <-ended, &closeErr)
+		assert.Equal(t, CloseError{Code: CloseNormal, Reason: "bye"}, closeErr)
+	})
+
+	t.Run("not a handshake", func(t *testing.T) {
+		server, ended := echoServer(t, 1<<20)
+		header := handshake()
+		header.Set("Sec-WebSocket-Version", "8")
+		_, response := dial(t, server, header)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+		assert.ErrorIs(t, <-ended, ErrNotWebSocket)
+	})
+
+	tests := []struct {
+		name  string
+		write func(t *testing.T, client *testClient)
+		code  int
+	}{
+		{
+			name: "unmasked frame",
+			write: func(t *testing.T, client *testClient) {
+				client.write(t, true, opText, []byte("hello"), false)
+			},
+			code: CloseProtocolError,
+		},
+		{
+			name: "message too big",
+			write: func(t *testing.T, client *testClient) {
+				client.write(t, false, opText, []byte("12345"), true)
+				client.write(t, true, opContinuation, []byte("67890"), true)
+			},
+			code: CloseTooBig,
+		},
+		{
+			name: "invalid text",
+			write: func(t *testing.T, client *testClient) {
+				client.write(t, true, opText, []byte{0xff}, true)
+			},
+			code: CloseInvalidPayload,
+		},
+		{
+			name: "continuation without a message",
+			write: func(t *testing.T, client *testClient) {
+				client.write(t, true, opContinuation, []byte("a"), true)
+			},
+			code: CloseProtocolError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, ended := echoServer(t, 8)
+			client, response := dial(t, server, handshake())
+			assert.Equal(t, http.StatusSwitchingProtocols, response.StatusCode)
+
+			tt.write(t, client)
+			opcode, payload := client.read(t)
+			assert.Equal(t, byte(opClose), opcode)
+			assert.Equal(t, tt.code, int(binary.BigEndian.Uint16(payload)))
+
+			var protocolErr ProtocolError
+			assert.ErrorAs(t, <-ended, &protocolErr)
+			assert.Equal(t, tt.code, protocolErr.Code)
+			// the server closed the connection
+			_, err := client.reader.ReadByte()
+			assert.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
+
+func Test_IsUpgrade(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header = handshake()
+	assert.True(t, IsUpgrade(request))
+
+	request.Header.Set("Upgrade", "h2c")
+	assert.False(t, IsUpgrade(request))
+
+	request = httptest.NewRequest(http.MethodPost, "/", nil)
+	request.Header = handshake()
+	assert.False(t, IsUpgrade(request))
+}
+
+func Test_Close(t *testing.T) {
+	t.Run("peer not reading", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+		conn := &Conn{
+			conn:    server,
+			reader:  bufio.NewReader(server),
+			writer:  bufio.NewWriter(server),
+			maxSize: 1 << 20,
+		}
+
+		written := make(chan error, 1)
+		go func() {
+			written <- conn.WriteMessage(KindText, []byte("never read"))
+		}()
+		// the write is blocked
+		select {
+		case err := <-written:
+			t.Fatalf("write returned %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		closed := make(chan struct{})
+		go func() {
+			conn.Close(CloseGoingAway, "")
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(closeTimeout + time.Second):
+			t.Fatal("close did not return")
+		}
+		assert.Error(t, <-written)
+	})
+}
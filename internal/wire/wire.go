@@ -0,0 +1,292 @@
+// Package wire records HTTP/1.x requests as they were read off the
+// connection, before net/http parses them into a normalised http.Request.
+//
+// A Listener wraps the accepted connections so every byte read is also fed
+// to a recorder following the framing of the requests. ConnContext and
+// Handler then hand each request the Record of its own bytes.
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxRecordedBytes bounds a request head, a chunk size line or a trailer
+// section. Connections exceeding it are no longer recorded.
+const maxRecordedBytes = 1 << 20
+
+// Record holds the bytes of a request that net/http does not keep.
+type Record struct {
+	// Head is the request line and header section, up to and including the
+	// blank line ending them, with the original order and casing of headers.
+	Head string
+	// ChunkSizes are the size lines, chunk extensions included, of a chunked
+	// body in the order they were received, the last chunk's "0" included.
+	ChunkSizes []string
+	// Trailers is the trailer section following a chunked body, without the
+	// blank line ending it.
+	Trailers string
+}
+
+type Listener struct {
+	net.Listener
+}
+
+func NewListener(listener net.Listener) *Listener {
+	return &Listener{Listener: listener}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn}, nil
+}
+
+type state int
+
+const (
+	readingHead state = iota
+	readingBody
+	readingChunkSize
+	readingChunk
+	readingTrailers
+)
+
+// Conn records the requests read from the connection it wraps.
+type Conn struct {
+	net.Conn
+
+	mu        sync.Mutex
+	state     state
+	buf       []byte
+	remaining int64
+	// records holds the requests whose head has been read but which have
+	// not yet been handed to a handler, oldest first.
+	records []*Record
+	current *Record
+	broken  bool
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		c.feed(p[:n])
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *Conn) feed(b []byte) {
+	for len(b) > 0 && !c.broken {
+		switch c.state {
+		case readingHead:
+			if len(c.buf) == 0 {
+				// empty lines are allowed before a request line
+				b = bytes.TrimLeft(b, "\r\n")
+			}
+			b = c.readHead(b)
+		case readingBody:
+			skip := min(c.remaining, int64(len(b)))
+			c.remaining -= skip
+			b = b[skip:]
+			if c.remaining == 0 {
+				c.state = readingHead
+			}
+		case readingChunkSize:
+			var line string
+			var ok bool
+			if line, b, ok = c.readLine(b); ok {
+				c.readChunkSize(line)
+			}
+		case readingChunk:
+			skip := min(c.remaining, int64(len(b)))
+			c.remaining -= skip
+			b = b[skip:]
+			if c.remaining == 0 {
+				c.state = readingChunkSize
+			}
+		case readingTrailers:
+			var line string
+			var ok bool
+			if line, b, ok = c.readLine(b); ok {
+				if strings.TrimRight(line, "\r\n") == "" {
+					c.state = readingHead
+				} else {
+					c.current.Trailers += line
+				}
+			}
+		}
+	}
+}
+
+// readHead buffers b until the end of the header section and returns what
+// is left of b after it.
+func (c *Conn) readHead(b []byte) []byte {
+	start := max(0, len(c.buf)-3)
+	c.buf = append(c.buf, b...)
+	end := headEnd(c.buf[start:])
+	if end < 0 {
+		if len(c.buf) > maxRecordedBytes {
+			c.broken = true
+		}
+		return nil
+	}
+	end += start
+	head, rest := c.buf[:end], c.buf[end:]
+	// the request body is framed by the head
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+	if err != nil {
+		c.broken = true
+		return nil
+	}
+
+	c.current = &Record{Head: string(head)}
+	c.records = append(c.records, c.current)
+	switch {
+	case slices.Contains(request.TransferEncoding, "chunked"):
+		c.state = readingChunkSize
+	case request.ContentLength > 0:
+		c.state = readingBody
+		c.remaining = request.ContentLength
+	}
+	rest = bytes.Clone(rest)
+	c.buf = c.buf[:0]
+	return rest
+}
+
+// headEnd returns the index following the blank line ending a header
+// section, or -1.
+func headEnd(b []byte) int {
+	crlf := bytes.Index(b, []byte("\n\r\n"))
+	lf := bytes.Index(b, []byte("\n\n"))
+	switch {
+	case crlf >= 0 && (lf < 0 || crlf < lf):
+		return crlf + 3
+	case lf >= 0:
+		return lf + 2
+	}
+	return -1
+}
+
+// readLine buffers b until the end of a line and returns the line and what
+// is left of b after it.
+func (c *Conn) readLine(b []byte) (string, []byte, bool) {
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		c.buf = append(c.buf, b...)
+		if len(c.buf) > maxRecordedBytes {
+			c.broken = true
+		}
+		return "", nil, false
+	}
+	line := string(c.buf) + string(b[:i+1])
+	c.buf = c.buf[:0]
+	return line, b[i+1:], true
+}
+
+func (c *Conn) readChunkSize(line string) {
+	sizeLine := strings.TrimRight(line, "\r\n")
+	c.current.ChunkSizes = append(c.current.ChunkSizes, sizeLine)
+	size, _, _ := strings.Cut(sizeLine, ";")
+	n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+	if err != nil || n < 0 {
+		c.broken = true
+		return
+	}
+	if n == 0 {
+		c.state = readingTrailers
+		return
+	}
+	c.state = readingChunk
+	// the chunk data is followed by a CRLF
+	c.remaining = n + 2
+}
+
+// next hands out the oldest request read from the connection.
+func (c *Conn) next() *Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.records) == 0 {
+		return nil
+	}
+	record := c.records[0]
+	c.records = c.records[1:]
+	return record
+}
+
+func (c *Conn) snapshot(record *Record) Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := *record
+	snapshot.ChunkSizes = slices.Clone(record.ChunkSizes)
+	return snapshot
+}
+
+type connKey struct{}
+
+type recordKey struct{}
+
+// ConnContext is meant for http.Server.ConnContext, it makes the recording
+// connection available to Handler.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if c, ok := conn.(*Conn); ok {
+		return context.WithValue(ctx, connKey{}, c)
+	}
+	return ctx
+}
+
+// Handler takes the record of every request from its connection, as the
+// requests of a connection are served in the order they were read.
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, ok := r.Context().Value(connKey{}).(*Conn)
+		// HTTP/2 requests share their connection and are not recorded
+		if ok && r.ProtoMajor == 1 {
+			if record := conn.next(); record != nil {
+				ctx := context.WithValue(r.Context(), recordKey{}, recorded{conn, record})
+				r = r.WithContext(ctx)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Hijacked stops recording the connection of the request being served,
+// once its handler takes the connection over, e.g. for a WebSocket, as what
+// follows is no longer HTTP.
+func Hijacked(ctx context.Context) {
+	conn, ok := ctx.Value(connKey{}).(*Conn)
+	if !ok {
+		return
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.broken = true
+	conn.buf = nil
+	conn.records = nil
+}
+
+type recorded struct {
+	conn   *Conn
+	record *Record
+}
+
+// FromContext returns the record of the request being served. Trailers are
+// only recorded once the body has been read.
+func FromContext(ctx context.Context) (Record, bool) {
+	r, ok := ctx.Value(recordKey{}).(recorded)
+	if !ok {
+		return Record{}, false
+	}
+	return r.conn.snapshot(r.record), true
+}
@@ -0,0 +1,122 @@
+package wire
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingServer serves requests recorded by a Listener and returns the
+// records handed to the requests, in order, once done.
+func recordingServer(t *testing.T) (*httptest.Server, chan Record) {
+	records := make(chan Record, 10)
+	server := httptest.NewUnstartedServer(Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		record, ok := FromContext(r.Context())
+		assert.True(t, ok)
+		records <- record
+	})))
+	server.Listener = NewListener(server.Listener)
+	server.Config.ConnContext = ConnContext
+	server.Start()
+	return server, records
+}
+
+func Test_Listener(t *testing.T) {
+	t.Run("pipelined requests keep their own bytes", func(t *testing.T) {
+		server, records := recordingServer(t)
+		defer server.Close()
+
+		heads := []string{
+			"POST /bin/1 HTTP/1.1\r\nhost: example.com\r\nX-B: 2\r\nx-a: 1\r\nX-B: 3\r\nContent-Length: 4\r\n\r\n",
+			"PUT /bin/1/chunked HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n",
+			"GET /bin/1?q=1 HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		}
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		assert.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn,
+			heads[0]+"body"+
+				heads[1]+"3;ext=1\r\nabc\r\n2\r\nde\r\n0\r\nX-Checksum: 42\r\n\r\n"+
+				heads[2])
+		assert.NoError(t, err)
+
+		reader := bufio.NewReader(conn)
+		for range heads {
+			response, err := http.ReadResponse(reader, nil)
+			assert.NoError(t, err)
+			response.Body.Close()
+		}
+
+		assert.Equal(t, Record{Head: heads[0]}, <-records)
+		assert.Equal(t, Record{
+			Head:       heads[1],
+			ChunkSizes: []string{"3;ext=1", "2", "0"},
+			Trailers:   "X-Checksum: 42\r\n",
+		}, <-records)
+		assert.Equal(t, Record{Head: heads[2]}, <-records)
+	})
+
+	t.Run("requests split across reads", func(t *testing.T) {
+		server, records := recordingServer(t)
+		defer server.Close()
+
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		head := "POST /bin/1 HTTP/1.0\r\nHost: example.com\r\nContent-Length: 2\r\n\r\n"
+		for _, part := range []string{head[:10], head[10 : len(head)-2], head[len(head)-2:], "o", "k"} {
+			_, err = io.WriteString(conn, part)
+			assert.NoError(t, err)
+		}
+
+		response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		assert.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, Record{Head: head}, <-records)
+	})
+}
+
+func Test_Hijacked(t *testing.T) {
+	recorded := make(chan int, 1)
+	server := httptest.NewUnstartedServer(Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		assert.NoError(t, err)
+		defer conn.Close()
+		Hijacked(r.Context())
+
+		// what follows looks like a request, but is not one
+		for {
+			line, err := rw.ReadString('\n')
+			if !assert.NoError(t, err) || line == "\r\n" {
+				break
+			}
+		}
+
+		wired := r.Context().Value(connKey{}).(*Conn)
+		wired.mu.Lock()
+		recorded <- len(wired.records) + len(wired.buf)
+		wired.mu.Unlock()
+	})))
+	server.Listener = NewListener(server.Listener)
+	server.Config.ConnContext = ConnContext
+	server.Start()
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /bin/1 HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\n\r\n")
+	assert.NoError(t, err)
+	_, err = io.WriteString(conn, "GET /bin/1 HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 0, <-recorded)
+}